@@ -0,0 +1,128 @@
+// Package acl holds the named users a server accepts, as listed in the file
+// named by its -users flag, and the commands and keys each one may use.
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// User is a named client and what it may do
+type User struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	// Commands are the ops the user may run, such as "GET" or "KEYS RANGE",
+	// with "*" allowing every op
+	Commands []string `json:"commands"`
+	// KeyPrefix restricts the user to keys starting with it if set
+	KeyPrefix string `json:"key_prefix,omitempty"`
+
+	allowed map[string]bool
+	all     bool
+}
+
+// Users is the set of users a server accepts
+type Users struct {
+	byName map[string]*User
+}
+
+// Load reads users from a JSON file of the form
+//
+//	{"users": [{"name": "reader", "password": "...", "commands": ["GET", "TTL"]}]}
+func Load(path string) (*Users, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse reads users from JSON in the format Load expects
+func Parse(data []byte) (*Users, error) {
+	var file struct {
+		Users []*User `json:"users"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse users: %w", err)
+	}
+	if len(file.Users) == 0 {
+		return nil, fmt.Errorf("no users defined")
+	}
+
+	users := &Users{byName: make(map[string]*User, len(file.Users))}
+	for _, u := range file.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("user without a name")
+		}
+		if u.Password == "" {
+			return nil, fmt.Errorf("user %q has no password", u.Name)
+		}
+		if _, dup := users.byName[u.Name]; dup {
+			return nil, fmt.Errorf("user %q is defined twice", u.Name)
+		}
+
+		u.allowed = make(map[string]bool, len(u.Commands))
+		for _, op := range u.Commands {
+			if op == "*" {
+				u.all = true
+			}
+			u.allowed[strings.ToUpper(op)] = true
+		}
+		users.byName[u.Name] = u
+	}
+	return users, nil
+}
+
+// Authenticate returns the user with the given name and password
+func (u *Users) Authenticate(name, password string) (*User, bool) {
+	user, ok := u.byName[name]
+	if !ok {
+		return nil, false
+	}
+
+	// Comparing digests takes the same time whatever the lengths
+	want := sha256.Sum256([]byte(user.Password))
+	got := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare(want[:], got[:]) != 1 {
+		return nil, false
+	}
+	return user, true
+}
+
+// Allows reports whether the user may run op
+func (u *User) Allows(op string) bool {
+	return u.all || u.allowed[strings.ToUpper(op)]
+}
+
+// AllowsKey reports whether key, or a key pattern or prefix, lies within the
+// user's key prefix
+func (u *User) AllowsKey(key string) bool {
+	return strings.HasPrefix(key, u.KeyPrefix)
+}
+
+// AllowsRange reports whether every key from start up to end, exclusive, lies
+// within the user's key prefix. An empty end leaves the range unbounded.
+func (u *User) AllowsRange(start, end string) bool {
+	if u.KeyPrefix == "" {
+		return true
+	}
+	if !u.AllowsKey(start) {
+		return false
+	}
+
+	// Keys with the prefix sort before the prefix with its last byte
+	// incremented, once trailing 0xff bytes that cannot be are dropped
+	limit := []byte(u.KeyPrefix)
+	for len(limit) > 0 && limit[len(limit)-1] == 0xff {
+		limit = limit[:len(limit)-1]
+	}
+	if len(limit) == 0 {
+		return true
+	}
+	limit[len(limit)-1]++
+	return end != "" && end <= string(limit)
+}
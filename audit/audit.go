@@ -0,0 +1,184 @@
+// Package audit keeps an append-only record of the mutating commands a
+// server was sent: who sent them, from where, and what came of them. Values
+// are never recorded, only their size.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSize is the size an audit log grows to before it is rotated
+const DefaultMaxSize = 100 << 20
+
+// Entry is one recorded command
+type Entry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user,omitempty"` // empty when the server has no users
+	Addr      string    `json:"addr"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key,omitempty"`
+	Keys      []string  `json:"keys,omitempty"` // the further keys of multi-key commands
+	ValueSize int       `json:"value_size"`
+	// Status and Code are those of the response, so a command refused for
+	// lack of permission has Code PERMISSION_DENIED
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	// Index is the raft index the leader had applied once the write
+	// returned, which covers the write's own entry
+	Index uint64 `json:"index,omitempty"`
+	// Forwarded marks a write a raft follower relayed, which the follower
+	// records as sent by its client
+	Forwarded bool `json:"forwarded,omitempty"`
+}
+
+// Log appends entries to a file of JSON lines. Once the file would grow past
+// its maximum size it is renamed with a ".1" suffix, replacing the previous
+// one, and a new file is started.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	size    int64
+	maxSize int64
+}
+
+// Open opens the audit log at path for appending, creating it if needed.
+// maxSize is the size at which it is rotated, zero meaning DefaultMaxSize.
+func Open(path string, maxSize int64) (*Log, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	l := &Log{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the current file for appending. Callers must hold the lock
+// unless l is not shared yet.
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Record appends e, rotating the file first if e would take it past its
+// maximum size
+func (l *Log) Record(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return errors.New("audit log is closed")
+	}
+	// An entry is never dropped for a failed rotation; it goes to the
+	// current file, which is rotated on the next try
+	var rotateErr error
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		rotateErr = l.rotate()
+	}
+
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return rotateErr
+}
+
+// rotate moves the current file aside and starts a new one, or carries on
+// with the current one if it cannot be moved. Callers must hold the lock.
+func (l *Log) rotate() error {
+	l.f.Close()
+	renameErr := os.Rename(l.path, l.path+".1")
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", renameErr)
+	}
+	return nil
+}
+
+// Last returns the latest n entries, oldest first, looking into the rotated
+// file if the current one holds fewer
+func (l *Log) Last(n int) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := readEntries(l.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) < n {
+		older, err := readEntries(l.path + ".1")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		entries = append(older, entries...)
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
+
+// readEntries reads every entry of the file at path
+func readEntries(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid audit log entry in %s: %w", path, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Close closes the file. Entries recorded afterwards fail.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// record appends n entries for keys key0 to key<n-1>
+func record(t *testing.T, l *Log, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		e := Entry{Time: time.Now(), User: "writer", Addr: "127.0.0.1:1234", Op: "SET", Key: fmt.Sprintf("key%d", i), ValueSize: i, Status: "success"}
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkKeys checks entries are for exactly keys, in order
+func checkKeys(t *testing.T, entries []Entry, keys ...string) {
+	t.Helper()
+
+	if len(entries) != len(keys) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(keys))
+	}
+	for i, e := range entries {
+		if e.Key != keys[i] {
+			t.Errorf("entry %d is for %q, want %q", i, e.Key, keys[i])
+		}
+	}
+}
+
+func TestRecordAndLast(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, l, 5)
+
+	entries, err := l.Last(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, entries, "key2", "key3", "key4")
+	if e := entries[0]; e.User != "writer" || e.Op != "SET" || e.ValueSize != 2 || e.Status != "success" {
+		t.Errorf("entry read back as %+v", e)
+	}
+
+	// Reopening appends to what is there
+	l.Close()
+	if err := l.Record(Entry{Op: "SET"}); err == nil {
+		t.Error("Record succeeded on a closed log")
+	}
+	if l, err = Open(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	record(t, l, 1)
+	entries, err = l.Last(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, entries, "key0", "key1", "key2", "key3", "key4", "key0")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("audit log has mode %v, want 0600", perm)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Every entry is over the maximum size, so each rotates out the last
+	record(t, l, 3)
+	current, err := readEntries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, current, "key2")
+	rotated, err := readEntries(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, rotated, "key1")
+
+	// Last reaches into the rotated file, and no further
+	entries, err := l.Last(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, entries, "key1", "key2")
+}
+
+func TestRotationKeepsEntriesTogether(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	record(t, l, 50)
+	for _, p := range []string{path, path + ".1"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1<<10 {
+			t.Errorf("%s grew to %d bytes, over the maximum size", p, info.Size())
+		}
+		if _, err := readEntries(p); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+
+	entries, err := l.Last(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, entries, "key49")
+}
@@ -0,0 +1,144 @@
+// Package chaos injects failures into the servers and store so client retry
+// and failover logic can be exercised without breaking real infrastructure.
+//
+// Injection is off unless Enable is called, which the server binaries only do
+// when started with -chaos. Every policy defaults to zero, i.e. no failures,
+// and can be changed at runtime through Handler.
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjected is returned by operations failed on purpose
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Config holds the failure policies. Rates are probabilities between 0 and 1.
+type Config struct {
+	// DropConnectionRate closes newly accepted connections immediately
+	DropConnectionRate float64 `json:"drop_connection_rate"`
+	// CommandDelayMin and CommandDelayMax delay every command by a uniformly
+	// distributed duration in [min, max]
+	CommandDelayMin time.Duration `json:"command_delay_min"`
+	CommandDelayMax time.Duration `json:"command_delay_max"`
+	// NotLeaderRate makes raft writes fail as if the node were not the leader
+	NotLeaderRate float64 `json:"not_leader_rate"`
+	// ApplyDelay stalls every raft FSM apply
+	ApplyDelay time.Duration `json:"apply_delay"`
+	// WALFailureRate fails writes to the store's log
+	WALFailureRate float64 `json:"wal_failure_rate"`
+}
+
+var (
+	enabled atomic.Bool
+
+	mu     sync.RWMutex
+	config Config
+)
+
+// Enable turns on failure injection for the process
+func Enable() {
+	enabled.Store(true)
+}
+
+// Enabled reports whether failure injection is turned on
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Set replaces the active policies
+func Set(cfg Config) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	config = cfg
+}
+
+// Get returns the active policies
+func Get() Config {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return config
+}
+
+// DropConnection reports whether a newly accepted connection should be dropped
+func DropConnection() bool {
+	return Enabled() && chance(Get().DropConnectionRate)
+}
+
+// DelayCommand sleeps for the configured command delay
+func DelayCommand() {
+	if !Enabled() {
+		return
+	}
+
+	cfg := Get()
+	if cfg.CommandDelayMax <= 0 {
+		return
+	}
+
+	delay := cfg.CommandDelayMin
+	if spread := cfg.CommandDelayMax - cfg.CommandDelayMin; spread > 0 {
+		delay += time.Duration(rand.Int63n(int64(spread) + 1))
+	}
+	time.Sleep(delay)
+}
+
+// NotLeader reports whether a raft write should be rejected as if this node
+// were not the leader
+func NotLeader() bool {
+	return Enabled() && chance(Get().NotLeaderRate)
+}
+
+// DelayApply sleeps for the configured raft apply delay
+func DelayApply() {
+	if !Enabled() {
+		return
+	}
+
+	if delay := Get().ApplyDelay; delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// WALWriteError returns ErrInjected if a log write should fail
+func WALWriteError() error {
+	if Enabled() && chance(Get().WALFailureRate) {
+		return ErrInjected
+	}
+	return nil
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Handler serves the active policies on GET and replaces them on PUT or POST
+// with a JSON Config body
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var cfg Config
+			if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			Set(cfg)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	})
+}
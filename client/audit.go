@@ -0,0 +1,54 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditEntry is one write recorded in a server's audit log. Status and Code
+// are those of the response the write got, so a write refused for lack of
+// permission has Code PERMISSION_DENIED.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user,omitempty"`
+	Addr      string    `json:"addr"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key,omitempty"`
+	Keys      []string  `json:"keys,omitempty"`
+	ValueSize int       `json:"value_size"`
+	Status    string    `json:"status"`
+	Code      string    `json:"code,omitempty"`
+	Index     uint64    `json:"index,omitempty"`     // the raft index, on a raft leader
+	Forwarded bool      `json:"forwarded,omitempty"` // relayed by a raft follower
+}
+
+func auditLog(send func(Command) (*Response, error), count int) ([]AuditEntry, error) {
+	resp, err := send(Command{Op: "AUDIT", Count: count})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	var entries []AuditEntry
+	if err := json.Unmarshal([]byte(resp.Value), &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// AuditLog returns the latest count entries of the server's audit log,
+// oldest first. A count of zero uses the server's default.
+func (c *Client) AuditLog(count int) ([]AuditEntry, error) {
+	return auditLog(c.sendCommand, count)
+}
+
+// AuditLog returns the latest count entries of the connected node's audit
+// log, oldest first. Each node records the writes its own clients sent.
+func (c *RaftClient) AuditLog(count int) ([]AuditEntry, error) {
+	return auditLog(c.sendCommand, count)
+}
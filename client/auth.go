@@ -0,0 +1,41 @@
+package client
+
+// login is the user a client logs in as
+type login struct {
+	user     string
+	password string
+}
+
+// Auth logs in as user on a server configured with users. Connections the
+// client opens later, such as for Subscribe, log in the same way.
+func (c *Client) Auth(user, password string) error {
+	if err := auth(c.sendCommand, user, password); err != nil {
+		return err
+	}
+	c.auth = &login{user: user, password: password}
+	return nil
+}
+
+// Auth logs in as user on a cluster configured with users. The client logs
+// in again whenever it follows a redirect to another node, and on the
+// connections it opens for Subscribe.
+func (c *RaftClient) Auth(user, password string) error {
+	if err := auth(c.sendCommand, user, password); err != nil {
+		return err
+	}
+	c.auth = &login{user: user, password: password}
+	return nil
+}
+
+func auth(send func(Command) (*Response, error), user, password string) error {
+	resp, err := send(Command{Op: "AUTH", Key: user, Value: password})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BackupFormat is the newest raft snapshot format this client reads
+const BackupFormat = 1
+
+// ErrClusterMismatch is returned by restores of a backup taken on another
+// cluster, unless they are forced
+var ErrClusterMismatch = errors.New("backup is from another cluster")
+
+// BackupInfo describes the raft snapshot a backup holds. SHA256 is the hex
+// encoded checksum of its contents. Entries, Format and ClusterID are read
+// from the snapshot itself: Format is 0 for snapshots without metadata and
+// ClusterID is empty for those taken before clusters had IDs.
+type BackupInfo struct {
+	ID        string
+	Index     uint64
+	Term      uint64
+	Size      int64
+	SHA256    string
+	Entries   int64
+	Format    int
+	ClusterID string
+}
+
+// TransferOptions configures a backup or restore
+type TransferOptions struct {
+	// User and Password log in as a user allowed BACKUP or RESTORE, for
+	// every key, when the cluster has users. An empty User sends no
+	// credentials.
+	User, Password string
+	// ClusterID, if set, is the cluster a restored backup must come from
+	ClusterID string
+	// Force restores a backup taken on another cluster
+	Force bool
+	// Progress, if set, is called as a backup is received or sent with the
+	// bytes so far and the total, -1 if unknown
+	Progress func(done, total int64)
+}
+
+// progressReader reports the bytes read through it to progress
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress func(done, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if n > 0 && p.progress != nil {
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+// scanBackup reads a whole raft snapshot from r, gzipped or not, counting
+// its entries and reading its format and cluster ID. Entries are decoded one
+// at a time, so a large backup is never held in memory.
+func scanBackup(r io.Reader, info *BackupInfo) error {
+	br := bufio.NewReader(r)
+	var data io.Reader = br
+	// JSON never starts with gzip's magic bytes
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("bad compression: %w", err)
+		}
+		data = zr
+	}
+	decoder := json.NewDecoder(data)
+
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("not a yakvs snapshot")
+	}
+	for decoder.More() {
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("not a yakvs snapshot: %w", err)
+		}
+		var entry json.RawMessage
+		if err := decoder.Decode(&entry); err != nil {
+			return fmt.Errorf("not a yakvs snapshot: %w", err)
+		}
+		info.Entries++
+	}
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("not a yakvs snapshot: %w", err)
+	}
+
+	var meta struct {
+		Format    int    `json:"format"`
+		ClusterID string `json:"cluster_id"`
+	}
+	if err := decoder.Decode(&meta); err != nil && err != io.EOF {
+		return fmt.Errorf("bad metadata: %w", err)
+	}
+	info.Format, info.ClusterID = meta.Format, meta.ClusterID
+
+	// Read anything the decoder left, so the whole backup is checksummed
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+// ScanBackup reads a backup, as written by Backup, and describes it. Only
+// Size, SHA256, Entries, Format and ClusterID are set.
+func ScanBackup(r io.Reader) (BackupInfo, error) {
+	var info BackupInfo
+	h := sha256.New()
+	counted := &progressReader{r: io.TeeReader(r, h)}
+	if err := scanBackup(counted, &info); err != nil {
+		return info, fmt.Errorf("invalid backup: %w", err)
+	}
+	info.Size = counted.done
+	info.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return info, nil
+}
+
+// Backup writes the latest raft snapshot of the node whose HTTP API is at
+// apiAddr to w. The node takes a new snapshot first unless nothing changed
+// since its last. The copy is checked against the snapshot's size and
+// checksum, so a backup that returns no error is complete.
+func Backup(apiAddr string, w io.Writer) (BackupInfo, error) {
+	return BackupWith(apiAddr, w, TransferOptions{})
+}
+
+// BackupAs is Backup logging in as user, who must be allowed the BACKUP
+// command when the cluster has users. An empty user sends no credentials.
+func BackupAs(apiAddr string, w io.Writer, user, password string) (BackupInfo, error) {
+	return BackupWith(apiAddr, w, TransferOptions{User: user, Password: password})
+}
+
+// BackupWith is Backup with options. The backup is checked against the
+// checksum the node sends after it, and is streamed through to w, so it is
+// never held in memory.
+func BackupWith(apiAddr string, w io.Writer, opts TransferOptions) (BackupInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/backup", apiAddr), nil)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to build backup request: %w", err)
+	}
+	if opts.User != "" {
+		req.SetBasicAuth(opts.User, opts.Password)
+	}
+
+	// No timeout, as a large snapshot takes as long as it takes to send
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to send backup request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return BackupInfo{}, fmt.Errorf("backup failed with status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	info := BackupInfo{
+		ID:     resp.Header.Get("X-Snapshot-Id"),
+		Size:   resp.ContentLength,
+		SHA256: resp.Header.Get("X-Snapshot-Sha256"),
+	}
+	// Servers that send a checksum trailer send the size in a header
+	if size, err := strconv.ParseInt(resp.Header.Get("X-Snapshot-Size"), 10, 64); err == nil {
+		info.Size = size
+	}
+	info.Index, _ = strconv.ParseUint(resp.Header.Get("X-Snapshot-Index"), 10, 64)
+	info.Term, _ = strconv.ParseUint(resp.Header.Get("X-Snapshot-Term"), 10, 64)
+
+	h := sha256.New()
+	received := &progressReader{r: io.TeeReader(resp.Body, io.MultiWriter(w, h)), total: info.Size, progress: opts.Progress}
+	if err := scanBackup(received, &info); err != nil {
+		return info, fmt.Errorf("failed to copy snapshot: %w", err)
+	}
+	n := received.done
+	if info.Size >= 0 && n != info.Size {
+		return info, fmt.Errorf("backup is truncated: got %d of %d bytes", n, info.Size)
+	}
+	info.Size = n
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if trailer := resp.Trailer.Get("X-Backup-Sha256"); trailer != "" && sum != trailer {
+		return info, fmt.Errorf("backup checksum mismatch: got %s, the node sent %s", sum, trailer)
+	}
+	if info.SHA256 != "" && sum != info.SHA256 {
+		return info, fmt.Errorf("backup checksum mismatch: got %s, want %s", sum, info.SHA256)
+	}
+	info.SHA256 = sum
+	return info, nil
+}
+
+// Backup writes the latest raft snapshot of the connected node to w through
+// the HTTP API the node registered, logging in as the user given to Auth
+func (c *RaftClient) Backup(w io.Writer) (BackupInfo, error) {
+	return c.BackupWith(w, TransferOptions{})
+}
+
+// BackupWith is Backup with options, logging in as the user given to Auth
+// unless they name one
+func (c *RaftClient) BackupWith(w io.Writer, opts TransferOptions) (BackupInfo, error) {
+	st, err := c.Status()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	var apiAddr string
+	for _, p := range st.Peers {
+		if p.ID == st.NodeID {
+			apiAddr = p.APIAddr
+		}
+	}
+	if apiAddr == "" {
+		return BackupInfo{}, fmt.Errorf("%s registered no API address to back up from", c.serverAddr)
+	}
+
+	if c.auth != nil && opts.User == "" {
+		opts.User, opts.Password = c.auth.user, c.auth.password
+	}
+	return BackupWith(apiAddr, w, opts)
+}
+
+// RestoreBackup replaces the data of the whole cluster with a backup, as
+// written by Backup, through the HTTP API of its leader at apiAddr. The
+// backup is read twice, once to checksum it, which the leader verifies
+// before restoring anything.
+func RestoreBackup(apiAddr string, r io.ReadSeeker) error {
+	_, err := RestoreBackupWith(apiAddr, r, TransferOptions{})
+	return err
+}
+
+// RestoreBackupAs is RestoreBackup logging in as user, who must be allowed
+// the RESTORE command, for every key, when the cluster has users. An empty
+// user sends no credentials.
+func RestoreBackupAs(apiAddr string, r io.ReadSeeker, user, password string) error {
+	_, err := RestoreBackupWith(apiAddr, r, TransferOptions{User: user, Password: password})
+	return err
+}
+
+// RestoreBackupWith is RestoreBackup with options, returning what the backup
+// held. A backup in a newer format than BackupFormat, or taken on another
+// cluster than opts.ClusterID, is refused before it is sent. The leader
+// refuses one from another cluster too, with ErrClusterMismatch, unless
+// opts.Force is set.
+func RestoreBackupWith(apiAddr string, r io.ReadSeeker, opts TransferOptions) (BackupInfo, error) {
+	info, err := ScanBackup(r)
+	if err != nil {
+		return info, err
+	}
+	if info.Format > BackupFormat {
+		return info, fmt.Errorf("backup format %d is newer than the supported format %d", info.Format, BackupFormat)
+	}
+	if !opts.Force && opts.ClusterID != "" && info.ClusterID != "" && info.ClusterID != opts.ClusterID {
+		return info, fmt.Errorf("%w: it was taken on cluster %s, this is cluster %s", ErrClusterMismatch, info.ClusterID, opts.ClusterID)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return info, fmt.Errorf("failed to rewind backup: %w", err)
+	}
+
+	url := fmt.Sprintf("http://%s/restore", apiAddr)
+	if opts.Force {
+		url += "?force=true"
+	}
+	body := &progressReader{r: r, total: info.Size, progress: opts.Progress}
+	req, err := http.NewRequest(http.MethodPost, url, io.NopCloser(body))
+	if err != nil {
+		return info, fmt.Errorf("failed to build restore request: %w", err)
+	}
+	req.ContentLength = info.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Snapshot-Sha256", info.SHA256)
+	if opts.User != "" {
+		req.SetBasicAuth(opts.User, opts.Password)
+	}
+
+	client := http.Client{
+		// The backup cannot be sent again, so a follower's redirect to the
+		// leader is reported instead
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return info, fmt.Errorf("failed to send restore request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return info, nil
+	case http.StatusTemporaryRedirect:
+		return info, fmt.Errorf("%s is not the leader, restore through %s", apiAddr, resp.Header.Get("Location"))
+	case http.StatusConflict:
+		return info, fmt.Errorf("%w: %s", ErrClusterMismatch, strings.TrimSpace(string(msg)))
+	default:
+		return info, fmt.Errorf("restore failed with status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// RestoreBackup replaces the data of the whole cluster with a backup through
+// the HTTP API the leader registered, logging in as the user given to Auth
+func (c *RaftClient) RestoreBackup(r io.ReadSeeker) error {
+	_, err := c.RestoreBackupWith(r, TransferOptions{})
+	return err
+}
+
+// RestoreBackupWith is RestoreBackup with options, logging in as the user
+// given to Auth unless they name one. A backup taken on another cluster than
+// the connected one is refused unless opts.Force is set.
+func (c *RaftClient) RestoreBackupWith(r io.ReadSeeker, opts TransferOptions) (BackupInfo, error) {
+	st, err := c.Status()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	var apiAddr string
+	for _, p := range st.Peers {
+		if p.Leader {
+			apiAddr = p.APIAddr
+		}
+	}
+	if apiAddr == "" {
+		return BackupInfo{}, fmt.Errorf("the leader registered no API address to restore through")
+	}
+
+	if opts.ClusterID == "" {
+		opts.ClusterID = st.ClusterID
+	}
+	if c.auth != nil && opts.User == "" {
+		opts.User, opts.Password = c.auth.user, c.auth.password
+	}
+	return RestoreBackupWith(apiAddr, r, opts)
+}
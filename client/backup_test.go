@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testSnapshot returns a gzipped raft snapshot of n keys taken on cluster
+func testSnapshot(t *testing.T, n, format int, cluster string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	fmt.Fprint(zw, "{")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			fmt.Fprint(zw, ",")
+		}
+		fmt.Fprintf(zw, `"key%d":{"data":"value %d"}`, i, i)
+	}
+	fmt.Fprintf(zw, "}\n{\"format\":%d,\"cluster_id\":%q}\n", format, cluster)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fakeAPI serves snapshot from /backup, streamed with its checksum in the
+// X-Backup-Sha256 trailer, set to trailer if not empty. /restore answers
+// with status, recording the query it was sent.
+type fakeAPI struct {
+	snapshot []byte
+	trailer  string
+	status   int
+	query    string
+	restored []byte
+}
+
+func (f *fakeAPI) start(t *testing.T) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backup", func(w http.ResponseWriter, r *http.Request) {
+		trailer := f.trailer
+		if trailer == "" {
+			trailer = sha256Hex(f.snapshot)
+		}
+		w.Header().Set("X-Snapshot-Id", "2-10-1")
+		w.Header().Set("X-Snapshot-Size", strconv.Itoa(len(f.snapshot)))
+		w.Header().Set("Trailer", "X-Backup-Sha256")
+		// Written in pieces, so the response is chunked
+		for rest := f.snapshot; len(rest) > 0; {
+			n := min(len(rest), 64)
+			w.Write(rest[:n])
+			w.(http.Flusher).Flush()
+			rest = rest[n:]
+		}
+		w.Header().Set("X-Backup-Sha256", trailer)
+	})
+	mux.HandleFunc("/restore", func(w http.ResponseWriter, r *http.Request) {
+		f.query = r.URL.RawQuery
+		f.restored, _ = io.ReadAll(r.Body)
+		if r.Header.Get("X-Snapshot-Sha256") != sha256Hex(f.restored) {
+			http.Error(w, "checksum mismatch", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, "snapshot is from another cluster")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestBackupChecksTrailer(t *testing.T) {
+	api := &fakeAPI{snapshot: testSnapshot(t, 500, 1, "c1")}
+	addr := api.start(t)
+
+	var out bytes.Buffer
+	var last, total int64
+	info, err := BackupWith(addr, &out, TransferOptions{Progress: func(done, n int64) { last, total = done, n }})
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), api.snapshot) {
+		t.Errorf("backup wrote %d bytes, want the %d byte snapshot", out.Len(), len(api.snapshot))
+	}
+	if info.Entries != 500 || info.Format != 1 || info.ClusterID != "c1" || info.Size != int64(len(api.snapshot)) {
+		t.Errorf("backup info = %+v", info)
+	}
+	if info.SHA256 != sha256Hex(api.snapshot) {
+		t.Errorf("backup checksum = %s, want %s", info.SHA256, sha256Hex(api.snapshot))
+	}
+	if last != total || total != info.Size {
+		t.Errorf("last progress = %d of %d, want %d of %d", last, total, info.Size, info.Size)
+	}
+
+	// A checksum trailer the bytes do not match fails the backup
+	api.trailer = strings.Repeat("0", 64)
+	if _, err := Backup(addr, io.Discard); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("backup with a bad trailer = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestRestoreFromAnotherCluster(t *testing.T) {
+	api := &fakeAPI{snapshot: testSnapshot(t, 10, 1, "c1"), status: http.StatusOK}
+	addr := api.start(t)
+
+	// Refused before it is sent
+	_, err := RestoreBackupWith(addr, bytes.NewReader(api.snapshot), TransferOptions{ClusterID: "c2"})
+	if !errors.Is(err, ErrClusterMismatch) {
+		t.Fatalf("restore from another cluster = %v, want ErrClusterMismatch", err)
+	}
+	if api.restored != nil {
+		t.Fatal("a backup from another cluster was sent")
+	}
+
+	info, err := RestoreBackupWith(addr, bytes.NewReader(api.snapshot), TransferOptions{ClusterID: "c2", Force: true})
+	if err != nil {
+		t.Fatalf("forced restore: %v", err)
+	}
+	if api.query != "force=true" || !bytes.Equal(api.restored, api.snapshot) {
+		t.Errorf("forced restore sent %d bytes with query %q", len(api.restored), api.query)
+	}
+	if info.Entries != 10 || info.ClusterID != "c1" {
+		t.Errorf("restore info = %+v", info)
+	}
+
+	// Refused by the leader, when the client did not know the cluster's ID
+	api.status = http.StatusConflict
+	if _, err := RestoreBackupWith(addr, bytes.NewReader(api.snapshot), TransferOptions{}); !errors.Is(err, ErrClusterMismatch) {
+		t.Errorf("restore refused by the leader = %v, want ErrClusterMismatch", err)
+	}
+}
+
+func TestRestoreRefusesNewerFormat(t *testing.T) {
+	api := &fakeAPI{snapshot: testSnapshot(t, 1, BackupFormat+1, ""), status: http.StatusOK}
+	addr := api.start(t)
+
+	_, err := RestoreBackupWith(addr, bytes.NewReader(api.snapshot), TransferOptions{Force: true})
+	if err == nil || !strings.Contains(err.Error(), "newer than") {
+		t.Fatalf("restore of a newer format = %v", err)
+	}
+	if api.restored != nil {
+		t.Fatal("a backup in a newer format was sent")
+	}
+}
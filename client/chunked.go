@@ -0,0 +1,206 @@
+package client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+)
+
+// chunkSize is the number of value bytes sent per CHUNK frame. Set switches
+// to a chunked upload for values larger than this.
+const chunkSize = 32 * 1024
+
+// sendChunks streams size bytes from r as CHUNK frames after the server has
+// accepted a chunked SET, returning the server's response to the final frame
+func sendChunks(send func(Command) (*Response, error), r io.Reader, size int64) (*Response, error) {
+	buf := make([]byte, chunkSize)
+	var sent int64
+	for {
+		n := min(int64(chunkSize), size-sent)
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			// An early final frame makes the server discard the partial value
+			send(Command{Op: "CHUNK", Final: true})
+			return nil, fmt.Errorf("failed to read value: %w", err)
+		}
+		sent += n
+
+		resp, err := send(Command{
+			Op:    "CHUNK",
+			Value: base64.StdEncoding.EncodeToString(buf[:n]),
+			Final: sent == size,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Status != "success" || sent == size {
+			return resp, nil
+		}
+	}
+}
+
+// receiveChunks writes the value of a GET reply to w, reading the frames that
+// follow when the server sent it in chunks
+func receiveChunks(read func() (*Response, error), header *Response, w io.Writer) (int64, error) {
+	if !header.Chunked {
+		n, err := io.WriteString(w, header.Value)
+		return int64(n), err
+	}
+
+	// Keep reading after a failed write so the connection stays usable
+	var written int64
+	var writeErr error
+	for {
+		frame, err := read()
+		if err != nil {
+			return written, err
+		}
+
+		data, err := base64.StdEncoding.DecodeString(frame.Value)
+		if err != nil {
+			return written, fmt.Errorf("invalid chunk encoding: %w", err)
+		}
+
+		if writeErr == nil {
+			var n int
+			n, writeErr = w.Write(data)
+			written += int64(n)
+		}
+
+		if frame.Final {
+			break
+		}
+	}
+
+	if writeErr != nil {
+		return written, writeErr
+	}
+	if written != header.Size {
+		return written, fmt.Errorf("received %d of %d bytes", written, header.Size)
+	}
+	return written, nil
+}
+
+// SetReader stores size bytes read from r under key, sending them in chunks
+// so large values never travel as a single message
+func (c *Client) SetReader(key string, r io.Reader, size int64, expiresIn time.Duration) error {
+	cmd := Command{
+		Op:        "SET",
+		Key:       key,
+		ExpiresIn: expiresIn,
+		Chunked:   true,
+		Size:      size,
+	}
+
+	resp, err := c.sendCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	resp, err = sendChunks(c.sendCommand, r, size)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+// GetWriter writes the value stored under key to w, returning the number of
+// bytes written and the key's TTL. Large values are streamed in chunks, and
+// small ones are sent base64 encoded so binary values arrive intact.
+func (c *Client) GetWriter(key string, w io.Writer) (int64, time.Duration, error) {
+	cmd := Command{
+		Op:       "GET",
+		Key:      key,
+		Chunked:  true,
+		Encoding: encodingBase64,
+	}
+
+	resp, err := c.sendCommand(cmd)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, 0, responseError(resp)
+	}
+
+	n, err := receiveChunks(c.readResponse, resp, w)
+	return n, resp.TTL, err
+}
+
+// SetReader stores size bytes read from r under key, sending them in chunks.
+// Redirects are followed before any data is read from r; losing leadership
+// mid-upload fails the write.
+func (c *RaftClient) SetReader(key string, r io.Reader, size int64, expiresIn time.Duration) error {
+	cmd := Command{
+		Op:        "SET",
+		Key:       key,
+		ExpiresIn: expiresIn,
+		Chunked:   true,
+		Size:      size,
+	}
+
+	for retry := 0; retry <= c.maxRetries; retry++ {
+		resp, err := c.sendCommand(cmd)
+		if err != nil {
+			return err
+		}
+
+		if newAddr := leaderAddress(resp); newAddr != "" && newAddr != c.serverAddr {
+			if err := c.reconnectToServer(newAddr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if resp.Status != "success" {
+			return responseError(resp)
+		}
+
+		resp, err = sendChunks(c.sendCommand, r, size)
+		if err != nil {
+			return err
+		}
+
+		if resp.Status != "success" {
+			return responseError(resp)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("max retries reached")
+}
+
+// GetWriter writes the value stored under key to w, returning the number of
+// bytes written and the key's TTL. Large values are streamed in chunks, and
+// small ones are sent base64 encoded so binary values arrive intact.
+func (c *RaftClient) GetWriter(key string, w io.Writer) (int64, time.Duration, error) {
+	cmd := Command{
+		Op:       "GET",
+		Key:      key,
+		Chunked:  true,
+		Encoding: encodingBase64,
+	}
+
+	resp, err := c.sendRead(cmd)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, 0, responseError(resp)
+	}
+
+	n, err := receiveChunks(c.readResponse, resp, w)
+	return n, resp.TTL, err
+}
@@ -2,40 +2,167 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net"
+	"strings"
 	"time"
+
+	"github.com/pixperk/yakvs/version"
+	"github.com/pixperk/yakvs/wire"
+)
+
+// Logf receives client warnings, such as connecting to a server newer than
+// this client. Replace it to route warnings elsewhere or silence them.
+var Logf = log.Printf
+
+// NoExpiry is the TTL reported for keys that never expire. Passing a zero
+// expiresIn to Set stores such a key.
+const NoExpiry time.Duration = -1
+
+// Read consistencies for RaftClient.SetReadConsistency
+const (
+	ConsistencyDefault      = "default"      // whatever the server is configured with
+	ConsistencyStale        = "stale"        // the connected node's state, which may lag
+	ConsistencyLinearizable = "linearizable" // every write committed before the read
 )
 
+// KV is the set of key-value operations shared by Client, RaftClient and
+// GRPCClient
+type KV interface {
+	Set(key, value string, expiresIn time.Duration) error
+	Get(key string) (string, time.Duration, error)
+	Delete(key string) error
+	TTL(key string) (time.Duration, error)
+	Expire(key string, expiresIn time.Duration) (bool, error)
+	Scan(cursor, pattern string, count int) (KeyPage, error)
+	MSetEntries(entries []Entry) (map[string]uint64, error)
+	Subscribe(ctx context.Context, pattern string) (<-chan Event, error)
+	Close() error
+}
+
 type Client struct {
-	conn   net.Conn
-	reader *bufio.Reader
+	conn       net.Conn
+	reader     *bufio.Reader
+	serverAddr string
+	tlsConfig  *tls.Config // nil for plain TCP
+	auth       *login      // set by Auth
+	name       string      // set by Hello
+	codec      wire.Codec  // how messages are framed, JSON until UseMessagePack
 }
 
 type Command struct {
-	Op        string        `json:"op"`
+	Op        string            `json:"op"`
+	Key       string            `json:"key"`
+	Keys      []string          `json:"keys,omitempty"`
+	Pairs     map[string]string `json:"pairs,omitempty"`
+	Value     string            `json:"value,omitempty"`
+	ExpiresIn time.Duration     `json:"expires_in,omitempty"` // zero means no expiry, see ExpiresInMs
+	Count     int               `json:"count,omitempty"`
+	End       string            `json:"end,omitempty"`
+	Elements  []string          `json:"elements,omitempty"`
+	Start     int               `json:"start,omitempty"`
+	Stop      int               `json:"stop,omitempty"`
+	Confirm   bool              `json:"confirm,omitempty"`
+	Cursor    string            `json:"cursor,omitempty"`
+	Protocol  int               `json:"protocol,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Framing   string            `json:"framing,omitempty"`
+	Job       uint64            `json:"job,omitempty"`
+	Encoding  string            `json:"encoding,omitempty"` // "base64" when values are encoded
+	Expected  string            `json:"expected,omitempty"`
+	Delta     int64             `json:"delta,omitempty"`
+	Chunked   bool              `json:"chunked,omitempty"`
+	Size      int64             `json:"size,omitempty"`
+	Final     bool              `json:"final,omitempty"`
+
+	// ExpiresInMs carries ExpiresIn in milliseconds, filled in as the
+	// command is sent. ExpiresIn goes along in nanoseconds for servers
+	// before protocol 6, for one release.
+	ExpiresInMs int64 `json:"expires_in_ms,omitempty"`
+
+	// Consistency is the consistency a raft node serves a read at, one of
+	// the Consistency constants
+	Consistency string `json:"consistency,omitempty"`
+
+	// Entries are further keys of an MSET, each with its own expiry
+	Entries []Entry `json:"entries,omitempty"`
+
+	// NoForward asks a raft follower to redirect a write instead of
+	// forwarding it to the leader, for clients that follow redirects
+	NoForward bool `json:"no_forward,omitempty"`
+}
+
+// Entry is one key of MSetEntries, expiring after ExpiresIn, or never if it
+// is zero
+type Entry struct {
 	Key       string        `json:"key"`
-	Value     string        `json:"value,omitempty"`
-	ExpiresIn time.Duration `json:"expires_in,omitempty"`
+	Value     string        `json:"value"`
+	ExpiresIn time.Duration `json:"-"`
+
+	// ExpiresInMs carries ExpiresIn in milliseconds, filled in as the
+	// command is sent
+	ExpiresInMs int64 `json:"expires_in_ms,omitempty"`
 }
 
 type Response struct {
-	Status  string        `json:"status"`
-	Message string        `json:"message,omitempty"`
-	Value   string        `json:"value,omitempty"`
-	TTL     time.Duration `json:"ttl,omitempty"`
+	Status   string            `json:"status"`
+	Code     string            `json:"code,omitempty"`
+	Message  string            `json:"message,omitempty"`
+	Leader   string            `json:"leader,omitempty"`
+	Value    string            `json:"value,omitempty"`
+	Encoding string            `json:"encoding,omitempty"`
+	TTL      time.Duration     `json:"ttl,omitempty"` // set from TTLMs, NoExpiry for keys without expiry
+	Chunked  bool              `json:"chunked,omitempty"`
+	Size     int64             `json:"size,omitempty"`
+	Final    bool              `json:"final,omitempty"`
+	Applied  bool              `json:"applied,omitempty"`
+	Int      int64             `json:"int,omitempty"`
+	Existed  bool              `json:"existed,omitempty"`
+	Exists   bool              `json:"exists,omitempty"`
+	Keys     []string          `json:"keys,omitempty"`
+	Cursor   string            `json:"cursor,omitempty"`
+	Role     string            `json:"role,omitempty"`
+	Values   map[string]string `json:"values,omitempty"`
+	Missing  []string          `json:"missing,omitempty"`
+	Elements []string          `json:"elements,omitempty"`
+
+	Version   uint64    `json:"version,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+
+	// TTLMs is the TTL in milliseconds, -1 for keys without expiry. Servers
+	// before protocol 6 only send ttl, in nanoseconds.
+	TTLMs int64 `json:"ttl_ms,omitempty"`
+
+	// Versions holds the version each key of a raft MSET was given
+	Versions map[string]uint64 `json:"versions,omitempty"`
 }
 
+// NewClient connects to serverAddr. A dns+srv:// address is resolved and
+// each returned server is tried in turn.
 func NewClient(serverAddr string) (*Client, error) {
-	conn, err := net.Dial("tcp", serverAddr)
+	return NewClientWithTLS(serverAddr, nil)
+}
+
+// NewClientWithTLS connects to serverAddr over TLS configured by tlsConfig,
+// or over plain TCP if it is nil. Connections opened later, such as for
+// Subscribe, use the same configuration.
+func NewClientWithTLS(serverAddr string, tlsConfig *tls.Config) (*Client, error) {
+	conn, addr, err := dial(serverAddr, tlsConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server at %s: %w", serverAddr, err)
+		return nil, err
 	}
 
 	return &Client{
-		conn:   conn,
-		reader: bufio.NewReader(conn),
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		serverAddr: addr,
+		tlsConfig:  tlsConfig,
+		codec:      wire.JSON,
 	}, nil
 }
 
@@ -43,7 +170,16 @@ func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
+// ServerAddr returns the address of the server the client is connected to
+func (c *Client) ServerAddr() string {
+	return c.serverAddr
+}
+
 func (c *Client) Set(key, value string, expiresIn time.Duration) error {
+	if len(value) > chunkSize {
+		return c.SetReader(key, strings.NewReader(value), int64(len(value)), expiresIn)
+	}
+
 	cmd := Command{
 		Op:        "SET",
 		Key:       key,
@@ -57,28 +193,21 @@ func (c *Client) Set(key, value string, expiresIn time.Duration) error {
 	}
 
 	if resp.Status != "success" {
-		return fmt.Errorf("server error: %s", resp.Message)
+		return responseError(resp)
 	}
 
 	return nil
 }
 
 func (c *Client) Get(key string) (string, time.Duration, error) {
-	cmd := Command{
-		Op:  "GET",
-		Key: key,
-	}
-
-	resp, err := c.sendCommand(cmd)
+	// Large values arrive in chunks rather than as one giant line
+	var value strings.Builder
+	_, ttl, err := c.GetWriter(key, &value)
 	if err != nil {
 		return "", 0, err
 	}
 
-	if resp.Status != "success" {
-		return "", 0, fmt.Errorf("server error: %s", resp.Message)
-	}
-
-	return resp.Value, resp.TTL, nil
+	return value.String(), ttl, nil
 }
 
 func (c *Client) Delete(key string) error {
@@ -93,7 +222,7 @@ func (c *Client) Delete(key string) error {
 	}
 
 	if resp.Status != "success" {
-		return fmt.Errorf("server error: %s", resp.Message)
+		return responseError(resp)
 	}
 
 	return nil
@@ -111,35 +240,91 @@ func (c *Client) TTL(key string) (time.Duration, error) {
 	}
 
 	if resp.Status != "success" {
-		return 0, fmt.Errorf("server error: %s", resp.Message)
+		return 0, responseError(resp)
 	}
 
 	return resp.TTL, nil
 }
 
+// Status returns the server's role and, for replicas, replication lag
+func (c *Client) Status() (string, error) {
+	cmd := Command{
+		Op: "STATUS",
+	}
+
+	resp, err := c.sendCommand(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Status != "success" {
+		return "", responseError(resp)
+	}
+
+	return resp.Message, nil
+}
+
+// ServerVersion returns the build information of the connected server and
+// warns through Logf if it speaks a newer protocol than this client
+func (c *Client) ServerVersion() (version.Info, error) {
+	cmd := Command{
+		Op: "VERSION",
+	}
+
+	resp, err := c.sendCommand(cmd)
+	if err != nil {
+		return version.Info{}, err
+	}
+
+	if resp.Status != "success" {
+		return version.Info{}, responseError(resp)
+	}
+
+	var info version.Info
+	if err := json.Unmarshal([]byte(resp.Value), &info); err != nil {
+		return version.Info{}, fmt.Errorf("failed to unmarshal version: %w", err)
+	}
+
+	warnIfNewer(c.serverAddr, info)
+	return info, nil
+}
+
+// warnIfNewer logs when a server speaks a protocol this client predates
+func warnIfNewer(addr string, info version.Info) {
+	if info.Protocol > version.Protocol && Logf != nil {
+		Logf("warning: server %s runs %s with protocol %d, this client only knows protocol %d",
+			addr, info.Version, info.Protocol, version.Protocol)
+	}
+}
+
 func (c *Client) sendCommand(cmd Command) (*Response, error) {
-	jsonCmd, err := json.Marshal(cmd)
+	data, err := c.codec.Marshal(encodeCommand(cmd))
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal command: %w", err)
 	}
 
-	jsonCmd = append(jsonCmd, '\n')
-
-	_, err = c.conn.Write(jsonCmd)
+	_, err = c.conn.Write(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send command: %w", err)
 	}
 
-	// Read response
-	line, err := c.reader.ReadString('\n')
+	return c.readResponse()
+}
+
+// readResponse reads one response, such as a frame of a chunked reply
+func (c *Client) readResponse() (*Response, error) {
+	data, err := c.codec.ReadFrame(c.reader, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var resp Response
-	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+	if err := c.codec.Unmarshal(data, &resp); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
+	if err := decodeResponse(&resp); err != nil {
+		return nil, err
+	}
 
 	return &resp, nil
 }
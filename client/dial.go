@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pixperk/yakvs/discovery"
+)
+
+// resolvers caches one resolver per dns+srv:// address so every client in
+// the process shares its last known good set of nodes
+var (
+	resolversMu sync.Mutex
+	resolvers   = make(map[string]*discovery.Resolver)
+)
+
+func resolverFor(addr string) (*discovery.Resolver, error) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+
+	if r, ok := resolvers[addr]; ok {
+		return r, nil
+	}
+
+	r, err := discovery.NewResolver(addr)
+	if err != nil {
+		return nil, err
+	}
+	resolvers[addr] = r
+	return r, nil
+}
+
+// dialAddr connects to one address, over TLS if tlsConfig is set. An address
+// of the form unix:/path names a unix socket.
+func dialAddr(ctx context.Context, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+
+	if tlsConfig == nil {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	dialer := tls.Dialer{Config: tlsConfig}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// dial connects to serverAddr, which may be a dns+srv:// address, and returns
+// the connection along with the address of the node it reached. A nil
+// tlsConfig means plain TCP.
+func dial(serverAddr string, tlsConfig *tls.Config) (net.Conn, string, error) {
+	if !discovery.IsSRV(serverAddr) {
+		conn, err := dialAddr(context.Background(), serverAddr, tlsConfig)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to connect to server at %s: %w", serverAddr, err)
+		}
+		return conn, serverAddr, nil
+	}
+
+	r, err := resolverFor(serverAddr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	addrs, err := r.Addrs(false)
+	if err != nil {
+		return nil, "", err
+	}
+	if conn, addr, err := dialAny(addrs, tlsConfig); err == nil {
+		return conn, addr, nil
+	}
+
+	// Every cached node failed, so the service may have moved
+	addrs, err = r.Addrs(true)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, addr, err := dialAny(addrs, tlsConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to any server of %s: %w", serverAddr, err)
+	}
+	return conn, addr, nil
+}
+
+// dialAny connects to the first reachable address
+func dialAny(addrs []string, tlsConfig *tls.Config) (net.Conn, string, error) {
+	var failed []string
+	for _, addr := range addrs {
+		conn, err := dialAddr(context.Background(), addr, tlsConfig)
+		if err == nil {
+			return conn, addr, nil
+		}
+		failed = append(failed, err.Error())
+	}
+	return nil, "", fmt.Errorf("%s", strings.Join(failed, "; "))
+}
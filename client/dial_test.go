@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/discovery"
+)
+
+// TestDialReresolvesWhenEveryNodeFails points a dns+srv:// address at a node
+// that went away, and checks dial looks the record up again and reaches the
+// node that replaced it
+func TestDialReresolvesWhenEveryNodeFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	live := l.Addr().(*net.TCPAddr)
+
+	gone, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := gone.Addr().(*net.TCPAddr)
+	gone.Close()
+
+	target := dead
+	lookups := 0
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		lookups++
+		return name, []*net.SRV{{Target: "127.0.0.1.", Port: uint16(target.Port)}}, nil
+	}
+
+	const addr = "dns+srv://_yakvs._tcp.dial-test.local"
+	r, err := discovery.NewResolver(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolversMu.Lock()
+	resolvers[addr] = r.WithLookup(lookup).WithRefresh(time.Hour)
+	resolversMu.Unlock()
+	t.Cleanup(func() {
+		resolversMu.Lock()
+		delete(resolvers, addr)
+		resolversMu.Unlock()
+	})
+
+	if _, _, err := dial(addr, nil); err == nil {
+		t.Fatal("dial reached a node that is gone")
+	}
+
+	// The cached answer has not expired, but every node in it failed
+	target = live
+	conn, reached, err := dial(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if reached != live.String() {
+		t.Errorf("dial reached %s, want %s", reached, live)
+	}
+	// The first lookup, then one forced by each dial that failed to
+	// reach every cached node
+	if lookups != 3 {
+		t.Errorf("%d lookups, want 3", lookups)
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// encodingBase64 marks a command or response whose values are base64
+// encoded. JSON strings cannot carry bytes that are not valid UTF-8, so
+// binary values travel encoded.
+const encodingBase64 = "base64"
+
+// encodeCommand fills in the expiry in milliseconds and base64 encodes the
+// values of cmd if any of them is not valid UTF-8 or the command asks for an
+// encoded response
+func encodeCommand(cmd Command) Command {
+	cmd.ExpiresInMs = cmd.ExpiresIn.Milliseconds()
+	if cmd.Entries != nil {
+		entries := make([]Entry, len(cmd.Entries))
+		for i, e := range cmd.Entries {
+			e.ExpiresInMs = e.ExpiresIn.Milliseconds()
+			entries[i] = e
+		}
+		cmd.Entries = entries
+	}
+	if cmd.Encoding == "" && utf8.ValidString(cmd.Value) && utf8.ValidString(cmd.Expected) && validPairs(cmd.Pairs) && validElements(cmd.Elements) && validEntries(cmd.Entries) {
+		return cmd
+	}
+
+	cmd.Encoding = encodingBase64
+	cmd.Value = base64.StdEncoding.EncodeToString([]byte(cmd.Value))
+	cmd.Expected = base64.StdEncoding.EncodeToString([]byte(cmd.Expected))
+	if cmd.Pairs != nil {
+		pairs := make(map[string]string, len(cmd.Pairs))
+		for key, value := range cmd.Pairs {
+			pairs[key] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		cmd.Pairs = pairs
+	}
+	if cmd.Elements != nil {
+		elems := make([]string, len(cmd.Elements))
+		for i, elem := range cmd.Elements {
+			elems[i] = base64.StdEncoding.EncodeToString([]byte(elem))
+		}
+		cmd.Elements = elems
+	}
+	for i, e := range cmd.Entries {
+		cmd.Entries[i].Value = base64.StdEncoding.EncodeToString([]byte(e.Value))
+	}
+	return cmd
+}
+
+func validPairs(pairs map[string]string) bool {
+	for _, value := range pairs {
+		if !utf8.ValidString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func validEntries(entries []Entry) bool {
+	for _, e := range entries {
+		if !utf8.ValidString(e.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func validElements(elems []string) bool {
+	for _, elem := range elems {
+		if !utf8.ValidString(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeResponse sets the TTL from the milliseconds it was sent in and
+// replaces the base64 values of an encoded response with their raw bytes
+func decodeResponse(resp *Response) error {
+	if resp.TTLMs != 0 {
+		resp.TTL = ttlFromMillis(resp.TTLMs)
+	}
+
+	if resp.Encoding != encodingBase64 {
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Value)
+	if err != nil {
+		return fmt.Errorf("invalid base64 value: %w", err)
+	}
+	resp.Value = string(data)
+
+	if resp.Values != nil {
+		values := make(map[string]string, len(resp.Values))
+		for key, value := range resp.Values {
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("invalid base64 value for key %q: %w", key, err)
+			}
+			values[key] = string(data)
+		}
+		resp.Values = values
+	}
+
+	for i, elem := range resp.Elements {
+		data, err := base64.StdEncoding.DecodeString(elem)
+		if err != nil {
+			return fmt.Errorf("invalid base64 element: %w", err)
+		}
+		resp.Elements[i] = string(data)
+	}
+
+	resp.Encoding = ""
+	return nil
+}
+
+// ttlFromMillis converts a TTL in milliseconds, keeping -1 as NoExpiry
+func ttlFromMillis(ms int64) time.Duration {
+	if ms < 0 {
+		return NoExpiry
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// SetBytes stores a binary value under key, expiring after expiresIn, or
+// never if it is zero
+func (c *Client) SetBytes(key string, value []byte, expiresIn time.Duration) error {
+	return c.Set(key, string(value), expiresIn)
+}
+
+// GetBytes returns the binary value stored under key and its remaining TTL
+func (c *Client) GetBytes(key string) ([]byte, time.Duration, error) {
+	var value bytes.Buffer
+	_, ttl, err := c.GetWriter(key, &value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value.Bytes(), ttl, nil
+}
+
+// SetBytes stores a binary value under key, expiring after expiresIn, or
+// never if it is zero
+func (c *RaftClient) SetBytes(key string, value []byte, expiresIn time.Duration) error {
+	return c.Set(key, string(value), expiresIn)
+}
+
+// GetBytes returns the binary value stored under key and its remaining TTL
+func (c *RaftClient) GetBytes(key string) ([]byte, time.Duration, error) {
+	var value bytes.Buffer
+	_, ttl, err := c.GetWriter(key, &value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value.Bytes(), ttl, nil
+}
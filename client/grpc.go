@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/pixperk/yakvs/yakvspb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCClient talks to a server's gRPC listener. It has the same methods as
+// Client for the operations the gRPC service offers and returns the same
+// errors, so either can be used through KV.
+type GRPCClient struct {
+	conn       *grpc.ClientConn
+	api        yakvspb.YakvsClient
+	serverAddr string
+	// authorization is the metadata sent with every call once Auth is
+	// called
+	authorization string
+}
+
+// NewGRPCClient connects to the gRPC listener at serverAddr. The connection
+// is made lazily, so an unreachable server is reported by the first call.
+func NewGRPCClient(serverAddr string) (*GRPCClient, error) {
+	return NewGRPCClientWithTLS(serverAddr, nil)
+}
+
+// NewGRPCClientWithTLS connects to serverAddr over TLS configured by
+// tlsConfig, or over plain HTTP/2 if it is nil
+func NewGRPCClientWithTLS(serverAddr string, tlsConfig *tls.Config) (*GRPCClient, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server at %s: %w", serverAddr, err)
+	}
+
+	return &GRPCClient{
+		conn:       conn,
+		api:        yakvspb.NewYakvsClient(conn),
+		serverAddr: serverAddr,
+	}, nil
+}
+
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// ServerAddr returns the address of the server the client is connected to
+func (c *GRPCClient) ServerAddr() string {
+	return c.serverAddr
+}
+
+// Auth sends the credentials of user with every later call on a server
+// configured with users. Unlike Client.Auth it does not contact the server;
+// wrong credentials fail each call with ErrUnauthenticated.
+func (c *GRPCClient) Auth(user, password string) error {
+	c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	return nil
+}
+
+// context adds the credentials given to Auth to a call's context
+func (c *GRPCClient) context(ctx context.Context) context.Context {
+	if c.authorization == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", c.authorization)
+}
+
+func (c *GRPCClient) Set(key, value string, expiresIn time.Duration) error {
+	_, err := c.api.Set(c.context(context.Background()), &yakvspb.SetRequest{Key: key, Value: []byte(value), TtlMs: expiresIn.Milliseconds()})
+	return grpcError(err)
+}
+
+// MSetEntries stores each entry with its own Set, as the gRPC API has no
+// batch call, so unlike the other clients it is neither atomic nor one round
+// trip. It stops at the first failure and reports no versions.
+func (c *GRPCClient) MSetEntries(entries []Entry) (map[string]uint64, error) {
+	for _, e := range entries {
+		if err := c.Set(e.Key, e.Value, e.ExpiresIn); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (c *GRPCClient) Get(key string) (string, time.Duration, error) {
+	resp, err := c.api.Get(c.context(context.Background()), &yakvspb.GetRequest{Key: key})
+	if err != nil {
+		return "", 0, grpcError(err)
+	}
+	return string(resp.Value), ttlFromMillis(resp.TtlMs), nil
+}
+
+func (c *GRPCClient) Delete(key string) error {
+	_, err := c.api.Delete(c.context(context.Background()), &yakvspb.DeleteRequest{Key: key})
+	return grpcError(err)
+}
+
+func (c *GRPCClient) TTL(key string) (time.Duration, error) {
+	resp, err := c.api.TTL(c.context(context.Background()), &yakvspb.TTLRequest{Key: key})
+	if err != nil {
+		return 0, grpcError(err)
+	}
+	return ttlFromMillis(resp.TtlMs), nil
+}
+
+// Expire sets key to expire after expiresIn, or removes its expiry if
+// expiresIn is zero. It returns false if the key does not exist.
+func (c *GRPCClient) Expire(key string, expiresIn time.Duration) (bool, error) {
+	resp, err := c.api.Expire(c.context(context.Background()), &yakvspb.ExpireRequest{Key: key, TtlMs: expiresIn.Milliseconds()})
+	if err != nil {
+		return false, grpcError(err)
+	}
+	return resp.Applied, nil
+}
+
+// Scan lists up to count keys matching the glob pattern, starting at
+// cursor. See Client.Scan.
+func (c *GRPCClient) Scan(cursor, pattern string, count int) (KeyPage, error) {
+	resp, err := c.api.Scan(c.context(context.Background()), &yakvspb.ScanRequest{Cursor: cursor, Pattern: pattern, Count: int32(count)})
+	if err != nil {
+		return KeyPage{}, grpcError(err)
+	}
+	return KeyPage{Keys: resp.Keys, Cursor: resp.Cursor}, nil
+}
+
+// Subscribe streams changes to keys matching pattern through the Watch call.
+// See Client.Subscribe.
+func (c *GRPCClient) Subscribe(ctx context.Context, pattern string) (<-chan Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.api.Watch(c.context(ctx), &yakvspb.WatchRequest{Pattern: pattern})
+	if err != nil {
+		cancel()
+		return nil, grpcError(err)
+	}
+
+	// The server sends its headers once the watch is in place, and fails
+	// the call without any for a bad pattern
+	md, err := stream.Header()
+	if err == nil && md == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		cancel()
+		return nil, grpcError(err)
+	}
+
+	events := make(chan Event, 64)
+	go func() {
+		defer cancel()
+		defer close(events)
+
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				return
+			}
+
+			select {
+			case events <- Event{
+				Event:   ev.Event,
+				Key:     ev.Key,
+				Value:   string(ev.Value),
+				Version: ev.Version,
+				TS:      ev.Ts,
+				Lost:    int(ev.Lost),
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// grpcError converts a failed call into the error Client would return for
+// the same failure, using the code the server puts in the status details
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == "yakvs" {
+			return responseError(&Response{Status: "error", Code: info.Reason, Message: st.Message(), Leader: info.Metadata["leader"]})
+		}
+	}
+	return fmt.Errorf("grpc error: %w", err)
+}
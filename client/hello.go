@@ -0,0 +1,178 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pixperk/yakvs/version"
+	"github.com/pixperk/yakvs/wire"
+)
+
+// Hello is a server's reply to HELLO. Protocol is the revision agreed for
+// the connection, the newest one both sides speak.
+type Hello struct {
+	Version      string   `json:"version"`
+	Protocol     int      `json:"protocol"`
+	MinProtocol  int      `json:"min_protocol"`
+	MaxProtocol  int      `json:"max_protocol"`
+	AuthRequired bool     `json:"auth_required"`
+	Features     []string `json:"features"` // such as "pipelining" or "binary_values"
+	Framing      string   `json:"framing"`  // "json" or "msgpack", empty from servers before it
+}
+
+// HasFeature reports whether the server lists feature
+func (h Hello) HasFeature(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientInfo describes a connection listed by CLIENT LIST
+type ClientInfo struct {
+	ID          uint64    `json:"id"`
+	Addr        string    `json:"addr"`
+	Name        string    `json:"name,omitempty"`
+	Protocol    int       `json:"protocol"`
+	Framing     string    `json:"framing,omitempty"` // set if the client sent HELLO
+	User        string    `json:"user,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Hello agrees on a protocol revision with the server and names the
+// connection, which CLIENT LIST and INFO then report. It may be sent before
+// Auth. Servers that predate HELLO are described from their VERSION reply,
+// with AuthRequired and Features left empty.
+func (c *Client) Hello(name string) (Hello, error) {
+	h, err := hello(c.sendCommand, name, "")
+	if err != nil {
+		return Hello{}, err
+	}
+	c.name = name
+	warnIfNewer(c.serverAddr, version.Info{Version: h.Version, Protocol: h.MaxProtocol})
+	return h, nil
+}
+
+// Hello agrees on a protocol revision with the connected node and names the
+// connection. The client repeats it whenever it follows a redirect to
+// another node.
+func (c *RaftClient) Hello(name string) (Hello, error) {
+	h, err := hello(c.sendCommand, name, "")
+	if err != nil {
+		return Hello{}, err
+	}
+	c.name = name
+	warnIfNewer(c.serverAddr, version.Info{Version: h.Version, Protocol: h.MaxProtocol})
+	return h, nil
+}
+
+func hello(send func(Command) (*Response, error), name, framing string) (Hello, error) {
+	resp, err := send(Command{Op: "HELLO", Protocol: version.Protocol, Name: name, Framing: framing})
+	if err != nil {
+		return Hello{}, err
+	}
+
+	if resp.Status == "error" && resp.Message == "Unknown command" {
+		return helloFromVersion(send)
+	}
+	if resp.Status != "success" {
+		return Hello{}, responseError(resp)
+	}
+
+	var h Hello
+	if err := json.Unmarshal([]byte(resp.Value), &h); err != nil {
+		return Hello{}, fmt.Errorf("failed to unmarshal hello: %w", err)
+	}
+
+	return h, nil
+}
+
+// helloFromVersion describes a server without HELLO, which speaks its own
+// protocol revision whatever the client's
+func helloFromVersion(send func(Command) (*Response, error)) (Hello, error) {
+	resp, err := send(Command{Op: "VERSION"})
+	if err != nil {
+		return Hello{}, err
+	}
+
+	if resp.Status != "success" {
+		return Hello{}, responseError(resp)
+	}
+
+	var info version.Info
+	if err := json.Unmarshal([]byte(resp.Value), &info); err != nil {
+		return Hello{}, fmt.Errorf("failed to unmarshal version: %w", err)
+	}
+
+	return Hello{
+		Version:     info.Version,
+		Protocol:    info.Protocol,
+		MinProtocol: info.Protocol,
+		MaxProtocol: info.Protocol,
+	}, nil
+}
+
+// UseMessagePack switches the connection to length-prefixed MessagePack,
+// which costs less to encode and decode than JSON, repeating the name given
+// to Hello. It fails, leaving the connection on JSON, if the server does not
+// support it. Subscriptions open connections of their own and stay on JSON.
+func (c *Client) UseMessagePack() error {
+	if err := useMessagePack(c.sendCommand, c.name); err != nil {
+		return err
+	}
+	c.codec = wire.MsgPack
+	return nil
+}
+
+// UseMessagePack switches the connection to length-prefixed MessagePack.
+// The client switches again whenever it follows a redirect to another node.
+// See Client.UseMessagePack.
+func (c *RaftClient) UseMessagePack() error {
+	if err := useMessagePack(c.sendCommand, c.name); err != nil {
+		return err
+	}
+	c.codec = wire.MsgPack
+	return nil
+}
+
+func useMessagePack(send func(Command) (*Response, error), name string) error {
+	h, err := hello(send, name, wire.MsgPack.Name())
+	if err != nil {
+		return err
+	}
+	if h.Framing != wire.MsgPack.Name() {
+		return fmt.Errorf("server %s does not support MessagePack framing", h.Version)
+	}
+	return nil
+}
+
+func clientList(send func(Command) (*Response, error)) ([]ClientInfo, error) {
+	resp, err := send(Command{Op: "CLIENT LIST"})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	var clients []ClientInfo
+	if err := json.Unmarshal([]byte(resp.Value), &clients); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal client list: %w", err)
+	}
+
+	return clients, nil
+}
+
+// ClientList returns the server's open connections, oldest first
+func (c *Client) ClientList() ([]ClientInfo, error) {
+	return clientList(c.sendCommand)
+}
+
+// ClientList returns the connected node's open connections, oldest first
+func (c *RaftClient) ClientList() ([]ClientInfo, error) {
+	return clientList(c.sendCommand)
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImportOptions controls how Import batches and reports entries
+type ImportOptions struct {
+	// BatchSize is the number of entries a worker writes with one MSET
+	BatchSize int
+	// Parallelism is the number of concurrent connections used for writes
+	Parallelism int
+	// ProgressEvery invokes Progress after this many entries have been written
+	ProgressEvery int
+	// Strict aborts the import on the first malformed line instead of skipping it
+	Strict bool
+
+	// Progress is called periodically with the running totals
+	Progress func(ImportResult)
+	// Invalid is called for every skipped line with its 1-based line number
+	Invalid func(line int, err error)
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Imported int
+	Skipped  int
+	Failed   int
+}
+
+// importEntry is a single line of newline-delimited JSON input
+type importEntry struct {
+	Key       string     `json:"key"`
+	Value     string     `json:"value"`
+	TTL       string     `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type importItem struct {
+	key   string
+	value string
+	ttl   time.Duration
+}
+
+// Import streams newline-delimited JSON entries from r and writes them through
+// connections obtained from dial. Each entry has the form
+// {"key":"k","value":"v","ttl":"300s"} or uses an RFC 3339 "expires_at"
+// instead of "ttl". Malformed lines are skipped and reported unless
+// opts.Strict is set, in which case the first one aborts the import.
+func Import(r io.Reader, dial func() (KV, error), opts ImportOptions) (ImportResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		result  ImportResult
+		written int
+	)
+
+	// Open every connection up front so a bad address fails before any input
+	// is consumed
+	conns := make([]KV, 0, opts.Parallelism)
+	defer func() {
+		for _, kv := range conns {
+			kv.Close()
+		}
+	}()
+	for i := 0; i < opts.Parallelism; i++ {
+		kv, err := dial()
+		if err != nil {
+			return result, err
+		}
+		conns = append(conns, kv)
+	}
+
+	batches := make(chan []importItem, opts.Parallelism)
+	var wg sync.WaitGroup
+	for _, kv := range conns {
+		wg.Add(1)
+		go func(kv KV) {
+			defer wg.Done()
+			for batch := range batches {
+				imported, failed := writeBatch(kv, batch)
+
+				mu.Lock()
+				result.Imported += imported
+				result.Failed += failed
+				before := written
+				written += len(batch)
+				if opts.Progress != nil && opts.ProgressEvery > 0 && written/opts.ProgressEvery > before/opts.ProgressEvery {
+					opts.Progress(result)
+				}
+				mu.Unlock()
+			}
+		}(kv)
+	}
+
+	readErr := readImportEntries(r, opts, batches, func() {
+		mu.Lock()
+		result.Skipped++
+		mu.Unlock()
+	})
+	close(batches)
+	wg.Wait()
+
+	return result, readErr
+}
+
+// writeBatch stores a batch with one MSET and returns how many entries were
+// imported and how many failed. If the MSET fails, for instance because the
+// batch is over the server's size limit, each entry is retried on its own so
+// one bad entry does not fail the others.
+func writeBatch(kv KV, batch []importItem) (imported, failed int) {
+	entries := make([]Entry, len(batch))
+	for i, item := range batch {
+		entries[i] = Entry{Key: item.key, Value: item.value, ExpiresIn: item.ttl}
+	}
+	if _, err := kv.MSetEntries(entries); err == nil {
+		return len(batch), 0
+	}
+
+	for _, item := range batch {
+		if err := kv.Set(item.key, item.value, item.ttl); err != nil {
+			failed++
+		} else {
+			imported++
+		}
+	}
+	return imported, failed
+}
+
+// readImportEntries parses r line by line and sends batches of valid entries
+// on the batches channel
+func readImportEntries(r io.Reader, opts ImportOptions, batches chan<- []importItem, skip func()) error {
+	// bufio.Reader is used instead of a Scanner so long values are not
+	// limited by the scanner's maximum token size
+	reader := bufio.NewReader(r)
+	batch := make([]importItem, 0, opts.BatchSize)
+	lineNum := 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		if line == "" && errors.Is(err, io.EOF) {
+			break
+		}
+		lineNum++
+
+		if strings.TrimSpace(line) != "" {
+			item, parseErr := parseImportEntry(line)
+			if parseErr != nil {
+				if opts.Strict {
+					return fmt.Errorf("line %d: %w", lineNum, parseErr)
+				}
+				skip()
+				if opts.Invalid != nil {
+					opts.Invalid(lineNum, parseErr)
+				}
+			} else {
+				batch = append(batch, item)
+				if len(batch) == opts.BatchSize {
+					batches <- batch
+					batch = make([]importItem, 0, opts.BatchSize)
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	if len(batch) > 0 {
+		batches <- batch
+	}
+	return nil
+}
+
+func parseImportEntry(line string) (importItem, error) {
+	var entry importEntry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return importItem{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if entry.Key == "" {
+		return importItem{}, fmt.Errorf("key is required")
+	}
+
+	item := importItem{key: entry.Key, value: entry.Value}
+	switch {
+	case entry.TTL != "":
+		ttl, err := time.ParseDuration(entry.TTL)
+		if err != nil {
+			return importItem{}, fmt.Errorf("invalid ttl: %w", err)
+		}
+		if ttl <= 0 {
+			return importItem{}, fmt.Errorf("ttl must be positive")
+		}
+		item.ttl = ttl
+	case entry.ExpiresAt != nil:
+		item.ttl = time.Until(*entry.ExpiresAt)
+		if item.ttl <= 0 {
+			return importItem{}, fmt.Errorf("entry already expired at %s", entry.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+
+	return item, nil
+}
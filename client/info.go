@@ -0,0 +1,150 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Info is a server's INFO report, grouped into sections
+type Info struct {
+	Server       ServerInfo              `json:"server"`
+	Store        Stats                   `json:"store"`
+	Commands     map[string]uint64       `json:"commands"` // processed commands by op
+	CommandStats map[string]CommandStats `json:"command_stats"`
+	Raft         *NodeInfo               `json:"raft,omitempty"`
+}
+
+// ServerInfo describes the server process. Role is "primary" or "replica",
+// or "leader" or "follower" on a raft node.
+type ServerInfo struct {
+	Version                 string         `json:"version"`
+	Address                 string         `json:"address"`
+	Role                    string         `json:"role"`
+	Uptime                  time.Duration  `json:"uptime"`
+	Connections             int            `json:"connections"`
+	SlowConsumerDisconnects uint64         `json:"slow_consumer_disconnects"`
+	OpsPerSec               float64        `json:"ops_per_sec"`       // averaged over the last few seconds
+	Clients                 map[string]int `json:"clients,omitempty"` // connections by the name given to Hello
+}
+
+// CommandStats sums up every outcome of one op since the server started or
+// its latencies were last reset. Errors counts the calls that did not
+// succeed, including redirects.
+type CommandStats struct {
+	Calls     uint64        `json:"calls"`
+	Errors    uint64        `json:"errors"`
+	ErrorRate float64       `json:"error_rate"`
+	P50       time.Duration `json:"p50"`
+	P95       time.Duration `json:"p95"`
+	P99       time.Duration `json:"p99"`
+	Max       time.Duration `json:"max"`
+}
+
+// NodeInfo is a raft node's view of the cluster. Leader is empty while no
+// leader is known.
+type NodeInfo struct {
+	State        string `json:"state"`
+	Term         uint64 `json:"term"`
+	Leader       string `json:"leader"`
+	LastIndex    uint64 `json:"last_index"`
+	AppliedIndex uint64 `json:"applied_index"`
+}
+
+// NodeStatus is a raft node's STATUS report
+type NodeStatus struct {
+	NodeID                  string `json:"node_id"`
+	ClusterID               string `json:"cluster_id"` // empty until the cluster elected a leader
+	Role                    string `json:"role"`       // "leader" or "follower"
+	State                   string `json:"state"`      // Leader, Follower, Candidate or Shutdown
+	Version                 string `json:"version"`
+	Term                    uint64 `json:"term"`
+	LastIndex               uint64 `json:"last_index"`
+	CommitIndex             uint64 `json:"commit_index"`
+	AppliedIndex            uint64 `json:"applied_index"`
+	LeaderID                string `json:"leader_id"`   // empty while no leader is known
+	LeaderAddr              string `json:"leader_addr"` // the leader's client address
+	Peers                   []Peer `json:"peers"`       // every member, this node included
+	SlowConsumerDisconnects uint64 `json:"slow_consumer_disconnects"`
+
+	// Raft is unset by servers before raft stats were reported
+	Raft RaftStats `json:"raft"`
+}
+
+// RaftStats is hashicorp/raft's view of a node. Stats holds raft's own
+// stats, such as last_log_index and fsm_pending, numbers decoding as
+// float64. CommitLag and ApplyLag are how many entries the node has yet to
+// commit and apply.
+type RaftStats struct {
+	Stats     map[string]any `json:"stats"`
+	CommitLag uint64         `json:"commit_lag"`
+	ApplyLag  uint64         `json:"apply_lag"`
+	Followers []FollowerLag  `json:"followers"` // only reported by the leader
+
+	// LastSnapshot is the latest snapshot the node wrote since it started,
+	// nil if none
+	LastSnapshot *SnapshotStats `json:"last_snapshot"`
+}
+
+// SnapshotStats describes a raft snapshot a node wrote. Size is its JSON in
+// bytes and CompressedSize what it took on disk, at gzip level Compression
+// or -1 if uncompressed.
+type SnapshotStats struct {
+	ID             string    `json:"id"`
+	Size           int64     `json:"size"`
+	CompressedSize int64     `json:"compressed_size"`
+	Compression    int       `json:"compression"`
+	DurationMS     int64     `json:"duration_ms"`
+	WrittenAt      time.Time `json:"written_at"`
+}
+
+// FollowerLag is how far a follower's log trails the leader's, as the
+// leader last checked. Error says why the check failed.
+type FollowerLag struct {
+	ID           string    `json:"id"`
+	LastIndex    uint64    `json:"last_index"`
+	AppliedIndex uint64    `json:"applied_index"`
+	Lag          uint64    `json:"lag"`
+	CheckedAt    time.Time `json:"checked_at"`
+	Error        string    `json:"error"`
+}
+
+// Peer is a member of a raft cluster. Suffrage is Voter, Nonvoter or Staging.
+type Peer struct {
+	ID         string `json:"id"`
+	Addr       string `json:"addr"`        // raft address
+	ClientAddr string `json:"client_addr"` // empty if it registered none
+	APIAddr    string `json:"api_addr"`    // empty if it registered none
+	Suffrage   string `json:"suffrage"`
+	Leader     bool   `json:"leader"` // unset by servers before /peers
+}
+
+func info(send func(Command) (*Response, error)) (Info, error) {
+	resp, err := send(Command{Op: "INFO"})
+	if err != nil {
+		return Info{}, err
+	}
+
+	if resp.Status != "success" {
+		return Info{}, responseError(resp)
+	}
+
+	var i Info
+	if err := json.Unmarshal([]byte(resp.Value), &i); err != nil {
+		return Info{}, fmt.Errorf("failed to unmarshal info: %w", err)
+	}
+
+	return i, nil
+}
+
+// Info returns the server's INFO report: its uptime and connections, the
+// store's counters and how many commands of each op it has processed
+func (c *Client) Info() (Info, error) {
+	return info(c.sendCommand)
+}
+
+// Info returns the connected node's INFO report, which also holds its raft
+// state, term and leader
+func (c *RaftClient) Info() (Info, error) {
+	return info(c.sendCommand)
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Job is an admin operation a server runs in the background, started by
+// Compact or BGSave
+type Job struct {
+	ID         uint64    `json:"id"`
+	Kind       string    `json:"kind"`              // "compact" or "bgsave"
+	State      string    `json:"state"`             // "running", "done" or "failed"
+	Message    string    `json:"message,omitempty"` // the outcome once finished
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// Running reports whether the job has yet to finish
+func (j Job) Running() bool {
+	return j.State == "running"
+}
+
+func jobOp(send func(Command) (*Response, error), cmd Command) (Job, error) {
+	resp, err := send(cmd)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if resp.Status != "success" {
+		return Job{}, responseError(resp)
+	}
+
+	var j Job
+	if err := json.Unmarshal([]byte(resp.Value), &j); err != nil {
+		return Job{}, fmt.Errorf("failed to unmarshal job: %w", err)
+	}
+
+	return j, nil
+}
+
+// waitJob polls JOBSTATUS every interval until the job finishes, returning
+// an error carrying its message if it failed
+func waitJob(send func(Command) (*Response, error), id uint64, interval time.Duration) (Job, error) {
+	for {
+		j, err := jobOp(send, Command{Op: "JOBSTATUS", Job: id})
+		if err != nil {
+			return Job{}, err
+		}
+		switch j.State {
+		case "running":
+			time.Sleep(interval)
+		case "failed":
+			return j, fmt.Errorf("%s job %d failed: %s", j.Kind, j.ID, j.Message)
+		default:
+			return j, nil
+		}
+	}
+}
+
+// Compact starts rewriting the server's log to hold only the live keys in
+// the background. JobStatus or WaitJob then report the space reclaimed.
+func (c *Client) Compact() (Job, error) {
+	return jobOp(c.sendCommand, Command{Op: "COMPACT"})
+}
+
+// BGSave starts writing a snapshot to the server's snapshot file in the
+// background
+func (c *Client) BGSave() (Job, error) {
+	return jobOp(c.sendCommand, Command{Op: "BGSAVE"})
+}
+
+// JobStatus reports on a job started by Compact or BGSave. Servers only
+// remember the latest finished jobs.
+func (c *Client) JobStatus(id uint64) (Job, error) {
+	return jobOp(c.sendCommand, Command{Op: "JOBSTATUS", Job: id})
+}
+
+// WaitJob polls a job every interval until it finishes
+func (c *Client) WaitJob(id uint64, interval time.Duration) (Job, error) {
+	return waitJob(c.sendCommand, id, interval)
+}
+
+// Compact starts rewriting the connected node's log to hold only the live
+// keys in the background. Followers compact too, as each node keeps its own
+// log.
+func (c *RaftClient) Compact() (Job, error) {
+	return jobOp(c.sendCommand, Command{Op: "COMPACT"})
+}
+
+// BGSave starts a raft snapshot on the leader in the background. The job
+// belongs to the leader, so poll it through the same client.
+func (c *RaftClient) BGSave() (Job, error) {
+	return jobOp(c.sendWrite, Command{Op: "BGSAVE"})
+}
+
+// JobStatus reports on a job started on the connected node. Jobs belong to
+// the node they were started on.
+func (c *RaftClient) JobStatus(id uint64) (Job, error) {
+	return jobOp(c.sendCommand, Command{Op: "JOBSTATUS", Job: id})
+}
+
+// WaitJob polls a job on the connected node every interval until it
+// finishes
+func (c *RaftClient) WaitJob(id uint64, interval time.Duration) (Job, error) {
+	return waitJob(c.sendCommand, id, interval)
+}
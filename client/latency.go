@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// LatencySummary reports latency quantiles for one command, outcome and
+// stage. Stage is empty for whole commands; raft writes also report the
+// raft_apply and local stages.
+type LatencySummary struct {
+	Op      string        `json:"op"`
+	Outcome string        `json:"outcome,omitempty"`
+	Stage   string        `json:"stage,omitempty"`
+	Count   uint64        `json:"count"`
+	P50     time.Duration `json:"p50"`
+	P95     time.Duration `json:"p95"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+}
+
+func latency(send func(Command) (*Response, error)) ([]LatencySummary, error) {
+	resp, err := send(Command{Op: "LATENCY"})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	var summaries []LatencySummary
+	if err := json.Unmarshal([]byte(resp.Value), &summaries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal latency stats: %w", err)
+	}
+
+	return summaries, nil
+}
+
+func resetLatency(send func(Command) (*Response, error)) error {
+	resp, err := send(Command{Op: "LATENCY RESET"})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+// Latency returns the server's command latency quantiles
+func (c *Client) Latency() ([]LatencySummary, error) {
+	return latency(c.sendCommand)
+}
+
+// ResetLatency clears the server's latency histograms
+func (c *Client) ResetLatency() error {
+	return resetLatency(c.sendCommand)
+}
+
+// Latency returns the connected node's command latency quantiles
+func (c *RaftClient) Latency() ([]LatencySummary, error) {
+	return latency(c.sendCommand)
+}
+
+// ResetLatency clears the connected node's latency histograms
+func (c *RaftClient) ResetLatency() error {
+	return resetLatency(c.sendCommand)
+}
@@ -0,0 +1,128 @@
+package client
+
+func push(send func(Command) (*Response, error), op, key string, elems []string) (int, error) {
+	resp, err := send(Command{Op: op, Key: key, Elements: elems})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return int(resp.Int), nil
+}
+
+func pop(send func(Command) (*Response, error), op, key string, count int) ([]string, error) {
+	resp, err := send(Command{Op: op, Key: key, Count: count, Encoding: encodingBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	return resp.Elements, nil
+}
+
+func llen(send func(Command) (*Response, error), key string) (int, error) {
+	resp, err := send(Command{Op: "LLEN", Key: key})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return int(resp.Int), nil
+}
+
+func lrange(send func(Command) (*Response, error), key string, start, stop int) ([]string, error) {
+	resp, err := send(Command{Op: "LRANGE", Key: key, Start: start, Stop: stop, Encoding: encodingBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	return resp.Elements, nil
+}
+
+// LPush inserts elems at the head of the list stored under key, the last
+// ending up first, and returns its new length. A key holding a plain value
+// returns an error wrapping ErrWrongType.
+func (c *Client) LPush(key string, elems ...string) (int, error) {
+	return push(c.sendCommand, "LPUSH", key, elems)
+}
+
+// RPush appends elems to the tail of the list stored under key and returns
+// its new length
+func (c *Client) RPush(key string, elems ...string) (int, error) {
+	return push(c.sendCommand, "RPUSH", key, elems)
+}
+
+// LPop removes and returns up to count elements from the head of the list
+// stored under key
+func (c *Client) LPop(key string, count int) ([]string, error) {
+	return pop(c.sendCommand, "LPOP", key, count)
+}
+
+// RPop removes and returns up to count elements from the tail of the list
+// stored under key, last element first
+func (c *Client) RPop(key string, count int) ([]string, error) {
+	return pop(c.sendCommand, "RPOP", key, count)
+}
+
+// LLen returns the length of the list stored under key, 0 if it does not
+// exist
+func (c *Client) LLen(key string) (int, error) {
+	return llen(c.sendCommand, key)
+}
+
+// LRange returns the elements of the list stored under key from start to
+// stop inclusive. Negative indexes count from the end, so LRange(key, 0, -1)
+// returns the whole list.
+func (c *Client) LRange(key string, start, stop int) ([]string, error) {
+	return lrange(c.sendCommand, key, start, stop)
+}
+
+// LPush inserts elems at the head of the list stored under key, the last
+// ending up first, and returns its new length. A key holding a plain value
+// returns an error wrapping ErrWrongType.
+func (c *RaftClient) LPush(key string, elems ...string) (int, error) {
+	return push(c.sendWrite, "LPUSH", key, elems)
+}
+
+// RPush appends elems to the tail of the list stored under key and returns
+// its new length
+func (c *RaftClient) RPush(key string, elems ...string) (int, error) {
+	return push(c.sendWrite, "RPUSH", key, elems)
+}
+
+// LPop removes and returns up to count elements from the head of the list
+// stored under key
+func (c *RaftClient) LPop(key string, count int) ([]string, error) {
+	return pop(c.sendWrite, "LPOP", key, count)
+}
+
+// RPop removes and returns up to count elements from the tail of the list
+// stored under key, last element first
+func (c *RaftClient) RPop(key string, count int) ([]string, error) {
+	return pop(c.sendWrite, "RPOP", key, count)
+}
+
+// LLen returns the length of the list stored under key on the connected
+// node, 0 if it does not exist
+func (c *RaftClient) LLen(key string) (int, error) {
+	return llen(c.sendRead, key)
+}
+
+// LRange returns the elements of the list stored under key on the connected
+// node from start to stop inclusive, negative indexes counting from the end
+func (c *RaftClient) LRange(key string, start, stop int) ([]string, error) {
+	return lrange(c.sendRead, key, start, stop)
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MemoryStats summarizes the approximate memory held by a server's entries
+type MemoryStats struct {
+	Entries      int         `json:"entries"`
+	Bytes        int64       `json:"bytes"`
+	ValueBytes   int64       `json:"value_bytes"`
+	AvgValueSize float64     `json:"avg_value_size"`
+	Largest      []KeyMemory `json:"largest,omitempty"`
+}
+
+// KeyMemory is the approximate memory used by one entry
+type KeyMemory struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+}
+
+func memoryUsage(send func(Command) (*Response, error), key string) (int64, error) {
+	cmd := Command{
+		Op:  "MEMORY USAGE",
+		Key: key,
+	}
+
+	resp, err := send(cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return resp.Size, nil
+}
+
+func memoryStats(send func(Command) (*Response, error), top int) (MemoryStats, error) {
+	cmd := Command{
+		Op:    "MEMORY STATS",
+		Count: top,
+	}
+
+	resp, err := send(cmd)
+	if err != nil {
+		return MemoryStats{}, err
+	}
+
+	if resp.Status != "success" {
+		return MemoryStats{}, responseError(resp)
+	}
+
+	var stats MemoryStats
+	if err := json.Unmarshal([]byte(resp.Value), &stats); err != nil {
+		return MemoryStats{}, fmt.Errorf("failed to unmarshal memory stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// MemoryUsage returns the approximate number of bytes key uses on the server
+func (c *Client) MemoryUsage(key string) (int64, error) {
+	return memoryUsage(c.sendCommand, key)
+}
+
+// MemoryStats returns the server's memory totals and its top largest keys.
+// A top of zero uses the server's default.
+func (c *Client) MemoryStats(top int) (MemoryStats, error) {
+	return memoryStats(c.sendCommand, top)
+}
+
+// MemoryUsage returns the approximate number of bytes key uses on the
+// connected node
+func (c *RaftClient) MemoryUsage(key string) (int64, error) {
+	return memoryUsage(c.sendRead, key)
+}
+
+// MemoryStats returns the connected node's memory totals and its top largest
+// keys. A top of zero uses the server's default.
+func (c *RaftClient) MemoryStats(top int) (MemoryStats, error) {
+	return memoryStats(c.sendCommand, top)
+}
@@ -0,0 +1,782 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrKeyNotFound is returned by commands on a key that does not exist or has
+// expired
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrNotLeader is returned by writes sent to a raft follower that could not
+// be redirected to the leader
+var ErrNotLeader = errors.New("not the leader")
+
+// ErrReadOnly is returned by writes sent to a read-only replica
+var ErrReadOnly = errors.New("server is read-only")
+
+// ErrBadRequest is returned for commands the server refuses as malformed or
+// not allowed in the connection's current state
+var ErrBadRequest = errors.New("bad request")
+
+// ErrConflict is returned by CompareAndSwap when the key no longer holds the
+// expected value
+var ErrConflict = errors.New("compare-and-swap conflict")
+
+// ErrTooLarge is returned by writes whose key or value is over the server's
+// size limits
+var ErrTooLarge = errors.New("key or value too large")
+
+// ErrOutOfMemory is returned by writes the server rejects because its data is
+// over its memory limit
+var ErrOutOfMemory = errors.New("server out of memory")
+
+// ErrNotInteger is returned by Incr, Decr and IncrBy when the key holds a
+// value that is not an integer
+var ErrNotInteger = errors.New("value is not an integer")
+
+// ErrWrongType is returned by list commands on a key holding a plain value,
+// and by value commands on a list
+var ErrWrongType = errors.New("wrong type of value")
+
+// ErrUnauthenticated is returned by servers with users to clients that have
+// not logged in with Auth, and by Auth for wrong credentials
+var ErrUnauthenticated = errors.New("not authenticated")
+
+// ErrPermissionDenied is returned for commands or keys the logged in user is
+// not allowed
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Codes of a Response
+const (
+	codeNotFound    = "NOT_FOUND"
+	codeNotLeader   = "NOT_LEADER"
+	codeReadOnly    = "READ_ONLY"
+	codeBadRequest  = "BAD_REQUEST"
+	codeTooLarge    = "TOO_LARGE"
+	codeConflict    = "CONFLICT"
+	codeOutOfMemory = "OUT_OF_MEMORY"
+	codeNotInteger  = "NOT_INTEGER"
+	codeWrongType   = "WRONG_TYPE"
+
+	codeUnauthenticated  = "UNAUTHENTICATED"
+	codePermissionDenied = "PERMISSION_DENIED"
+)
+
+// statusCodes are the codes implied by the statuses of servers that predate
+// codes
+var statusCodes = map[string]string{
+	"redirect":      codeNotLeader,
+	"conflict":      codeConflict,
+	"too_large":     codeTooLarge,
+	"out_of_memory": codeOutOfMemory,
+	"not_integer":   codeNotInteger,
+	"wrong_type":    codeWrongType,
+}
+
+// responseError converts an unsuccessful response into an error wrapping the
+// sentinel for its code, such as ErrKeyNotFound for a missing key, ErrConflict
+// for a CAS conflict or ErrTooLarge for an oversized write. Internal and
+// unknown failures are returned as plain server errors.
+func responseError(resp *Response) error {
+	code := resp.Code
+	if code == "" {
+		code = statusCodes[resp.Status]
+	}
+
+	switch code {
+	case codeNotFound:
+		return ErrKeyNotFound
+	case codeNotLeader:
+		return fmt.Errorf("%w: %s", ErrNotLeader, resp.Message)
+	case codeReadOnly:
+		return fmt.Errorf("%w: %s", ErrReadOnly, resp.Message)
+	case codeBadRequest:
+		return fmt.Errorf("%w: %s", ErrBadRequest, resp.Message)
+	case codeConflict:
+		return fmt.Errorf("%w: %s", ErrConflict, resp.Message)
+	case codeTooLarge:
+		return fmt.Errorf("%w: %s", ErrTooLarge, resp.Message)
+	case codeOutOfMemory:
+		return fmt.Errorf("%w: %s", ErrOutOfMemory, resp.Message)
+	case codeNotInteger:
+		return ErrNotInteger
+	case codeWrongType:
+		return fmt.Errorf("%w: %s", ErrWrongType, resp.Message)
+	case codeUnauthenticated:
+		return fmt.Errorf("%w: %s", ErrUnauthenticated, resp.Message)
+	case codePermissionDenied:
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, resp.Message)
+	}
+	return fmt.Errorf("server error: %s", resp.Message)
+}
+
+func setNX(send func(Command) (*Response, error), key, value string, expiresIn time.Duration) (bool, error) {
+	resp, err := send(Command{Op: "SETNX", Key: key, Value: value, ExpiresIn: expiresIn})
+	if err != nil {
+		return false, err
+	}
+
+	if resp.Status != "success" {
+		return false, responseError(resp)
+	}
+
+	return resp.Applied, nil
+}
+
+func compareAndSwap(send func(Command) (*Response, error), key, expected, value string, expiresIn time.Duration) (uint64, error) {
+	resp, err := send(Command{Op: "CAS", Key: key, Expected: expected, Value: value, ExpiresIn: expiresIn})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return resp.Version, nil
+}
+
+// ValueMeta describes a stored value as returned by GetWithMeta
+type ValueMeta struct {
+	TTL       time.Duration // NoExpiry for keys without expiry
+	Version   uint64        // incremented on every write, starting at 1
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func getWithMeta(send func(Command) (*Response, error), key string) (string, ValueMeta, error) {
+	resp, err := send(Command{Op: "GETMETA", Key: key, Encoding: encodingBase64})
+	if err != nil {
+		return "", ValueMeta{}, err
+	}
+
+	if resp.Status != "success" {
+		return "", ValueMeta{}, responseError(resp)
+	}
+
+	meta := ValueMeta{
+		TTL:       resp.TTL,
+		Version:   resp.Version,
+		CreatedAt: resp.CreatedAt,
+		UpdatedAt: resp.UpdatedAt,
+	}
+	return resp.Value, meta, nil
+}
+
+func incrBy(send func(Command) (*Response, error), key string, delta int64) (int64, error) {
+	resp, err := send(Command{Op: "INCRBY", Key: key, Delta: delta})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return resp.Int, nil
+}
+
+func mSet(send func(Command) (*Response, error), pairs map[string]string, expiresIn time.Duration) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	resp, err := send(Command{Op: "MSET", Pairs: pairs, ExpiresIn: expiresIn})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+func mSetEntries(send func(Command) (*Response, error), entries []Entry) (map[string]uint64, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	resp, err := send(Command{Op: "MSET", Entries: entries})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	return resp.Versions, nil
+}
+
+func mGet(send func(Command) (*Response, error), keys []string) (map[string]string, error) {
+	resp, err := send(Command{Op: "MGET", Keys: keys, Encoding: encodingBase64})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	if resp.Values == nil {
+		return map[string]string{}, nil
+	}
+	return resp.Values, nil
+}
+
+func scanPrefix(send func(Command) (*Response, error), prefix string, limit int) ([]string, error) {
+	resp, err := send(Command{Op: "SCANPREFIX", Key: prefix, Count: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	return resp.Keys, nil
+}
+
+func keysRange(send func(Command) (*Response, error), start, end string, limit int) ([]string, error) {
+	resp, err := send(Command{Op: "KEYS RANGE", Key: start, End: end, Count: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	return resp.Keys, nil
+}
+
+// KeyPage is one reply of KEYS or SCAN
+type KeyPage struct {
+	Keys []string
+	// Cursor continues the listing with Scan, empty once every key has been
+	// seen
+	Cursor string
+	// Role is "leader" or "follower" in a raft node's reply, which is read
+	// from that node and may be stale on a follower
+	Role string
+}
+
+func keyPage(send func(Command) (*Response, error), cmd Command) (KeyPage, error) {
+	resp, err := send(cmd)
+	if err != nil {
+		return KeyPage{}, err
+	}
+
+	if resp.Status != "success" {
+		return KeyPage{}, responseError(resp)
+	}
+
+	return KeyPage{Keys: resp.Keys, Cursor: resp.Cursor, Role: resp.Role}, nil
+}
+
+func randomKeys(send func(Command) (*Response, error), n int) ([]string, error) {
+	resp, err := send(Command{Op: "RANDOMKEY", Count: n})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	return resp.Keys, nil
+}
+
+func randomKey(send func(Command) (*Response, error)) (string, bool, error) {
+	keys, err := randomKeys(send, 1)
+	if err != nil || len(keys) == 0 {
+		return "", false, err
+	}
+	return keys[0], true, nil
+}
+
+func flushAll(send func(Command) (*Response, error)) error {
+	resp, err := send(Command{Op: "FLUSHALL", Confirm: true})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+func deletePrefix(send func(Command) (*Response, error), prefix string) (int, error) {
+	resp, err := send(Command{Op: "DELPREFIX", Key: prefix})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return int(resp.Int), nil
+}
+
+func existsCount(send func(Command) (*Response, error), keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	resp, err := send(Command{Op: "EXISTS", Key: keys[0], Keys: keys[1:]})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return int(resp.Int), nil
+}
+
+func keyType(send func(Command) (*Response, error), key string) (string, error) {
+	resp, err := send(Command{Op: "TYPE", Key: key})
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Status != "success" {
+		return "", responseError(resp)
+	}
+
+	return resp.Value, nil
+}
+
+func ping(send func(Command) (*Response, error)) (time.Duration, error) {
+	start := time.Now()
+	resp, err := send(Command{Op: "PING"})
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+	if resp.Message != "PONG" {
+		return 0, fmt.Errorf("unexpected reply to PING: %q", resp.Message)
+	}
+
+	return rtt, nil
+}
+
+func getSet(send func(Command) (*Response, error), key, value string, expiresIn time.Duration) (string, bool, error) {
+	resp, err := send(Command{Op: "GETSET", Key: key, Value: value, ExpiresIn: expiresIn, Encoding: encodingBase64})
+	if err != nil {
+		return "", false, err
+	}
+
+	if resp.Status != "success" {
+		return "", false, responseError(resp)
+	}
+
+	return resp.Value, resp.Existed, nil
+}
+
+func touch(send func(Command) (*Response, error), key string, expiresIn time.Duration) (bool, error) {
+	resp, err := send(Command{Op: "TOUCH", Key: key, ExpiresIn: expiresIn})
+	if err != nil {
+		return false, err
+	}
+
+	if resp.Status != "success" {
+		return false, responseError(resp)
+	}
+
+	return resp.Applied, nil
+}
+
+func getEx(send func(Command) (*Response, error), key string, expiresIn time.Duration) (string, error) {
+	resp, err := send(Command{Op: "GETEX", Key: key, ExpiresIn: expiresIn, Encoding: encodingBase64})
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Status != "success" {
+		return "", responseError(resp)
+	}
+
+	return resp.Value, nil
+}
+
+func appendValue(send func(Command) (*Response, error), key, suffix string, expiresIn time.Duration) (int, error) {
+	resp, err := send(Command{Op: "APPEND", Key: key, Value: suffix, ExpiresIn: expiresIn})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return int(resp.Int), nil
+}
+
+func expire(send func(Command) (*Response, error), key string, expiresIn time.Duration) (bool, error) {
+	op := "EXPIRE"
+	if expiresIn == 0 {
+		op = "PERSIST"
+	}
+
+	resp, err := send(Command{Op: op, Key: key, ExpiresIn: expiresIn})
+	if err != nil {
+		return false, err
+	}
+
+	if resp.Status != "success" {
+		return false, responseError(resp)
+	}
+
+	return resp.Applied, nil
+}
+
+// SetNX stores value under key only if the key does not exist, returning
+// whether it did. Competing clients can use it as a lock.
+func (c *Client) SetNX(key, value string, expiresIn time.Duration) (bool, error) {
+	return setNX(c.sendCommand, key, value, expiresIn)
+}
+
+// CompareAndSwap stores value under key only if the key still holds
+// expected, or does not exist when expected is empty, and returns the key's
+// new version. Otherwise it returns an error wrapping ErrConflict and the
+// caller should re-read and retry.
+func (c *Client) CompareAndSwap(key, expected, value string, expiresIn time.Duration) (uint64, error) {
+	return compareAndSwap(c.sendCommand, key, expected, value, expiresIn)
+}
+
+// GetWithMeta returns the value stored under key along with its TTL,
+// version and creation and update times
+func (c *Client) GetWithMeta(key string) (string, ValueMeta, error) {
+	return getWithMeta(c.sendCommand, key)
+}
+
+// Incr adds one to the integer stored under key and returns the result. A
+// missing key counts as 0.
+func (c *Client) Incr(key string) (int64, error) {
+	return incrBy(c.sendCommand, key, 1)
+}
+
+// Decr subtracts one from the integer stored under key and returns the result
+func (c *Client) Decr(key string) (int64, error) {
+	return incrBy(c.sendCommand, key, -1)
+}
+
+// IncrBy adds delta to the integer stored under key and returns the result
+func (c *Client) IncrBy(key string, delta int64) (int64, error) {
+	return incrBy(c.sendCommand, key, delta)
+}
+
+// MSet stores every key-value pair in pairs atomically in one round trip,
+// each expiring after expiresIn, or never if it is zero. The batch is sent
+// as one request line, so it must stay under the server's request size limit.
+func (c *Client) MSet(pairs map[string]string, expiresIn time.Duration) error {
+	return mSet(c.sendCommand, pairs, expiresIn)
+}
+
+// MSetEntries stores every entry atomically in one round trip, each with its
+// own expiry. A key given twice takes the last value. A standalone server
+// reports no versions, so the map is nil; it is returned for KV.
+func (c *Client) MSetEntries(entries []Entry) (map[string]uint64, error) {
+	return mSetEntries(c.sendCommand, entries)
+}
+
+// MGet returns the values of the keys that exist in one round trip. Missing
+// keys are left out of the result.
+func (c *Client) MGet(keys ...string) (map[string]string, error) {
+	return mGet(c.sendCommand, keys)
+}
+
+// ScanPrefix returns the keys starting with prefix in sorted order, at most
+// limit of them if it is positive
+func (c *Client) ScanPrefix(prefix string, limit int) ([]string, error) {
+	return scanPrefix(c.sendCommand, prefix, limit)
+}
+
+// KeysRange returns the keys from start up to but not including end in sorted
+// order, at most limit of them if limit is positive. An empty end means no
+// upper bound.
+func (c *Client) KeysRange(start, end string, limit int) ([]string, error) {
+	return keysRange(c.sendCommand, start, end, limit)
+}
+
+// Keys lists the keys matching a glob pattern such as "user:*", in sorted
+// order. The server caps the reply; a page cut short has a Cursor to resume
+// from with Scan and the same pattern.
+func (c *Client) Keys(pattern string) (KeyPage, error) {
+	return keyPage(c.sendCommand, Command{Op: "KEYS", Key: pattern})
+}
+
+// Scan lists about count keys matching pattern, or every key if it is
+// empty, after cursor, in sorted order. Start with an empty cursor and pass
+// each page's Cursor to the next call until it comes back empty. A page may
+// hold fewer keys than asked for, or none, before the end.
+func (c *Client) Scan(cursor, pattern string, count int) (KeyPage, error) {
+	return keyPage(c.sendCommand, Command{Op: "SCAN", Cursor: cursor, Key: pattern, Count: count})
+}
+
+// RandomKey returns a live key chosen uniformly at random, or false if the
+// store is empty
+func (c *Client) RandomKey() (string, bool, error) {
+	return randomKey(c.sendCommand)
+}
+
+// SampleKeys returns up to n distinct live keys chosen uniformly at random
+func (c *Client) SampleKeys(n int) ([]string, error) {
+	return randomKeys(c.sendCommand, n)
+}
+
+// DeletePrefix removes every key starting with prefix and returns how many
+// were removed. The prefix must not be empty.
+func (c *Client) DeletePrefix(prefix string) (int, error) {
+	return deletePrefix(c.sendCommand, prefix)
+}
+
+// FlushAll removes every key on the server and empties its log
+func (c *Client) FlushAll() error {
+	return flushAll(c.sendCommand)
+}
+
+// Ping checks that the server is up and answering commands, returning the
+// round-trip time. It works on read-only replicas and raft followers too.
+func (c *Client) Ping() (time.Duration, error) {
+	return ping(c.sendCommand)
+}
+
+// Exists reports whether key is present without fetching its value
+func (c *Client) Exists(key string) (bool, error) {
+	n, err := existsCount(c.sendCommand, []string{key})
+	return n == 1, err
+}
+
+// ExistsCount returns how many of keys are present, in one round trip. A key
+// listed twice is counted twice.
+func (c *Client) ExistsCount(keys ...string) (int, error) {
+	return existsCount(c.sendCommand, keys)
+}
+
+// Type returns "string" or "list" for the kind of value stored under key, or
+// "none" if it is missing or expired
+func (c *Client) Type(key string) (string, error) {
+	return keyType(c.sendCommand, key)
+}
+
+// GetSet stores value under key and returns the value it replaced,
+// reporting whether there was one
+func (c *Client) GetSet(key, value string, expiresIn time.Duration) (string, bool, error) {
+	return getSet(c.sendCommand, key, value, expiresIn)
+}
+
+// Append adds suffix to the end of the value stored under key and returns
+// the new length. A missing key is created expiring after expiresIn, or never
+// if it is zero.
+func (c *Client) Append(key, suffix string, expiresIn time.Duration) (int, error) {
+	return appendValue(c.sendCommand, key, suffix, expiresIn)
+}
+
+// Expire sets key to expire after expiresIn, or never if it is zero. It
+// returns false if the key does not exist.
+func (c *Client) Expire(key string, expiresIn time.Duration) (bool, error) {
+	return expire(c.sendCommand, key, expiresIn)
+}
+
+// Touch resets key's expiry to expiresIn from now, or removes it if
+// expiresIn is zero. It returns false if the key does not exist.
+func (c *Client) Touch(key string, expiresIn time.Duration) (bool, error) {
+	return touch(c.sendCommand, key, expiresIn)
+}
+
+// GetEx returns key's value while resetting its expiry to expiresIn from now,
+// or removing it if expiresIn is zero, so sliding expiries need one round
+// trip and a single write
+func (c *Client) GetEx(key string, expiresIn time.Duration) (string, error) {
+	return getEx(c.sendCommand, key, expiresIn)
+}
+
+// Persist removes key's expiry. It returns false if the key does not exist
+// or has no expiry.
+func (c *Client) Persist(key string) (bool, error) {
+	return expire(c.sendCommand, key, 0)
+}
+
+// SetNX stores value under key only if the key does not exist, returning
+// whether it did. The leader decides, so exactly one competing client wins.
+func (c *RaftClient) SetNX(key, value string, expiresIn time.Duration) (bool, error) {
+	return setNX(c.sendWrite, key, value, expiresIn)
+}
+
+// CompareAndSwap stores value under key only if the key still holds
+// expected, or does not exist when expected is empty, and returns the key's
+// new version. Otherwise it returns an error wrapping ErrConflict and the
+// caller should re-read and retry.
+func (c *RaftClient) CompareAndSwap(key, expected, value string, expiresIn time.Duration) (uint64, error) {
+	return compareAndSwap(c.sendWrite, key, expected, value, expiresIn)
+}
+
+// GetWithMeta returns the value stored under key on the connected node along
+// with its TTL, version and creation and update times
+func (c *RaftClient) GetWithMeta(key string) (string, ValueMeta, error) {
+	return getWithMeta(c.sendRead, key)
+}
+
+// Incr adds one to the integer stored under key and returns the result. A
+// missing key counts as 0.
+func (c *RaftClient) Incr(key string) (int64, error) {
+	return incrBy(c.sendWrite, key, 1)
+}
+
+// Decr subtracts one from the integer stored under key and returns the result
+func (c *RaftClient) Decr(key string) (int64, error) {
+	return incrBy(c.sendWrite, key, -1)
+}
+
+// IncrBy adds delta to the integer stored under key and returns the result
+func (c *RaftClient) IncrBy(key string, delta int64) (int64, error) {
+	return incrBy(c.sendWrite, key, delta)
+}
+
+// MSet stores every key-value pair in pairs atomically in one round trip,
+// each expiring after expiresIn, or never if it is zero. The batch is sent
+// as one request line, so it must stay under the server's request size limit.
+func (c *RaftClient) MSet(pairs map[string]string, expiresIn time.Duration) error {
+	return mSet(c.sendWrite, pairs, expiresIn)
+}
+
+// MSetEntries stores every entry as a single raft log entry, each with its
+// own expiry, and returns the version each key was given. A key given twice
+// takes the last value. Nodes refuse batches over their -max-batch-bytes
+// with ErrTooLarge.
+func (c *RaftClient) MSetEntries(entries []Entry) (map[string]uint64, error) {
+	return mSetEntries(c.sendWrite, entries)
+}
+
+// MGet returns the values of the keys that exist in one round trip. Missing
+// keys are left out of the result.
+func (c *RaftClient) MGet(keys ...string) (map[string]string, error) {
+	return mGet(c.sendRead, keys)
+}
+
+// ScanPrefix returns the keys starting with prefix in sorted order, at most
+// limit of them if it is positive
+func (c *RaftClient) ScanPrefix(prefix string, limit int) ([]string, error) {
+	return scanPrefix(c.sendRead, prefix, limit)
+}
+
+// KeysRange returns the keys from start up to but not including end in sorted
+// order, at most limit of them if limit is positive. An empty end means no
+// upper bound.
+func (c *RaftClient) KeysRange(start, end string, limit int) ([]string, error) {
+	return keysRange(c.sendRead, start, end, limit)
+}
+
+// Keys lists the keys matching a glob pattern such as "user:*", in sorted
+// order. The server caps the reply; a page cut short has a Cursor to resume
+// from with Scan and the same pattern.
+func (c *RaftClient) Keys(pattern string) (KeyPage, error) {
+	return keyPage(c.sendRead, Command{Op: "KEYS", Key: pattern})
+}
+
+// Scan lists about count keys matching pattern, or every key if it is
+// empty, after cursor, in sorted order. Start with an empty cursor and pass
+// each page's Cursor to the next call until it comes back empty. A page may
+// hold fewer keys than asked for, or none, before the end.
+func (c *RaftClient) Scan(cursor, pattern string, count int) (KeyPage, error) {
+	return keyPage(c.sendRead, Command{Op: "SCAN", Cursor: cursor, Key: pattern, Count: count})
+}
+
+// RandomKey returns a live key chosen uniformly at random, or false if the
+// store is empty
+func (c *RaftClient) RandomKey() (string, bool, error) {
+	return randomKey(c.sendRead)
+}
+
+// SampleKeys returns up to n distinct live keys chosen uniformly at random
+func (c *RaftClient) SampleKeys(n int) ([]string, error) {
+	return randomKeys(c.sendRead, n)
+}
+
+// DeletePrefix removes every key starting with prefix and returns how many
+// were removed. The prefix must not be empty.
+func (c *RaftClient) DeletePrefix(prefix string) (int, error) {
+	return deletePrefix(c.sendWrite, prefix)
+}
+
+// FlushAll removes every key on all nodes and empties their logs
+func (c *RaftClient) FlushAll() error {
+	return flushAll(c.sendWrite)
+}
+
+// Ping checks that the server is up and answering commands, returning the
+// round-trip time. It works on read-only replicas and raft followers too.
+func (c *RaftClient) Ping() (time.Duration, error) {
+	return ping(c.sendCommand)
+}
+
+// Exists reports whether key is present without fetching its value
+func (c *RaftClient) Exists(key string) (bool, error) {
+	n, err := existsCount(c.sendRead, []string{key})
+	return n == 1, err
+}
+
+// ExistsCount returns how many of keys are present, in one round trip. A key
+// listed twice is counted twice.
+func (c *RaftClient) ExistsCount(keys ...string) (int, error) {
+	return existsCount(c.sendRead, keys)
+}
+
+// Type returns "string" or "list" for the kind of value stored under key, or
+// "none" if it is missing or expired
+func (c *RaftClient) Type(key string) (string, error) {
+	return keyType(c.sendRead, key)
+}
+
+// GetSet stores value under key and returns the value it replaced,
+// reporting whether there was one
+func (c *RaftClient) GetSet(key, value string, expiresIn time.Duration) (string, bool, error) {
+	return getSet(c.sendWrite, key, value, expiresIn)
+}
+
+// Append adds suffix to the end of the value stored under key and returns
+// the new length. A missing key is created expiring after expiresIn, or never
+// if it is zero.
+func (c *RaftClient) Append(key, suffix string, expiresIn time.Duration) (int, error) {
+	return appendValue(c.sendWrite, key, suffix, expiresIn)
+}
+
+// Expire sets key to expire after expiresIn, or never if it is zero. It
+// returns false if the key does not exist.
+func (c *RaftClient) Expire(key string, expiresIn time.Duration) (bool, error) {
+	return expire(c.sendWrite, key, expiresIn)
+}
+
+// Touch resets key's expiry to expiresIn from now, or removes it if
+// expiresIn is zero. It returns false if the key does not exist.
+func (c *RaftClient) Touch(key string, expiresIn time.Duration) (bool, error) {
+	return touch(c.sendWrite, key, expiresIn)
+}
+
+// GetEx returns key's value while resetting its expiry to expiresIn from now,
+// or removing it if expiresIn is zero, so sliding expiries need one round
+// trip and a single write
+func (c *RaftClient) GetEx(key string, expiresIn time.Duration) (string, error) {
+	return getEx(c.sendWrite, key, expiresIn)
+}
+
+// Persist removes key's expiry. It returns false if the key does not exist
+// or has no expiry.
+func (c *RaftClient) Persist(key string) (bool, error) {
+	return expire(c.sendWrite, key, 0)
+}
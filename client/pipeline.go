@@ -0,0 +1,183 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pixperk/yakvs/wire"
+)
+
+// Pipeline queues commands that Exec sends as one request message, saving a
+// round trip per command. Unlike a Txn the commands are not atomic: each one
+// succeeds or fails on its own. The whole pipeline travels as one message, so
+// it must stay under the server's request size limit.
+type Pipeline struct {
+	cmds []Command
+	send func([]Command) ([]Response, error)
+}
+
+// PipelineResult is the outcome of one pipelined command. Err is nil if the
+// command succeeded, and Response holds what it returned, such as the Value
+// of a GET or the Int of an INCRBY.
+type PipelineResult struct {
+	Response Response
+	Err      error
+}
+
+// Set queues storing value under key, expiring after expiresIn, or never if
+// it is zero
+func (p *Pipeline) Set(key, value string, expiresIn time.Duration) *Pipeline {
+	p.cmds = append(p.cmds, Command{Op: "SET", Key: key, Value: value, ExpiresIn: expiresIn})
+	return p
+}
+
+// Get queues reading the value and TTL of key
+func (p *Pipeline) Get(key string) *Pipeline {
+	p.cmds = append(p.cmds, Command{Op: "GET", Key: key, Encoding: encodingBase64})
+	return p
+}
+
+// Delete queues removing key
+func (p *Pipeline) Delete(key string) *Pipeline {
+	p.cmds = append(p.cmds, Command{Op: "DELETE", Key: key})
+	return p
+}
+
+// IncrBy queues adding delta to the integer stored under key
+func (p *Pipeline) IncrBy(key string, delta int64) *Pipeline {
+	p.cmds = append(p.cmds, Command{Op: "INCRBY", Key: key, Delta: delta})
+	return p
+}
+
+// Expire queues setting key to expire after expiresIn, or never if it is zero
+func (p *Pipeline) Expire(key string, expiresIn time.Duration) *Pipeline {
+	p.cmds = append(p.cmds, Command{Op: "EXPIRE", Key: key, ExpiresIn: expiresIn})
+	return p
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec sends the queued commands and returns one result per command, in the
+// order they were queued, leaving the pipeline empty for reuse. The error is
+// only set if the pipeline as a whole failed, such as on a network error.
+func (p *Pipeline) Exec() ([]PipelineResult, error) {
+	if len(p.cmds) == 0 {
+		return nil, nil
+	}
+
+	cmds := p.cmds
+	p.cmds = nil
+	resps, err := p.send(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PipelineResult, len(resps))
+	for i := range resps {
+		results[i].Response = resps[i]
+		if resps[i].Status != "success" {
+			results[i].Err = responseError(&resps[i])
+		}
+	}
+	return results, nil
+}
+
+// sendPipeline writes cmds as one array message and reads the array of
+// responses. A single response in reply means the server rejected the whole
+// pipeline.
+func sendPipeline(w io.Writer, r *bufio.Reader, codec wire.Codec, cmds []Command) ([]Response, error) {
+	encoded := make([]Command, len(cmds))
+	for i, cmd := range cmds {
+		encoded[i] = encodeCommand(cmd)
+	}
+
+	data, err := codec.Marshal(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pipeline: %w", err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to send pipeline: %w", err)
+	}
+
+	data, err = codec.ReadFrame(r, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if !codec.IsBatch(data) {
+		var resp Response
+		if err := codec.Unmarshal(data, &resp); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		return nil, responseError(&resp)
+	}
+
+	var resps []Response
+	if err := codec.Unmarshal(data, &resps); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal responses: %w", err)
+	}
+	if len(resps) != len(cmds) {
+		return nil, fmt.Errorf("got %d responses to %d pipelined commands", len(resps), len(cmds))
+	}
+	for i := range resps {
+		if err := decodeResponse(&resps[i]); err != nil {
+			return nil, err
+		}
+	}
+	return resps, nil
+}
+
+// Pipeline starts a pipeline
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{
+		send: func(cmds []Command) ([]Response, error) {
+			return sendPipeline(c.conn, c.reader, c.codec, cmds)
+		},
+	}
+}
+
+// Pipeline starts a pipeline, whose reads go at the client's read
+// consistency. If any command is redirected the whole pipeline is sent again
+// to the leader: followers are asked not to forward writes, so they apply
+// none and only the reads run twice.
+func (c *RaftClient) Pipeline() *Pipeline {
+	return &Pipeline{
+		send: func(cmds []Command) ([]Response, error) {
+			for i := range cmds {
+				if cmds[i].Consistency == "" {
+					cmds[i].Consistency = c.readConsistency
+				}
+				cmds[i].NoForward = true
+			}
+			for retry := 0; retry <= c.maxRetries; retry++ {
+				resps, err := sendPipeline(c.conn, c.reader, c.codec, cmds)
+				if err != nil {
+					return nil, err
+				}
+
+				newAddr := ""
+				for i := range resps {
+					if newAddr = leaderAddress(&resps[i]); newAddr != "" {
+						break
+					}
+				}
+				if newAddr != "" && newAddr != c.serverAddr {
+					if err := c.reconnectToServer(newAddr); err != nil {
+						return nil, err
+					}
+					continue
+				}
+
+				return resps, nil
+			}
+
+			return nil, fmt.Errorf("max retries reached")
+		},
+	}
+}
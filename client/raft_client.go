@@ -2,31 +2,57 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
 	"time"
+
+	"github.com/pixperk/yakvs/discovery"
+	"github.com/pixperk/yakvs/version"
+	"github.com/pixperk/yakvs/wire"
 )
 
 type RaftClient struct {
 	conn       net.Conn
 	reader     *bufio.Reader
 	serverAddr string
+	seedAddr   string      // address the client was created with, possibly dns+srv://
+	tlsConfig  *tls.Config // nil for plain TCP
+	auth       *login      // set by Auth
+	name       string      // set by Hello
+	codec      wire.Codec  // how messages are framed, JSON until UseMessagePack
 	maxRetries int
 	retryDelay time.Duration
+
+	readConsistency string // set by SetReadConsistency
 }
 
+// NewRaftClient connects to any node of the cluster. A dns+srv:// address is
+// resolved and each returned node is tried in turn.
 func NewRaftClient(serverAddr string) (*RaftClient, error) {
-	conn, err := net.Dial("tcp", serverAddr)
+	return NewRaftClientWithTLS(serverAddr, nil)
+}
+
+// NewRaftClientWithTLS connects to any node of the cluster over TLS
+// configured by tlsConfig, or over plain TCP if it is nil. Redirects to the
+// leader are followed over TLS as well, so every node's certificate must be
+// valid for the address it is reached at.
+func NewRaftClientWithTLS(serverAddr string, tlsConfig *tls.Config) (*RaftClient, error) {
+	conn, addr, err := dial(serverAddr, tlsConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server at %s: %w", serverAddr, err)
+		return nil, err
 	}
 
 	return &RaftClient{
 		conn:       conn,
 		reader:     bufio.NewReader(conn),
-		serverAddr: serverAddr,
+		serverAddr: addr,
+		seedAddr:   serverAddr,
+		tlsConfig:  tlsConfig,
+		codec:      wire.JSON,
 		maxRetries: 3,
 		retryDelay: 500 * time.Millisecond,
 	}, nil
@@ -36,7 +62,17 @@ func (c *RaftClient) Close() error {
 	return c.conn.Close()
 }
 
+// ServerAddr returns the address of the server the client is currently
+// connected to, which changes when a redirect to the leader is followed
+func (c *RaftClient) ServerAddr() string {
+	return c.serverAddr
+}
+
 func (c *RaftClient) Set(key, value string, expiresIn time.Duration) error {
+	if len(value) > chunkSize {
+		return c.SetReader(key, strings.NewReader(value), int64(len(value)), expiresIn)
+	}
+
 	cmd := Command{
 		Op:        "SET",
 		Key:       key,
@@ -44,76 +80,107 @@ func (c *RaftClient) Set(key, value string, expiresIn time.Duration) error {
 		ExpiresIn: expiresIn,
 	}
 
+	_, err := c.sendWrite(cmd)
+	return err
+}
+
+func (c *RaftClient) Get(key string) (string, time.Duration, error) {
+	// Large values arrive in chunks rather than as one giant line
+	var value strings.Builder
+	_, ttl, err := c.GetWriter(key, &value)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return value.String(), ttl, nil
+}
+
+func (c *RaftClient) Delete(key string) error {
+	cmd := Command{
+		Op:  "DELETE",
+		Key: key,
+	}
+
+	_, err := c.sendWrite(cmd)
+	return err
+}
+
+// sendWrite sends a write, following redirects until it reaches the leader
+// and waiting out elections. A response other than success is returned as an error. Followers are asked
+// not to forward it, so later writes go straight to the leader.
+func (c *RaftClient) sendWrite(cmd Command) (*Response, error) {
+	cmd.NoForward = true
 	for retry := 0; retry <= c.maxRetries; retry++ {
 		resp, err := c.sendCommand(cmd)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if resp.Status == "success" {
-			return nil
-		} else if resp.Status == "redirect" {
-			newAddr := extractServerAddress(resp.Message)
-			if newAddr != "" && newAddr != c.serverAddr {
-				if err := c.reconnectToServer(newAddr); err != nil {
-					return err
-				}
-				continue
+			return resp, nil
+		} else if newAddr := leaderAddress(resp); newAddr != "" && newAddr != c.serverAddr {
+			if err := c.reconnectToServer(newAddr); err != nil {
+				return nil, err
 			}
+			continue
+		} else if c.awaitLeader(resp, retry) {
+			continue
 		}
 
-		return fmt.Errorf("server error: %s", resp.Message)
+		return nil, responseError(resp)
 	}
 
-	return fmt.Errorf("max retries reached")
+	return nil, fmt.Errorf("max retries reached")
 }
 
-func (c *RaftClient) Get(key string) (string, time.Duration, error) {
-	cmd := Command{
-		Op:  "GET",
-		Key: key,
-	}
-
-	resp, err := c.sendCommand(cmd)
-	if err != nil {
-		return "", 0, err
-	}
-
-	if resp.Status != "success" {
-		return "", 0, fmt.Errorf("server error: %s", resp.Message)
-	}
-
-	return resp.Value, resp.TTL, nil
+// SetReadConsistency sets the consistency reads are sent with:
+// ConsistencyStale reads from the connected node, which may lag the leader,
+// ConsistencyLinearizable reads from the leader once it has confirmed it
+// still leads, seeing every write committed before the read, and
+// ConsistencyDefault, the initial setting, leaves it up to the server.
+// Linearizable reads follow redirects to the leader like writes.
+func (c *RaftClient) SetReadConsistency(level string) {
+	c.readConsistency = level
 }
 
-func (c *RaftClient) Delete(key string) error {
-	cmd := Command{
-		Op:  "DELETE",
-		Key: key,
+// sendRead sends a read at the client's read consistency, following
+// redirects to the leader and waiting out elections. Unlike sendWrite it
+// returns responses other than success as they are.
+func (c *RaftClient) sendRead(cmd Command) (*Response, error) {
+	if cmd.Consistency == "" {
+		cmd.Consistency = c.readConsistency
 	}
 
 	for retry := 0; retry <= c.maxRetries; retry++ {
 		resp, err := c.sendCommand(cmd)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if resp.Status == "success" {
-			return nil
-		} else if resp.Status == "redirect" {
-			newAddr := extractServerAddress(resp.Message)
-			if newAddr != "" && newAddr != c.serverAddr {
-				if err := c.reconnectToServer(newAddr); err != nil {
-					return err
-				}
-				continue
+		if newAddr := leaderAddress(resp); newAddr != "" && newAddr != c.serverAddr {
+			if err := c.reconnectToServer(newAddr); err != nil {
+				return nil, err
 			}
+			continue
+		} else if c.awaitLeader(resp, retry) {
+			continue
 		}
 
-		return fmt.Errorf("server error: %s", resp.Message)
+		return resp, nil
 	}
 
-	return fmt.Errorf("max retries reached")
+	return nil, fmt.Errorf("max retries reached")
+}
+
+// awaitLeader waits retryDelay and reports true if resp refused a command
+// for want of a leader to redirect to, as during an election, and retries
+// are left. Such a command was not applied, so it can be sent again.
+func (c *RaftClient) awaitLeader(resp *Response, retry int) bool {
+	if resp.Code != codeNotLeader || retry == c.maxRetries {
+		return false
+	}
+	time.Sleep(c.retryDelay)
+	return true
 }
 
 func (c *RaftClient) TTL(key string) (time.Duration, error) {
@@ -122,40 +189,110 @@ func (c *RaftClient) TTL(key string) (time.Duration, error) {
 		Key: key,
 	}
 
-	resp, err := c.sendCommand(cmd)
+	resp, err := c.sendRead(cmd)
 	if err != nil {
 		return 0, err
 	}
 
 	if resp.Status != "success" {
-		return 0, fmt.Errorf("server error: %s", resp.Message)
+		return 0, responseError(resp)
 	}
 
 	return resp.TTL, nil
 }
 
-func (c *RaftClient) Status() (string, error) {
+// Status returns the connected node's role, raft term and indexes, its
+// view of the leader and the members of the cluster. Servers before the
+// detailed status only report Role and Version.
+func (c *RaftClient) Status() (NodeStatus, error) {
 	cmd := Command{
 		Op: "STATUS",
 	}
 
 	resp, err := c.sendCommand(cmd)
+	if err != nil {
+		return NodeStatus{}, err
+	}
+
+	if resp.Status != "success" {
+		return NodeStatus{}, responseError(resp)
+	}
+
+	if resp.Value == "" {
+		// "Node status: <role>, version: <version>, ..."
+		role, rest, _ := strings.Cut(strings.TrimPrefix(resp.Message, "Node status: "), ", ")
+		ver, _, _ := strings.Cut(strings.TrimPrefix(rest, "version: "), ",")
+		return NodeStatus{Role: role, Version: ver}, nil
+	}
+
+	var st NodeStatus
+	if err := json.Unmarshal([]byte(resp.Value), &st); err != nil {
+		return NodeStatus{}, fmt.Errorf("failed to unmarshal status: %w", err)
+	}
+	return st, nil
+}
+
+// Peers returns the members of the cluster as the connected node sees them,
+// which is empty for servers before the detailed status
+func (c *RaftClient) Peers() ([]Peer, error) {
+	st, err := c.Status()
+	if err != nil {
+		return nil, err
+	}
+	return st.Peers, nil
+}
+
+// TransferLeadership asks the connected node, which must be the leader, to
+// hand its leadership to nodeID, or to the most up to date follower if
+// nodeID is empty, and returns the new leader's ID. Redirects are not
+// followed, so a follower answers with an error instead of the leader losing
+// its leadership.
+func (c *RaftClient) TransferLeadership(nodeID string) (string, error) {
+	resp, err := c.sendCommand(Command{Op: "TRANSFER", Key: nodeID})
 	if err != nil {
 		return "", err
 	}
+	if resp.Status != "success" {
+		return "", responseError(resp)
+	}
+	return resp.Value, nil
+}
+
+// ServerVersion returns the build information of the connected server and
+// warns through Logf if it speaks a newer protocol than this client
+func (c *RaftClient) ServerVersion() (version.Info, error) {
+	cmd := Command{
+		Op: "VERSION",
+	}
+
+	resp, err := c.sendCommand(cmd)
+	if err != nil {
+		return version.Info{}, err
+	}
 
 	if resp.Status != "success" {
-		return "", fmt.Errorf("server error: %s", resp.Message)
+		return version.Info{}, responseError(resp)
+	}
+
+	var info version.Info
+	if err := json.Unmarshal([]byte(resp.Value), &info); err != nil {
+		return version.Info{}, fmt.Errorf("failed to unmarshal version: %w", err)
 	}
 
-	return resp.Message, nil
+	warnIfNewer(c.serverAddr, info)
+	return info, nil
 }
 
 func (c *RaftClient) reconnectToServer(serverAddr string) error {
 	// Close current connection
 	c.conn.Close()
 
-	conn, err := net.Dial("tcp", serverAddr)
+	conn, err := dialAddr(context.Background(), serverAddr, c.tlsConfig)
+	if err != nil && discovery.IsSRV(c.seedAddr) {
+		// The redirect target may be gone, so fall back to whichever nodes
+		// the service currently lists
+		conn, serverAddr, err = dial(c.seedAddr, c.tlsConfig)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to connect to server at %s: %w", serverAddr, err)
 	}
@@ -164,42 +301,64 @@ func (c *RaftClient) reconnectToServer(serverAddr string) error {
 	c.reader = bufio.NewReader(conn)
 	c.serverAddr = serverAddr
 
+	// A new connection starts out on JSON, whatever the old one had switched to
+	msgpack := c.codec == wire.MsgPack
+	c.codec = wire.JSON
+	if msgpack {
+		if err := c.UseMessagePack(); err != nil {
+			return fmt.Errorf("failed to switch %s to MessagePack: %w", serverAddr, err)
+		}
+	} else if c.name != "" {
+		if _, err := hello(c.sendCommand, c.name, ""); err != nil {
+			return fmt.Errorf("failed to greet %s: %w", serverAddr, err)
+		}
+	}
+	if c.auth != nil {
+		if err := auth(c.sendCommand, c.auth.user, c.auth.password); err != nil {
+			return fmt.Errorf("failed to log in to %s: %w", serverAddr, err)
+		}
+	}
+
 	return nil
 }
 
-func extractServerAddress(message string) string {
-	if strings.Contains(message, "try:") {
-		parts := strings.Split(message, "try:")
-		if len(parts) >= 2 {
-			return strings.TrimSpace(parts[1])
-		}
+// leaderAddress returns the leader a NOT_LEADER response redirects to, or
+// an empty string for other responses and while no leader is known
+func leaderAddress(resp *Response) string {
+	if resp.Code != codeNotLeader {
+		return ""
 	}
-	return ""
+	return resp.Leader
 }
 
 func (c *RaftClient) sendCommand(cmd Command) (*Response, error) {
-	jsonCmd, err := json.Marshal(cmd)
+	data, err := c.codec.Marshal(encodeCommand(cmd))
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal command: %w", err)
 	}
 
-	jsonCmd = append(jsonCmd, '\n')
-
-	_, err = c.conn.Write(jsonCmd)
+	_, err = c.conn.Write(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send command: %w", err)
 	}
 
-	// Read response
-	line, err := c.reader.ReadString('\n')
+	return c.readResponse()
+}
+
+// readResponse reads one response, such as a frame of a chunked reply
+func (c *RaftClient) readResponse() (*Response, error) {
+	data, err := c.codec.ReadFrame(c.reader, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var resp Response
-	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+	if err := c.codec.Unmarshal(data, &resp); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
+	if err := decodeResponse(&resp); err != nil {
+		return nil, err
+	}
 
 	return &resp, nil
 }
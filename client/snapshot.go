@@ -0,0 +1,45 @@
+package client
+
+import (
+	"io"
+)
+
+// Dump writes a snapshot of every live key on the server to w, returning the
+// number of bytes written. Restore loads it back.
+func (c *Client) Dump(w io.Writer) (int64, error) {
+	resp, err := c.sendCommand(Command{Op: "DUMP", Chunked: true, Encoding: encodingBase64})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != "success" {
+		return 0, responseError(resp)
+	}
+
+	return receiveChunks(c.readResponse, resp, w)
+}
+
+// Restore replaces the server's contents with size bytes of a snapshot read
+// from r, as written by Dump. The server rejects a corrupt snapshot without
+// changing anything.
+func (c *Client) Restore(r io.Reader, size int64) error {
+	resp, err := c.sendCommand(Command{Op: "RESTORE", Chunked: true, Size: size})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	resp, err = sendChunks(c.sendCommand, r, size)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Stats summarizes the size of a server's store and the writes it has seen
+// since it started
+type Stats struct {
+	Keys            int       `json:"keys"`
+	MemoryBytes     int64     `json:"memory_bytes"`
+	Sets            uint64    `json:"sets"`
+	Deletes         uint64    `json:"deletes"`
+	ExpiredKeys     uint64    `json:"expired_keys"`
+	EvictedKeys     uint64    `json:"evicted_keys"`
+	LogSize         int64     `json:"log_size"`
+	LogBytesWritten uint64    `json:"log_bytes_written"`
+	LastCompaction  time.Time `json:"last_compaction"` // zero if the log was never compacted
+
+	Compactions              uint64        `json:"compactions"`
+	CompactionBytesReclaimed uint64        `json:"compaction_bytes_reclaimed"`
+	LastCompactionDuration   time.Duration `json:"last_compaction_duration"`
+}
+
+func stats(send func(Command) (*Response, error)) (Stats, error) {
+	resp, err := send(Command{Op: "STATS"})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if resp.Status != "success" {
+		return Stats{}, responseError(resp)
+	}
+
+	var s Stats
+	if err := json.Unmarshal([]byte(resp.Value), &s); err != nil {
+		return Stats{}, fmt.Errorf("failed to unmarshal stats: %w", err)
+	}
+
+	return s, nil
+}
+
+// Stats returns the server's key count, memory estimate and write counters
+func (c *Client) Stats() (Stats, error) {
+	return stats(c.sendCommand)
+}
+
+// Stats returns the connected node's key count, memory estimate and write
+// counters
+func (c *RaftClient) Stats() (Stats, error) {
+	return stats(c.sendCommand)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+)
+
+// Event is a key change pushed by the server to a subscriber
+type Event struct {
+	Event string `json:"event"` // "set", "delete" or "expire"
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	// Version is the key's version after a set, or the version removed by a
+	// delete or expiry
+	Version uint64 `json:"version,omitempty"`
+	TS      int64  `json:"ts"` // Unix milliseconds
+	// Lost is the number of events the server dropped before this one
+	// because the subscriber was not keeping up
+	Lost int `json:"lost,omitempty"`
+}
+
+// Subscribe streams changes to keys matching pattern, which is "*" for all
+// keys, "prefix*" for a key prefix, or an exact key. Events arrive on a
+// dedicated connection, so the client remains usable for other commands. The
+// channel is closed when ctx is cancelled or the connection fails.
+func (c *Client) Subscribe(ctx context.Context, pattern string) (<-chan Event, error) {
+	return subscribe(ctx, c.serverAddr, c.tlsConfig, c.auth, pattern)
+}
+
+// Subscribe streams changes to keys matching pattern from the connected node.
+// Events fire when the node applies committed writes. See Client.Subscribe.
+func (c *RaftClient) Subscribe(ctx context.Context, pattern string) (<-chan Event, error) {
+	return subscribe(ctx, c.serverAddr, c.tlsConfig, c.auth, pattern)
+}
+
+func subscribe(ctx context.Context, serverAddr string, tlsConfig *tls.Config, creds *login, pattern string) (<-chan Event, error) {
+	conn, err := dialAddr(ctx, serverAddr, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server at %s: %w", serverAddr, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	send := func(cmd Command) (*Response, error) {
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal command: %w", err)
+		}
+		if _, err := conn.Write(append(data, '\n')); err != nil {
+			return nil, fmt.Errorf("failed to send command: %w", err)
+		}
+
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		return &resp, nil
+	}
+
+	// The dedicated connection logs in like the client's own
+	if creds != nil {
+		if err := auth(send, creds.user, creds.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	resp, err := send(Command{Op: "SUBSCRIBE", Key: pattern})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.Status != "success" {
+		conn.Close()
+		return nil, responseError(resp)
+	}
+
+	events := make(chan Event, 64)
+	done := make(chan struct{})
+
+	// Closing the connection unblocks the reader when ctx is cancelled
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	go func() {
+		defer close(events)
+		defer close(done)
+
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+
+			var ev Event
+			if err := json.Unmarshal(line, &ev); err != nil || ev.Event == "" {
+				continue
+			}
+
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// Txn queues writes that Exec applies atomically: either all of them land or
+// none do. Each command travels as one request line, so values must stay
+// under the server's request size limit.
+type Txn struct {
+	cmds []Command
+	exec func([]Command) (*Response, error)
+}
+
+// Set queues storing value under key, expiring after expiresIn, or never if
+// it is zero
+func (t *Txn) Set(key, value string, expiresIn time.Duration) *Txn {
+	t.cmds = append(t.cmds, Command{Op: "SET", Key: key, Value: value, ExpiresIn: expiresIn})
+	return t
+}
+
+// Delete queues removing key
+func (t *Txn) Delete(key string) *Txn {
+	t.cmds = append(t.cmds, Command{Op: "DELETE", Key: key})
+	return t
+}
+
+// Expire queues setting key to expire after expiresIn, or never if it is
+// zero. It has no effect if the key is missing when the transaction runs.
+func (t *Txn) Expire(key string, expiresIn time.Duration) *Txn {
+	t.cmds = append(t.cmds, Command{Op: "EXPIRE", Key: key, ExpiresIn: expiresIn})
+	return t
+}
+
+// Persist queues removing key's expiry
+func (t *Txn) Persist(key string) *Txn {
+	t.cmds = append(t.cmds, Command{Op: "PERSIST", Key: key})
+	return t
+}
+
+// Exec sends the queued writes between MULTI and EXEC. If the server rejects
+// any of them the transaction is discarded and nothing is applied.
+func (t *Txn) Exec() error {
+	if len(t.cmds) == 0 {
+		return nil
+	}
+
+	resp, err := t.exec(t.cmds)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+// runTxn queues cmds in a MULTI block and returns the response to EXEC
+func runTxn(send func(Command) (*Response, error), cmds []Command) (*Response, error) {
+	resp, err := send(Command{Op: "MULTI"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != "success" {
+		return nil, responseError(resp)
+	}
+
+	for _, cmd := range cmds {
+		resp, err := send(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Status != "success" {
+			send(Command{Op: "DISCARD"})
+			return nil, responseError(resp)
+		}
+	}
+
+	return send(Command{Op: "EXEC"})
+}
+
+// Txn starts a transaction
+func (c *Client) Txn() *Txn {
+	return &Txn{
+		exec: func(cmds []Command) (*Response, error) {
+			return runTxn(c.sendCommand, cmds)
+		},
+	}
+}
+
+// Txn starts a transaction. Commands are queued on the connected node and
+// the whole transaction is sent again to the leader if EXEC is redirected.
+func (c *RaftClient) Txn() *Txn {
+	return &Txn{
+		exec: func(cmds []Command) (*Response, error) {
+			for retry := 0; retry <= c.maxRetries; retry++ {
+				resp, err := runTxn(c.sendCommand, cmds)
+				if err != nil {
+					return nil, err
+				}
+
+				if newAddr := leaderAddress(resp); newAddr != "" && newAddr != c.serverAddr {
+					if err := c.reconnectToServer(newAddr); err != nil {
+						return nil, err
+					}
+					continue
+				}
+
+				return resp, nil
+			}
+
+			return nil, fmt.Errorf("max retries reached")
+		},
+	}
+}
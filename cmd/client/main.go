@@ -2,21 +2,77 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pixperk/yakvs/client"
+	"github.com/pixperk/yakvs/tlsconfig"
+	"github.com/pixperk/yakvs/version"
 )
 
 func printUsage() {
 	fmt.Println("\nAvailable Commands:")
-	fmt.Println("  set <key> <value> <ttl-seconds>  - Set a value with TTL")
+	fmt.Println("  set <key> <value> [ttl-seconds]  - Set a value, without expiry if no TTL (or 0) is given")
+	fmt.Println("  set <key> @<file> [ttl-seconds]  - Set a value read from a file ('@-' reads stdin, command line only)")
+	fmt.Println("  setnx <key> <value> [ttl]       - Set a value only if the key does not exist")
+	fmt.Println("  cas <key> <expected> <new> [ttl]- Replace a value only if it still equals <expected>")
 	fmt.Println("  get <key>                       - Get a value")
+	fmt.Println("  get <key> --out <file>          - Write a value to a file")
+	fmt.Println("  getmeta <key>                   - Get a value with its version and write times")
 	fmt.Println("  delete <key>                    - Delete a value")
+	fmt.Println("  getset <key> <value> [ttl]      - Set a value and print the one it replaced")
+	fmt.Println("  append <key> <value> [ttl]      - Append to a value, creating it if missing")
+	fmt.Println("  incr <key> [delta]              - Add delta (default 1) to an integer value")
+	fmt.Println("  decr <key>                      - Subtract one from an integer value")
+	fmt.Println("  mset <key> <value> [...]        - Set several values at once")
+	fmt.Println("  mget <key> [key...]             - Get several values at once")
+	fmt.Println("  scan <prefix> [limit]           - List keys starting with a prefix")
+	fmt.Println("  range <start> <end> [limit]     - List keys from start up to end ('-' for no end)")
+	fmt.Println("  keys <pattern>                  - List keys matching a glob pattern such as user:*")
+	fmt.Println("  scankeys <cursor> [n] [pattern] - List keys after a cursor ('-' to start), n at a time")
+	fmt.Println("  randomkey [count]               - Print random keys, one unless count is given")
+	fmt.Println("  delprefix <prefix>              - Delete every key starting with a prefix")
+	fmt.Println("  flushall [confirm]              - Delete every key, asking first at the prompt unless confirmed")
+	fmt.Println("  exists <key> [key...]           - Count how many of the keys exist")
+	fmt.Println("  type <key>                      - Show whether a key holds a string or a list")
 	fmt.Println("  ttl <key>                       - Get the TTL for a key")
+	fmt.Println("  expire <key> <ttl-seconds>      - Change the TTL of an existing key")
+	fmt.Println("  touch <key> <ttl-seconds>       - Reset the TTL of an existing key")
+	fmt.Println("  getex <key> <ttl-seconds>       - Get a value and reset its TTL")
+	fmt.Println("  persist <key>                   - Remove the TTL of an existing key")
+	fmt.Println("  lpush <key> <elem> [elem...]    - Push elements onto the head of a list")
+	fmt.Println("  rpush <key> <elem> [elem...]    - Push elements onto the tail of a list")
+	fmt.Println("  lpop <key> [count]              - Pop elements from the head of a list")
+	fmt.Println("  rpop <key> [count]              - Pop elements from the tail of a list")
+	fmt.Println("  llen <key>                      - Get the length of a list")
+	fmt.Println("  lrange <key> <start> <stop>     - List elements of a list, -1 being the last")
+	fmt.Println("  status                          - Show the server role and replication lag")
+	fmt.Println("  memory usage <key>              - Show the approximate memory used by a key")
+	fmt.Println("  stats                           - Show key count, memory and write counters")
+	fmt.Println("  info                            - Show server, store and command sections")
+	fmt.Println("  memory stats [count]            - Show memory totals and the largest keys")
+	fmt.Println("  latency [reset]                 - Show or reset per-command latency quantiles")
+	fmt.Println("  compact                         - Rewrite the server's log to hold only live keys")
+	fmt.Println("  bgsave                          - Save a snapshot to the server's snapshot file")
+	fmt.Println("  jobstatus <id>                  - Show a compact or bgsave job")
+	fmt.Println("  dump <file>                     - Save a snapshot of every key to a local file")
+	fmt.Println("  restore <file>                  - Replace every key with a snapshot from a local file")
+	fmt.Println("  ping                            - Check the server is answering and show the round trip")
+	fmt.Println("  auth <user> <password>          - Log in as another user")
+	fmt.Println("  clients                         - List the server's open connections")
+	fmt.Println("  version                         - Show client and server versions")
+	fmt.Println("  import <file|->                 - Import newline-delimited JSON entries")
+	fmt.Println("  connect <addr>                  - Switch to another server")
+	fmt.Println("  disconnect                      - Close the current connection")
+	fmt.Println("  reconnect                       - Reconnect to the last server")
 	fmt.Println("  help                            - Show this help message")
 	fmt.Println("  exit                            - Exit the client")
 }
@@ -37,17 +93,58 @@ func main() {
 
 	serverAddr := flag.String("server", "localhost:8080", "server address")
 	interactive := flag.Bool("interactive", true, "run in interactive mode")
+	flag.Int64Var(&maxValueSize, "max-value-size", 1<<20, "maximum size in bytes of a value read from a file")
+	flag.IntVar(&importOpts.BatchSize, "batch-size", 100, "number of entries per import batch")
+	flag.IntVar(&importOpts.Parallelism, "parallel", 4, "number of concurrent connections used by import")
+	flag.IntVar(&importOpts.ProgressEvery, "progress-every", 10000, "print import progress every N entries")
+	flag.BoolVar(&importOpts.Strict, "strict", false, "abort import on the first malformed line")
+	useTLS := flag.Bool("tls", false, "connect over TLS, trusting the system CAs unless -tls-ca is given")
+	tlsCA := flag.String("tls-ca", "", "PEM CAs to trust the server's certificate with (implies -tls)")
+	tlsCert := flag.String("tls-cert", "", "PEM client certificate for servers that require one (implies -tls)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert")
+	flag.StringVar(&authUser, "user", "", "log in as this user on servers configured with users")
+	flag.StringVar(&authPassword, "password", "", "password of -user (default: $YAKVS_PASSWORD if set)")
+	flag.BoolVar(&useMsgPack, "msgpack", false, "switch connections to MessagePack framing instead of JSON")
+	showVersion := flag.Bool("version", false, "print version information and exit")
 	flag.Parse()
 
-	c, err := client.NewClient(*serverAddr)
+	if *showVersion {
+		fmt.Println(version.String())
+		return
+	}
+	if authPassword == "" {
+		authPassword = os.Getenv("YAKVS_PASSWORD")
+	}
+
+	if *useTLS || *tlsCA != "" || *tlsCert != "" {
+		var err error
+		if tlsConfig, err = tlsconfig.Client(*tlsCA, *tlsCert, *tlsKey); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	c, err := connect(*serverAddr)
 	if err != nil {
 		fmt.Printf("Error connecting to server: %v\n", err)
 		os.Exit(1)
 	}
-	defer c.Close()
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
 
 	args := flag.Args()
 
+	// Imports are always run non-interactively so input can be piped in
+	if len(args) > 0 && args[0] == "import" {
+		if !runImport(c, args) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Check if there are command-line arguments for non-interactive mode
 	if len(args) > 0 && !*interactive {
 		processCommand(c, args)
@@ -56,10 +153,13 @@ func main() {
 
 	// Interactive mode
 	printWelcome(*serverAddr)
+	replOwnsStdin = true
 	scanner := bufio.NewScanner(os.Stdin)
+	replInput = scanner
+	addr := *serverAddr
 
 	for {
-		fmt.Print("\n\033[1;36myakvs>\033[0m ")
+		fmt.Print(prompt(c, addr))
 		if !scanner.Scan() {
 			break
 		}
@@ -84,6 +184,56 @@ func main() {
 			continue
 		}
 
+		switch args[0] {
+		case "connect":
+			if len(args) < 2 {
+				fmt.Println("Error: 'connect' requires an address argument")
+				fmt.Println("Usage: connect <addr>")
+				continue
+			}
+
+			// Keep the current connection until the new one is established
+			newClient, err := connect(args[1])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			if c != nil {
+				c.Close()
+			}
+			c, addr = newClient, args[1]
+			fmt.Printf("Connected to %s\n", addr)
+			continue
+
+		case "disconnect":
+			if c == nil {
+				fmt.Println("Not connected")
+				continue
+			}
+			c.Close()
+			c = nil
+			fmt.Printf("Disconnected from %s\n", addr)
+			continue
+
+		case "reconnect":
+			newClient, err := connect(addr)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			if c != nil {
+				c.Close()
+			}
+			c = newClient
+			fmt.Printf("Reconnected to %s\n", addr)
+			continue
+		}
+
+		if c == nil {
+			fmt.Println("Not connected. Use 'connect <addr>' or 'reconnect'")
+			continue
+		}
+
 		processCommand(c, args)
 	}
 
@@ -92,6 +242,89 @@ func main() {
 	}
 }
 
+// prompt renders the REPL prompt with the connected server address
+func prompt(c *client.Client, addr string) string {
+	if c == nil {
+		return "\n\033[1;36myakvs(disconnected)>\033[0m "
+	}
+	return fmt.Sprintf("\n\033[1;36myakvs(%s)>\033[0m ", addr)
+}
+
+// replOwnsStdin is set once the REPL reads commands from stdin, which then
+// cannot also supply a value for '@-'
+var replOwnsStdin bool
+
+// replInput is the REPL's stdin scanner, which also reads the answers to the
+// questions commands ask at the prompt. It is nil outside the REPL.
+var replInput *bufio.Scanner
+
+// confirmFlushAll reports whether a flushall may go ahead: when confirm is
+// given, as scripts do, or when answered yes at the REPL's prompt
+func confirmFlushAll(args []string) bool {
+	if len(args) >= 2 && args[1] == "confirm" {
+		return true
+	}
+	if len(args) >= 2 || replInput == nil {
+		fmt.Println("Error: 'flushall' removes every key and must be confirmed")
+		fmt.Println("Usage: flushall confirm")
+		return false
+	}
+
+	fmt.Print("Delete every key? [y/N] ")
+	if replInput.Scan() {
+		switch strings.ToLower(strings.TrimSpace(replInput.Text())) {
+		case "y", "yes":
+			return true
+		}
+	} else {
+		fmt.Println()
+	}
+	fmt.Println("Aborted")
+	return false
+}
+
+// importOpts holds the import settings configured by flags
+var importOpts client.ImportOptions
+
+// tlsConfig is the TLS configuration built from the flags, nil for plain TCP
+var tlsConfig *tls.Config
+
+// authUser and authPassword log every connection in when -user is set
+var authUser, authPassword string
+
+// useMsgPack switches every connection to MessagePack when -msgpack is set
+var useMsgPack bool
+
+// connect opens a client to addr, naming the connection kvs-client, switching
+// to MessagePack if -msgpack is set and logging in if -user is set
+func connect(addr string) (*client.Client, error) {
+	c, err := client.NewClientWithTLS(addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Hello("kvs-client"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if useMsgPack {
+		if err := c.UseMessagePack(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if authUser != "" {
+		if err := c.Auth(authUser, authPassword); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// maxValueSize caps values read with the @file syntax so oversized files are
+// rejected before anything is sent to the server
+var maxValueSize int64
+
 // parseInput splits the input string into arguments, respecting quotes
 func parseInput(input string) []string {
 	var args []string
@@ -130,19 +363,26 @@ func processCommand(c *client.Client, args []string) {
 	cmd := args[0]
 	switch cmd {
 	case "set":
-		if len(args) < 4 {
-			fmt.Println("Error: 'set' requires key, value and TTL arguments")
-			fmt.Println("Usage: set <key> <value> <ttl-seconds>")
+		if len(args) < 3 {
+			fmt.Println("Error: 'set' requires key and value arguments")
+			fmt.Println("Usage: set <key> <value|@file> [ttl-seconds]")
 			return
 		}
 
 		key := args[1]
-		value := args[2]
-		ttl, err := time.ParseDuration(args[3] + "s")
+		value, err := readValueArg(args[2])
 		if err != nil {
-			fmt.Printf("Error parsing TTL: %v\n", err)
+			fmt.Printf("Error reading value: %v\n", err)
 			return
 		}
+		var ttl time.Duration
+		if len(args) >= 4 {
+			ttl, err = time.ParseDuration(args[3] + "s")
+			if err != nil {
+				fmt.Printf("Error parsing TTL: %v\n", err)
+				return
+			}
+		}
 
 		if err := c.Set(key, value, ttl); err != nil {
 			fmt.Printf("Error: %v\n", err)
@@ -150,14 +390,94 @@ func processCommand(c *client.Client, args []string) {
 		}
 		fmt.Printf("Successfully set key '%s'\n", key)
 
+	case "setnx":
+		if len(args) < 3 {
+			fmt.Println("Error: 'setnx' requires key and value arguments")
+			fmt.Println("Usage: setnx <key> <value> [ttl-seconds]")
+			return
+		}
+
+		key := args[1]
+		var ttl time.Duration
+		if len(args) >= 4 {
+			var err error
+			ttl, err = time.ParseDuration(args[3] + "s")
+			if err != nil {
+				fmt.Printf("Error parsing TTL: %v\n", err)
+				return
+			}
+		}
+
+		applied, err := c.SetNX(key, args[2], ttl)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if !applied {
+			fmt.Printf("Key '%s' already exists\n", key)
+			return
+		}
+		fmt.Printf("Successfully set key '%s'\n", key)
+
+	case "cas":
+		if len(args) < 4 {
+			fmt.Println("Error: 'cas' requires key, expected and new value arguments")
+			fmt.Println("Usage: cas <key> <expected> <new> [ttl-seconds]")
+			return
+		}
+
+		key := args[1]
+		var ttl time.Duration
+		if len(args) >= 5 {
+			var err error
+			ttl, err = time.ParseDuration(args[4] + "s")
+			if err != nil {
+				fmt.Printf("Error parsing TTL: %v\n", err)
+				return
+			}
+		}
+
+		version, err := c.CompareAndSwap(key, args[2], args[3], ttl)
+		if errors.Is(err, client.ErrConflict) {
+			fmt.Printf("Key '%s' does not hold the expected value\n", key)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Successfully set key '%s', now at version %d\n", key, version)
+
 	case "get":
 		if len(args) < 2 {
 			fmt.Println("Error: 'get' requires a key argument")
-			fmt.Println("Usage: get <key>")
+			fmt.Println("Usage: get <key> [--out <file>]")
 			return
 		}
 
 		key := args[1]
+
+		// Stream straight to the file so large values are never held in memory
+		if len(args) >= 4 && args[2] == "--out" {
+			f, err := os.Create(args[3])
+			if err != nil {
+				fmt.Printf("Error writing value: %v\n", err)
+				return
+			}
+
+			n, _, err := c.GetWriter(key, f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(args[3])
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Printf("Wrote %d bytes to %s\n", n, args[3])
+			return
+		}
+
 		value, ttl, err := c.Get(key)
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
@@ -165,7 +485,27 @@ func processCommand(c *client.Client, args []string) {
 		}
 		fmt.Printf("Key: %s\n", key)
 		fmt.Printf("Value: %s\n", value)
-		fmt.Printf("TTL: %v\n", ttl)
+		fmt.Printf("TTL: %s\n", formatTTL(ttl))
+
+	case "getmeta":
+		if len(args) < 2 {
+			fmt.Println("Error: 'getmeta' requires a key argument")
+			fmt.Println("Usage: getmeta <key>")
+			return
+		}
+
+		key := args[1]
+		value, meta, err := c.GetWithMeta(key)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Key: %s\n", key)
+		fmt.Printf("Value: %s\n", value)
+		fmt.Printf("TTL: %s\n", formatTTL(meta.TTL))
+		fmt.Printf("Version: %d\n", meta.Version)
+		fmt.Printf("Created: %s\n", formatTime(meta.CreatedAt))
+		fmt.Printf("Updated: %s\n", formatTime(meta.UpdatedAt))
 
 	case "delete":
 		if len(args) < 2 {
@@ -181,23 +521,909 @@ func processCommand(c *client.Client, args []string) {
 		}
 		fmt.Printf("Successfully deleted key '%s'\n", key)
 
-	case "ttl":
+	case "getset":
+		if len(args) < 3 {
+			fmt.Println("Error: 'getset' requires key and value arguments")
+			fmt.Println("Usage: getset <key> <value> [ttl-seconds]")
+			return
+		}
+
+		var ttl time.Duration
+		if len(args) >= 4 {
+			var err error
+			ttl, err = time.ParseDuration(args[3] + "s")
+			if err != nil {
+				fmt.Printf("Error parsing TTL: %v\n", err)
+				return
+			}
+		}
+
+		old, existed, err := c.GetSet(args[1], args[2], ttl)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if !existed {
+			fmt.Printf("Set key '%s', it had no previous value\n", args[1])
+			return
+		}
+		fmt.Printf("Previous value: %s\n", old)
+
+	case "append":
+		if len(args) < 3 {
+			fmt.Println("Error: 'append' requires key and value arguments")
+			fmt.Println("Usage: append <key> <value> [ttl-seconds]")
+			return
+		}
+
+		var ttl time.Duration
+		if len(args) >= 4 {
+			var err error
+			ttl, err = time.ParseDuration(args[3] + "s")
+			if err != nil {
+				fmt.Printf("Error parsing TTL: %v\n", err)
+				return
+			}
+		}
+
+		n, err := c.Append(args[1], args[2], ttl)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Length of '%s' is now %d\n", args[1], n)
+
+	case "incr", "decr":
 		if len(args) < 2 {
-			fmt.Println("Error: 'ttl' requires a key argument")
-			fmt.Println("Usage: ttl <key>")
+			fmt.Printf("Error: '%s' requires a key argument\n", args[0])
 			return
 		}
 
-		key := args[1]
-		ttl, err := c.TTL(key)
+		delta := int64(1)
+		if args[0] == "decr" {
+			delta = -1
+		} else if len(args) >= 3 {
+			var err error
+			delta, err = strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				fmt.Printf("Error parsing delta: %v\n", err)
+				return
+			}
+		}
+
+		n, err := c.IncrBy(args[1], delta)
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			return
 		}
-		fmt.Printf("TTL for key '%s': %v\n", key, ttl)
+		fmt.Println(n)
 
-	default:
-		fmt.Printf("Unknown command: %s\n", cmd)
-		printUsage()
+	case "mset":
+		if len(args) < 3 || len(args)%2 == 0 {
+			fmt.Println("Error: 'mset' requires key and value pairs")
+			fmt.Println("Usage: mset <key> <value> [<key> <value>...]")
+			return
+		}
+
+		pairs := make(map[string]string, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			pairs[args[i]] = args[i+1]
+		}
+
+		if err := c.MSet(pairs, 0); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Successfully set %d keys\n", len(pairs))
+
+	case "mget":
+		if len(args) < 2 {
+			fmt.Println("Error: 'mget' requires at least one key argument")
+			fmt.Println("Usage: mget <key> [key...]")
+			return
+		}
+
+		values, err := c.MGet(args[1:]...)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		for _, key := range args[1:] {
+			value, ok := values[key]
+			if !ok {
+				fmt.Printf("%s: (not found)\n", key)
+				continue
+			}
+			fmt.Printf("%s: %s\n", key, value)
+		}
+
+	case "scan":
+		if len(args) < 2 {
+			fmt.Println("Error: 'scan' requires a prefix argument")
+			fmt.Println("Usage: scan <prefix> [limit]")
+			return
+		}
+
+		limit := 0
+		if len(args) >= 3 {
+			var err error
+			limit, err = strconv.Atoi(args[2])
+			if err != nil {
+				fmt.Printf("Error parsing limit: %v\n", err)
+				return
+			}
+		}
+
+		keys, err := c.ScanPrefix(args[1], limit)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		fmt.Printf("%d keys\n", len(keys))
+
+	case "keys":
+		if len(args) != 2 {
+			fmt.Println("Error: 'keys' requires a pattern argument")
+			fmt.Println("Usage: keys <pattern>")
+			return
+		}
+
+		page, err := c.Keys(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		printKeyPage(page)
+
+	case "scankeys":
+		if len(args) < 2 || len(args) > 4 {
+			fmt.Println("Error: 'scankeys' requires a cursor argument")
+			fmt.Println("Usage: scankeys <cursor> [n] [pattern]")
+			return
+		}
+
+		cursor := args[1]
+		if cursor == "-" {
+			cursor = ""
+		}
+		count := 0
+		if len(args) >= 3 {
+			var err error
+			count, err = strconv.Atoi(args[2])
+			if err != nil {
+				fmt.Printf("Error parsing n: %v\n", err)
+				return
+			}
+		}
+		pattern := ""
+		if len(args) == 4 {
+			pattern = args[3]
+		}
+
+		page, err := c.Scan(cursor, pattern, count)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		printKeyPage(page)
+
+	case "range":
+		if len(args) < 3 {
+			fmt.Println("Error: 'range' requires start and end arguments")
+			fmt.Println("Usage: range <start> <end> [limit]")
+			return
+		}
+
+		end := args[2]
+		if end == "-" {
+			end = ""
+		}
+		limit := 0
+		if len(args) >= 4 {
+			var err error
+			limit, err = strconv.Atoi(args[3])
+			if err != nil {
+				fmt.Printf("Error parsing limit: %v\n", err)
+				return
+			}
+		}
+
+		keys, err := c.KeysRange(args[1], end, limit)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		fmt.Printf("%d keys\n", len(keys))
+
+	case "randomkey":
+		count := 1
+		if len(args) >= 2 {
+			var err error
+			count, err = strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Printf("Error parsing count: %v\n", err)
+				return
+			}
+		}
+
+		keys, err := c.SampleKeys(count)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(keys) == 0 {
+			fmt.Println("(empty)")
+			return
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+
+	case "delprefix":
+		if len(args) < 2 {
+			fmt.Println("Error: 'delprefix' requires a prefix argument")
+			fmt.Println("Usage: delprefix <prefix>")
+			return
+		}
+
+		n, err := c.DeletePrefix(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Deleted %d keys\n", n)
+
+	case "flushall":
+		if !confirmFlushAll(args) {
+			return
+		}
+
+		if err := c.FlushAll(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Println("Removed every key")
+
+	case "exists":
+		if len(args) < 2 {
+			fmt.Println("Error: 'exists' requires at least one key argument")
+			fmt.Println("Usage: exists <key> [key...]")
+			return
+		}
+
+		n, err := c.ExistsCount(args[1:]...)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("%d of %d keys exist\n", n, len(args)-1)
+
+	case "type":
+		if len(args) != 2 {
+			fmt.Println("Error: 'type' requires a key argument")
+			fmt.Println("Usage: type <key>")
+			return
+		}
+
+		typ, err := c.Type(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Println(typ)
+
+	case "ttl":
+		if len(args) < 2 {
+			fmt.Println("Error: 'ttl' requires a key argument")
+			fmt.Println("Usage: ttl <key>")
+			return
+		}
+
+		key := args[1]
+		ttl, err := c.TTL(key)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("TTL for key '%s': %s\n", key, formatTTL(ttl))
+
+	case "touch", "getex":
+		if len(args) < 3 {
+			fmt.Printf("Error: '%s' requires key and TTL arguments\n", cmd)
+			fmt.Printf("Usage: %s <key> <ttl-seconds>\n", cmd)
+			return
+		}
+
+		key := args[1]
+		ttl, err := time.ParseDuration(args[2] + "s")
+		if err != nil {
+			fmt.Printf("Error parsing TTL: %v\n", err)
+			return
+		}
+
+		if cmd == "getex" {
+			value, err := c.GetEx(key, ttl)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Printf("Key: %s\nValue: %s\n", key, value)
+			return
+		}
+
+		applied, err := c.Touch(key, ttl)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if !applied {
+			fmt.Printf("Key '%s' not found\n", key)
+			return
+		}
+		fmt.Printf("Touched key '%s'\n", key)
+
+	case "lpush", "rpush":
+		if len(args) < 3 {
+			fmt.Printf("Error: '%s' requires key and element arguments\n", cmd)
+			fmt.Printf("Usage: %s <key> <element> [element...]\n", cmd)
+			return
+		}
+
+		push := c.RPush
+		if cmd == "lpush" {
+			push = c.LPush
+		}
+		n, err := push(args[1], args[2:]...)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("List '%s' now has %d elements\n", args[1], n)
+
+	case "lpop", "rpop":
+		if len(args) < 2 {
+			fmt.Printf("Error: '%s' requires a key argument\n", cmd)
+			fmt.Printf("Usage: %s <key> [count]\n", cmd)
+			return
+		}
+
+		count := 1
+		if len(args) >= 3 {
+			var err error
+			count, err = strconv.Atoi(args[2])
+			if err != nil {
+				fmt.Printf("Error parsing count: %v\n", err)
+				return
+			}
+		}
+
+		pop := c.RPop
+		if cmd == "lpop" {
+			pop = c.LPop
+		}
+		elems, err := pop(args[1], count)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(elems) == 0 {
+			fmt.Println("(empty)")
+			return
+		}
+		for _, elem := range elems {
+			fmt.Println(elem)
+		}
+
+	case "llen":
+		if len(args) < 2 {
+			fmt.Println("Error: 'llen' requires a key argument")
+			fmt.Println("Usage: llen <key>")
+			return
+		}
+
+		n, err := c.LLen(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Length: %d\n", n)
+
+	case "lrange":
+		if len(args) < 4 {
+			fmt.Println("Error: 'lrange' requires key, start and stop arguments")
+			fmt.Println("Usage: lrange <key> <start> <stop>")
+			return
+		}
+
+		start, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Printf("Error parsing start: %v\n", err)
+			return
+		}
+		stop, err := strconv.Atoi(args[3])
+		if err != nil {
+			fmt.Printf("Error parsing stop: %v\n", err)
+			return
+		}
+
+		elems, err := c.LRange(args[1], start, stop)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(elems) == 0 {
+			fmt.Println("(empty)")
+			return
+		}
+		for i, elem := range elems {
+			fmt.Printf("%d) %s\n", i+1, elem)
+		}
+
+	case "expire":
+		if len(args) < 3 {
+			fmt.Println("Error: 'expire' requires key and TTL arguments")
+			fmt.Println("Usage: expire <key> <ttl-seconds>")
+			return
+		}
+
+		key := args[1]
+		ttl, err := time.ParseDuration(args[2] + "s")
+		if err != nil {
+			fmt.Printf("Error parsing TTL: %v\n", err)
+			return
+		}
+
+		applied, err := c.Expire(key, ttl)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if !applied {
+			fmt.Printf("Key '%s' not found\n", key)
+			return
+		}
+		if ttl == 0 {
+			fmt.Printf("Removed the TTL of key '%s'\n", key)
+			return
+		}
+		fmt.Printf("TTL for key '%s' set to %s\n", key, ttl)
+
+	case "persist":
+		if len(args) < 2 {
+			fmt.Println("Error: 'persist' requires a key argument")
+			fmt.Println("Usage: persist <key>")
+			return
+		}
+
+		key := args[1]
+		applied, err := c.Persist(key)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if !applied {
+			fmt.Printf("Key '%s' not found or has no TTL\n", key)
+			return
+		}
+		fmt.Printf("Removed the TTL of key '%s'\n", key)
+
+	case "status":
+		status, err := c.Status()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Println(status)
+
+	case "memory":
+		if len(args) >= 3 && args[1] == "usage" {
+			size, err := c.MemoryUsage(args[2])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Printf("Key '%s' uses about %d bytes\n", args[2], size)
+			return
+		}
+
+		if len(args) >= 2 && args[1] == "stats" {
+			top := 0
+			if len(args) >= 3 {
+				n, err := strconv.Atoi(args[2])
+				if err != nil {
+					fmt.Printf("Error parsing count: %v\n", err)
+					return
+				}
+				top = n
+			}
+
+			stats, err := c.MemoryStats(top)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Printf("Entries: %d\n", stats.Entries)
+			fmt.Printf("Memory: %d bytes (%d in values)\n", stats.Bytes, stats.ValueBytes)
+			fmt.Printf("Average value size: %.1f bytes\n", stats.AvgValueSize)
+			for i, k := range stats.Largest {
+				fmt.Printf("  %2d. %s (%d bytes)\n", i+1, k.Key, k.Bytes)
+			}
+			return
+		}
+
+		fmt.Println("Usage: memory usage <key> | memory stats [count]")
+
+	case "stats":
+		stats, err := c.Stats()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		lastCompaction := "never"
+		if !stats.LastCompaction.IsZero() {
+			lastCompaction = stats.LastCompaction.Format(time.RFC3339)
+		}
+		fmt.Printf("Keys: %d\n", stats.Keys)
+		fmt.Printf("Memory: %d bytes\n", stats.MemoryBytes)
+		fmt.Printf("Sets: %d, deletes: %d, expired: %d, evicted: %d\n", stats.Sets, stats.Deletes, stats.ExpiredKeys, stats.EvictedKeys)
+		fmt.Printf("Log: %d bytes, %d written since start\n", stats.LogSize, stats.LogBytesWritten)
+		fmt.Printf("Last compaction: %s\n", lastCompaction)
+		if stats.Compactions > 0 {
+			fmt.Printf("Compactions: %d, %d bytes reclaimed, last took %v\n", stats.Compactions, stats.CompactionBytesReclaimed, stats.LastCompactionDuration)
+		}
+
+	case "info":
+		i, err := c.Info()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		printInfo(i)
+
+	case "compact":
+		j, err := c.Compact()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		waitForJob(c, j)
+
+	case "bgsave":
+		j, err := c.BGSave()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		waitForJob(c, j)
+
+	case "jobstatus":
+		if len(args) < 2 {
+			fmt.Println("Error: 'jobstatus' requires a job ID")
+			return
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			fmt.Printf("Error: invalid job ID %q\n", args[1])
+			return
+		}
+		j, err := c.JobStatus(id)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		printJob(j)
+
+	case "dump":
+		if len(args) < 2 {
+			fmt.Println("Error: 'dump' requires a file argument")
+			fmt.Println("Usage: dump <file>")
+			return
+		}
+
+		f, err := os.Create(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		n, err := c.Dump(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(args[1])
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Wrote a %d byte snapshot to %s\n", n, args[1])
+
+	case "restore":
+		if len(args) < 2 {
+			fmt.Println("Error: 'restore' requires a file argument")
+			fmt.Println("Usage: restore <file>")
+			return
+		}
+
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := c.Restore(f, info.Size()); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Restored the snapshot from %s\n", args[1])
+
+	case "latency":
+		if len(args) >= 2 && args[1] == "reset" {
+			if err := c.ResetLatency(); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Println("Latency stats reset")
+			return
+		}
+
+		summaries, err := c.Latency()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("%-14s %-9s %-11s %8s %10s %10s %10s %10s\n", "OP", "OUTCOME", "STAGE", "COUNT", "P50", "P95", "P99", "MAX")
+		for _, l := range summaries {
+			fmt.Printf("%-14s %-9s %-11s %8d %10v %10v %10v %10v\n", l.Op, l.Outcome, l.Stage, l.Count, l.P50, l.P95, l.P99, l.Max)
+		}
+
+	case "auth":
+		if len(args) != 3 {
+			fmt.Println("Error: 'auth' requires user and password arguments")
+			fmt.Println("Usage: auth <user> <password>")
+			return
+		}
+		if err := c.Auth(args[1], args[2]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Logged in as %s\n", args[1])
+
+	case "ping":
+		rtt, err := c.Ping()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("PONG in %v\n", rtt)
+
+	case "clients":
+		clients, err := c.ClientList()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		for _, cl := range clients {
+			name := cl.Name
+			if name == "" {
+				name = "-"
+			}
+			user := cl.User
+			if user == "" {
+				user = "-"
+			}
+			framing := cl.Framing
+			if framing == "" {
+				framing = "json"
+			}
+			fmt.Printf("id=%d addr=%s name=%s user=%s protocol=%d framing=%s age=%v\n",
+				cl.ID, cl.Addr, name, user, cl.Protocol, framing, time.Since(cl.ConnectedAt).Round(time.Second))
+		}
+		fmt.Printf("%d clients\n", len(clients))
+
+	case "version":
+		fmt.Printf("Client: %s\n", version.String())
+		info, err := c.ServerVersion()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Server: %s\n", info)
+
+	case "import":
+		runImport(c, args)
+
+	default:
+		fmt.Printf("Unknown command: %s\n", cmd)
+		printUsage()
+	}
+}
+
+// readValueArg resolves the value argument of a set command. A leading '@'
+// reads the value from the named file, or from stdin when the name is '-',
+// which only works for a command given on the command line.
+func readValueArg(arg string) (string, error) {
+	if !strings.HasPrefix(arg, "@") {
+		return arg, nil
+	}
+
+	var r io.Reader
+	path := arg[1:]
+	if path == "-" {
+		if replOwnsStdin {
+			return "", fmt.Errorf("'@-' cannot be used at the prompt, which reads stdin; pass the command on the command line or use @<file>")
+		}
+		r = os.Stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// Read one byte past the limit so oversized input is detected without
+	// loading the whole file
+	data, err := io.ReadAll(io.LimitReader(r, maxValueSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > maxValueSize {
+		return "", fmt.Errorf("value exceeds maximum size of %d bytes", maxValueSize)
+	}
+
+	return string(data), nil
+}
+
+// runImport streams newline-delimited JSON entries from a file or stdin into
+// the server and prints a summary. It reports whether every entry was written.
+func runImport(c *client.Client, args []string) bool {
+	if len(args) < 2 {
+		fmt.Println("Error: 'import' requires a file argument")
+		fmt.Println("Usage: import <file|->")
+		return false
+	}
+
+	var r io.Reader = os.Stdin
+	if args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Printf("Error opening input: %v\n", err)
+			return false
+		}
+		defer f.Close()
+		r = f
+	}
+
+	opts := importOpts
+	opts.Progress = func(res client.ImportResult) {
+		fmt.Printf("Progress: %d imported, %d skipped, %d failed\n", res.Imported, res.Skipped, res.Failed)
+	}
+	opts.Invalid = func(line int, err error) {
+		fmt.Printf("Skipping line %d: %v\n", line, err)
+	}
+
+	dial := func() (client.KV, error) {
+		return connect(c.ServerAddr())
+	}
+
+	res, err := client.Import(r, dial, opts)
+	fmt.Printf("Import finished: %d imported, %d skipped-invalid, %d failed\n", res.Imported, res.Skipped, res.Failed)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return false
+	}
+
+	return res.Failed == 0
+}
+
+// waitForJob reports a job started by compact or bgsave and waits for it to
+// finish
+func waitForJob(c *client.Client, j client.Job) {
+	fmt.Printf("Started %s job %d\n", j.Kind, j.ID)
+	j, err := c.WaitJob(j.ID, 100*time.Millisecond)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Println(j.Message)
+}
+
+// printJob describes a job reported by jobstatus
+func printJob(j client.Job) {
+	fmt.Printf("Job %d (%s): %s\n", j.ID, j.Kind, j.State)
+	fmt.Printf("Started: %s\n", formatTime(j.StartedAt))
+	if !j.Running() {
+		fmt.Printf("Finished: %s, after %s\n", formatTime(j.FinishedAt), j.FinishedAt.Sub(j.StartedAt).Round(time.Millisecond))
+		fmt.Println(j.Message)
+	}
+}
+
+// formatTTL renders a TTL, spelling out the sentinel for keys without expiry
+func formatTTL(ttl time.Duration) string {
+	if ttl == client.NoExpiry {
+		return "no expiry"
+	}
+	// Servers report milliseconds, which are noise once a minute is left
+	if ttl >= time.Minute {
+		return ttl.Round(time.Second).String()
+	}
+	return ttl.Round(time.Millisecond).String()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// printKeyPage prints the keys of a KEYS or SCAN reply and where to resume
+func printKeyPage(page client.KeyPage) {
+	for _, key := range page.Keys {
+		fmt.Println(key)
+	}
+	fmt.Printf("%d keys\n", len(page.Keys))
+	if page.Cursor != "" {
+		fmt.Printf("Next cursor: %s\n", page.Cursor)
+	}
+	if page.Role == "follower" {
+		fmt.Println("(read from a follower, may be stale)")
+	}
+}
+
+// printInfo prints each section of an INFO report
+func printInfo(i client.Info) {
+	fmt.Println("# Server")
+	fmt.Printf("version: %s\n", i.Server.Version)
+	fmt.Printf("address: %s\n", i.Server.Address)
+	fmt.Printf("role: %s\n", i.Server.Role)
+	fmt.Printf("uptime: %v\n", i.Server.Uptime.Round(time.Second))
+	fmt.Printf("connections: %d\n", i.Server.Connections)
+	fmt.Printf("slow_consumer_disconnects: %d\n", i.Server.SlowConsumerDisconnects)
+	fmt.Printf("ops_per_sec: %.1f\n", i.Server.OpsPerSec)
+	names := make([]string, 0, len(i.Server.Clients))
+	for name := range i.Server.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("clients[%s]: %d\n", name, i.Server.Clients[name])
+	}
+
+	fmt.Println("\n# Store")
+	fmt.Printf("keys: %d\n", i.Store.Keys)
+	fmt.Printf("memory_bytes: %d\n", i.Store.MemoryBytes)
+	fmt.Printf("expired_keys: %d\n", i.Store.ExpiredKeys)
+	fmt.Printf("evicted_keys: %d\n", i.Store.EvictedKeys)
+	fmt.Printf("log_size: %d\n", i.Store.LogSize)
+	fmt.Printf("compactions: %d\n", i.Store.Compactions)
+
+	fmt.Println("\n# Commands")
+	ops := make([]string, 0, len(i.Commands))
+	for op := range i.Commands {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		st, ok := i.CommandStats[op]
+		if !ok {
+			fmt.Printf("%s: %d\n", strings.ToLower(op), i.Commands[op])
+			continue
+		}
+		fmt.Printf("%s: calls=%d errors=%d (%.1f%%) p50=%v p99=%v max=%v\n",
+			strings.ToLower(op), st.Calls, st.Errors, st.ErrorRate*100, st.P50, st.P99, st.Max)
 	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pixperk/yakvs/client"
+	"github.com/pixperk/yakvs/server"
+)
+
+// startServer starts a standalone server and returns a client connected to it
+func startServer(t *testing.T) *client.Client {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	srv, err := server.NewServer(addr, filepath.Join(t.TempDir(), "kv.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	c, err := client.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// run runs a command line and returns what it printed
+func run(t *testing.T, c *client.Client, line string) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	processCommand(c, strings.Fields(line))
+	w.Close()
+	return <-out
+}
+
+func TestFlushAllConfirmation(t *testing.T) {
+	c := startServer(t)
+	defer func() { replInput = nil }()
+
+	exists := func() bool {
+		t.Helper()
+
+		_, _, err := c.Get("key")
+		return err == nil
+	}
+	run(t, c, "set key value")
+
+	// Scripts must pass confirm, as there is nobody to ask
+	if out := run(t, c, "flushall"); !strings.Contains(out, "must be confirmed") || !exists() {
+		t.Fatalf("flushall without confirm printed %q", out)
+	}
+
+	// At the prompt, anything but yes aborts
+	for _, answer := range []string{"n", "", "nope"} {
+		replInput = bufio.NewScanner(strings.NewReader(answer + "\n"))
+		if out := run(t, c, "flushall"); !strings.Contains(out, "[y/N]") || !strings.Contains(out, "Aborted") || !exists() {
+			t.Fatalf("flushall answered %q printed %q", answer, out)
+		}
+	}
+	replInput = bufio.NewScanner(strings.NewReader("y\n"))
+	if out := run(t, c, "flushall"); !strings.Contains(out, "Removed every key") || exists() {
+		t.Fatalf("flushall answered y printed %q", out)
+	}
+
+	run(t, c, "set key value")
+	replInput = nil
+	if out := run(t, c, "flushall confirm"); !strings.Contains(out, "Removed every key") || exists() {
+		t.Fatalf("flushall confirm printed %q", out)
+	}
+}
@@ -0,0 +1,81 @@
+// Command codec-bench compares the JSON and MessagePack framings of the TCP
+// protocol, encoding and decoding a typical SET command and GET response
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/client"
+	"github.com/pixperk/yakvs/wire"
+)
+
+func main() {
+	valueSize := flag.Int("value-size", 256, "size in bytes of the value set and returned")
+	flag.Parse()
+
+	value := strings.Repeat("v", *valueSize)
+	messages := []struct {
+		name string
+		v    any
+		new  func() any
+	}{
+		{"set", client.Command{Op: "SET", Key: "user:1042:profile", Value: value, ExpiresIn: time.Hour}, func() any { return new(client.Command) }},
+		{"get", client.Response{Status: "success", Code: "OK", Value: value, TTL: time.Hour}, func() any { return new(client.Response) }},
+	}
+
+	fmt.Printf("%-8s %-8s %-7s %12s %10s %10s %10s\n", "codec", "message", "op", "ns/op", "MB/s", "B/op", "allocs/op")
+	for _, c := range []wire.Codec{wire.JSON, wire.MsgPack} {
+		for _, m := range messages {
+			frame, err := c.Marshal(m.v)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: failed to marshal %s: %v\n", c.Name(), m.name, err)
+				os.Exit(1)
+			}
+
+			encode := testing.Benchmark(func(b *testing.B) {
+				b.SetBytes(int64(len(frame)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Marshal(m.v); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+
+			// Decoding includes reading the frame, as a connection does
+			decode := testing.Benchmark(func(b *testing.B) {
+				b.SetBytes(int64(len(frame)))
+				b.ReportAllocs()
+				r := bufio.NewReader(nil)
+				for i := 0; i < b.N; i++ {
+					r.Reset(bytes.NewReader(frame))
+					payload, err := c.ReadFrame(r, 0)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := c.Unmarshal(payload, m.new()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+
+			printResult(c.Name(), m.name, "encode", encode)
+			printResult(c.Name(), m.name, "decode", decode)
+		}
+	}
+}
+
+func printResult(codec, message, op string, r testing.BenchmarkResult) {
+	mbPerSec := 0.0
+	if r.T > 0 {
+		mbPerSec = float64(r.Bytes) * float64(r.N) / 1e6 / r.T.Seconds()
+	}
+	fmt.Printf("%-8s %-8s %-7s %12d %10.1f %10d %10d\n", codec, message, op, r.NsPerOp(), mbPerSec, r.AllocedBytesPerOp(), r.AllocsPerOp())
+}
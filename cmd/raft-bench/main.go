@@ -0,0 +1,92 @@
+// Command raft-bench compares the write throughput of a running raft cluster
+// when keys are set one per log entry and when they are batched with
+// MSetEntries into one log entry per batch
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixperk/yakvs/client"
+)
+
+func main() {
+	addr := flag.String("server", "localhost:8080", "any node of the cluster")
+	keys := flag.Int("keys", 20000, "number of keys each run writes")
+	batchSize := flag.Int("batch", 500, "keys per MSET in the batched run")
+	conns := flag.Int("conns", 4, "number of concurrent connections")
+	valueSize := flag.Int("value-size", 128, "size in bytes of each value")
+	flag.Parse()
+
+	value := strings.Repeat("v", *valueSize)
+
+	single := run(*addr, *conns, *keys, func(c *client.RaftClient, from, to int) error {
+		for i := from; i < to; i++ {
+			if err := c.Set(fmt.Sprintf("bench:single:%d", i), value, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	batched := run(*addr, *conns, *keys, func(c *client.RaftClient, from, to int) error {
+		for start := from; start < to; start += *batchSize {
+			end := min(start+*batchSize, to)
+			entries := make([]client.Entry, 0, end-start)
+			for i := start; i < end; i++ {
+				entries = append(entries, client.Entry{Key: fmt.Sprintf("bench:batch:%d", i), Value: value})
+			}
+			if _, err := c.MSetEntries(entries); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	fmt.Printf("%-8s %10s %12s %14s\n", "mode", "keys", "elapsed", "writes/sec")
+	fmt.Printf("%-8s %10d %12s %14.0f\n", "single", *keys, single.Round(time.Millisecond), float64(*keys)/single.Seconds())
+	fmt.Printf("%-8s %10d %12s %14.0f\n", "batched", *keys, batched.Round(time.Millisecond), float64(*keys)/batched.Seconds())
+}
+
+// run splits keys between conns connections, each writing its share with
+// write, and returns how long they took
+func run(addr string, conns, keys int, write func(c *client.RaftClient, from, to int) error) time.Duration {
+	clients := make([]*client.RaftClient, conns)
+	for i := range clients {
+		c, err := client.NewRaftClient(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to %s: %v\n", addr, err)
+			os.Exit(1)
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, conns)
+	start := time.Now()
+	share := (keys + conns - 1) / conns
+	for i, c := range clients {
+		from, to := i*share, min((i+1)*share, keys)
+		wg.Add(1)
+		go func(c *client.RaftClient, from, to int) {
+			defer wg.Done()
+			if err := write(c, from, to); err != nil {
+				errs <- err
+			}
+		}(c, from, to)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	close(errs)
+	if err := <-errs; err != nil {
+		fmt.Fprintf(os.Stderr, "Write failed: %v\n", err)
+		os.Exit(1)
+	}
+	return elapsed
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/client"
+	"github.com/pixperk/yakvs/raft"
+	"github.com/pixperk/yakvs/server"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startCluster starts a one node cluster serving clients and its HTTP API,
+// waits for it to be given a cluster ID and returns a client connected to it
+func startCluster(t *testing.T) *client.RaftClient {
+	t.Helper()
+
+	dir := t.TempDir()
+	config := raft.Config{
+		NodeID:           "node0",
+		RaftDir:          dir,
+		RaftAddr:         freeAddr(t),
+		LogFilePath:      filepath.Join(dir, "kv.log"),
+		Bootstrap:        true,
+		ClientAddr:       freeAddr(t),
+		APIAddr:          freeAddr(t),
+		HeartbeatTimeout: 100 * time.Millisecond,
+		ElectionTimeout:  100 * time.Millisecond,
+		ApplyTimeout:     5 * time.Second,
+	}
+	rs, err := raft.NewRaftStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rs.Shutdown() })
+
+	api := raft.NewAPI(rs, config.APIAddr)
+	if err := api.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { api.Stop() })
+	srv := server.NewRaftServer(config.ClientAddr, rs)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !rs.IsLeader() || rs.ClusterID() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the cluster to lead and have an ID")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c, err := client.NewRaftClient(config.ClientAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// run runs a command line and returns what it printed
+func run(t *testing.T, c *client.RaftClient, line string) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	processCommand(c, strings.Fields(line))
+	w.Close()
+	return <-out
+}
+
+func TestRestoreFromAnotherClusterNeedsForce(t *testing.T) {
+	source, target := startCluster(t), startCluster(t)
+	path := filepath.Join(t.TempDir(), "backup.snap")
+
+	run(t, source, "set from the-other-cluster")
+	if out := run(t, source, "backup --out "+path); !strings.Contains(out, "1 entries") {
+		t.Fatalf("backup printed %q", out)
+	}
+	run(t, target, "set mine this-cluster")
+
+	out := run(t, target, "restore --in "+path)
+	if !strings.Contains(out, "another cluster") || !strings.Contains(out, "--force") {
+		t.Errorf("restore from another cluster printed %q", out)
+	}
+	if value, _, err := target.Get("mine"); err != nil || value != "this-cluster" {
+		t.Fatalf("after a refused restore, mine = %q, %v", value, err)
+	}
+
+	if out := run(t, target, "restore --in "+path+" --mode merge"); !strings.Contains(out, "unsupported mode") {
+		t.Errorf("restore --mode merge printed %q", out)
+	}
+
+	out = run(t, target, "restore --in "+path+" --mode replace --force")
+	if !strings.Contains(out, "Restored the cluster") {
+		t.Fatalf("forced restore printed %q", out)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		value, _, err := target.Get("from")
+		if err == nil && value == "the-other-cluster" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after a forced restore, from = %q, %v", value, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFlushAllConfirmation(t *testing.T) {
+	c := startCluster(t)
+	defer func() { replInput = nil }()
+
+	exists := func() bool {
+		t.Helper()
+
+		_, _, err := c.Get("key")
+		return err == nil
+	}
+	run(t, c, "set key value")
+
+	// Scripts must pass confirm, as there is nobody to ask
+	if out := run(t, c, "flushall"); !strings.Contains(out, "must be confirmed") || !exists() {
+		t.Fatalf("flushall without confirm printed %q", out)
+	}
+
+	// At the prompt, anything but yes aborts
+	for _, answer := range []string{"n", "", "nope"} {
+		replInput = bufio.NewScanner(strings.NewReader(answer + "\n"))
+		if out := run(t, c, "flushall"); !strings.Contains(out, "[y/N]") || !strings.Contains(out, "Aborted") || !exists() {
+			t.Fatalf("flushall answered %q printed %q", answer, out)
+		}
+	}
+	replInput = bufio.NewScanner(strings.NewReader("y\n"))
+	if out := run(t, c, "flushall"); !strings.Contains(out, "Removed every key") || exists() {
+		t.Fatalf("flushall answered y printed %q", out)
+	}
+
+	run(t, c, "set key value")
+	replInput = nil
+	if out := run(t, c, "flushall confirm"); !strings.Contains(out, "Removed every key") || exists() {
+		t.Fatalf("flushall confirm printed %q", out)
+	}
+}
@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/audit"
+	"github.com/pixperk/yakvs/chaos"
+	"github.com/pixperk/yakvs/discovery"
+	"github.com/pixperk/yakvs/handover"
 	"github.com/pixperk/yakvs/raft"
 	"github.com/pixperk/yakvs/server"
+	"github.com/pixperk/yakvs/snapshot"
+	"github.com/pixperk/yakvs/store"
+	"github.com/pixperk/yakvs/tlsconfig"
+	"github.com/pixperk/yakvs/version"
 )
 
 func main() {
@@ -18,18 +32,135 @@ func main() {
 	nodeID := flag.String("id", "", "unique node ID")
 	raftAddr := flag.String("raft", "localhost:7000", "raft transport address")
 	tcpAddr := flag.String("tcp", "localhost:8080", "TCP server address")
+	advertiseAddr := flag.String("advertise", "", "TCP address other nodes redirect clients to while this node leads (default: -tcp)")
 	apiAddr := flag.String("api", "localhost:8081", "HTTP API address")
+	respAddr := flag.String("resp", "", "also serve Redis clients (RESP2) on this address")
+	respMaxArgs := flag.Int("resp-max-args", server.DefaultMaxRESPArgs, "most arguments a RESP command may have (0 for no limit)")
+	grpcAddr := flag.String("grpc", "", "also serve the gRPC API on this address")
 	raftDir := flag.String("dir", "raft-data", "directory for Raft data")
 	joinAddr := flag.String("join", "", "leader address to join (empty for first node)")
 	bootstrap := flag.Bool("bootstrap", false, "bootstrap the cluster with this node")
+	restoreFile := flag.String("restore", "", "start a new cluster from this backup file (requires -bootstrap and an empty -dir)")
+	snapshotBackend := flag.String("snapshot-backend", "", "mirror snapshots to a directory or s3://bucket/prefix")
+	enableChaos := flag.Bool("chaos", false, "enable failure injection, configured through the API's /chaos endpoint")
+	writeTimeout := flag.Duration("write-timeout", server.DefaultWriteTimeout, "disconnect clients that stop reading for this long (0 disables)")
+	outputBufferLimit := flag.Int("output-buffer-limit", server.DefaultOutputBufferLimit, "disconnect subscribers with more than this many bytes of events queued (0 disables)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "disconnect clients that send no request for this long (0 disables)")
+	maxRequestSize := flag.Int("max-request-size", server.DefaultMaxRequestSize, "longest request line in bytes a client may send (0 for no limit)")
+	forwardWrites := flag.Bool("forward-writes", false, "forward writes sent to a follower to the leader instead of redirecting the client")
+	forwardTimeout := flag.Duration("forward-timeout", 5*time.Second, "how long a forwarded write may take")
+	forwardAuth := flag.String("forward-auth", "", "user:password followers forward writes as when the cluster has users (default: -join-auth)")
+	readConsistency := flag.String("read-consistency", server.ConsistencyStale, "how reads that name no consistency are served: stale reads this node, linearizable reads the leader")
+	fsync := flag.String("fsync", string(store.DefaultSyncPolicy), "when to fsync the key-value log: always, everysec or no")
+	localLog := flag.Bool("local-log", false, "also write the key-value data to a log in -dir, which the raft log and snapshots already rebuild it from on start")
+	flag.Bool("no-persistence", false, "no longer needed: the key-value data is kept only in memory unless -local-log is set")
+	keyFile := flag.String("encryption-key-file", "", "file holding a hex encoded AES key to encrypt the key-value log with (default: $"+store.EncryptionKeyEnv+" if set)")
+	replayFlag := flag.String("replay", string(store.DefaultReplayMode), "what to do with corrupt records in the key-value log on start: strict refuses to start, skip drops them")
+	maxKeySize := flag.Int("max-key-size", store.DefaultLimits.MaxKeySize, "largest key in bytes a write may use (0 for no limit)")
+	maxValueSize := flag.Int("max-value-size", store.DefaultLimits.MaxValueSize, "largest value in bytes a write may store (0 for no limit)")
+	maxBatchBytes := flag.Int("max-batch-bytes", raft.DefaultMaxBatchBytes, "largest MSET or transaction in bytes of keys and values, which is proposed as one log entry")
+	maxMemory := flag.Int64("max-memory", 0, "reject writes while the data uses this many bytes or more (0 for no limit)")
+	cleanerInterval := flag.Duration("cleaner-interval", 10*time.Second, "how often expired keys are swept")
+	applyTimeout := flag.Duration("apply-timeout", raft.DefaultApplyTimeout, "how long a write may wait to be committed and applied")
+	heartbeatTimeout := flag.Duration("heartbeat-timeout", 0, "raft heartbeat timeout (0 for the raft default of 1s)")
+	electionTimeout := flag.Duration("election-timeout", 0, "raft election timeout, at least -heartbeat-timeout (0 for the raft default of 1s)")
+	snapshotInterval := flag.Duration("snapshot-interval", 0, "how often raft checks whether to snapshot (0 for the raft default of 2m)")
+	snapshotThreshold := flag.Uint64("snapshot-threshold", 0, "log entries since the last snapshot that trigger a new one (0 for the raft default of 8192)")
+	trailingLogs := flag.Uint64("trailing-logs", 0, "log entries kept after a snapshot for slow followers (0 for the raft default of 10240)")
+	snapshotCompression := flag.Int("snapshot-compression", raft.DefaultSnapshotCompression, "gzip level from 1 to 9 raft snapshots are written with (-1 writes them uncompressed)")
+	maxAppendEntries := flag.Int("max-append-entries", 0, "most log entries sent to a follower at once, up to 1024 (0 for the raft default of 64)")
+	compactRatio := flag.Float64("auto-compact-ratio", store.DefaultAutoCompaction.Ratio, "compact the log once it is this many times the size of the live keys (0 disables)")
+	compactMinSize := flag.Int64("auto-compact-min-size", store.DefaultAutoCompaction.MinSize, "smallest log in bytes compacted automatically")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate the TCP server serves TLS with (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "PEM CAs client certificates must be signed by")
+	usersFile := flag.String("users", "", "JSON file of the users clients and joining nodes must log in as, with the commands and keys each may use")
+	auditPath := flag.String("audit-log", "", "file to record every write clients send in, with who sent it and its outcome")
+	auditMaxSize := flag.Int64("audit-log-max-size", audit.DefaultMaxSize, "size in bytes at which the audit log is rotated to <file>.1")
+	joinAttempts := flag.Int("join-attempts", raft.DefaultJoinAttempts, "requests a join may send to each -join target, counting redirects to the leader and retries while there is none")
+	joinAuth := flag.String("join-auth", "", "user:password to join the cluster with (default: $YAKVS_JOIN_AUTH if set)")
+	var listen listenFlags
+	flag.Var(&listen, "listen", "also accept clients on network:address[,tls][,noauth], such as unix:/run/yakvs.sock,noauth (repeatable)")
+	transferOnShutdown := flag.Bool("transfer-on-shutdown", false, "hand over the leadership on SIGINT or SIGTERM if this node leads")
+	leaveOnShutdown := flag.Bool("leave-on-shutdown", false, "leave the cluster on SIGINT or SIGTERM, handing over leadership first, instead of staying a member that is down")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "time allowed for in-flight commands to finish on restart")
+	showVersion := flag.Bool("version", false, "print version information and exit")
 
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(version.String())
+		return
+	}
+
 	// Check required parameters
 	if *nodeID == "" {
 		log.Fatal("Error: node ID is required")
 	}
 
+	syncPolicy, err := store.ParseSyncPolicy(*fsync)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	replayMode, err := store.ParseReplayMode(*replayFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	var serverTLS, forwardTLS *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		if serverTLS, err = tlsconfig.Server(*tlsCert, *tlsKey, *tlsCA); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		// Forwarded writes reach the leader's TLS listener, which presents
+		// a certificate signed by the same CA
+		if *forwardWrites {
+			if forwardTLS, err = tlsconfig.Client(*tlsCA, *tlsCert, *tlsKey); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+	}
+	var users *acl.Users
+	if *usersFile != "" {
+		if users, err = acl.Load(*usersFile); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	var auditLog *audit.Log
+	if *auditPath != "" {
+		if auditLog, err = audit.Open(*auditPath, *auditMaxSize); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if *joinAuth == "" {
+		*joinAuth = os.Getenv("YAKVS_JOIN_AUTH")
+	}
+	if *forwardAuth == "" {
+		*forwardAuth = *joinAuth
+	}
+	var encryptionKey []byte
+	if *localLog {
+		if encryptionKey, err = store.LoadEncryptionKey(*keyFile); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *enableChaos {
+		chaos.Enable()
+		fmt.Println("WARNING: failure injection is enabled")
+	}
+
+	// During a graceful restart the old process still owns the raft stores
+	if handover.InProgress() {
+		fmt.Println("Waiting for the previous process to hand over...")
+		if err := handover.WaitForParent(); err != nil {
+			log.Fatalf("Error waiting for handover: %v", err)
+		}
+	}
+
+	if *advertiseAddr == "" {
+		*advertiseAddr = *tcpAddr
+	}
+
 	// Create data directory
 	dataDir := filepath.Join(*raftDir, *nodeID)
 	os.MkdirAll(dataDir, 0755)
@@ -38,57 +169,255 @@ func main() {
 
 	// Create and start RaftStore
 	config := raft.Config{
-		NodeID:      *nodeID,
-		RaftDir:     dataDir,
-		RaftAddr:    *raftAddr,
-		Bootstrap:   *bootstrap,
-		LogFilePath: logFilePath,
+		NodeID:        *nodeID,
+		RaftDir:       dataDir,
+		RaftAddr:      *raftAddr,
+		ClientAddr:    *advertiseAddr,
+		APIAddr:       *apiAddr,
+		Bootstrap:     *bootstrap,
+		LogFilePath:   logFilePath,
+		SyncPolicy:    syncPolicy,
+		ReplayMode:    replayMode,
+		EncryptionKey: encryptionKey,
+		LocalLog:      *localLog,
+		Limits:        &store.Limits{MaxKeySize: *maxKeySize, MaxValueSize: *maxValueSize},
+
+		MaxMemory:       *maxMemory,
+		MaxBatchBytes:   *maxBatchBytes,
+		AutoCompaction:  &store.AutoCompaction{Ratio: *compactRatio, MinSize: *compactMinSize},
+		CleanerInterval: *cleanerInterval,
+
+		ApplyTimeout:      *applyTimeout,
+		HeartbeatTimeout:  *heartbeatTimeout,
+		ElectionTimeout:   *electionTimeout,
+		SnapshotInterval:  *snapshotInterval,
+		SnapshotThreshold: *snapshotThreshold,
+		TrailingLogs:      *trailingLogs,
+		MaxAppendEntries:  *maxAppendEntries,
+
+		SnapshotCompression: *snapshotCompression,
+	}
+
+	// A graceful restart reruns the same flags, but the backup was already
+	// restored by the first process
+	if !handover.InProgress() {
+		config.RestoreFrom = *restoreFile
+	}
+
+	if *snapshotBackend != "" {
+		storage, err := snapshot.Open(*snapshotBackend)
+		if err != nil {
+			log.Fatalf("Failed to open snapshot backend: %v", err)
+		}
+		config.SnapshotStorage = storage
 	}
 
 	raftStore, err := raft.NewRaftStore(config)
 	if err != nil {
 		log.Fatalf("Failed to create Raft store: %v", err)
 	}
+	raftStore.OnLeaderChange(func(isLeader bool, leaderAddr string) {
+		switch {
+		case isLeader:
+			fmt.Printf("This node is now the leader, at %s\n", leaderAddr)
+		case leaderAddr == "":
+			fmt.Println("Lost the leader, waiting for an election")
+		default:
+			fmt.Printf("The leader is now %s\n", leaderAddr)
+		}
+	})
+	if config.LocalLog {
+		replay := raftStore.ReplayStats()
+		fmt.Printf("Replayed %d log records from %s, discarded %d, skipped %d corrupt\n", replay.Replayed, config.LogFilePath, replay.Discarded, replay.Skipped)
+	} else {
+		fmt.Println("Key-value log is disabled, data is rebuilt from the raft log")
+		if _, err := os.Stat(logFilePath); err == nil {
+			fmt.Printf("Ignoring the key-value log %s left by -local-log, which can be removed\n", logFilePath)
+		}
+	}
 
-	// Create and start API server
+	srv := server.NewRaftServer(*tcpAddr, raftStore)
+
+	// Create and start API server, which also serves the node's metrics and
+	// command stats
 	api := raft.NewAPI(raftStore, *apiAddr)
+	api.Handle("/metrics", srv.MetricsHandler())
+	api.SetLatencies(srv.Latencies())
+	api.SetUsers(users)
 	if err := api.Start(); err != nil {
 		log.Fatalf("Failed to start API server: %v", err)
 	}
 
-	// Create and start TCP server
-	srv := server.NewRaftServer(*tcpAddr, raftStore)
+	// Start TCP server
+	srv.SetWriteTimeout(*writeTimeout)
+	srv.SetIdleTimeout(*idleTimeout)
+	srv.SetOutputBufferLimit(*outputBufferLimit)
+	srv.SetMaxRequestSize(*maxRequestSize)
+	srv.SetMaxRESPArgs(*respMaxArgs)
+	if err := srv.SetReadConsistency(*readConsistency); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	srv.SetTLSConfig(serverTLS)
+	srv.SetUsers(users)
+	srv.SetAuditLog(auditLog)
+	if *forwardWrites {
+		forwardUser, forwardPassword, _ := strings.Cut(*forwardAuth, ":")
+		srv.SetForwarding(*forwardTimeout)
+		srv.SetForwardTLSConfig(forwardTLS)
+		srv.SetForwardAuth(forwardUser, forwardPassword)
+	}
+	for _, l := range listen {
+		spec, err := server.ParseListenerSpec(l, serverTLS)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		srv.AddListener(spec)
+	}
 	if err := srv.Start(); err != nil {
 		log.Fatalf("Failed to start TCP server: %v", err)
 	}
+	if *respAddr != "" {
+		if err := srv.StartRESP(*respAddr); err != nil {
+			log.Fatalf("Failed to start RESP listener: %v", err)
+		}
+	}
+	if *grpcAddr != "" {
+		if err := srv.StartGRPC(*grpcAddr); err != nil {
+			log.Fatalf("Failed to start gRPC listener: %v", err)
+		}
+	}
 
 	// Join an existing cluster if specified
 	if *joinAddr != "" && *joinAddr != *apiAddr {
 		fmt.Printf("Joining cluster at %s\n", *joinAddr)
-
-		// Create HTTP client to join the cluster
-		joinURL := fmt.Sprintf("http://%s/join", *joinAddr)
-		payload := fmt.Sprintf(`{"node_id":"%s","addr":"%s"}`, *nodeID, *raftAddr)
-
-		// In a real implementation, you would make an HTTP POST request here
-		// For simplicity, we'll just print the command
-		fmt.Printf("curl -X POST -d '%s' %s\n", payload, joinURL)
+		if err := joinCluster(*joinAddr, *apiAddr, *joinAttempts, raft.JoinRequest{
+			NodeID:     *nodeID,
+			Addr:       *raftAddr,
+			ClientAddr: *advertiseAddr,
+			APIAddr:    *apiAddr,
+		}, *joinAuth); err != nil {
+			fmt.Printf("Failed to join cluster: %v\n", err)
+		}
 	}
 
-	fmt.Printf("Raft node %s started\n", *nodeID)
+	fmt.Printf("Raft node %s started (%s)\n", *nodeID, version.Version)
 	fmt.Printf("- Raft Address: %s\n", *raftAddr)
 	fmt.Printf("- TCP Address:  %s\n", *tcpAddr)
 	fmt.Printf("- API Address:  %s\n", *apiAddr)
+	if *respAddr != "" {
+		fmt.Printf("- RESP Address: %s\n", *respAddr)
+	}
+	if *grpcAddr != "" {
+		fmt.Printf("- gRPC Address: %s\n", *grpcAddr)
+	}
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+
+	// SIGUSR2 hands the TCP, API, RESP and gRPC listeners over to a freshly started
+	// binary
+	var release func()
+	for sig := range quit {
+		if sig != syscall.SIGUSR2 {
+			break
+		}
+
+		var err error
+		listeners := srv.Listeners()
+		listeners["api"] = api.Listener()
+		if *respAddr != "" {
+			listeners["resp"] = srv.RESPListener()
+		}
+		if *grpcAddr != "" {
+			listeners["grpc"] = srv.GRPCListener()
+		}
+		release, err = handover.StartReplacement(listeners)
+		if err != nil {
+			fmt.Printf("Error starting graceful restart: %v\n", err)
+			continue
+		}
+		break
+	}
 
 	fmt.Println("Shutting down...")
 
+	// A graceful restart keeps the node in the cluster, though the leadership
+	// may still be handed over while it restarts. Leaving hands it over
+	// anyway.
+	leave := *leaveOnShutdown && release == nil
+	if *transferOnShutdown && !leave && raftStore.IsLeader() {
+		if leaderID, err := raftStore.TransferLeadership(""); err != nil {
+			fmt.Printf("Failed to transfer leadership: %v\n", err)
+		} else {
+			fmt.Printf("Transferred leadership to %s\n", leaderID)
+		}
+	}
+	if leave {
+		user, password, _ := strings.Cut(*joinAuth, ":")
+		if err := raftStore.LeaveAs(user, password); err != nil {
+			fmt.Printf("Failed to leave the cluster: %v\n", err)
+		} else {
+			fmt.Println("Left the cluster")
+		}
+	}
+
 	// Graceful shutdown
-	srv.Stop()
-	api.Stop()
+	srv.Shutdown(*drainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	api.Shutdown(ctx)
+	cancel()
 	raftStore.Shutdown()
+
+	if release != nil {
+		release()
+	}
+}
+
+// joinCluster asks the nodes behind joinAddr, which may be a dns+srv://
+// address, to add this node, trying each in turn until one accepts or the
+// leader refuses
+func joinCluster(joinAddr, selfAPI string, attempts int, req raft.JoinRequest, auth string) error {
+	targets, err := discovery.Resolve(joinAddr)
+	if err != nil {
+		return err
+	}
+
+	user, password, _ := strings.Cut(auth, ":")
+	opts := raft.JoinOptions{User: user, Password: password, Attempts: attempts}
+	var lastErr error
+	for _, target := range targets {
+		if target == selfAPI {
+			continue
+		}
+
+		if lastErr = raft.JoinClusterWithOptions(target, req, opts); lastErr == nil {
+			fmt.Printf("Joined cluster through %s\n", target)
+			return nil
+		}
+		var rejected *raft.JoinRejectedError
+		if errors.As(lastErr, &rejected) {
+			return lastErr
+		}
+		fmt.Printf("Join through %s failed: %v\n", target, lastErr)
+	}
+
+	if lastErr == nil {
+		return fmt.Errorf("no other nodes found at %s", joinAddr)
+	}
+	return lastErr
+}
+
+// listenFlags collects the values of the repeatable -listen flag
+type listenFlags []string
+
+func (f *listenFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *listenFlags) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
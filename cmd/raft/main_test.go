@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// replacementPID finds the process a graceful restart started in the output
+// of the one it replaced
+var replacementPID = regexp.MustCompile(`Started replacement process (\d+)`)
+
+// response is the part of a server response the test looks at
+type response struct {
+	Status  string `json:"status"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// roundTrip sends one command on a new connection to addr. Only failures to
+// talk to the server are returned as errors.
+func roundTrip(addr string, cmd map[string]string) (response, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return response{}, err
+	}
+	defer conn.Close()
+
+	line, _ := json.Marshal(cmd)
+	if _, err := conn.Write(append(line, '\n')); err != nil {
+		return response{}, err
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reply, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return response{}, err
+	}
+	var resp response
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return response{}, fmt.Errorf("invalid response %q: %w", reply, err)
+	}
+	return resp, nil
+}
+
+// buildNode builds this command into a temporary directory
+func buildNode(t *testing.T) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("builds and runs the raft binary")
+	}
+	bin := filepath.Join(t.TempDir(), "yakvs-raft")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the raft node: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// waitForWrite sets key through the first of addrs to accept it, retrying
+// until one leads, and returns the address that did
+func waitForWrite(t *testing.T, addrs []string, key, value string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		for _, addr := range addrs {
+			resp, err := roundTrip(addr, map[string]string{"op": "SET", "key": key, "value": value})
+			if err == nil && resp.Status == "success" {
+				return addr
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no node of %v accepted a write of %s", addrs, key)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestGracefulRestartUnderLoad restarts a single node cluster with SIGUSR2
+// while clients keep connecting to it, and checks every connection gets a
+// response and every acknowledged write survives. Writes sent while the
+// replacement is electing itself are refused, not dropped.
+func TestGracefulRestartUnderLoad(t *testing.T) {
+	bin := buildNode(t)
+	dir := t.TempDir()
+	addr := freeAddr(t)
+
+	out, err := os.Create(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	cmd := exec.Command(bin, "-id", "node1", "-bootstrap", "-dir", filepath.Join(dir, "data"),
+		"-raft", freeAddr(t), "-tcp", addr, "-api", freeAddr(t), "-drain-timeout", "5s")
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	defer cmd.Process.Kill()
+
+	waitForWrite(t, []string{addr}, "ready", "1")
+
+	var (
+		stop    atomic.Bool
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errs    []error
+		written []string
+		refused int
+	)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				resp, err := roundTrip(addr, map[string]string{"op": "SET", "key": key, "value": key})
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				case resp.Status == "success":
+					written = append(written, key)
+				default:
+					refused++
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("old process exited with %v", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("old process did not exit after SIGUSR2")
+	}
+
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := replacementPID.FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("no replacement process was started:\n%s", data)
+	}
+	pid, _ := strconv.Atoi(string(m[1]))
+	replacement, err := os.FindProcess(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replacement.Kill()
+
+	// Keep the load on until the replacement has been leading for a while
+	waitForWrite(t, []string{addr}, "ready", "2")
+	time.Sleep(500 * time.Millisecond)
+	stop.Store(true)
+	wg.Wait()
+
+	for _, err := range errs {
+		t.Error(err)
+	}
+	if len(errs) > 0 {
+		t.Logf("node output:\n%s", data)
+	}
+
+	for _, key := range written {
+		if resp, err := roundTrip(addr, map[string]string{"op": "GET", "key": key}); err != nil || resp.Value != key {
+			t.Errorf("acknowledged write of %s lost across the restart: %+v, %v", key, resp, err)
+		}
+	}
+	t.Logf("%d writes across the restart, %d refused while no node led", len(written), refused)
+}
+
+// clusterNode is a node process of a cluster started by the test, which
+// keeps its flags and addresses across restarts
+type clusterNode struct {
+	args []string
+	tcp  string
+	out  string // file the node's output is appended to
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// start runs the node, killing it when the test ends
+func (n *clusterNode) start(t *testing.T, bin string) {
+	t.Helper()
+
+	out, err := os.OpenFile(n.out, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	n.cmd = exec.Command(bin, n.args...)
+	n.cmd.Stdout, n.cmd.Stderr = out, out
+	if err := n.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	n.done = make(chan struct{})
+	cmd, done := n.cmd, n.done
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-done
+	})
+}
+
+// kill stops the node with SIGKILL, giving it no chance to flush or close
+// anything
+func (n *clusterNode) kill(t *testing.T) {
+	t.Helper()
+
+	if err := n.cmd.Process.Signal(syscall.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	<-n.done
+}
+
+// waitForSnapshot waits for raft to have written a snapshot in dir, a
+// node's -dir joined with its ID
+func waitForSnapshot(t *testing.T, dir string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if snapshots, _ := filepath.Glob(filepath.Join(dir, "snapshots", "*", "state.bin")); len(snapshots) > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no snapshot was written in %s", dir)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestKillRecovery writes to a three node cluster, which keeps no key-value
+// log, across several snapshots, kills every node with SIGKILL and restarts
+// them, and checks each rebuilt every acknowledged write from its raft log
+// and snapshots
+func TestKillRecovery(t *testing.T) {
+	bin := buildNode(t)
+	dir := t.TempDir()
+
+	var nodes []*clusterNode
+	var tcpAddrs []string
+	firstAPI := ""
+	for i := 1; i <= 3; i++ {
+		api := freeAddr(t)
+		n := &clusterNode{
+			tcp: freeAddr(t),
+			out: filepath.Join(dir, fmt.Sprintf("node%d.txt", i)),
+		}
+		n.args = []string{
+			"-id", fmt.Sprintf("node%d", i), "-dir", filepath.Join(dir, fmt.Sprintf("node%d", i)),
+			"-raft", freeAddr(t), "-tcp", n.tcp, "-api", api,
+			"-heartbeat-timeout", "200ms", "-election-timeout", "200ms",
+			"-snapshot-interval", "100ms", "-snapshot-threshold", "50", "-trailing-logs", "10",
+		}
+		if i == 1 {
+			n.args = append(n.args, "-bootstrap")
+			firstAPI = api
+		} else {
+			n.args = append(n.args, "-join", firstAPI)
+		}
+		n.start(t, bin)
+		nodes = append(nodes, n)
+		tcpAddrs = append(tcpAddrs, n.tcp)
+
+		if i == 1 {
+			waitForWrite(t, tcpAddrs, "ready", "1")
+		}
+	}
+
+	// checkHolds waits for every node to serve every key from its own copy
+	checkHolds := func(keys []string) {
+		t.Helper()
+
+		for _, n := range nodes {
+			last := keys[len(keys)-1]
+			deadline := time.Now().Add(15 * time.Second)
+			for {
+				resp, err := roundTrip(n.tcp, map[string]string{"op": "GET", "key": last})
+				if err == nil && resp.Value == last {
+					break
+				}
+				if time.Now().After(deadline) {
+					data, _ := os.ReadFile(n.out)
+					t.Fatalf("%s never got %s: %+v, %v\n%s", n.tcp, last, resp, err, data)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			for _, key := range keys {
+				if resp, err := roundTrip(n.tcp, map[string]string{"op": "GET", "key": key}); err != nil || resp.Value != key {
+					t.Errorf("%s lost %s: %+v, %v", n.tcp, key, resp, err)
+				}
+			}
+		}
+	}
+
+	// Enough writes for several snapshots, with the latest ones only in
+	// the raft log
+	var keys []string
+	leader := waitForWrite(t, tcpAddrs, "ready", "2")
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		resp, err := roundTrip(leader, map[string]string{"op": "SET", "key": key, "value": key})
+		if err != nil || resp.Status != "success" {
+			t.Fatalf("SET %s: %+v, %v", key, resp, err)
+		}
+		keys = append(keys, key)
+		if i%100 == 99 {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}
+	checkHolds(keys)
+	for i := range nodes {
+		id := fmt.Sprintf("node%d", i+1)
+		waitForSnapshot(t, filepath.Join(dir, id, id))
+	}
+
+	for _, n := range nodes {
+		n.kill(t)
+	}
+	for _, n := range nodes {
+		n.start(t, bin)
+	}
+
+	waitForWrite(t, tcpAddrs, "after", "after")
+	checkHolds(append(keys, "after"))
+
+	for _, n := range nodes {
+		data, err := os.ReadFile(n.out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "Key-value log is disabled") {
+			t.Errorf("%s kept a key-value log:\n%s", n.tcp, data)
+		}
+	}
+}
@@ -1,40 +1,282 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/pixperk/yakvs"
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/audit"
+	"github.com/pixperk/yakvs/chaos"
+	"github.com/pixperk/yakvs/handover"
 	"github.com/pixperk/yakvs/server"
+	"github.com/pixperk/yakvs/store"
+	"github.com/pixperk/yakvs/tlsconfig"
+	"github.com/pixperk/yakvs/version"
 )
 
 func main() {
 	// Parse command line flags
 	addr := flag.String("addr", "localhost:8080", "server address")
 	logPath := flag.String("log", "kvs.log", "path to log file")
+	noPersistence := flag.Bool("no-persistence", false, "keep data only in memory, without a log file")
+	snapshotPath := flag.String("snapshot", "", "file BGSAVE writes snapshots to (default: the log path with .snapshot appended)")
+	replicaOf := flag.String("replica-of", "", "primary server address to replicate from (read-only replica)")
+	chaosAddr := flag.String("chaos", "", "enable failure injection with its admin endpoint on this HTTP address")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics at /metrics on this HTTP address")
+	respAddr := flag.String("resp", "", "also serve Redis clients (RESP2) on this address")
+	respMaxArgs := flag.Int("resp-max-args", server.DefaultMaxRESPArgs, "most arguments a RESP command may have (0 for no limit)")
+	grpcAddr := flag.String("grpc", "", "also serve the gRPC API on this address")
+	httpAddr := flag.String("http", "", "also serve the store over HTTP at /kv/ and /keys on this address")
+	writeTimeout := flag.Duration("write-timeout", server.DefaultWriteTimeout, "disconnect clients that stop reading for this long (0 disables)")
+	outputBufferLimit := flag.Int("output-buffer-limit", server.DefaultOutputBufferLimit, "disconnect subscribers with more than this many bytes of events queued (0 disables)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "disconnect clients that send no request for this long (0 disables)")
+	maxRequestSize := flag.Int("max-request-size", server.DefaultMaxRequestSize, "longest request line in bytes a client may send (0 for no limit)")
+	fsync := flag.String("fsync", string(store.DefaultSyncPolicy), "when to fsync the log: always, everysec or no")
+	keyFile := flag.String("encryption-key-file", "", "file holding a hex encoded AES key to encrypt the log and snapshots with (default: $"+store.EncryptionKeyEnv+" if set)")
+	replayFlag := flag.String("replay", string(store.DefaultReplayMode), "what to do with corrupt log records on start: strict refuses to start, skip drops them")
+	maxKeySize := flag.Int("max-key-size", store.DefaultLimits.MaxKeySize, "largest key in bytes a write may use (0 for no limit)")
+	maxValueSize := flag.Int("max-value-size", store.DefaultLimits.MaxValueSize, "largest value in bytes a write may store (0 for no limit)")
+	maxMemory := flag.Int64("max-memory", 0, "approximate memory in bytes the data may use (0 for no limit)")
+	evictionFlag := flag.String("eviction-policy", string(store.DefaultEvictionPolicy), "what writes over -max-memory do: noeviction rejects them, allkeys-random and volatile-ttl evict keys")
+	cleanerInterval := flag.Duration("cleaner-interval", 10*time.Second, "how often expired keys are swept")
+	compactRatio := flag.Float64("auto-compact-ratio", store.DefaultAutoCompaction.Ratio, "compact the log once it is this many times the size of the live keys (0 disables)")
+	compactMinSize := flag.Int64("auto-compact-min-size", store.DefaultAutoCompaction.MinSize, "smallest log in bytes compacted automatically")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate to serve TLS with (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "PEM CAs client certificates must be signed by, and a replica trusts its primary with")
+	usersFile := flag.String("users", "", "JSON file of the users clients must AUTH as, with the commands and keys each may use")
+	auditPath := flag.String("audit-log", "", "file to record every write clients send in, with who sent it and its outcome")
+	auditMaxSize := flag.Int64("audit-log-max-size", audit.DefaultMaxSize, "size in bytes at which the audit log is rotated to <file>.1")
+	replicaAuth := flag.String("replica-auth", "", "user:password a replica logs in to its primary with (default: $YAKVS_REPLICA_AUTH if set)")
+	var listen listenFlags
+	flag.Var(&listen, "listen", "also accept clients on network:address[,tls][,noauth], such as unix:/run/yakvs.sock,noauth (repeatable)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "time allowed for in-flight commands to finish on restart")
+	showVersion := flag.Bool("version", false, "print version information and exit")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(version.String())
+		return
+	}
+
+	syncPolicy, err := store.ParseSyncPolicy(*fsync)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	replayMode, err := store.ParseReplayMode(*replayFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	evictionPolicy, err := store.ParseEvictionPolicy(*evictionFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *cleanerInterval <= 0 {
+		fmt.Println("Error: -cleaner-interval must be positive")
+		os.Exit(1)
+	}
+	var serverTLS, replicaTLS *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		if serverTLS, err = tlsconfig.Server(*tlsCert, *tlsKey, *tlsCA); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *replicaOf != "" {
+			if replicaTLS, err = tlsconfig.Client(*tlsCA, *tlsCert, *tlsKey); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	var users *acl.Users
+	if *usersFile != "" {
+		if users, err = acl.Load(*usersFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var auditLog *audit.Log
+	if *auditPath != "" {
+		if auditLog, err = audit.Open(*auditPath, *auditMaxSize); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *replicaAuth == "" {
+		*replicaAuth = os.Getenv("YAKVS_REPLICA_AUTH")
+	}
+	replicaUser, replicaPassword, _ := strings.Cut(*replicaAuth, ":")
+	var encryptionKey []byte
+	if *noPersistence {
+		*logPath = ""
+	} else if encryptionKey, err = store.LoadEncryptionKey(*keyFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *chaosAddr != "" {
+		chaos.Enable()
+		fmt.Printf("WARNING: failure injection is enabled, configure it at http://%s/chaos\n", *chaosAddr)
+
+		mux := http.NewServeMux()
+		mux.Handle("/chaos", chaos.Handler())
+		go func() {
+			if err := http.ListenAndServe(*chaosAddr, mux); err != nil {
+				fmt.Printf("Error starting chaos endpoint: %v\n", err)
+			}
+		}()
+	}
+
+	// During a graceful restart the old process still owns the log file
+	if handover.InProgress() {
+		fmt.Println("Waiting for the previous process to hand over...")
+		if err := handover.WaitForParent(); err != nil {
+			fmt.Printf("Error waiting for handover: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Create and start server
-	srv, err := server.NewServer(*addr, *logPath)
+	srv, err := server.NewServerWithOptions(*addr, *logPath, *replicaOf, yakvs.Options{
+		SyncPolicy:      syncPolicy,
+		ReplayMode:      replayMode,
+		EncryptionKey:   encryptionKey,
+		CleanerInterval: *cleanerInterval,
+		Limits:          &store.Limits{MaxKeySize: *maxKeySize, MaxValueSize: *maxValueSize},
+		MaxMemory:       *maxMemory,
+		AutoCompaction:  &store.AutoCompaction{Ratio: *compactRatio, MinSize: *compactMinSize},
+		EvictionPolicy:  evictionPolicy,
+	})
 	if err != nil {
 		fmt.Printf("Error creating server: %v\n", err)
 		os.Exit(1)
 	}
 
+	srv.SetWriteTimeout(*writeTimeout)
+	srv.SetIdleTimeout(*idleTimeout)
+	srv.SetOutputBufferLimit(*outputBufferLimit)
+	srv.SetMaxRequestSize(*maxRequestSize)
+	srv.SetMaxRESPArgs(*respMaxArgs)
+	srv.SetTLSConfig(serverTLS)
+	srv.SetReplicaTLSConfig(replicaTLS)
+	srv.SetReplicaAuth(replicaUser, replicaPassword)
+	srv.SetUsers(users)
+	srv.SetAuditLog(auditLog)
+	for _, l := range listen {
+		spec, err := server.ParseListenerSpec(l, serverTLS)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		srv.AddListener(spec)
+	}
+	if *snapshotPath != "" {
+		srv.SetSnapshotPath(*snapshotPath)
+	}
 	if err := srv.Start(); err != nil {
 		fmt.Printf("Error starting server: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Wait for interrupt signal to gracefully shut down the server
+	// The RESP, gRPC, HTTP and metrics listeners are handed over on restart like
+	// the server's own
+	listeners := srv.Listeners()
+	if *respAddr != "" {
+		if err := srv.StartRESP(*respAddr); err != nil {
+			fmt.Printf("Error starting RESP listener: %v\n", err)
+			os.Exit(1)
+		}
+		listeners["resp"] = srv.RESPListener()
+	}
+	if *grpcAddr != "" {
+		if err := srv.StartGRPC(*grpcAddr); err != nil {
+			fmt.Printf("Error starting gRPC listener: %v\n", err)
+			os.Exit(1)
+		}
+		listeners["grpc"] = srv.GRPCListener()
+	}
+	if *httpAddr != "" {
+		httpListener, err := handover.Listen("http", *httpAddr)
+		if err != nil {
+			fmt.Printf("Error starting HTTP endpoint: %v\n", err)
+			os.Exit(1)
+		}
+		listeners["http"] = httpListener
+
+		go func() {
+			if err := http.Serve(httpListener, srv.HTTPHandler()); err != nil {
+				fmt.Printf("Error serving HTTP: %v\n", err)
+			}
+		}()
+		fmt.Printf("Serving the store over HTTP at http://%s/kv/\n", *httpAddr)
+	}
+	if *metricsAddr != "" {
+		metricsListener, err := handover.Listen("metrics", *metricsAddr)
+		if err != nil {
+			fmt.Printf("Error starting metrics endpoint: %v\n", err)
+			os.Exit(1)
+		}
+		listeners["metrics"] = metricsListener
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", srv.MetricsHandler())
+		go func() {
+			if err := http.Serve(metricsListener, mux); err != nil {
+				fmt.Printf("Error serving metrics: %v\n", err)
+			}
+		}()
+		fmt.Printf("Serving metrics at http://%s/metrics\n", *metricsAddr)
+	}
+
+	// Wait for interrupt signal to gracefully shut down the server, or
+	// SIGUSR2 to hand the listener over to a freshly started binary
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+
+	var release func()
+	for sig := range quit {
+		if sig != syscall.SIGUSR2 {
+			break
+		}
+
+		var err error
+		release, err = handover.StartReplacement(listeners)
+		if err != nil {
+			fmt.Printf("Error starting graceful restart: %v\n", err)
+			continue
+		}
+		break
+	}
 
 	fmt.Println("Shutting down server...")
-	if err := srv.Stop(); err != nil {
+	if err := srv.Shutdown(*drainTimeout); err != nil {
 		fmt.Printf("Error stopping server: %v\n", err)
 	}
+
+	if release != nil {
+		release()
+	}
+}
+
+// listenFlags collects the values of the repeatable -listen flag
+type listenFlags []string
+
+func (f *listenFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *listenFlags) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
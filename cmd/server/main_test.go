@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/client"
+)
+
+// buildServer builds this command into a temporary directory
+func buildServer(t *testing.T) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("builds and runs the server binary")
+	}
+	bin := filepath.Join(t.TempDir(), "yakvs-server")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the server: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// replacementPID finds the process a graceful restart started in the output
+// of the one it replaced
+var replacementPID = regexp.MustCompile(`Started replacement process (\d+)`)
+
+// TestGracefulRestartUnderLoad restarts a server with SIGUSR2 while clients
+// keep connecting to it, and checks none of them sees an error and every
+// acknowledged write survives
+func TestGracefulRestartUnderLoad(t *testing.T) {
+	bin := buildServer(t)
+	dir := t.TempDir()
+	addr := freeAddr(t)
+
+	out, err := os.Create(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	cmd := exec.Command(bin, "-addr", addr, "-log", filepath.Join(dir, "kv.log"), "-drain-timeout", "5s")
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	defer cmd.Process.Kill()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Each worker connects afresh for every write and reads it back
+	var (
+		stop    atomic.Bool
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errs    []error
+		written []string
+	)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				err := func() error {
+					c, err := client.NewClient(addr)
+					if err != nil {
+						return err
+					}
+					defer c.Close()
+					if err := c.Set(key, key, 0); err != nil {
+						return err
+					}
+					if got, _, err := c.Get(key); err != nil || got != key {
+						return fmt.Errorf("GET %s = %q, %v", key, got, err)
+					}
+					return nil
+				}()
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				} else {
+					written = append(written, key)
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("old process exited with %v", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("old process did not exit after SIGUSR2")
+	}
+
+	// The replacement inherited the output file
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := replacementPID.FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("no replacement process was started:\n%s", data)
+	}
+	pid, _ := strconv.Atoi(string(m[1]))
+	replacement, err := os.FindProcess(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replacement.Kill()
+
+	// Keep the load on the replacement for a while
+	time.Sleep(500 * time.Millisecond)
+	stop.Store(true)
+	wg.Wait()
+
+	for _, err := range errs {
+		t.Error(err)
+	}
+	if len(errs) > 0 {
+		t.Logf("server output:\n%s", data)
+	}
+
+	c, err := client.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, key := range written {
+		if got, _, err := c.Get(key); err != nil || got != key {
+			t.Errorf("acknowledged write of %s lost across the restart: %q, %v", key, got, err)
+		}
+	}
+	t.Logf("%d writes across the restart", len(written))
+}
@@ -0,0 +1,125 @@
+// Package discovery resolves dns+srv:// addresses, such as a Kubernetes
+// headless service, into the current set of node addresses.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme prefixes addresses that name a DNS SRV record
+const Scheme = "dns+srv://"
+
+// DefaultRefresh is how long a resolved set is reused before it is looked up
+// again. The standard resolver does not expose record TTLs, so this stands in
+// for them.
+const DefaultRefresh = 30 * time.Second
+
+// LookupFunc looks up SRV records, with the signature of
+// net.Resolver.LookupSRV. Tests can supply a fake.
+type LookupFunc func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// IsSRV reports whether addr uses the dns+srv:// scheme
+func IsSRV(addr string) bool {
+	return strings.HasPrefix(addr, Scheme)
+}
+
+// Resolver turns a dns+srv:// address into host:port targets, caching the
+// last successful answer. If a lookup fails, the last known good set is
+// returned instead of an error.
+type Resolver struct {
+	name    string
+	lookup  LookupFunc
+	refresh time.Duration
+
+	mu         sync.Mutex
+	addrs      []string
+	resolvedAt time.Time
+}
+
+// NewResolver creates a resolver for a dns+srv:// address, for example
+// dns+srv://_yakvs._tcp.my-service.ns.svc.cluster.local
+func NewResolver(addr string) (*Resolver, error) {
+	if !IsSRV(addr) {
+		return nil, fmt.Errorf("address %q does not use the %s scheme", addr, Scheme)
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(addr, Scheme), "/")
+	if name == "" {
+		return nil, fmt.Errorf("address %q has no record name", addr)
+	}
+
+	return &Resolver{
+		name:    name,
+		lookup:  net.DefaultResolver.LookupSRV,
+		refresh: DefaultRefresh,
+	}, nil
+}
+
+// WithLookup replaces the DNS lookup, for tests
+func (r *Resolver) WithLookup(lookup LookupFunc) *Resolver {
+	r.lookup = lookup
+	return r
+}
+
+// WithRefresh sets how long a resolved set is reused
+func (r *Resolver) WithRefresh(refresh time.Duration) *Resolver {
+	r.refresh = refresh
+	return r
+}
+
+// Addrs returns the targets of the SRV record in priority order. The cached
+// set is reused until it is older than the refresh interval, unless force is
+// set, which callers use after failing to reach every cached target.
+func (r *Resolver) Addrs(force bool) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !force && r.addrs != nil && time.Since(r.resolvedAt) < r.refresh {
+		return r.addrs, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// With an empty service and proto the name is looked up as given
+	_, records, err := r.lookup(ctx, "", "", r.name)
+	if err == nil && len(records) == 0 {
+		err = fmt.Errorf("no SRV records for %s", r.name)
+	}
+	if err != nil {
+		if r.addrs != nil {
+			return r.addrs, nil
+		}
+		return nil, fmt.Errorf("failed to resolve %s: %w", r.name, err)
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(rec.Port))))
+	}
+
+	r.addrs = addrs
+	r.resolvedAt = time.Now()
+	return addrs, nil
+}
+
+// Resolve returns the targets of addr if it is a dns+srv:// address, or addr
+// itself otherwise
+func Resolve(addr string) ([]string, error) {
+	if !IsSRV(addr) {
+		return []string{addr}, nil
+	}
+
+	r, err := NewResolver(addr)
+	if err != nil {
+		return nil, err
+	}
+	return r.Addrs(false)
+}
@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDNS answers SRV lookups with records that tests change, counting the
+// lookups made
+type fakeDNS struct {
+	mu      sync.Mutex
+	records []*net.SRV
+	err     error
+	lookups int
+	names   []string
+}
+
+func (d *fakeDNS) set(err error, records ...*net.SRV) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.records, d.err = records, err
+}
+
+func (d *fakeDNS) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.lookups
+}
+
+func (d *fakeDNS) lookup(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lookups++
+	d.names = append(d.names, name)
+	return name, d.records, d.err
+}
+
+func srv(target string, port uint16) *net.SRV {
+	return &net.SRV{Target: target, Port: port}
+}
+
+func newTestResolver(t *testing.T, dns *fakeDNS, refresh time.Duration) *Resolver {
+	t.Helper()
+
+	r, err := NewResolver("dns+srv://_yakvs._tcp.kv.default.svc.cluster.local/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.WithLookup(dns.lookup).WithRefresh(refresh)
+}
+
+// checkAddrs checks r resolves to want, with or without forcing a lookup
+func checkAddrs(t *testing.T, r *Resolver, force bool, want ...string) {
+	t.Helper()
+
+	got, err := r.Addrs(force)
+	if err != nil {
+		t.Fatalf("Addrs(%v): %v", force, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Addrs(%v) = %v, want %v", force, got, want)
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	for _, addr := range []string{"localhost:8080", "dns+srv://", "dns+srv:///"} {
+		if _, err := NewResolver(addr); err == nil {
+			t.Errorf("NewResolver(%q) succeeded", addr)
+		}
+	}
+
+	dns := &fakeDNS{}
+	dns.set(nil, srv("node-0.kv.default.svc.cluster.local.", 8080), srv("node-1.kv.default.svc.cluster.local.", 8081))
+	r := newTestResolver(t, dns, time.Minute)
+	checkAddrs(t, r, false, "node-0.kv.default.svc.cluster.local:8080", "node-1.kv.default.svc.cluster.local:8081")
+	if want := []string{"_yakvs._tcp.kv.default.svc.cluster.local"}; !reflect.DeepEqual(dns.names, want) {
+		t.Errorf("looked up %v, want %v", dns.names, want)
+	}
+}
+
+func TestResolverReusesAnswersUntilTheyExpire(t *testing.T) {
+	const refresh = 100 * time.Millisecond
+	dns := &fakeDNS{}
+	dns.set(nil, srv("a", 1))
+	r := newTestResolver(t, dns, refresh)
+
+	checkAddrs(t, r, false, "a:1")
+	dns.set(nil, srv("b", 2), srv("c", 3))
+	checkAddrs(t, r, false, "a:1")
+	if n := dns.count(); n != 1 {
+		t.Fatalf("%d lookups before the answer expired, want 1", n)
+	}
+
+	time.Sleep(refresh)
+	checkAddrs(t, r, false, "b:2", "c:3")
+	if n := dns.count(); n != 2 {
+		t.Fatalf("%d lookups once the answer expired, want 2", n)
+	}
+
+	// Forcing looks up again straight away, as after failing to reach
+	// every cached node
+	dns.set(nil, srv("d", 4))
+	checkAddrs(t, r, true, "d:4")
+	checkAddrs(t, r, false, "d:4")
+	if n := dns.count(); n != 3 {
+		t.Errorf("%d lookups after forcing one, want 3", n)
+	}
+}
+
+func TestResolverKeepsTheLastKnownGoodSet(t *testing.T) {
+	dns := &fakeDNS{}
+	r := newTestResolver(t, dns, time.Millisecond)
+
+	// With nothing to fall back on, failures are reported
+	dns.set(errors.New("server misbehaving"))
+	if _, err := r.Addrs(false); err == nil {
+		t.Fatal("Addrs succeeded on a failed first lookup")
+	}
+	dns.set(nil)
+	if _, err := r.Addrs(false); err == nil {
+		t.Fatal("Addrs succeeded without records")
+	}
+
+	dns.set(nil, srv("a", 1), srv("b", 2))
+	checkAddrs(t, r, false, "a:1", "b:2")
+
+	// Once something resolved, failed and empty lookups keep it
+	time.Sleep(2 * time.Millisecond)
+	dns.set(errors.New("i/o timeout"))
+	checkAddrs(t, r, false, "a:1", "b:2")
+	dns.set(nil)
+	checkAddrs(t, r, true, "a:1", "b:2")
+
+	// and the next good answer replaces it
+	dns.set(nil, srv("c", 3))
+	checkAddrs(t, r, true, "c:3")
+}
+
+func TestResolve(t *testing.T) {
+	addrs, err := Resolve("localhost:8080")
+	if err != nil || !reflect.DeepEqual(addrs, []string{"localhost:8080"}) {
+		t.Errorf("Resolve of a plain address = %v, %v", addrs, err)
+	}
+}
@@ -0,0 +1,177 @@
+// Package handover implements graceful restarts: a running process starts a
+// replacement of itself, passing its listening sockets so no connection is
+// refused while the binary is swapped.
+//
+// The parent stops accepting, drains in-flight work and releases its files
+// (the WAL, the raft stores) while the child waits; pending connections queue
+// in the kernel's accept backlog in the meantime. Once the parent signals that
+// it has released everything, the child opens its state and starts accepting
+// on the inherited sockets.
+package handover
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// envListeners lists the names of inherited listeners, in file descriptor
+	// order starting at 3
+	envListeners = "YAKVS_LISTENERS"
+	// envReadyFD is the descriptor of a pipe the parent closes once it has
+	// released its resources
+	envReadyFD = "YAKVS_HANDOVER_FD"
+)
+
+// InProgress reports whether this process was started by a graceful restart
+func InProgress() bool {
+	return os.Getenv(envReadyFD) != ""
+}
+
+// WaitForParent blocks until the process that started this one has drained
+// and released its resources. It returns immediately if there is no parent.
+func WaitForParent() error {
+	fdStr := os.Getenv(envReadyFD)
+	if fdStr == "" {
+		return nil
+	}
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envReadyFD, err)
+	}
+
+	pipe := os.NewFile(uintptr(fd), "handover")
+	defer pipe.Close()
+
+	// The parent never writes; EOF means it has closed its end
+	_, err = io.Copy(io.Discard, pipe)
+	return err
+}
+
+// Listener returns the inherited listener with the given name, or nil if the
+// parent did not pass one
+func Listener(name string) (net.Listener, error) {
+	names := os.Getenv(envListeners)
+	if names == "" {
+		return nil, nil
+	}
+
+	for i, n := range strings.Split(names, ",") {
+		if n != name {
+			continue
+		}
+
+		f := os.NewFile(uintptr(3+i), name)
+		defer f.Close()
+
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit %s listener: %w", name, err)
+		}
+		return l, nil
+	}
+
+	return nil, nil
+}
+
+// Listen returns the inherited listener with the given name if there is one,
+// and otherwise listens on the TCP address addr
+func Listen(name, addr string) (net.Listener, error) {
+	return ListenNetwork(name, "tcp", addr)
+}
+
+// ListenNetwork is Listen for any network net.Listen accepts, such as "unix".
+// A unix socket file left behind by a process that crashed is replaced.
+func ListenNetwork(name, network, addr string) (net.Listener, error) {
+	l, err := Listener(name)
+	if err != nil || l != nil {
+		return l, err
+	}
+	if network == "unix" {
+		removeStaleSocket(addr)
+	}
+	return net.Listen(network, addr)
+}
+
+// removeStaleSocket removes the unix socket at path if nothing accepts
+// connections on it
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// StartReplacement starts a new copy of the running binary with the same
+// arguments, passing it the given listeners. The returned release function
+// must be called once this process has released its resources; the new
+// process does not open its state until then.
+func StartReplacement(listeners map[string]net.Listener) (func(), error) {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate binary: %w", err)
+	}
+
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for name, l := range listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s cannot be passed to another process", name)
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("failed to duplicate %s listener: %w", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+
+		// The replacement serves the socket file from now on, so closing
+		// this process's listener must leave it in place
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyR)
+	cmd.Env = append(os.Environ(),
+		envListeners+"="+strings.Join(names, ","),
+		envReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+
+	if err := cmd.Start(); err != nil {
+		readyW.Close()
+		return nil, fmt.Errorf("failed to start replacement: %w", err)
+	}
+
+	fmt.Printf("Started replacement process %d\n", cmd.Process.Pid)
+	return func() { readyW.Close() }, nil
+}
@@ -0,0 +1,264 @@
+// Package metrics records server-side measurements such as command latency
+// and writes them in the Prometheus text format.
+package metrics
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// numBuckets covers 1µs to about 33s in powers of two, plus an overflow bucket
+const numBuckets = 27
+
+// Histogram is a fixed log-scale latency histogram. Bucket i counts
+// observations of at most 2^i microseconds. Observe never allocates and is
+// safe for concurrent use.
+type Histogram struct {
+	counts [numBuckets]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+	max    atomic.Int64
+}
+
+func bucketFor(d time.Duration) int {
+	us := uint64(d.Microseconds())
+	if us <= 1 {
+		return 0
+	}
+	// Smallest i with 2^i >= us
+	i := bits.Len64(us - 1)
+	if i >= numBuckets {
+		return numBuckets - 1
+	}
+	return i
+}
+
+// BucketBound returns the upper bound of bucket i
+func BucketBound(i int) time.Duration {
+	return time.Duration(1<<i) * time.Microsecond
+}
+
+// Observe records one duration
+func (h *Histogram) Observe(d time.Duration) {
+	h.counts[bucketFor(d)].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		old := h.max.Load()
+		if int64(d) <= old || h.max.CompareAndSwap(old, int64(d)) {
+			break
+		}
+	}
+}
+
+// Snapshot returns a point-in-time copy of the histogram
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count:   h.count.Load(),
+		Sum:     time.Duration(h.sum.Load()),
+		Max:     time.Duration(h.max.Load()),
+		Buckets: make([]uint64, numBuckets),
+	}
+	for i := range h.counts {
+		s.Buckets[i] = h.counts[i].Load()
+	}
+	return s
+}
+
+// HistogramSnapshot is a copy of a Histogram's counters
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     time.Duration
+	Max     time.Duration
+	Buckets []uint64
+}
+
+// add sums o into s, which must have as many buckets
+func (s *HistogramSnapshot) add(o HistogramSnapshot) {
+	s.Count += o.Count
+	s.Sum += o.Sum
+	s.Max = max(s.Max, o.Max)
+	for i, n := range o.Buckets {
+		s.Buckets[i] += n
+	}
+}
+
+// Quantile estimates the q-th quantile as the upper bound of the bucket that
+// contains it, capped at the largest observation
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(s.Count))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, n := range s.Buckets {
+		seen += n
+		if seen >= rank {
+			return min(BucketBound(i), s.Max)
+		}
+	}
+	return s.Max
+}
+
+// Key identifies one latency series. Outcome is the response status, such as
+// success, error or redirect. Stage is empty for the whole command, or names a
+// part of it, such as raft_apply.
+type Key struct {
+	Op      string
+	Outcome string
+	Stage   string
+}
+
+// Latencies holds a histogram per command, outcome and stage. Observing an
+// existing series takes no lock, so it is cheap enough to do on every command.
+type Latencies struct {
+	hists atomic.Pointer[sync.Map] // Key to *Histogram
+	rate  Rate
+}
+
+// Observe records d in the series for key, creating it on first use
+func (l *Latencies) Observe(key Key, d time.Duration) {
+	hists := l.hists.Load()
+	if hists == nil {
+		l.hists.CompareAndSwap(nil, &sync.Map{})
+		hists = l.hists.Load()
+	}
+
+	h, ok := hists.Load(key)
+	if !ok {
+		h, _ = hists.LoadOrStore(key, &Histogram{})
+	}
+	h.(*Histogram).Observe(d)
+
+	if key.Stage == "" {
+		l.rate.Mark()
+	}
+}
+
+// Reset discards every series
+func (l *Latencies) Reset() {
+	l.hists.Store(&sync.Map{})
+	l.rate.Reset()
+}
+
+// PerSecond returns how many commands were observed per second over the last
+// few seconds
+func (l *Latencies) PerSecond() float64 {
+	return l.rate.PerSecond()
+}
+
+// Series is a snapshot of one latency series
+type Series struct {
+	Key
+	HistogramSnapshot
+}
+
+// Snapshot returns every series sorted by op, stage and outcome
+func (l *Latencies) Snapshot() []Series {
+	var series []Series
+	if hists := l.hists.Load(); hists != nil {
+		hists.Range(func(key, h any) bool {
+			series = append(series, Series{Key: key.(Key), HistogramSnapshot: h.(*Histogram).Snapshot()})
+			return true
+		})
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i].Key, series[j].Key
+		if a.Op != b.Op {
+			return a.Op < b.Op
+		}
+		if a.Stage != b.Stage {
+			return a.Stage < b.Stage
+		}
+		return a.Outcome < b.Outcome
+	})
+	return series
+}
+
+// Summary reports the usual quantiles of a series
+type Summary struct {
+	Op      string        `json:"op"`
+	Outcome string        `json:"outcome,omitempty"`
+	Stage   string        `json:"stage,omitempty"`
+	Count   uint64        `json:"count"`
+	P50     time.Duration `json:"p50"`
+	P95     time.Duration `json:"p95"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+}
+
+// Summaries returns the quantile summary of every series
+func (l *Latencies) Summaries() []Summary {
+	series := l.Snapshot()
+	summaries := make([]Summary, len(series))
+	for i, s := range series {
+		summaries[i] = Summary{
+			Op:      s.Op,
+			Outcome: s.Outcome,
+			Stage:   s.Stage,
+			Count:   s.Count,
+			P50:     s.Quantile(0.50),
+			P95:     s.Quantile(0.95),
+			P99:     s.Quantile(0.99),
+			Max:     s.Max,
+		}
+	}
+	return summaries
+}
+
+// CommandStats sums up every outcome of one op. Errors counts the calls that
+// did not succeed, including redirects.
+type CommandStats struct {
+	Calls     uint64        `json:"calls"`
+	Errors    uint64        `json:"errors"`
+	ErrorRate float64       `json:"error_rate"`
+	P50       time.Duration `json:"p50"`
+	P95       time.Duration `json:"p95"`
+	P99       time.Duration `json:"p99"`
+	Max       time.Duration `json:"max"`
+}
+
+// CommandStats returns the stats of every op, merging the whole-command
+// series of all its outcomes
+func (l *Latencies) CommandStats() map[string]CommandStats {
+	merged := make(map[string]*HistogramSnapshot)
+	errs := make(map[string]uint64)
+	for _, s := range l.Snapshot() {
+		if s.Stage != "" {
+			continue
+		}
+
+		m, ok := merged[s.Op]
+		if !ok {
+			m = &HistogramSnapshot{Buckets: make([]uint64, numBuckets)}
+			merged[s.Op] = m
+		}
+		m.add(s.HistogramSnapshot)
+		if s.Outcome != "success" {
+			errs[s.Op] += s.Count
+		}
+	}
+
+	stats := make(map[string]CommandStats, len(merged))
+	for op, m := range merged {
+		stats[op] = CommandStats{
+			Calls:     m.Count,
+			Errors:    errs[op],
+			ErrorRate: float64(errs[op]) / float64(m.Count),
+			P50:       m.Quantile(0.50),
+			P95:       m.Quantile(0.95),
+			P99:       m.Quantile(0.99),
+			Max:       m.Max,
+		}
+	}
+	return stats
+}
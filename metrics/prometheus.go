@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Label is a name and value pair identifying one sample of a metric
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a metric
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// PromWriter writes metrics in the Prometheus text exposition format. The
+// first write error is kept and returned by Err, later writes do nothing.
+type PromWriter struct {
+	w   io.Writer
+	err error
+}
+
+// NewPromWriter returns a writer of metrics to w
+func NewPromWriter(w io.Writer) *PromWriter {
+	return &PromWriter{w: w}
+}
+
+// Err returns the first error met while writing
+func (p *PromWriter) Err() error {
+	return p.err
+}
+
+// Counter writes a counter with one value per sample
+func (p *PromWriter) Counter(name, help string, samples ...Sample) {
+	p.family(name, "counter", help, samples)
+}
+
+// Gauge writes a gauge with one value per sample
+func (p *PromWriter) Gauge(name, help string, samples ...Sample) {
+	p.family(name, "gauge", help, samples)
+}
+
+// Histograms writes a histogram in seconds with one set of buckets per
+// latency series, labelled by labels
+func (p *PromWriter) Histograms(name, help string, series []Series, labels func(Key) []Label) {
+	if len(series) == 0 {
+		return
+	}
+
+	p.header(name, "histogram", help)
+	for _, s := range series {
+		base := labels(s.Key)
+
+		// The last bucket also holds everything over its bound, so it is
+		// only reported as +Inf
+		var cumulative uint64
+		for i := 0; i < numBuckets-1; i++ {
+			cumulative += s.Buckets[i]
+			le := Label{Name: "le", Value: formatFloat(BucketBound(i).Seconds())}
+			p.sample(name+"_bucket", append(base[:len(base):len(base)], le), float64(cumulative))
+		}
+		p.sample(name+"_bucket", append(base[:len(base):len(base)], Label{Name: "le", Value: "+Inf"}), float64(s.Count))
+		p.sample(name+"_sum", base, s.Sum.Seconds())
+		p.sample(name+"_count", base, float64(s.Count))
+	}
+}
+
+func (p *PromWriter) family(name, kind, help string, samples []Sample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	p.header(name, kind, help)
+	for _, s := range samples {
+		p.sample(name, s.Labels, s.Value)
+	}
+}
+
+func (p *PromWriter) header(name, kind, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+func (p *PromWriter) sample(name string, labels []Label, value float64) {
+	if len(labels) == 0 {
+		p.printf("%s %s\n", name, formatFloat(value))
+		return
+	}
+
+	var b strings.Builder
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(l.Value))
+		b.WriteByte('"')
+	}
+	p.printf("%s{%s} %s\n", name, b.String(), formatFloat(value))
+}
+
+func (p *PromWriter) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rateWindow is how many whole seconds a Rate averages over
+const rateWindow = 5
+
+// Rate counts events per second. Events are counted in one slot per second,
+// reused once its second is out of the window, so Mark never allocates or
+// locks. A Mark racing with the reuse of its slot may be lost, which is fine
+// for an estimate.
+type Rate struct {
+	slots [rateWindow + 1]rateSlot
+}
+
+type rateSlot struct {
+	second atomic.Int64
+	count  atomic.Uint64
+}
+
+// Mark counts one event
+func (r *Rate) Mark() {
+	now := time.Now().Unix()
+	slot := &r.slots[now%int64(len(r.slots))]
+	if second := slot.second.Load(); second != now && slot.second.CompareAndSwap(second, now) {
+		slot.count.Store(0)
+	}
+	slot.count.Add(1)
+}
+
+// PerSecond returns the average events per second over the last rateWindow
+// whole seconds, leaving out the current one
+func (r *Rate) PerSecond() float64 {
+	now := time.Now().Unix()
+	var total uint64
+	for i := range r.slots {
+		second := r.slots[i].second.Load()
+		if second < now && second >= now-rateWindow {
+			total += r.slots[i].count.Load()
+		}
+	}
+	return float64(total) / rateWindow
+}
+
+// Reset forgets every event
+func (r *Rate) Reset() {
+	for i := range r.slots {
+		r.slots[i].second.Store(0)
+		r.slots[i].count.Store(0)
+	}
+}
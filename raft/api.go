@@ -1,22 +1,57 @@
 package raft
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"sync"
+	"time"
+
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/chaos"
+	"github.com/pixperk/yakvs/handover"
+	"github.com/pixperk/yakvs/metrics"
+	"github.com/pixperk/yakvs/store"
+	"github.com/pixperk/yakvs/version"
 )
 
 type API struct {
 	store     *RaftStore
 	apiAddr   string
 	apiServer *http.Server
+	listener  net.Listener
+	handlers  map[string]http.Handler
+	latency   *metrics.Latencies
+	users     *acl.Users // nil leaves the cluster endpoints open
 	mu        sync.Mutex
+
+	// closing is closed once the API starts shutting down, ending the
+	// /watch-leader requests it would otherwise wait for
+	closing chan struct{}
 }
 
 type JoinRequest struct {
 	NodeID string `json:"node_id"`
 	Addr   string `json:"addr"`
+
+	// ClientAddr is where the joining node serves clients, which followers
+	// redirect them to once it leads
+	ClientAddr string `json:"client_addr,omitempty"`
+	// APIAddr is where the joining node serves this API, which it asks the
+	// leader to remove it through when it leaves
+	APIAddr string `json:"api_addr,omitempty"`
+}
+
+// RemoveRequest asks the leader to take a node out of the cluster. Forwarded
+// marks a request a node passed on to the leader, which is not passed on
+// again.
+type RemoveRequest struct {
+	NodeID    string `json:"node_id"`
+	Forwarded bool   `json:"forwarded,omitempty"`
 }
 
 func NewAPI(store *RaftStore, apiAddr string) *API {
@@ -26,22 +61,79 @@ func NewAPI(store *RaftStore, apiAddr string) *API {
 	}
 }
 
+// Handle serves handler at pattern alongside the API's own endpoints. It
+// must be called before Start.
+func (a *API) Handle(pattern string, handler http.Handler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.handlers == nil {
+		a.handlers = make(map[string]http.Handler)
+	}
+	a.handlers[pattern] = handler
+}
+
+// SetLatencies makes /stats also report per-command counts, error rates and
+// latency percentiles from l, along with the commands per second. It must be
+// called before Start.
+func (a *API) SetLatencies(l *metrics.Latencies) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.latency = l
+}
+
+// SetUsers requires /join, /remove, /transfer-leadership, /snapshot, /backup,
+// /restore and /chaos requests to log in with basic auth as one of users
+// allowed the JOIN, REMOVE, TRANSFER, SNAPSHOT, BACKUP, RESTORE or CHAOS
+// command, the same users the node's TCP server accepts. It must be called
+// before Start.
+func (a *API) SetUsers(users *acl.Users) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.users = users
+}
+
 func (a *API) Start() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/join", a.handleJoin)
+	mux.HandleFunc("/join", restricted(a.users, "JOIN", a.handleJoin))
+	mux.HandleFunc("/remove", restricted(a.users, "REMOVE", a.handleRemove))
+	mux.HandleFunc("/transfer-leadership", restricted(a.users, "TRANSFER", a.handleTransfer))
 	mux.HandleFunc("/status", a.handleStatus)
-	mux.HandleFunc("/snapshot", a.handleSnapshot)
+	mux.HandleFunc("/peers", a.handlePeers)
+	mux.HandleFunc("/snapshot", restricted(a.users, "SNAPSHOT", a.handleSnapshot))
+	mux.HandleFunc("/backup", restricted(a.users, "BACKUP", a.handleBackup))
+	mux.HandleFunc("/restore", restricted(a.users, "RESTORE", a.handleRestore))
+	mux.HandleFunc("/version", a.handleVersion)
+	mux.HandleFunc("/stats", a.handleStats)
+	mux.HandleFunc("/watch-leader", a.handleWatchLeader)
+	if chaos.Enabled() {
+		mux.HandleFunc("/chaos", restricted(a.users, "CHAOS", chaos.Handler().ServeHTTP))
+	}
+	for pattern, handler := range a.handlers {
+		mux.Handle(pattern, handler)
+	}
 
 	a.apiServer = &http.Server{
 		Addr:    a.apiAddr,
 		Handler: mux,
 	}
+	a.closing = make(chan struct{})
+	a.apiServer.RegisterOnShutdown(func() { close(a.closing) })
+
+	// After a graceful restart the listener is inherited from the old process
+	listener, err := handover.Listen("api", a.apiAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", a.apiAddr, err)
+	}
+	a.listener = listener
 
 	go func() {
-		if err := a.apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := a.apiServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("Error starting API server: %v\n", err)
 		}
 	}()
@@ -49,6 +141,26 @@ func (a *API) Start() error {
 	return nil
 }
 
+// Listener returns the API's listening socket, for passing to a replacement
+// process during a graceful restart
+func (a *API) Listener() net.Listener {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.listener
+}
+
+// Shutdown stops accepting requests and waits for in-flight ones to finish
+func (a *API) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.apiServer != nil {
+		return a.apiServer.Shutdown(ctx)
+	}
+	return nil
+}
+
 func (a *API) Stop() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -59,7 +171,82 @@ func (a *API) Stop() error {
 	return nil
 }
 
-// handleJoin handles requests to join the cluster
+// wholeStoreOps are the API operations that touch every key regardless of
+// prefix
+var wholeStoreOps = map[string]bool{
+	"BACKUP":   true,
+	"SNAPSHOT": true,
+	"RESTORE":  true,
+	"CHAOS":    true,
+}
+
+// restricted serves handler only to requests logging in as a user allowed
+// op, or to every request if users is nil. BACKUP and SNAPSHOT cover every
+// key, RESTORE replaces every key and CHAOS fails writes to any key, so they
+// are also refused to users restricted to a key prefix.
+func restricted(users *acl.Users, op string, handler http.HandlerFunc) http.HandlerFunc {
+	if users == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, password, _ := r.BasicAuth()
+		user, ok := users.Authenticate(name, password)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="yakvs"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if !user.Allows(op) {
+			http.Error(w, fmt.Sprintf("Permission denied: %s may not run %s", user.Name, op), http.StatusForbidden)
+			return
+		}
+		if wholeStoreOps[op] && user.KeyPrefix != "" {
+			http.Error(w, fmt.Sprintf("Permission denied: %s may only use keys starting with %s", user.Name, user.KeyPrefix), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// LeaderRedirect is the JSON body of a /join answered by a node that is not
+// the leader. With status 307 LeaderAPI, also in the Location header, is the
+// leader's API to send the join to; with 503 no leader is known yet, or it
+// registered no API address.
+type LeaderRedirect struct {
+	Error     string `json:"error"`
+	LeaderID  string `json:"leader_id,omitempty"`
+	LeaderAPI string `json:"leader_api,omitempty"`
+}
+
+// redirectToLeader answers a request only the leader can serve with the
+// leader's API address at the same path
+func (a *API) redirectToLeader(w http.ResponseWriter, r *http.Request) {
+	_, leaderID := a.store.raft.LeaderWithID()
+	resp := LeaderRedirect{
+		Error:     "not the leader",
+		LeaderID:  string(leaderID),
+		LeaderAPI: a.store.LeaderAPI(),
+	}
+
+	status := http.StatusTemporaryRedirect
+	switch {
+	case leaderID == "":
+		resp.Error = "no leader elected yet"
+		status = http.StatusServiceUnavailable
+	case resp.LeaderAPI == "":
+		resp.Error = "the leader registered no API address"
+		status = http.StatusServiceUnavailable
+	default:
+		w.Header().Set("Location", "http://"+resp.LeaderAPI+r.URL.Path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleJoin handles requests to join the cluster. Nodes other than the
+// leader redirect them to the leader.
 func (a *API) handleJoin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -72,7 +259,44 @@ func (a *API) handleJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.store.Join(req.NodeID, req.Addr); err != nil {
+	if err := a.store.Join(req.NodeID, req.Addr, req.ClientAddr, req.APIAddr); err != nil {
+		if errors.Is(err, ErrNotLeader) {
+			a.redirectToLeader(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleRemove handles requests to remove a node from the cluster. A node
+// that is not the leader, including a leader that just handed over its
+// leadership to remove itself, passes the request on to the leader with the
+// caller's credentials.
+func (a *API) handleRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RemoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.NodeID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := a.store.Remove(req.NodeID)
+	if errors.Is(err, ErrNotLeader) && !req.Forwarded {
+		var leaderAPI string
+		leaderAPI, err = a.store.waitLeaderAPI(5 * time.Second)
+		if err == nil {
+			user, password, _ := r.BasicAuth()
+			err = postAPI(leaderAPI, "remove", RemoveRequest{NodeID: req.NodeID, Forwarded: true}, user, password)
+		}
+	}
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -80,12 +304,48 @@ func (a *API) handleJoin(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// StatusResponse represents the status of the Raft cluster
+// TransferRequest asks the leader to hand its leadership to NodeID, or to
+// the most up to date follower if it is empty
+type TransferRequest struct {
+	NodeID string `json:"node_id,omitempty"`
+}
+
+// handleTransfer handles requests to move the leadership to another node,
+// such as before restarting the leader. The body may be empty.
+func (a *API) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	leaderID, err := a.store.TransferLeadership(req.NodeID)
+	if errors.Is(err, ErrNotLeader) {
+		http.Error(w, "Not the leader, try: "+a.store.GetLeader(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Leadership transferred to " + leaderID))
+}
+
+// StatusResponse represents the status of the Raft cluster. Leading is the
+// leader's client address, also in LeaderAddr, when this node follows.
 type StatusResponse struct {
-	NodeID  string `json:"node_id"`
+	ClusterStatus
 	Addr    string `json:"addr"`
 	Leader  bool   `json:"leader"`
 	Leading string `json:"leading,omitempty"`
+	Version string `json:"version"`
 }
 
 // handleStatus handles requests for the cluster status
@@ -95,20 +355,44 @@ func (a *API) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cluster, err := a.store.ClusterStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	resp := StatusResponse{
-		NodeID: a.store.nodeID,
-		Addr:   a.store.addr,
-		Leader: a.store.IsLeader(),
+		ClusterStatus: cluster,
+		Addr:          a.store.addr,
+		Leader:        a.store.IsLeader(),
+		Version:       version.Version,
 	}
 
 	if !resp.Leader {
-		resp.Leading = a.store.GetLeader()
+		resp.Leading = cluster.LeaderAddr
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handlePeers lists the members of the cluster, which any node can answer
+func (a *API) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peers, err := a.store.Peers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
 // handleSnapshot handles requests to create a snapshot
 func (a *API) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -131,3 +415,42 @@ func (a *API) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Snapshot created successfully"))
 }
+
+// handleVersion reports the build this node is running
+func (a *API) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Get())
+}
+
+// StatsResponse is a node's store stats, followed by its command stats if
+// the API was given its latencies, and its raft stats
+type StatsResponse struct {
+	store.Stats
+	OpsPerSec float64                         `json:"ops_per_sec,omitempty"`
+	Commands  map[string]metrics.CommandStats `json:"commands,omitempty"`
+	Raft      ReplicationStats                `json:"raft"`
+}
+
+// handleStats reports this node's key count, memory estimate and write
+// counters, how many commands of each op it served and how fast, and how far
+// its raft log, and on the leader each follower, trails
+func (a *API) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := StatsResponse{Stats: a.store.Stats(), Raft: a.store.ReplicationStats()}
+	if a.latency != nil {
+		resp.OpsPerSec = a.latency.PerSecond()
+		resp.Commands = a.latency.CommandStats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
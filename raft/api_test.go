@@ -0,0 +1,59 @@
+package raft
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/chaos"
+)
+
+// TestChaosNeedsAuth checks /chaos, which can fail any write on the node,
+// is refused to anyone but users allowed CHAOS on every key
+func TestChaosNeedsAuth(t *testing.T) {
+	nodes := newTestCluster(t, 1)
+	users, err := acl.Parse([]byte(`{"users": [
+		{"name": "admin", "password": "admin-pw", "commands": ["*"]},
+		{"name": "reader", "password": "reader-pw", "commands": ["GET"]},
+		{"name": "app", "password": "app-pw", "commands": ["CHAOS"], "key_prefix": "app:"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chaos.Enable()
+	addr := freeAddr(t)
+	api := NewAPI(nodes[0].RaftStore, addr)
+	api.SetUsers(users)
+	if err := api.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer api.Stop()
+
+	for _, tc := range []struct {
+		user, password string
+		want           int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"admin", "wrong", http.StatusUnauthorized},
+		{"reader", "reader-pw", http.StatusForbidden},
+		{"app", "app-pw", http.StatusForbidden},
+		{"admin", "admin-pw", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/chaos", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("GET /chaos as %q = %d, want %d", tc.user, resp.StatusCode, tc.want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package raft
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// BackupInfo describes the raft snapshot a backup holds. SHA256 is the hex
+// encoded checksum of its contents.
+type BackupInfo struct {
+	ID     string `json:"id"`
+	Index  uint64 `json:"index"`
+	Term   uint64 `json:"term"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup headers carry a backup's BackupInfo alongside its contents.
+// HeaderBackupSHA256 is a trailer, the checksum of the bytes actually sent,
+// so backups are sent chunked with their size in HeaderSnapshotSize.
+const (
+	HeaderSnapshotID     = "X-Snapshot-Id"
+	HeaderSnapshotIndex  = "X-Snapshot-Index"
+	HeaderSnapshotTerm   = "X-Snapshot-Term"
+	HeaderSnapshotSize   = "X-Snapshot-Size"
+	HeaderSnapshotSHA256 = "X-Snapshot-Sha256"
+	HeaderClusterID      = "X-Cluster-Id"
+	HeaderBackupSHA256   = "X-Backup-Sha256"
+)
+
+// LatestSnapshot snapshots this node, unless nothing was applied since its
+// last snapshot, and describes its latest snapshot. Any node can take one,
+// as each snapshots its own FSM. The checksum is computed by reading the
+// snapshot, not by loading it into memory.
+func (rs *RaftStore) LatestSnapshot() (BackupInfo, error) {
+	// List returns the newest snapshot first
+	list, err := rs.snapshots.List()
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if len(list) == 0 || list[0].Index < rs.raft.AppliedIndex() {
+		if err := rs.raft.Snapshot().Error(); err != nil && !errors.Is(err, raft.ErrNothingNewToSnapshot) {
+			return BackupInfo{}, fmt.Errorf("failed to take snapshot: %w", err)
+		}
+		if list, err = rs.snapshots.List(); err != nil {
+			return BackupInfo{}, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+	}
+	if len(list) == 0 {
+		return BackupInfo{}, fmt.Errorf("no snapshot to back up")
+	}
+
+	meta, rc, err := rs.snapshots.Open(list[0].ID)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to open snapshot %s: %w", list[0].ID, err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to read snapshot %s: %w", meta.ID, err)
+	}
+
+	return BackupInfo{
+		ID:     meta.ID,
+		Index:  meta.Index,
+		Term:   meta.Term,
+		Size:   meta.Size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// OpenSnapshot opens a snapshot of this node for reading
+func (rs *RaftStore) OpenSnapshot(id string) (io.ReadCloser, error) {
+	_, rc, err := rs.snapshots.Open(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot %s: %w", id, err)
+	}
+	return rc, nil
+}
+
+// handleBackup streams this node's latest raft snapshot, taking a new one
+// first unless nothing changed since the last, with its ID, index, term,
+// checksum and the cluster's ID in headers. The snapshot is copied from disk
+// as it is sent, and the checksum of what was sent follows in a trailer.
+func (a *API) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info, err := a.store.LatestSnapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rc, err := a.store.OpenSnapshot(info.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.ID+".snap"))
+	w.Header().Set(HeaderSnapshotID, info.ID)
+	w.Header().Set(HeaderSnapshotIndex, strconv.FormatUint(info.Index, 10))
+	w.Header().Set(HeaderSnapshotTerm, strconv.FormatUint(info.Term, 10))
+	w.Header().Set(HeaderSnapshotSize, strconv.FormatInt(info.Size, 10))
+	w.Header().Set(HeaderSnapshotSHA256, info.SHA256)
+	w.Header().Set(HeaderClusterID, a.store.ClusterID())
+	w.Header().Set("Trailer", HeaderBackupSHA256)
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), rc); err != nil {
+		fmt.Printf("Error streaming backup of snapshot %s: %v\n", info.ID, err)
+		return
+	}
+	w.Header().Set(HeaderBackupSHA256, hex.EncodeToString(h.Sum(nil)))
+}
+
+// restoreTimeout bounds how long a restore waits for raft to take it up
+const restoreTimeout = time.Minute
+
+// ErrChecksumMismatch is returned by Restore when the snapshot it was given
+// does not match the checksum it was given
+var ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+
+// ErrClusterMismatch is returned by Restore for a snapshot taken on another
+// cluster, unless it is forced
+var ErrClusterMismatch = errors.New("snapshot is from another cluster")
+
+// Restore replaces the whole cluster's data with a snapshot read from r, as
+// served by /backup, and returns its size. If sha256Hex is set, the snapshot
+// must match it. A snapshot taken on another cluster is refused with
+// ErrClusterMismatch unless force is set. The snapshot is spooled to a file
+// in the raft directory and checked before anything changes, then raft
+// installs it on the leader and sends it on to the followers. The cluster
+// keeps its own ID and members' addresses rather than the ones the snapshot
+// was taken with. Only the leader can restore.
+func (rs *RaftStore) Restore(r io.Reader, sha256Hex string, force bool) (int64, error) {
+	size, _, err := rs.restore(r, sha256Hex, force)
+	return size, err
+}
+
+// restore is Restore, also returning the snapshot's metadata
+func (rs *RaftStore) restore(r io.Reader, sha256Hex string, force bool) (int64, snapshotMeta, error) {
+	if !rs.IsLeader() {
+		return 0, snapshotMeta{}, ErrNotLeader
+	}
+
+	f, err := os.CreateTemp(rs.raftDir, "restore-*.tmp")
+	if err != nil {
+		return 0, snapshotMeta{}, fmt.Errorf("failed to create restore file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return 0, snapshotMeta{}, fmt.Errorf("failed to receive snapshot: %w", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sha256Hex != "" && sum != sha256Hex {
+		return 0, snapshotMeta{}, fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, sum, sha256Hex)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, snapshotMeta{}, err
+	}
+	snapMeta, err := validateSnapshot(bufio.NewReader(f))
+	if err != nil {
+		return 0, snapshotMeta{}, err
+	}
+	clusterID := rs.ClusterID()
+	if !force && snapMeta.ClusterID != "" && clusterID != "" && snapMeta.ClusterID != clusterID {
+		return 0, snapshotMeta{}, fmt.Errorf("%w: it was taken on cluster %s, this is cluster %s", ErrClusterMismatch, snapMeta.ClusterID, clusterID)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, snapshotMeta{}, err
+	}
+
+	clientAddrs, apiAddrs := rs.fsm.nodeAddrs()
+	meta := &raft.SnapshotMeta{Version: raft.SnapshotVersionMax, Size: size}
+	if err := rs.raft.Restore(meta, bufio.NewReader(f), restoreTimeout); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			return 0, snapshotMeta{}, ErrNotLeader
+		}
+		return 0, snapshotMeta{}, fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	if err := rs.restoreNodeAddrs(clientAddrs, apiAddrs); err != nil {
+		return size, snapMeta, fmt.Errorf("snapshot restored, but failed to register node addresses: %w", err)
+	}
+	if clusterID != "" && rs.ClusterID() != clusterID {
+		if _, err := rs.apply(Command{Op: "CLUSTERID", Value: clusterID}); err != nil {
+			return size, snapMeta, fmt.Errorf("snapshot restored, but failed to keep the cluster ID: %w", err)
+		}
+	}
+	return size, snapMeta, nil
+}
+
+// restoreNodeAddrs puts back the members' addresses a restored snapshot
+// replaced, and forgets the nodes it named that are not members
+func (rs *RaftStore) restoreNodeAddrs(clientAddrs, apiAddrs map[string]string) error {
+	configFuture := rs.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+
+	members := make(map[string]bool)
+	for _, srv := range configFuture.Configuration().Servers {
+		id := string(srv.ID)
+		members[id] = true
+		clientAddr, apiAddr := clientAddrs[id], apiAddrs[id]
+		if id == rs.nodeID {
+			clientAddr, apiAddr = rs.clientAddr, rs.apiAddr
+		}
+		if err := rs.registerAddrs(id, clientAddr, apiAddr); err != nil {
+			return err
+		}
+	}
+
+	restoredClient, restoredAPI := rs.fsm.nodeAddrs()
+	for _, addrs := range []map[string]string{restoredClient, restoredAPI} {
+		for id := range addrs {
+			if members[id] {
+				continue
+			}
+			if _, err := rs.apply(Command{Op: "FORGETNODE", Key: id}); err != nil {
+				return err
+			}
+			members[id] = true // forgotten once is enough
+		}
+	}
+	return nil
+}
+
+// restoreOnStart loads the snapshot file at path once this freshly
+// bootstrapped node leads. The new cluster takes the ID of the one the
+// snapshot came from, so later backups of either restore into it.
+func (rs *RaftStore) restoreOnStart(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !rs.IsLeader() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node did not become the leader")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	size, meta, err := rs.restore(f, "", true)
+	if err != nil {
+		return err
+	}
+	if meta.ClusterID != "" && rs.ClusterID() != meta.ClusterID {
+		if _, err := rs.apply(Command{Op: "CLUSTERID", Value: meta.ClusterID}); err != nil {
+			return fmt.Errorf("failed to take the snapshot's cluster ID: %w", err)
+		}
+	}
+	fmt.Printf("Restored %d byte snapshot from %s\n", size, path)
+	return nil
+}
+
+// handleRestore replaces the cluster's data with the snapshot in the request
+// body, which must match the checksum in the X-Snapshot-Sha256 header, as
+// served by /backup. A snapshot from another cluster is refused with 409
+// Conflict unless the force parameter is true. Nodes other than the leader
+// redirect it to the leader.
+func (a *API) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !a.store.IsLeader() {
+		a.redirectToLeader(w, r)
+		return
+	}
+
+	sum := r.Header.Get(HeaderSnapshotSHA256)
+	if sum == "" {
+		http.Error(w, HeaderSnapshotSHA256+" header is required", http.StatusBadRequest)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	size, err := a.store.Restore(r.Body, sum, force)
+	switch {
+	case errors.Is(err, ErrNotLeader):
+		a.redirectToLeader(w, r)
+		return
+	case errors.Is(err, ErrChecksumMismatch), errors.Is(err, errInvalidSnapshot):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrClusterMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Restored %d byte snapshot", size)
+}
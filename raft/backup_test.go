@@ -0,0 +1,216 @@
+package raft
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+// contents returns every live key on node with its data, lists included as
+// their elements
+func contents(node *testNode) map[string]string {
+	kv := make(map[string]string)
+	node.RangeSorted("", "", func(key string, v store.Value) bool {
+		kv[key] = v.Data
+		return true
+	})
+	for key := range kv {
+		if node.Type(key) == store.TypeList {
+			elems, _ := node.LRange(key, 0, -1)
+			kv[key] = fmt.Sprint(elems)
+		}
+	}
+	return kv
+}
+
+// waitForContents waits for every node to hold exactly want
+func waitForContents(t *testing.T, nodes []*testNode, want map[string]string) {
+	t.Helper()
+
+	for _, node := range nodes {
+		waitFor(t, 10*time.Second, node.id+" to hold the expected keys", func() bool {
+			return reflect.DeepEqual(contents(node), want)
+		})
+	}
+}
+
+// readBackup takes a backup of node and returns its contents
+func readBackup(t *testing.T, node *testNode) (BackupInfo, []byte) {
+	t.Helper()
+
+	info, err := node.LatestSnapshot()
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	rc, err := node.OpenSnapshot(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != info.Size {
+		t.Fatalf("backup %s holds %d bytes, its info says %d", info.ID, len(data), info.Size)
+	}
+	return info, data
+}
+
+func TestBackupWipeRestore(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := leaderOf(t, nodes)
+
+	for i := 0; i < 50; i++ {
+		if err := leader.Set(fmt.Sprintf("key%02d", i), store.NewValue(fmt.Sprintf("value %d", i), time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := leader.RPush("list", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	want := contents(leader)
+	if len(want) != 51 {
+		t.Fatalf("leader holds %d keys before the backup, want 51", len(want))
+	}
+	waitForContents(t, nodes, want)
+
+	info, data := readBackup(t, leader)
+
+	// Wipe the cluster, then write something the restore must undo
+	if err := leader.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	waitForContents(t, nodes, map[string]string{})
+	if err := leader.Set("after", store.NewValue("written after the backup", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A backup that does not match its checksum changes nothing
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if _, err := leader.Restore(bytes.NewReader(corrupt), info.SHA256, false); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("restore of a corrupted backup = %v, want ErrChecksumMismatch", err)
+	}
+	waitForContents(t, nodes, map[string]string{"after": "written after the backup"})
+
+	size, err := leader.Restore(bytes.NewReader(data), info.SHA256, false)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if size != info.Size {
+		t.Errorf("restore read %d bytes, want %d", size, info.Size)
+	}
+	waitForContents(t, nodes, want)
+
+	// The restored cluster still takes writes
+	if err := leader.Set("key00", store.NewValue("rewritten", 0)); err != nil {
+		t.Fatalf("SET after restore: %v", err)
+	}
+	want["key00"] = "rewritten"
+	waitForContents(t, nodes, want)
+}
+
+func TestStartFromBackup(t *testing.T) {
+	nodes := newTestCluster(t, 1)
+	for i := 0; i < 20; i++ {
+		if err := nodes[0].Set(fmt.Sprintf("key%02d", i), store.NewValue(fmt.Sprint(i), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := contents(nodes[0])
+	_, data := readBackup(t, nodes[0])
+
+	path := filepath.Join(t.TempDir(), "backup.snap")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig(t, 0)
+	config.RestoreFrom = path
+	restored := startNode(t, config)
+	waitFor(t, 10*time.Second, "the restored node to lead", restored.IsLeader)
+	waitForContents(t, []*testNode{restored}, want)
+
+	// A node started from a backup takes over the backed up cluster's ID
+	if id := nodes[0].ClusterID(); id == "" || restored.ClusterID() != id {
+		t.Errorf("restored node has cluster ID %q, want %q", restored.ClusterID(), id)
+	}
+}
+
+// waitForClusterID waits for the cluster led by leader to be given an ID
+func waitForClusterID(t *testing.T, leader *testNode) string {
+	t.Helper()
+
+	waitFor(t, 10*time.Second, leader.id+" to have a cluster ID", func() bool {
+		return leader.ClusterID() != ""
+	})
+	return leader.ClusterID()
+}
+
+func TestRestoreFromAnotherCluster(t *testing.T) {
+	source := leaderOf(t, newTestCluster(t, 1))
+	nodes := newTestCluster(t, 3)
+	leader := leaderOf(t, nodes)
+
+	sourceID, targetID := waitForClusterID(t, source), waitForClusterID(t, leader)
+	if sourceID == targetID {
+		t.Fatalf("two clusters share the ID %s", sourceID)
+	}
+
+	if err := source.Set("from", store.NewValue("the other cluster", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Set("mine", store.NewValue("this cluster", 0)); err != nil {
+		t.Fatal(err)
+	}
+	mine := map[string]string{"mine": "this cluster"}
+	waitForContents(t, nodes, mine)
+	info, data := readBackup(t, source)
+
+	// The leader refuses the backup, changing nothing
+	if _, err := leader.Restore(bytes.NewReader(data), info.SHA256, false); !errors.Is(err, ErrClusterMismatch) {
+		t.Fatalf("restore from another cluster = %v, want ErrClusterMismatch", err)
+	}
+	api := httptest.NewServer(http.HandlerFunc(NewAPI(leader.RaftStore, "").handleRestore))
+	defer api.Close()
+	post := func(query string) int {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, api.URL+"/restore"+query, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(HeaderSnapshotSHA256, info.SHA256)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(""); code != http.StatusConflict {
+		t.Errorf("POST /restore from another cluster = %d, want %d", code, http.StatusConflict)
+	}
+	waitForContents(t, nodes, mine)
+
+	// Forced, it restores the data but the cluster keeps its own ID
+	if code := post("?force=true"); code != http.StatusOK {
+		t.Fatalf("forced POST /restore = %d, want %d", code, http.StatusOK)
+	}
+	waitForContents(t, nodes, map[string]string{"from": "the other cluster"})
+	for _, node := range nodes {
+		waitFor(t, 10*time.Second, node.id+" to keep its cluster ID", func() bool {
+			return node.ClusterID() == targetID
+		})
+	}
+}
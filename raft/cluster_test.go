@@ -0,0 +1,114 @@
+package raft
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testNode is one node of a cluster started by newTestCluster
+type testNode struct {
+	*RaftStore
+	id     string
+	config Config
+}
+
+// freeAddr returns a localhost address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// testConfig returns the config of node i, with timeouts short enough for
+// elections to take a fraction of a second
+func testConfig(t *testing.T, i int) Config {
+	dir := t.TempDir()
+	return Config{
+		NodeID:           fmt.Sprintf("node%d", i),
+		RaftDir:          dir,
+		RaftAddr:         freeAddr(t),
+		LogFilePath:      filepath.Join(dir, "kv.log"),
+		Bootstrap:        i == 0,
+		HeartbeatTimeout: 100 * time.Millisecond,
+		ElectionTimeout:  100 * time.Millisecond,
+		ApplyTimeout:     5 * time.Second,
+	}
+}
+
+// startNode opens a node with config and shuts it down when the test ends,
+// unless the test already did
+func startNode(t *testing.T, config Config) *testNode {
+	t.Helper()
+
+	rs, err := NewRaftStore(config)
+	if err != nil {
+		t.Fatalf("failed to start %s: %v", config.NodeID, err)
+	}
+	n := &testNode{RaftStore: rs, id: config.NodeID, config: config}
+	t.Cleanup(func() { n.Shutdown() })
+	return n
+}
+
+// newTestCluster starts n nodes, the first bootstrapping the cluster and the
+// others joining it, and waits for every node to know the leader
+func newTestCluster(t *testing.T, n int) []*testNode {
+	t.Helper()
+
+	nodes := []*testNode{startNode(t, testConfig(t, 0))}
+	waitFor(t, 10*time.Second, "a leader to be elected", nodes[0].IsLeader)
+
+	for i := 1; i < n; i++ {
+		node := startNode(t, testConfig(t, i))
+		if err := nodes[0].Join(node.id, node.config.RaftAddr, "", ""); err != nil {
+			t.Fatalf("failed to join %s: %v", node.id, err)
+		}
+		nodes = append(nodes, node)
+	}
+	waitFor(t, 10*time.Second, "every node to know the leader", func() bool {
+		for _, node := range nodes {
+			if node.GetLeader() == "" {
+				return false
+			}
+		}
+		return true
+	})
+	return nodes
+}
+
+// leaderOf returns the node among nodes that leads, waiting for one to
+func leaderOf(t *testing.T, nodes []*testNode) *testNode {
+	t.Helper()
+
+	var leader *testNode
+	waitFor(t, 10*time.Second, "a leader", func() bool {
+		for _, node := range nodes {
+			if node.IsLeader() {
+				leader = node
+				return true
+			}
+		}
+		return false
+	})
+	return leader
+}
+
+// waitFor polls cond until it holds, failing the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,125 @@
+package raft
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+// stored lists every key node holds, expired or not
+func stored(node *testNode) []string {
+	var keys []string
+	node.store.Range(func(key string, v store.Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// waitForStored waits for every node to hold exactly keys, expired or not
+func waitForStored(t *testing.T, nodes []*testNode, keys ...string) {
+	t.Helper()
+
+	for _, node := range nodes {
+		waitFor(t, 10*time.Second, fmt.Sprintf("%s to hold %v", node.id, keys), func() bool {
+			return reflect.DeepEqual(stored(node), keys)
+		})
+	}
+}
+
+// TestExpiryConvergesDespiteSkew writes keys expiring around now to a three
+// node cluster and removes them with leaders whose clocks run ahead of and
+// behind the followers', checking the followers never remove keys on their
+// own and every node ends up holding the same keys
+func TestExpiryConvergesDespiteSkew(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := leaderOf(t, nodes)
+
+	now := time.Now()
+	expiries := map[string]time.Time{
+		"expired": now.Add(-time.Second),
+		"soon":    now.Add(time.Second),
+		"later":   now.Add(1500 * time.Millisecond),
+		"hour":    now.Add(time.Hour),
+		"never":   {},
+	}
+	for key, at := range expiries {
+		if err := leader.Set(key, store.Value{Data: key, ExpiresAt: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForStored(t, nodes, "expired", "hour", "later", "never", "soon")
+
+	// Followers read expired keys as missing but leave them be
+	for _, node := range nodes {
+		if node == leader {
+			continue
+		}
+		if _, ok := node.Get("expired"); ok {
+			t.Errorf("%s reads an expired key", node.id)
+		}
+		if err := node.BackgroundCleaner(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, node := range nodes {
+		if node == leader {
+			continue
+		}
+		if keys := stored(node); len(keys) != 5 {
+			t.Fatalf("%s removed keys on its own, holding %v", node.id, keys)
+		}
+	}
+
+	// A leader whose clock runs ahead removes "soon" everywhere, though the
+	// followers' clocks say it has not expired yet
+	ahead := now.Add(1200 * time.Millisecond)
+	if _, err := leader.apply(Command{Op: "EXPIREKEYS", Keys: leader.store.ExpiredKeys(ahead, expireBatch), Now: ahead}); err != nil {
+		t.Fatal(err)
+	}
+	waitForStored(t, nodes, "hour", "later", "never")
+
+	// One whose clock runs behind keeps "later" everywhere, even once the
+	// followers' clocks are past its expiry
+	if _, err := leader.apply(Command{Op: "EXPIREKEYS", Keys: []string{"later"}, Now: now}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(expiries["later"]))
+	for _, node := range nodes {
+		if keys := stored(node); !reflect.DeepEqual(keys, []string{"hour", "later", "never"}) {
+			t.Errorf("%s holds %v after an expiry as of before \"later\" was due", node.id, keys)
+		}
+		if _, ok := node.Get("later"); ok {
+			t.Errorf("%s reads \"later\" after it expired", node.id)
+		}
+	}
+
+	// After a leadership change the new leader's sweep removes it, and the
+	// old leader's does nothing
+	var target *testNode
+	for _, node := range nodes {
+		if node != leader {
+			target = node
+			break
+		}
+	}
+	if _, err := leader.TransferLeadership(target.id); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 10*time.Second, target.id+" to lead", target.IsLeader)
+	if err := leader.BackgroundCleaner(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := stored(leader); len(keys) != 3 {
+		t.Fatalf("the old leader removed keys on its own, holding %v", keys)
+	}
+	if err := target.BackgroundCleaner(); err != nil {
+		t.Fatal(err)
+	}
+	waitForStored(t, nodes, "hour", "never")
+}
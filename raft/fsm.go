@@ -1,11 +1,20 @@
 package raft
 
 import (
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/hashicorp/raft"
+	"github.com/pixperk/yakvs/chaos"
 	"github.com/pixperk/yakvs/store"
 )
 
@@ -14,24 +23,224 @@ type Command struct {
 	Key       string    `json:"key"`
 	Value     string    `json:"value,omitempty"`
 	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Expected  string    `json:"expected,omitempty"` // the value CAS compares against
+	Delta     int64     `json:"delta,omitempty"`
+
+	// Pairs holds every key and value of an MSET, which is applied as one
+	// log entry
+	Pairs map[string]store.Value `json:"pairs,omitempty"`
+	// Ops holds the writes of a BATCH, also applied as one log entry
+	Ops []store.Op `json:"ops,omitempty"`
+
+	// Keys holds the expired keys an EXPIREKEYS removes
+	Keys []string `json:"keys,omitempty"`
+
+	// Elements holds the elements an LPUSH or RPUSH adds, and Count the
+	// number an LPOP or RPOP removes
+	Elements []string `json:"elements,omitempty"`
+	Count    int      `json:"count,omitempty"`
+
+	// Encoding is "base64" when Value, Expected and Elements are base64
+	// encoded because one of them is not valid UTF-8 and would be mangled as
+	// a JSON string
+	Encoding string `json:"encoding,omitempty"`
+
+	// Now is the proposing node's clock, used by commands whose outcome
+	// depends on whether a key has expired so every node decides alike. It is
+	// also the time writes are stamped with, so every node records the same
+	// versions and times.
+	Now time.Time `json:"now,omitempty"`
+}
+
+// encodeValues base64 encodes Value, Expected and Elements if any of them is
+// not valid UTF-8
+func (c *Command) encodeValues() {
+	valid := utf8.ValidString(c.Value) && utf8.ValidString(c.Expected)
+	for _, e := range c.Elements {
+		valid = valid && utf8.ValidString(e)
+	}
+	if valid {
+		return
+	}
+	c.Encoding = "base64"
+	c.Value = base64.StdEncoding.EncodeToString([]byte(c.Value))
+	c.Expected = base64.StdEncoding.EncodeToString([]byte(c.Expected))
+	elems := make([]string, len(c.Elements))
+	for i, e := range c.Elements {
+		elems[i] = base64.StdEncoding.EncodeToString([]byte(e))
+	}
+	c.Elements = elems
+}
+
+// decodeValues reverses encodeValues
+func (c *Command) decodeValues() error {
+	if c.Encoding != "base64" {
+		return nil
+	}
+
+	value, err := base64.StdEncoding.DecodeString(c.Value)
+	if err != nil {
+		return err
+	}
+	expected, err := base64.StdEncoding.DecodeString(c.Expected)
+	if err != nil {
+		return err
+	}
+	c.Value, c.Expected = string(value), string(expected)
+	for i, e := range c.Elements {
+		elem, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return err
+		}
+		c.Elements[i] = string(elem)
+	}
+	return nil
+}
+
+// applyResult is returned by Apply for commands that report an outcome,
+// which the proposing node reads back from the ApplyFuture
+type applyResult struct {
+	ok      bool
+	n       int64
+	old     store.Value
+	value   store.Value // the value GETEX read
+	elems   []string    // the elements LPOP or RPOP removed
+	version uint64
+	// versions holds the version each key of an MSET was given
+	versions map[string]uint64
+	err      error
 }
 
 type FSM struct {
 	store *store.Store
+
+	// clientAddrs maps node IDs to the addresses clients reach them on, set
+	// by NODEADDR entries so every node can redirect clients to the leader.
+	// apiAddrs maps them to their HTTP APIs, set by NODEAPI entries so a
+	// leaving node can ask the leader to remove it.
+	mu          sync.RWMutex
+	clientAddrs map[string]string
+	apiAddrs    map[string]string
+	// clusterID is set by a CLUSTERID entry when the cluster first elects a
+	// leader, so backups can tell which cluster they came from
+	clusterID string
+
+	// compression is the gzip level snapshots are written with, or
+	// NoSnapshotCompression. lastSnapshot describes the latest one written.
+	compression  int
+	lastSnapshot atomic.Pointer[SnapshotStats]
+
+	// addrsChanged, if set, is called after entries or a restore change the
+	// registered addresses
+	addrsChanged func()
 }
 
 func NewFSM(store *store.Store) *FSM {
 	return &FSM{
-		store: store,
+		store:       store,
+		clientAddrs: make(map[string]string),
+		apiAddrs:    make(map[string]string),
+		compression: DefaultSnapshotCompression,
+	}
+}
+
+// clientAddr returns the client address registered for a node, or "" if it
+// has none
+func (f *FSM) clientAddr(nodeID string) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.clientAddrs[nodeID]
+}
+
+// setClientAddr registers a node's client address, or forgets it if addr is
+// empty
+func (f *FSM) setClientAddr(nodeID, addr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if addr == "" {
+		delete(f.clientAddrs, nodeID)
+		return
+	}
+	f.clientAddrs[nodeID] = addr
+}
+
+// cluster returns the cluster's ID, or "" until one is set
+func (f *FSM) cluster() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.clusterID
+}
+
+func (f *FSM) setCluster(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.clusterID = id
+}
+
+// notifyAddrs calls addrsChanged, if set
+func (f *FSM) notifyAddrs() {
+	if f.addrsChanged != nil {
+		f.addrsChanged()
 	}
 }
 
-// Apply applies a Raft log entry to the store
+// apiAddr returns the API address registered for a node, or "" if it has
+// none
+func (f *FSM) apiAddr(nodeID string) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.apiAddrs[nodeID]
+}
+
+// setAPIAddr registers a node's API address, or forgets it if addr is empty
+func (f *FSM) setAPIAddr(nodeID, addr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if addr == "" {
+		delete(f.apiAddrs, nodeID)
+		return
+	}
+	f.apiAddrs[nodeID] = addr
+}
+
+// nodeAddrs returns copies of the registered client and API addresses
+func (f *FSM) nodeAddrs() (clientAddrs, apiAddrs map[string]string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	clientAddrs = make(map[string]string, len(f.clientAddrs))
+	for id, addr := range f.clientAddrs {
+		clientAddrs[id] = addr
+	}
+	apiAddrs = make(map[string]string, len(f.apiAddrs))
+	for id, addr := range f.apiAddrs {
+		apiAddrs[id] = addr
+	}
+	return clientAddrs, apiAddrs
+}
+
+// Apply applies a Raft log entry to the store. The returned error, if any,
+// is the response of the entry's ApplyFuture on the leader.
 func (f *FSM) Apply(log *raft.Log) interface{} {
+	chaos.DelayApply()
+
 	var cmd Command
 	if err := json.Unmarshal(log.Data, &cmd); err != nil {
 		return err
 	}
+	if err := cmd.decodeValues(); err != nil {
+		return err
+	}
+	if cmd.Now.IsZero() {
+		// Entries proposed before SET and MSET carried the clock
+		cmd.Now = log.AppendedAt
+	}
 
 	switch cmd.Op {
 	case "SET":
@@ -39,17 +248,174 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 			Data:      cmd.Value,
 			ExpiresAt: cmd.ExpiresAt,
 		}
-		f.store.Set(cmd.Key, value)
-		return nil
+		return f.store.SetAsOf(cmd.Key, value, cmd.Now)
 	case "DELETE":
-		f.store.Delete(cmd.Key)
+		return f.store.Delete(cmd.Key)
+	case "MSET":
+		versions, err := f.store.MSetAsOf(cmd.Pairs, cmd.Now)
+		return applyResult{versions: versions, err: err}
+	case "BATCH":
+		return f.store.ApplyBatchAsOf(cmd.Ops, cmd.Now)
+	case "SETNX":
+		value := store.Value{
+			Data:      cmd.Value,
+			ExpiresAt: cmd.ExpiresAt,
+		}
+		ok, err := f.store.SetNXAsOf(cmd.Key, value, cmd.Now)
+		return applyResult{ok: ok, err: err}
+	case "CAS":
+		value := store.Value{
+			Data:      cmd.Value,
+			ExpiresAt: cmd.ExpiresAt,
+		}
+		version, err := f.store.CompareAndSwapAsOf(cmd.Key, cmd.Expected, value, cmd.Now)
+		return applyResult{version: version, err: err}
+	case "INCRBY":
+		n, err := f.store.IncrByAsOf(cmd.Key, cmd.Delta, cmd.Now)
+		return applyResult{n: n, err: err}
+	case "DELPREFIX":
+		n, err := f.store.DeletePrefix(cmd.Key)
+		return applyResult{n: int64(n), err: err}
+	case "FLUSHALL":
+		return applyResult{err: f.store.Clear()}
+	case "GETSET":
+		value := store.Value{
+			Data:      cmd.Value,
+			ExpiresAt: cmd.ExpiresAt,
+		}
+		old, existed, err := f.store.GetSetAsOf(cmd.Key, value, cmd.Now)
+		return applyResult{ok: existed, old: old, err: err}
+	case "APPEND":
+		suffix := store.Value{
+			Data:      cmd.Value,
+			ExpiresAt: cmd.ExpiresAt,
+		}
+		n, err := f.store.AppendAsOf(cmd.Key, suffix, cmd.Now)
+		return applyResult{n: int64(n), err: err}
+	case "EXPIRE":
+		ok, err := f.store.ExpireAt(cmd.Key, cmd.ExpiresAt, cmd.Now)
+		return applyResult{ok: ok, err: err}
+	case "LPUSH", "RPUSH":
+		n, err := f.store.PushAsOf(cmd.Key, cmd.Elements, cmd.Op == "LPUSH", cmd.Now)
+		return applyResult{n: int64(n), err: err}
+	case "LPOP", "RPOP":
+		elems, err := f.store.PopAsOf(cmd.Key, cmd.Count, cmd.Op == "LPOP", cmd.Now)
+		return applyResult{elems: elems, err: err}
+	case "TOUCH":
+		ok, err := f.store.TouchAt(cmd.Key, cmd.ExpiresAt, cmd.Now)
+		return applyResult{ok: ok, err: err}
+	case "GETEX":
+		value, ok, err := f.store.GetExAt(cmd.Key, cmd.ExpiresAt, cmd.Now)
+		return applyResult{ok: ok, value: value, err: err}
+	case "EXPIREKEYS":
+		n, err := f.store.ExpireKeysAsOf(cmd.Keys, cmd.Now)
+		return applyResult{n: int64(n), err: err}
+	case "NODEADDR":
+		f.setClientAddr(cmd.Key, cmd.Value)
+		f.notifyAddrs()
+		return nil
+	case "NODEAPI":
+		f.setAPIAddr(cmd.Key, cmd.Value)
+		f.notifyAddrs()
+		return nil
+	case "FORGETNODE":
+		f.setClientAddr(cmd.Key, "")
+		f.setAPIAddr(cmd.Key, "")
+		f.notifyAddrs()
+		return nil
+	case "CLUSTERID":
+		f.setCluster(cmd.Value)
 		return nil
 	default:
 		return nil
 	}
 }
 
-// Snapshot returns a snapshot of the store
+// snapshotFormat is the layout of the snapshots this version writes. Format
+// 0 snapshots have no metadata, or metadata without a format.
+const snapshotFormat = 1
+
+// snapshotMeta is the cluster metadata a snapshot carries after the data.
+// Snapshots written before it existed end after the data.
+type snapshotMeta struct {
+	Format      int               `json:"format,omitempty"`
+	ClusterID   string            `json:"cluster_id,omitempty"`
+	ClientAddrs map[string]string `json:"client_addrs,omitempty"`
+	APIAddrs    map[string]string `json:"api_addrs,omitempty"`
+}
+
+// DefaultSnapshotCompression is the gzip level raft snapshots are written
+// with unless Config.SnapshotCompression says otherwise
+const DefaultSnapshotCompression = gzip.BestSpeed
+
+// NoSnapshotCompression, as Config.SnapshotCompression, writes raft
+// snapshots as plain JSON
+const NoSnapshotCompression = -1
+
+// SnapshotStats describes the latest raft snapshot a node wrote
+type SnapshotStats struct {
+	ID             string    `json:"id"`
+	Size           int64     `json:"size"`            // bytes of JSON
+	CompressedSize int64     `json:"compressed_size"` // bytes written, Size if uncompressed
+	Compression    int       `json:"compression"`     // gzip level, or -1 for none
+	DurationMS     int64     `json:"duration_ms"`
+	WrittenAt      time.Time `json:"written_at"`
+}
+
+// snapshotReader returns the JSON of a snapshot read from r, decompressing
+// it if it was gzipped, so snapshots written before compression, or
+// without it, still load
+func snapshotReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	// JSON never starts with gzip's magic bytes
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// errInvalidSnapshot is returned by validateSnapshot for data that is not a
+// snapshot this version can restore
+var errInvalidSnapshot = errors.New("invalid snapshot")
+
+// validateSnapshot checks that r holds a snapshot in a format this version
+// reads, as written by Persist, and returns its metadata. Entries are
+// decoded one at a time, so a large snapshot is never held in memory.
+func validateSnapshot(r io.Reader) (snapshotMeta, error) {
+	r, err := snapshotReader(r)
+	if err != nil {
+		return snapshotMeta{}, fmt.Errorf("%w: bad compression: %v", errInvalidSnapshot, err)
+	}
+	decoder := json.NewDecoder(r)
+
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return snapshotMeta{}, fmt.Errorf("%w: not a yakvs snapshot", errInvalidSnapshot)
+	}
+	for decoder.More() {
+		if _, err := decoder.Token(); err != nil {
+			return snapshotMeta{}, fmt.Errorf("%w: not a yakvs snapshot: %v", errInvalidSnapshot, err)
+		}
+		var value store.Value
+		if err := decoder.Decode(&value); err != nil {
+			return snapshotMeta{}, fmt.Errorf("%w: not a yakvs snapshot: %v", errInvalidSnapshot, err)
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return snapshotMeta{}, fmt.Errorf("%w: not a yakvs snapshot: %v", errInvalidSnapshot, err)
+	}
+
+	var meta snapshotMeta
+	if err := decoder.Decode(&meta); err != nil && err != io.EOF {
+		return snapshotMeta{}, fmt.Errorf("%w: bad metadata: %v", errInvalidSnapshot, err)
+	}
+	if meta.Format > snapshotFormat {
+		return snapshotMeta{}, fmt.Errorf("%w: format %d is newer than the supported format %d", errInvalidSnapshot, meta.Format, snapshotFormat)
+	}
+	return meta, nil
+}
+
+// Snapshot returns a snapshot of the store, the cluster ID and the registered
+// client and API addresses
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
 	data := make(map[string]store.Value)
 
@@ -58,47 +424,122 @@ func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
 		return true
 	})
 
-	return &Snapshot{data: data}, nil
+	meta := snapshotMeta{Format: snapshotFormat, ClusterID: f.cluster()}
+	meta.ClientAddrs, meta.APIAddrs = f.nodeAddrs()
+
+	return &Snapshot{data: data, meta: meta, fsm: f}, nil
 }
 
+// Restore replaces the store with a snapshot, gzipped or not
 func (f *FSM) Restore(rc io.ReadCloser) error {
 	defer rc.Close()
 
-	decoder := json.NewDecoder(rc)
+	r, err := snapshotReader(rc)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(r)
 
 	var data map[string]store.Value
 	if err := decoder.Decode(&data); err != nil {
 		return err
 	}
 
-	// Clear the current store
-	f.store.Clear()
+	var meta snapshotMeta
+	if err := decoder.Decode(&meta); err != nil && err != io.EOF {
+		return err
+	}
+	if meta.Format > snapshotFormat {
+		return fmt.Errorf("snapshot format %d is newer than the supported format %d", meta.Format, snapshotFormat)
+	}
 
-	// Restore all key-value pairs from snapshot
-	for key, value := range data {
-		f.store.Set(key, value)
+	f.mu.Lock()
+	f.clusterID = meta.ClusterID
+	f.clientAddrs = make(map[string]string, len(meta.ClientAddrs))
+	for id, addr := range meta.ClientAddrs {
+		f.clientAddrs[id] = addr
+	}
+	f.apiAddrs = make(map[string]string, len(meta.APIAddrs))
+	for id, addr := range meta.APIAddrs {
+		f.apiAddrs[id] = addr
 	}
+	f.mu.Unlock()
+	f.notifyAddrs()
 
-	return nil
+	// Replace the whole store and its log in one pass, keeping versions
+	return f.store.BulkLoad(data)
 }
 
 // Snapshot implements the raft.FSMSnapshot interface
 type Snapshot struct {
 	data map[string]store.Value
+	meta snapshotMeta
+	fsm  *FSM // records the snapshot's stats
 }
 
+// Persist writes the data and then the metadata as JSON, gzipped at the
+// FSM's compression level
 func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
 	defer sink.Close()
 
-	encoder := json.NewEncoder(sink)
+	start := time.Now()
+	level := s.fsm.compression
+	written := &countingWriter{w: sink}
+	var w io.Writer = written
+	var zw *gzip.Writer
+	if level != NoSnapshotCompression {
+		var err error
+		if zw, err = gzip.NewWriterLevel(written, level); err != nil {
+			sink.Cancel()
+			return err
+		}
+		w = zw
+	}
+	raw := &countingWriter{w: w}
+
+	encoder := json.NewEncoder(raw)
 	if err := encoder.Encode(s.data); err != nil {
 		sink.Cancel()
 		return err
 	}
+	if err := encoder.Encode(s.meta); err != nil {
+		sink.Cancel()
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			sink.Cancel()
+			return err
+		}
+	}
+
+	stats := &SnapshotStats{
+		ID:             sink.ID(),
+		Size:           raw.n,
+		CompressedSize: written.n,
+		Compression:    level,
+		DurationMS:     time.Since(start).Milliseconds(),
+		WrittenAt:      time.Now(),
+	}
+	s.fsm.lastSnapshot.Store(stats)
+	fmt.Printf("Wrote raft snapshot %s: %d keys, %d bytes, %d compressed, in %s\n",
+		stats.ID, len(s.data), stats.Size, stats.CompressedSize, time.Since(start).Round(time.Millisecond))
 
 	return nil
 }
 
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func (s *Snapshot) Release() {
 	// Release resources if needed
 	s.data = nil
@@ -0,0 +1,106 @@
+package raft
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/pixperk/yakvs/store"
+)
+
+// jsonDataset returns a store of n keys holding ~100 byte JSON documents,
+// the kind of compressible values snapshots are gzipped for
+func jsonDataset(b *testing.B, n int) *store.Store {
+	b.Helper()
+
+	s := store.NewMemoryStore()
+	for i := 0; i < n; i++ {
+		doc := fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user-%d@example.com","active":%t}`, i, i, i, i%3 == 0)
+		if err := s.Set(fmt.Sprintf("user:%d", i), store.NewValue(doc, 0)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+// silenceStdout discards what is printed to stdout until the benchmark ends
+func silenceStdout(b *testing.B) {
+	b.Helper()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+// BenchmarkSnapshotRestore writes a snapshot of 100k keys to a file snapshot
+// store and restores it into another FSM, uncompressed and at gzip levels 1
+// and 6, reporting the time of each half and the size on disk
+func BenchmarkSnapshotRestore(b *testing.B) {
+	const keys = 100_000
+
+	// Persist logs every snapshot, which would break up the results
+	silenceStdout(b)
+	src := NewFSM(jsonDataset(b, keys))
+	for _, level := range []int{NoSnapshotCompression, gzip.BestSpeed, 6} {
+		name := fmt.Sprintf("gzip-%d", level)
+		if level == NoSnapshotCompression {
+			name = "uncompressed"
+		}
+		b.Run(name, func(b *testing.B) {
+			src.compression = level
+			snapshots, err := raft.NewFileSnapshotStore(b.TempDir(), 1, io.Discard)
+			if err != nil {
+				b.Fatal(err)
+			}
+			dst := NewFSM(store.NewMemoryStore())
+
+			var snapshotTime, restoreTime time.Duration
+			var size int64
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				snap, err := src.Snapshot()
+				if err != nil {
+					b.Fatal(err)
+				}
+				sink, err := snapshots.Create(raft.SnapshotVersionMax, uint64(i+1), 1, raft.Configuration{}, 1, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := snap.Persist(sink); err != nil {
+					b.Fatal(err)
+				}
+				snap.Release()
+				snapshotTime += time.Since(start)
+
+				start = time.Now()
+				meta, rc, err := snapshots.Open(sink.ID())
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := dst.Restore(rc); err != nil {
+					b.Fatal(err)
+				}
+				restoreTime += time.Since(start)
+				size = meta.Size
+			}
+
+			if n := dst.store.Stats().Keys; n != keys {
+				b.Fatalf("restored %d keys, want %d", n, keys)
+			}
+			b.ReportMetric(float64(snapshotTime.Nanoseconds())/float64(b.N), "snapshot-ns/op")
+			b.ReportMetric(float64(restoreTime.Nanoseconds())/float64(b.N), "restore-ns/op")
+			b.ReportMetric(float64(size), "bytes")
+		})
+	}
+}
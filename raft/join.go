@@ -3,17 +3,85 @@ package raft
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
-func JoinCluster(leaderAPI, nodeID, raftAddr string) error {
-	joinURL := fmt.Sprintf("http://%s/join", leaderAPI)
+// DefaultJoinAttempts is how many requests a join sends, counting redirects
+// to the leader, unless JoinOptions.Attempts says otherwise
+const DefaultJoinAttempts = 5
 
+// DefaultJoinBackoff is how long a join waits before its first retry unless
+// JoinOptions.Backoff says otherwise
+const DefaultJoinBackoff = 250 * time.Millisecond
+
+// ErrNoLeader is returned by a join that ran out of attempts while the
+// cluster had no leader, or none it could be redirected to
+var ErrNoLeader = errors.New("no leader elected yet")
+
+// JoinRejectedError is returned by a join the node it reached refused, such
+// as a leader failing to add the node or a node refusing its credentials.
+// Rejected joins are not retried.
+type JoinRejectedError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *JoinRejectedError) Error() string {
+	return fmt.Sprintf("join rejected with status %d: %s", e.StatusCode, e.Message)
+}
+
+// JoinOptions configures JoinClusterWithOptions
+type JoinOptions struct {
+	// User is logged in as, and must be allowed the JOIN command when the
+	// cluster has users. Empty sends no credentials.
+	User     string
+	Password string
+
+	// Attempts caps the requests sent, counting redirects to the leader.
+	// Zero means DefaultJoinAttempts.
+	Attempts int
+
+	// Backoff is the wait before retrying after a connection error or while
+	// no leader is elected, doubled after every retry. Zero means
+	// DefaultJoinBackoff.
+	Backoff time.Duration
+}
+
+func JoinCluster(leaderAPI, nodeID, raftAddr, clientAddr, apiAddr string) error {
+	return JoinClusterAs(leaderAPI, nodeID, raftAddr, clientAddr, apiAddr, "", "")
+}
+
+// JoinClusterAs joins the cluster logging in as user, who must be allowed
+// the JOIN command when the cluster has users. An empty user sends no
+// credentials.
+func JoinClusterAs(leaderAPI, nodeID, raftAddr, clientAddr, apiAddr, user, password string) error {
 	req := JoinRequest{
-		NodeID: nodeID,
-		Addr:   raftAddr,
+		NodeID:     nodeID,
+		Addr:       raftAddr,
+		ClientAddr: clientAddr,
+		APIAddr:    apiAddr,
+	}
+	return JoinClusterWithOptions(leaderAPI, req, JoinOptions{User: user, Password: password})
+}
+
+// JoinClusterWithOptions sends req to the API at apiAddr, which may be any
+// node of the cluster: followers redirect it to the leader. Connection
+// errors and a cluster without a leader are retried with backoff until
+// opts.Attempts requests were sent, after which ErrNoLeader or the last
+// error is returned. A refused join returns a *JoinRejectedError at once.
+func JoinClusterWithOptions(apiAddr string, req JoinRequest, opts JoinOptions) error {
+	attempts := opts.Attempts
+	if attempts <= 0 {
+		attempts = DefaultJoinAttempts
+	}
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = DefaultJoinBackoff
 	}
 
 	jsonData, err := json.Marshal(req)
@@ -21,18 +89,128 @@ func JoinCluster(leaderAPI, nodeID, raftAddr string) error {
 		return fmt.Errorf("failed to marshal join request: %w", err)
 	}
 
+	target := apiAddr
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		leaderAPI, err := sendJoin(target, jsonData, opts.User, opts.Password)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+
+		var rejected *JoinRejectedError
+		if errors.As(err, &rejected) {
+			return err
+		}
+		if leaderAPI != "" {
+			target = leaderAPI
+			continue
+		}
+
+		// Ask the node we were given again, which may know a newer leader
+		target = apiAddr
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return lastErr
+}
+
+// sendJoin sends one join request to the API at apiAddr. A follower's
+// redirect returns the leader's API address along with an error.
+func sendJoin(apiAddr string, jsonData []byte, user, password string) (string, error) {
+	url := fmt.Sprintf("http://%s/join", apiAddr)
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to build join request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		httpReq.SetBasicAuth(user, password)
+	}
+
+	client := http.Client{
+		Timeout: 5 * time.Second,
+		// Redirects are followed by JoinClusterWithOptions, which counts
+		// them and keeps the credentials
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to send join request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return "", nil
+	case http.StatusTemporaryRedirect, http.StatusServiceUnavailable:
+		var redirect LeaderRedirect
+		if err := json.NewDecoder(resp.Body).Decode(&redirect); err != nil {
+			return "", fmt.Errorf("invalid redirect from %s: %w", apiAddr, err)
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable || redirect.LeaderAPI == "" {
+			if redirect.Error == "" || redirect.Error == ErrNoLeader.Error() {
+				return "", ErrNoLeader
+			}
+			return "", fmt.Errorf("%w: %s", ErrNoLeader, redirect.Error)
+		}
+		return redirect.LeaderAPI, fmt.Errorf("%s is not the leader, redirected to %s", apiAddr, redirect.LeaderAPI)
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return "", &JoinRejectedError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+// RemoveFromCluster asks the leader's API to take a node out of the cluster
+func RemoveFromCluster(leaderAPI, nodeID string) error {
+	return RemoveFromClusterAs(leaderAPI, nodeID, "", "")
+}
+
+// RemoveFromClusterAs removes a node logging in as user, who must be allowed
+// the REMOVE command when the cluster has users. An empty user sends no
+// credentials.
+func RemoveFromClusterAs(leaderAPI, nodeID, user, password string) error {
+	return postAPI(leaderAPI, "remove", RemoveRequest{NodeID: nodeID}, user, password)
+}
+
+// postAPI sends body as JSON to the endpoint of a node's API
+func postAPI(apiAddr, endpoint string, body any, user, password string) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", endpoint, err)
+	}
+
+	url := fmt.Sprintf("http://%s/%s", apiAddr, endpoint)
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to build %s request: %w", endpoint, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		httpReq.SetBasicAuth(user, password)
+	}
+
 	client := http.Client{
 		Timeout: 5 * time.Second,
 	}
 
-	resp, err := client.Post(joinURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := client.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("failed to send join request: %w", err)
+		return fmt.Errorf("failed to send %s request: %w", endpoint, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("join request failed with status: %s", resp.Status)
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if text := strings.TrimSpace(string(msg)); text != "" {
+			return fmt.Errorf("%s request failed with status: %s: %s", endpoint, resp.Status, text)
+		}
+		return fmt.Errorf("%s request failed with status: %s", endpoint, resp.Status)
 	}
 
 	return nil
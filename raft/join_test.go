@@ -0,0 +1,177 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// joinAPI is a fake node API answering joins with handle, counting them
+type joinAPI struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newJoinAPI(t *testing.T, handle func(w http.ResponseWriter, r *http.Request)) *joinAPI {
+	t.Helper()
+
+	api := &joinAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.requests.Add(1)
+		if r.URL.Path != "/join" || r.Method != http.MethodPost {
+			http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		handle(w, r)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// addr is the API address a join is sent to, without the scheme
+func (a *joinAPI) addr() string {
+	return strings.TrimPrefix(a.URL, "http://")
+}
+
+// redirectTo answers like a follower of the leader at leaderAPI
+func redirectTo(leaderAPI string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "http://"+leaderAPI+"/join")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		json.NewEncoder(w).Encode(LeaderRedirect{Error: "not the leader", LeaderID: "node0", LeaderAPI: leaderAPI})
+	}
+}
+
+// noLeader answers like a node of a cluster that has not elected a leader
+func noLeader(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(LeaderRedirect{Error: ErrNoLeader.Error()})
+}
+
+var testJoin = JoinRequest{NodeID: "node3", Addr: "127.0.0.1:7003", ClientAddr: "127.0.0.1:8003", APIAddr: "127.0.0.1:9003"}
+
+// fastJoin retries quickly
+var fastJoin = JoinOptions{User: "joiner", Password: "j01n", Attempts: 4, Backoff: time.Millisecond}
+
+func TestJoinFollowsRedirectToTheLeader(t *testing.T) {
+	var got JoinRequest
+	var user, password string
+	leader := newJoinAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ = r.BasicAuth()
+		json.NewDecoder(r.Body).Decode(&got)
+	})
+	follower := newJoinAPI(t, redirectTo(leader.addr()))
+
+	if err := JoinClusterWithOptions(follower.addr(), testJoin, fastJoin); err != nil {
+		t.Fatal(err)
+	}
+	if n := leader.requests.Load(); n != 1 {
+		t.Errorf("leader got %d joins, want 1", n)
+	}
+	if got != testJoin {
+		t.Errorf("leader got %+v, want %+v", got, testJoin)
+	}
+	// The credentials are sent again to the leader
+	if user != fastJoin.User || password != fastJoin.Password {
+		t.Errorf("leader got credentials %q:%q", user, password)
+	}
+}
+
+func TestJoinWaitsForALeader(t *testing.T) {
+	leader := newJoinAPI(t, func(w http.ResponseWriter, r *http.Request) {})
+	var follower *joinAPI
+	follower = newJoinAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if follower.requests.Load() <= 2 {
+			noLeader(w, r)
+			return
+		}
+		redirectTo(leader.addr())(w, r)
+	})
+
+	if err := JoinClusterWithOptions(follower.addr(), testJoin, fastJoin); err != nil {
+		t.Fatal(err)
+	}
+	if n := follower.requests.Load(); n != 3 {
+		t.Errorf("follower got %d joins, want 3", n)
+	}
+	if n := leader.requests.Load(); n != 1 {
+		t.Errorf("leader got %d joins, want 1", n)
+	}
+}
+
+func TestJoinWithoutALeader(t *testing.T) {
+	node := newJoinAPI(t, noLeader)
+
+	err := JoinClusterWithOptions(node.addr(), testJoin, fastJoin)
+	if !errors.Is(err, ErrNoLeader) {
+		t.Fatalf("join without a leader = %v, want ErrNoLeader", err)
+	}
+	if n := node.requests.Load(); n != int32(fastJoin.Attempts) {
+		t.Errorf("%d joins sent, want %d", n, fastJoin.Attempts)
+	}
+}
+
+func TestJoinRejected(t *testing.T) {
+	leader := newJoinAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "node3 is already a member with another address", http.StatusInternalServerError)
+	})
+	follower := newJoinAPI(t, redirectTo(leader.addr()))
+
+	err := JoinClusterWithOptions(follower.addr(), testJoin, fastJoin)
+	var rejected *JoinRejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("rejected join = %v, want a *JoinRejectedError", err)
+	}
+	if rejected.StatusCode != http.StatusInternalServerError || !strings.Contains(rejected.Message, "already a member") {
+		t.Errorf("rejected with %d %q", rejected.StatusCode, rejected.Message)
+	}
+	if errors.Is(err, ErrNoLeader) {
+		t.Error("a rejected join reports no leader")
+	}
+	// Rejections are not retried
+	if n := leader.requests.Load(); n != 1 {
+		t.Errorf("leader got %d joins, want 1", n)
+	}
+}
+
+func TestJoinRetriesConnectionErrors(t *testing.T) {
+	down := newJoinAPI(t, func(w http.ResponseWriter, r *http.Request) {})
+	addr := down.addr()
+	down.Close()
+
+	opts := fastJoin
+	opts.Backoff = 20 * time.Millisecond
+	start := time.Now()
+	err := JoinClusterWithOptions(addr, testJoin, opts)
+	if err == nil {
+		t.Fatal("join through a node that is down succeeded")
+	}
+	var rejected *JoinRejectedError
+	if errors.As(err, &rejected) || errors.Is(err, ErrNoLeader) {
+		t.Errorf("join through a node that is down = %v, want a connection error", err)
+	}
+	// 20ms, 40ms and 80ms between the four attempts
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("join gave up after %v, without backing off", elapsed)
+	}
+}
+
+func TestJoinRedirectLoopRunsOutOfAttempts(t *testing.T) {
+	var a, b *joinAPI
+	a = newJoinAPI(t, func(w http.ResponseWriter, r *http.Request) { redirectTo(b.addr())(w, r) })
+	b = newJoinAPI(t, func(w http.ResponseWriter, r *http.Request) { redirectTo(a.addr())(w, r) })
+
+	if err := JoinClusterWithOptions(a.addr(), testJoin, fastJoin); err == nil {
+		t.Fatal("join between two nodes redirecting to each other succeeded")
+	}
+	if n := a.requests.Load() + b.requests.Load(); n != int32(fastJoin.Attempts) {
+		t.Errorf("%d joins sent, want %d", n, fastJoin.Attempts)
+	}
+}
@@ -0,0 +1,202 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// DefaultWatchLeaderTimeout is how long /watch-leader waits for a change
+// unless the request says otherwise
+const DefaultWatchLeaderTimeout = 30 * time.Second
+
+// maxWatchLeaderTimeout caps the wait a /watch-leader request may ask for
+const maxWatchLeaderTimeout = 5 * time.Minute
+
+// LeaderChange is a node's view of the leader after it changed
+type LeaderChange struct {
+	IsLeader   bool   `json:"is_leader"`            // whether this node leads
+	LeaderID   string `json:"leader_id"`            // empty while no leader is known
+	LeaderAddr string `json:"leader_addr"`          // as GetLeader returns it
+	LeaderAPI  string `json:"leader_api,omitempty"` // empty if it registered none
+	Term       uint64 `json:"term"`
+	Seq        uint64 `json:"seq"` // counts the changes this node saw since it started
+}
+
+// leaderWatch holds the callbacks told about leader changes and the latest
+// change, which /watch-leader waits on
+type leaderWatch struct {
+	mu        sync.Mutex
+	callbacks []*leaderCallback
+	current   LeaderChange
+	changed   chan struct{} // closed and replaced on every change
+	wake      chan struct{} // nudged by new callbacks and addresses
+}
+
+// leaderCallback is a function registered with OnLeaderChange
+type leaderCallback struct {
+	fn   func(isLeader bool, leaderAddr string)
+	seen uint64 // the Seq it was last called with
+}
+
+// OnLeaderChange calls fn every time this node sees the leader change: when
+// it wins or loses an election, when another node takes over, when the
+// leader is lost, with an empty leaderAddr, and when the leader's addresses
+// are registered. leaderAddr is what GetLeader returns. If the node already
+// saw a leader, fn is first called with the latest change. Callbacks run one
+// at a time, in the order of the changes, on a goroutine of their own, so a
+// slow one delays the others but never raft.
+func (rs *RaftStore) OnLeaderChange(fn func(isLeader bool, leaderAddr string)) {
+	rs.leaders.mu.Lock()
+	rs.leaders.callbacks = append(rs.leaders.callbacks, &leaderCallback{fn: fn})
+	rs.leaders.mu.Unlock()
+
+	select {
+	case rs.leaders.wake <- struct{}{}:
+	default:
+	}
+}
+
+// LeaderChange returns the latest leader change this node saw
+func (rs *RaftStore) LeaderChange() LeaderChange {
+	rs.leaders.mu.Lock()
+	defer rs.leaders.mu.Unlock()
+
+	return rs.leaders.current
+}
+
+// watchLeaderChanges tells the callbacks and watchers about every change of
+// leader raft observes until the store is shut down
+func (rs *RaftStore) watchLeaderChanges(observations <-chan raft.Observation) {
+	for {
+		select {
+		case <-observations:
+		case <-rs.leaders.wake:
+		case <-rs.stop:
+			return
+		}
+
+		current := rs.updateLeader()
+
+		rs.leaders.mu.Lock()
+		callbacks := rs.leaders.callbacks
+		rs.leaders.mu.Unlock()
+
+		// seen is only touched here, so needs no lock
+		for _, cb := range callbacks {
+			if cb.seen < current.Seq {
+				cb.seen = current.Seq
+				cb.fn(current.IsLeader, current.LeaderAddr)
+			}
+		}
+	}
+}
+
+// updateLeader records the leader raft knows of as a change, and wakes the
+// watchers, unless it is the one already recorded. Observations are only a
+// nudge: the leader is read from raft so a dropped or stale one cannot leave
+// the watchers behind.
+func (rs *RaftStore) updateLeader() LeaderChange {
+	addr, id := rs.raft.LeaderWithID()
+	isLeader := rs.IsLeader()
+
+	rs.leaders.mu.Lock()
+	defer rs.leaders.mu.Unlock()
+
+	prev := rs.leaders.current
+	if addr == "" && prev.Seq == 0 {
+		return prev
+	}
+
+	current := LeaderChange{
+		IsLeader: isLeader,
+		LeaderID: string(id),
+		Term:     rs.raft.CurrentTerm(),
+		Seq:      prev.Seq + 1,
+	}
+	if addr != "" {
+		current.LeaderAddr = rs.GetLeader()
+		current.LeaderAPI = rs.fsm.apiAddr(string(id))
+	}
+	if prev.Seq > 0 && current.IsLeader == prev.IsLeader && current.LeaderID == prev.LeaderID &&
+		current.LeaderAddr == prev.LeaderAddr && current.LeaderAPI == prev.LeaderAPI {
+		return prev
+	}
+	rs.leaders.current = current
+	close(rs.leaders.changed)
+	rs.leaders.changed = make(chan struct{})
+	return current
+}
+
+// waitLeaderChange returns the latest leader change once its Seq differs
+// from seq, or once ctx is done
+func (rs *RaftStore) waitLeaderChange(ctx context.Context, seq uint64) LeaderChange {
+	for {
+		rs.leaders.mu.Lock()
+		current, changed := rs.leaders.current, rs.leaders.changed
+		rs.leaders.mu.Unlock()
+		if current.Seq != seq {
+			return current
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return current
+		case <-rs.stop:
+			return current
+		}
+	}
+}
+
+// handleWatchLeader long-polls for a leader change. It answers at once with
+// the latest change unless its seq parameter names it, and otherwise waits
+// for the next one, or up to the timeout parameter (default 30s), and
+// answers with the latest change either way. Watchers pass back the seq of
+// each answer.
+func (a *API) handleWatchLeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var seq uint64
+	if s := r.URL.Query().Get("seq"); s != "" {
+		var err error
+		if seq, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid seq %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	timeout := DefaultWatchLeaderTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %q", t), http.StatusBadRequest)
+			return
+		}
+		timeout = min(d, maxWatchLeaderTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	// Shutting the API down waits for requests, so watchers are let go
+	go func() {
+		select {
+		case <-a.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	change := a.store.waitLeaderChange(ctx, seq)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(change)
+}
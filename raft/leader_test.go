@@ -0,0 +1,170 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// leaderEvent is one call of an OnLeaderChange callback
+type leaderEvent struct {
+	isLeader   bool
+	leaderAddr string
+}
+
+// recordLeaderChanges registers a callback on node sending its calls to the
+// returned channel
+func recordLeaderChanges(node *testNode) <-chan leaderEvent {
+	events := make(chan leaderEvent, 100)
+	node.OnLeaderChange(func(isLeader bool, leaderAddr string) {
+		events <- leaderEvent{isLeader, leaderAddr}
+	})
+	return events
+}
+
+// waitForLeaderEvent waits for node's callback to be told of want, skipping
+// the changes it sees on the way
+func waitForLeaderEvent(t *testing.T, node *testNode, events <-chan leaderEvent, want leaderEvent) {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s was never told of %+v", node.id, want)
+		}
+	}
+}
+
+// killLeader shuts down the leader of nodes and returns the nodes left and
+// the one elected among them
+func killLeader(t *testing.T, nodes []*testNode) (rest []*testNode, leader *testNode) {
+	t.Helper()
+
+	old := leaderOf(t, nodes)
+	for _, node := range nodes {
+		if node != old {
+			rest = append(rest, node)
+		}
+	}
+	if err := old.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	return rest, leaderOf(t, rest)
+}
+
+func TestLeaderChangeCallbacksFireOnEveryNode(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	first := leaderOf(t, nodes)
+
+	// Registering tells each node's callback of the current leader
+	events := make(map[*testNode]<-chan leaderEvent)
+	for _, node := range nodes {
+		events[node] = recordLeaderChanges(node)
+		waitForLeaderEvent(t, node, events[node], leaderEvent{node == first, first.config.RaftAddr})
+	}
+
+	rest, leader := killLeader(t, nodes)
+	for _, node := range rest {
+		waitForLeaderEvent(t, node, events[node], leaderEvent{node == leader, leader.config.RaftAddr})
+	}
+
+	// Every survivor was told, and only the new leader that it leads
+	for _, node := range rest {
+		if change := node.LeaderChange(); change.LeaderID != leader.id || change.IsLeader != (node == leader) {
+			t.Errorf("%s last saw %+v, want %s leading", node.id, change, leader.id)
+		}
+	}
+}
+
+func TestWatchLeader(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	first := leaderOf(t, nodes)
+	var follower *testNode
+	for _, node := range nodes {
+		if node != first {
+			follower = node
+		}
+	}
+
+	api := httptest.NewServer(http.HandlerFunc(NewAPI(follower.RaftStore, "").handleWatchLeader))
+	defer api.Close()
+	watch := func(query string) (int, LeaderChange) {
+		t.Helper()
+
+		resp, err := http.Get(api.URL + "/watch-leader" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var change LeaderChange
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&change); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, change
+	}
+
+	for _, query := range []string{"?seq=x", "?timeout=-1s"} {
+		if code, _ := watch(query); code != http.StatusBadRequest {
+			t.Errorf("/watch-leader%s = %d, want 400", query, code)
+		}
+	}
+
+	// Without a seq it answers at once with the latest change
+	_, current := watch("")
+	if current.LeaderID != first.id || current.IsLeader || current.Seq == 0 {
+		t.Fatalf("/watch-leader = %+v, want %s leading", current, first.id)
+	}
+
+	// With it, it waits for the next one, or times out with the same
+	start := time.Now()
+	if _, change := watch(fmt.Sprintf("?seq=%d&timeout=200ms", current.Seq)); change != current {
+		t.Errorf("/watch-leader with no change = %+v, want %+v", change, current)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("/watch-leader answered after %v, before its timeout", elapsed)
+	}
+
+	done := make(chan LeaderChange, 1)
+	go func() {
+		resp, err := http.Get(fmt.Sprintf("%s/watch-leader?seq=%d&timeout=10s", api.URL, current.Seq))
+		if err != nil {
+			done <- LeaderChange{}
+			return
+		}
+		defer resp.Body.Close()
+		var change LeaderChange
+		json.NewDecoder(resp.Body).Decode(&change)
+		done <- change
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := first.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first change may be losing the leader; watching on from each
+	// answer leads to the new one
+	change := <-done
+	deadline := time.Now().Add(10 * time.Second)
+	for change.LeaderID == "" || change.LeaderID == first.id {
+		if change.Seq <= current.Seq {
+			t.Fatalf("/watch-leader answered %+v after the leader was killed", change)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("/watch-leader never reported a new leader")
+		}
+		_, change = watch(fmt.Sprintf("?seq=%d&timeout=10s", change.Seq))
+	}
+	if leader := leaderOf(t, nodes[1:]); change.LeaderID != leader.id {
+		t.Errorf("/watch-leader reported %s leading, %s leads", change.LeaderID, leader.id)
+	}
+}
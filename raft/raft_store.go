@@ -1,18 +1,38 @@
 package raft
 
 import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/pixperk/yakvs/chaos"
+	"github.com/pixperk/yakvs/snapshot"
 	"github.com/pixperk/yakvs/store"
 )
 
+// ErrNotLeader is returned by writes and cluster changes made on a node that
+// is not the leader
+var ErrNotLeader = errors.New("not the leader")
+
+// DefaultMaxBatchBytes is the largest MSET or BATCH proposed as one log
+// entry unless Config.MaxBatchBytes says otherwise
+const DefaultMaxBatchBytes = 4 << 20
+
+// DefaultApplyTimeout is how long a write waits to be applied unless
+// Config.ApplyTimeout says otherwise
+const DefaultApplyTimeout = 500 * time.Millisecond
+
 // Raft-backed key-value store
 type RaftStore struct {
 	store       *store.Store
@@ -25,7 +45,32 @@ type RaftStore struct {
 	raftDir     string
 	nodeID      string
 	addr        string
+	clientAddr  string
+	apiAddr     string
 	bootstrap   bool
+
+	// limits, maxMemory and maxBatchBytes are checked before writes are
+	// proposed. The FSM's store has none of them, so every node applies the
+	// same entries whatever its own settings.
+	limits        store.Limits
+	maxMemory     int64
+	maxBatchBytes int
+
+	applyTimeout    time.Duration
+	cleanerInterval time.Duration
+	stop            chan struct{} // closed by Shutdown to stop the cleaner
+	stopOnce        sync.Once
+
+	// lags is the leader's view of its followers, refreshed while it leads
+	lags followerLags
+
+	// leaders holds the OnLeaderChange callbacks and the latest change
+	leaders leaderWatch
+
+	// readTerm is the last term in which this node, leading, saw a barrier
+	// applied. Until then a new leader's commit index may lag what its
+	// predecessor committed, so VerifyRead cannot use it.
+	readTerm atomic.Uint64
 }
 
 type Config struct {
@@ -34,19 +79,165 @@ type Config struct {
 	RaftAddr    string
 	Bootstrap   bool
 	LogFilePath string
+
+	// ClientAddr is the address clients reach this node's TCP server on.
+	// It is replicated so followers can redirect clients to the leader.
+	// Empty leaves them the leader's raft address.
+	ClientAddr string
+
+	// APIAddr is the address this node serves its HTTP API on. It is
+	// replicated so a node leaving the cluster can ask the leader to remove
+	// it.
+	APIAddr string
+
+	// SyncPolicy controls when the key-value log is fsynced. Empty means
+	// store.DefaultSyncPolicy.
+	SyncPolicy store.SyncPolicy
+
+	// ReplayMode controls what opening a key-value log with corrupt records
+	// does. Empty means store.DefaultReplayMode.
+	ReplayMode store.ReplayMode
+
+	// EncryptionKey, if set, encrypts the key-value log with AES-GCM. Raft's
+	// own log and snapshots are not encrypted.
+	EncryptionKey []byte
+
+	// LocalLog also writes the key-value data to LogFilePath. Every write is
+	// already durable in raft's own log, which with its snapshots rebuilds
+	// the data on start, so by default the data is only kept in memory and
+	// each write is stored once. The settings above only apply with it.
+	LocalLog bool
+
+	// SnapshotStorage, if set, receives a copy of every raft snapshot
+	SnapshotStorage snapshot.Storage
+
+	// Limits caps the size of keys and values in proposed writes. Nil means
+	// store.DefaultLimits.
+	Limits *store.Limits
+
+	// MaxMemory, if set, rejects writes with store.ErrOutOfMemory while the
+	// leader's data uses this many bytes or more. Keys are never evicted, as
+	// nodes would evict different ones.
+	MaxMemory int64
+
+	// MaxBatchBytes caps the keys and values of an MSET or BATCH, which is
+	// proposed as one log entry, in bytes. Zero means DefaultMaxBatchBytes.
+	MaxBatchBytes int
+
+	// CleanerInterval is how often the leader sweeps expired keys once
+	// StartBackgroundCleaner is called. Zero means every 10 seconds.
+	CleanerInterval time.Duration
+
+	// AutoCompaction controls when each node compacts its key-value log on
+	// its own. Nil means store.DefaultAutoCompaction.
+	AutoCompaction *store.AutoCompaction
+
+	// ApplyTimeout is how long a write waits to be committed and applied
+	// before it fails. Zero means DefaultApplyTimeout.
+	ApplyTimeout time.Duration
+
+	// The remaining fields tune raft itself. Zero leaves hashicorp/raft's
+	// default. A HeartbeatTimeout below its 500ms leader lease also
+	// shortens the lease, which must not exceed it.
+	HeartbeatTimeout  time.Duration
+	ElectionTimeout   time.Duration
+	SnapshotInterval  time.Duration
+	SnapshotThreshold uint64
+	TrailingLogs      uint64
+	MaxAppendEntries  int
+
+	// SnapshotCompression is the gzip level, from 1 to 9, raft snapshots
+	// are written with. Zero means DefaultSnapshotCompression and
+	// NoSnapshotCompression writes plain JSON. Snapshots load either way.
+	SnapshotCompression int
+
+	// RestoreFrom, if set, is a snapshot file, as served by /backup, that
+	// the cluster starts from. It needs Bootstrap and a RaftDir without raft
+	// state, as it is only for starting a new cluster from a backup.
+	RestoreFrom string
+}
+
+// raftConfig returns the hashicorp/raft configuration for config, with its
+// zero fields left at their defaults
+func (config Config) raftConfig() *raft.Config {
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = raft.ServerID(config.NodeID)
+	if config.HeartbeatTimeout > 0 {
+		raftConfig.HeartbeatTimeout = config.HeartbeatTimeout
+		if raftConfig.LeaderLeaseTimeout > config.HeartbeatTimeout {
+			raftConfig.LeaderLeaseTimeout = config.HeartbeatTimeout
+		}
+	}
+	if config.ElectionTimeout > 0 {
+		raftConfig.ElectionTimeout = config.ElectionTimeout
+	}
+	if config.SnapshotInterval > 0 {
+		raftConfig.SnapshotInterval = config.SnapshotInterval
+	}
+	if config.SnapshotThreshold > 0 {
+		raftConfig.SnapshotThreshold = config.SnapshotThreshold
+	}
+	if config.TrailingLogs > 0 {
+		raftConfig.TrailingLogs = config.TrailingLogs
+	}
+	if config.MaxAppendEntries > 0 {
+		raftConfig.MaxAppendEntries = config.MaxAppendEntries
+	}
+	return raftConfig
 }
 
 func NewRaftStore(config Config) (*RaftStore, error) {
+	if config.MaxMemory < 0 {
+		return nil, fmt.Errorf("max memory must not be negative")
+	}
+	if config.CleanerInterval < 0 {
+		return nil, fmt.Errorf("cleaner interval must not be negative")
+	}
+	if config.MaxBatchBytes < 0 {
+		return nil, fmt.Errorf("max batch bytes must not be negative")
+	}
+	if config.ApplyTimeout < 0 || config.HeartbeatTimeout < 0 || config.ElectionTimeout < 0 || config.SnapshotInterval < 0 {
+		return nil, fmt.Errorf("timeouts and intervals must not be negative")
+	}
+	if config.MaxAppendEntries < 0 {
+		return nil, fmt.Errorf("max append entries must not be negative")
+	}
+	if config.SnapshotCompression < NoSnapshotCompression || config.SnapshotCompression > gzip.BestCompression {
+		return nil, fmt.Errorf("snapshot compression must be a gzip level from 1 to 9, or -1 for none")
+	}
+
+	raftConfig := config.raftConfig()
+	if err := raft.ValidateConfig(raftConfig); err != nil {
+		return nil, fmt.Errorf("invalid raft config: %w", err)
+	}
+
+	var logPath string
+	if config.LocalLog {
+		logPath = config.LogFilePath
+	}
+
 	// Create the underlying store
-	s, err := store.NewStore(config.LogFilePath)
+	s, err := store.NewStoreWithOptions(store.StoreOptions{
+		LogPath:        logPath,
+		SyncPolicy:     config.SyncPolicy,
+		ReplayMode:     config.ReplayMode,
+		EncryptionKey:  config.EncryptionKey,
+		Limits:         &store.Limits{},
+		AutoCompaction: config.AutoCompaction,
+		// Expired keys are only removed by entries the leader proposes
+		KeepExpired: true,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create store: %w", err)
 	}
 
 	fsm := NewFSM(s)
+	if config.SnapshotCompression != 0 {
+		fsm.compression = config.SnapshotCompression
+	}
 
-	raftConfig := raft.DefaultConfig()
-	raftConfig.LocalID = raft.ServerID(config.NodeID)
+	leaderNotify := make(chan bool, 1)
+	raftConfig.NotifyCh = leaderNotify
 
 	//Raft transport
 	addr, err := net.ResolveTCPAddr("tcp", config.RaftAddr)
@@ -74,8 +265,46 @@ func NewRaftStore(config Config) (*RaftStore, error) {
 		return nil, fmt.Errorf("failed to create file snapshot store: %w", err)
 	}
 
+	if config.RestoreFrom != "" {
+		if !config.Bootstrap {
+			return nil, fmt.Errorf("restoring from a snapshot file needs bootstrap")
+		}
+		existing, err := raft.HasExistingState(logStore, stableStore, snapshots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check for raft state: %w", err)
+		}
+		if existing {
+			return nil, fmt.Errorf("cannot restore from a snapshot file into %s, which has raft state", config.RaftDir)
+		}
+	}
+
+	// Raft re-applies the entries after the latest snapshot on start, and
+	// Restore clears the store before loading one. Without a snapshot every
+	// entry is applied again, so drop what the store replayed from its own log
+	// or counters and versions would be bumped twice.
+	if list, err := snapshots.List(); err == nil && len(list) == 0 {
+		if err := s.Clear(); err != nil {
+			return nil, fmt.Errorf("failed to clear store: %w", err)
+		}
+	}
+
+	// Registered addresses may change the leader's, so applying them wakes
+	// the leader watch. It is set up before raft starts applying entries.
+	leaderWake := make(chan struct{}, 1)
+	fsm.addrsChanged = func() {
+		select {
+		case leaderWake <- struct{}{}:
+		default:
+		}
+	}
+
+	var snapshotStore raft.SnapshotStore = snapshots
+	if config.SnapshotStorage != nil {
+		snapshotStore = newMirroredSnapshotStore(snapshots, config.SnapshotStorage)
+	}
+
 	// Create the Raft instance
-	r, err := raft.NewRaft(raftConfig, fsm, logStore, stableStore, snapshots, transport)
+	r, err := raft.NewRaft(raftConfig, fsm, logStore, stableStore, snapshotStore, transport)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new raft: %w", err)
 	}
@@ -91,7 +320,27 @@ func NewRaftStore(config Config) (*RaftStore, error) {
 		raftDir:     config.RaftDir,
 		nodeID:      config.NodeID,
 		addr:        config.RaftAddr,
+		clientAddr:  config.ClientAddr,
+		apiAddr:     config.APIAddr,
 		bootstrap:   config.Bootstrap,
+		limits:      store.DefaultLimits,
+		maxMemory:   config.MaxMemory,
+
+		maxBatchBytes:   DefaultMaxBatchBytes,
+		applyTimeout:    DefaultApplyTimeout,
+		cleanerInterval: config.CleanerInterval,
+		stop:            make(chan struct{}),
+	}
+	rs.leaders.changed = make(chan struct{})
+	rs.leaders.wake = leaderWake
+	if config.Limits != nil {
+		rs.limits = *config.Limits
+	}
+	if config.MaxBatchBytes > 0 {
+		rs.maxBatchBytes = config.MaxBatchBytes
+	}
+	if config.ApplyTimeout > 0 {
+		rs.applyTimeout = config.ApplyTimeout
 	}
 
 	// Bootstrap the cluster if needed
@@ -107,73 +356,718 @@ func NewRaftStore(config Config) (*RaftStore, error) {
 		r.BootstrapCluster(configuration)
 	}
 
+	go rs.watchLeadership(leaderNotify)
+
+	// Every node observes leader changes, not just the leader, and raft
+	// drops observations rather than wait for a slow reader
+	observations := make(chan raft.Observation, 16)
+	r.RegisterObserver(raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.LeaderObservation)
+		return ok
+	}))
+	go rs.watchLeaderChanges(observations)
+
+	if config.RestoreFrom != "" {
+		if err := rs.restoreOnStart(config.RestoreFrom); err != nil {
+			rs.Shutdown()
+			return nil, fmt.Errorf("failed to restore from %s: %w", config.RestoreFrom, err)
+		}
+	}
+
 	return rs, nil
 }
 
+// watchLeadership registers this node's client and API addresses each time
+// it becomes the leader, so they are replicated even if they changed on
+// restart, and polls the followers' lag while it leads. Raft blocks until
+// notify is read, so registering happens elsewhere.
+func (rs *RaftStore) watchLeadership(notify <-chan bool) {
+	var stopPolling chan struct{}
+	for leader := range notify {
+		if stopPolling != nil {
+			close(stopPolling)
+			stopPolling = nil
+		}
+		if !leader {
+			continue
+		}
+
+		stopPolling = make(chan struct{})
+		go rs.pollFollowers(stopPolling)
+		go func() {
+			if err := rs.registerAddrs(rs.nodeID, rs.clientAddr, rs.apiAddr); err != nil {
+				fmt.Printf("Failed to register node addresses: %v\n", err)
+			}
+			if err := rs.ensureClusterID(); err != nil {
+				fmt.Printf("Failed to set the cluster ID: %v\n", err)
+			}
+		}()
+	}
+}
+
+// registerAddrs replicates the client and API addresses of a node, skipping
+// empty ones and those already registered
+func (rs *RaftStore) registerAddrs(nodeID, clientAddr, apiAddr string) error {
+	if clientAddr == "" && apiAddr == "" {
+		return nil
+	}
+	if err := rs.raft.Barrier(5 * time.Second).Error(); err != nil {
+		return err
+	}
+	if clientAddr != "" && rs.fsm.clientAddr(nodeID) != clientAddr {
+		if _, err := rs.apply(Command{Op: "NODEADDR", Key: nodeID, Value: clientAddr}); err != nil {
+			return fmt.Errorf("failed to register client address %s: %w", clientAddr, err)
+		}
+	}
+	if apiAddr != "" && rs.fsm.apiAddr(nodeID) != apiAddr {
+		if _, err := rs.apply(Command{Op: "NODEAPI", Key: nodeID, Value: apiAddr}); err != nil {
+			return fmt.Errorf("failed to register API address %s: %w", apiAddr, err)
+		}
+	}
+	return nil
+}
+
+// ensureClusterID gives the cluster a random ID unless it has one. Only the
+// leader can, and clusters started before IDs existed get one the next time
+// they elect a leader.
+func (rs *RaftStore) ensureClusterID() error {
+	if err := rs.raft.Barrier(5 * time.Second).Error(); err != nil {
+		return err
+	}
+	if rs.fsm.cluster() != "" {
+		return nil
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	_, err := rs.apply(Command{Op: "CLUSTERID", Value: hex.EncodeToString(id)})
+	return err
+}
+
+// ClusterID returns the ID the cluster was given when it first elected a
+// leader, or "" until it has one
+func (rs *RaftStore) ClusterID() string {
+	return rs.fsm.cluster()
+}
+
 func (rs *RaftStore) Get(key string) (store.Value, bool) {
 	return rs.store.Get(key)
 }
 
-func (rs *RaftStore) Set(key string, value store.Value) error {
-	if rs.raft.State() != raft.Leader {
-		return fmt.Errorf("not the leader")
+// MSet stores every pair on all nodes as a single raft log entry, so
+// replicas apply the whole batch or none of it
+func (rs *RaftStore) MSet(pairs map[string]store.Value) (map[string]uint64, error) {
+	size := 0
+	for key, value := range pairs {
+		if err := rs.checkWrite(key, len(value.Data)); err != nil {
+			return nil, err
+		}
+		size += len(key) + len(value.Data)
+	}
+	if err := rs.checkBatch(size); err != nil {
+		return nil, err
+	}
+	result, err := rs.apply(Command{
+		Op:    "MSET",
+		Pairs: pairs,
+		Now:   time.Now(),
+	})
+	return result.versions, err
+}
+
+// checkBatch refuses a batch of size bytes of keys and values that would
+// make too large a log entry
+func (rs *RaftStore) checkBatch(size int) error {
+	if size > rs.maxBatchBytes {
+		return fmt.Errorf("%w: batch is %d bytes, the limit is %d", store.ErrTooLarge, size, rs.maxBatchBytes)
+	}
+	return nil
+}
+
+// ApplyBatch applies ops on all nodes as a single raft log entry, so every
+// node applies all of them or none. The ops are validated before they are
+// proposed so a malformed batch never reaches the log.
+func (rs *RaftStore) ApplyBatch(ops []store.Op) error {
+	if err := store.ValidateBatch(ops); err != nil {
+		return err
+	}
+	size := 0
+	for _, op := range ops {
+		size += len(op.Key) + len(op.Value.Data)
+		if op.Kind != store.BatchSet {
+			continue
+		}
+		if err := rs.checkWrite(op.Key, len(op.Value.Data)); err != nil {
+			return err
+		}
+	}
+	if err := rs.checkBatch(size); err != nil {
+		return err
 	}
+	_, err := rs.apply(Command{
+		Op:  "BATCH",
+		Ops: ops,
+		Now: time.Now(),
+	})
+	return err
+}
+
+// MGet returns the live values of keys on this node
+func (rs *RaftStore) MGet(keys []string) map[string]store.Value {
+	return rs.store.MGet(keys)
+}
+
+// Exists reports whether key is present on this node and not expired
+func (rs *RaftStore) Exists(key string) bool {
+	return rs.store.Exists(key)
+}
+
+// Type returns the kind of value stored under key on this node, see
+// store.Store.Type
+func (rs *RaftStore) Type(key string) string {
+	return rs.store.Type(key)
+}
 
-	cmd := Command{
+// RandomKey returns a live key on this node chosen uniformly at random, or
+// false if there are none
+func (rs *RaftStore) RandomKey() (string, bool) {
+	return rs.store.RandomKey()
+}
+
+// Sample returns up to n distinct live keys on this node chosen uniformly at
+// random
+func (rs *RaftStore) Sample(n int) []string {
+	return rs.store.Sample(n)
+}
+
+func (rs *RaftStore) Set(key string, value store.Value) error {
+	if err := rs.checkWrite(key, len(value.Data)); err != nil {
+		return err
+	}
+	_, err := rs.apply(Command{
 		Op:        "SET",
 		Key:       key,
 		Value:     value.Data,
 		ExpiresAt: value.ExpiresAt,
+		Now:       time.Now(),
+	})
+	return err
+}
+
+func (rs *RaftStore) Delete(key string) error {
+	_, err := rs.apply(Command{
+		Op:  "DELETE",
+		Key: key,
+	})
+	return err
+}
+
+// SetNX stores value under key only if the key does not exist or has
+// expired, reporting whether it did. The check is made in the FSM so every
+// node agrees on the winner.
+func (rs *RaftStore) SetNX(key string, value store.Value) (bool, error) {
+	if err := rs.checkWrite(key, len(value.Data)); err != nil {
+		return false, err
+	}
+	result, err := rs.apply(Command{
+		Op:        "SETNX",
+		Key:       key,
+		Value:     value.Data,
+		ExpiresAt: value.ExpiresAt,
+		Now:       time.Now(),
+	})
+	return result.ok, err
+}
+
+// CompareAndSwap stores value under key only if the key currently holds
+// expected and returns its new version, or a *store.ConflictError otherwise.
+// The comparison is made when the FSM applies the command, so it sees every
+// earlier write.
+func (rs *RaftStore) CompareAndSwap(key, expected string, value store.Value) (uint64, error) {
+	if err := rs.checkWrite(key, len(value.Data)); err != nil {
+		return 0, err
 	}
+	result, err := rs.apply(Command{
+		Op:        "CAS",
+		Key:       key,
+		Value:     value.Data,
+		ExpiresAt: value.ExpiresAt,
+		Expected:  expected,
+		Now:       time.Now(),
+	})
+	return result.version, err
+}
 
-	data, err := json.Marshal(cmd)
-	if err != nil {
-		return err
+// IncrBy adds delta to the integer stored under key and returns the result,
+// computed by the FSM so every node holds the same counter
+func (rs *RaftStore) IncrBy(key string, delta int64) (int64, error) {
+	if err := rs.checkWrite(key, 0); err != nil {
+		return 0, err
 	}
+	result, err := rs.apply(Command{
+		Op:    "INCRBY",
+		Key:   key,
+		Delta: delta,
+		Now:   time.Now(),
+	})
+	return result.n, err
+}
 
-	future := rs.raft.Apply(data, 500*time.Millisecond)
-	return future.Error()
+// RangePrefix calls fn for each live key on this node starting with prefix,
+// in sorted order, until fn returns false
+func (rs *RaftStore) RangePrefix(prefix string, fn func(key string, value store.Value) bool) {
+	rs.store.RangePrefix(prefix, fn)
 }
 
-func (rs *RaftStore) Delete(key string) error {
-	if rs.raft.State() != raft.Leader {
-		return fmt.Errorf("not the leader")
+// RangeSorted calls fn for each live key on this node from start up to but
+// not including end, in sorted order, until fn returns false
+func (rs *RaftStore) RangeSorted(start, end string, fn func(key string, value store.Value) bool) {
+	rs.store.RangeSorted(start, end, fn)
+}
+
+// Scan lists keys on this node matching pattern after cursor, see
+// store.Store.Scan
+func (rs *RaftStore) Scan(cursor, pattern string, count int) ([]string, string) {
+	return rs.store.Scan(cursor, pattern, count)
+}
+
+// DeletePrefix removes every key starting with prefix on all nodes and
+// returns how many live keys were removed
+func (rs *RaftStore) DeletePrefix(prefix string) (int, error) {
+	result, err := rs.apply(Command{
+		Op:  "DELPREFIX",
+		Key: prefix,
+		Now: time.Now(),
+	})
+	return int(result.n), err
+}
+
+// FlushAll removes every key on all nodes, emptying each node's log
+func (rs *RaftStore) FlushAll() error {
+	_, err := rs.apply(Command{Op: "FLUSHALL"})
+	return err
+}
+
+// GetSet stores value under key and returns the value it replaced, as seen
+// by the FSM when the command was applied
+func (rs *RaftStore) GetSet(key string, value store.Value) (store.Value, bool, error) {
+	if err := rs.checkWrite(key, len(value.Data)); err != nil {
+		return store.Value{}, false, err
 	}
+	result, err := rs.apply(Command{
+		Op:        "GETSET",
+		Key:       key,
+		Value:     value.Data,
+		ExpiresAt: value.ExpiresAt,
+		Now:       time.Now(),
+	})
+	return result.old, result.ok, err
+}
 
-	cmd := Command{
-		Op:  "DELETE",
-		Key: key,
+// Append adds suffix.Data to the value stored under key and returns the new
+// length. suffix's expiry only applies if the key is created.
+func (rs *RaftStore) Append(key string, suffix store.Value) (int, error) {
+	// The check uses this node's copy of the value, which a concurrent
+	// write may change before the append is applied
+	current, _ := rs.store.Get(key)
+	if err := rs.checkWrite(key, len(current.Data)+len(suffix.Data)); err != nil {
+		return 0, err
+	}
+	result, err := rs.apply(Command{
+		Op:        "APPEND",
+		Key:       key,
+		Value:     suffix.Data,
+		ExpiresAt: suffix.ExpiresAt,
+		Now:       time.Now(),
+	})
+	return int(result.n), err
+}
+
+// Expire sets key to expire after d, or never if d is zero, reporting false
+// if the key is missing or expired
+func (rs *RaftStore) Expire(key string, d time.Duration) (bool, error) {
+	now := time.Now()
+	cmd := Command{Op: "EXPIRE", Key: key, Now: now}
+	if d != 0 {
+		cmd.ExpiresAt = now.Add(d)
+	}
+
+	result, err := rs.apply(cmd)
+	return result.ok, err
+}
+
+// Persist removes key's expiry, reporting false if the key is missing,
+// expired or has no expiry
+func (rs *RaftStore) Persist(key string) (bool, error) {
+	result, err := rs.apply(Command{Op: "EXPIRE", Key: key, Now: time.Now()})
+	return result.ok, err
+}
+
+// Touch resets key's expiry to d from now, or removes it if d is zero,
+// reporting false if the key is missing or expired
+func (rs *RaftStore) Touch(key string, d time.Duration) (bool, error) {
+	now := time.Now()
+	cmd := Command{Op: "TOUCH", Key: key, Now: now}
+	if d != 0 {
+		cmd.ExpiresAt = now.Add(d)
 	}
 
+	result, err := rs.apply(cmd)
+	return result.ok, err
+}
+
+// GetEx returns key's value while resetting its expiry to d from now, or
+// removing it if d is zero. The read and the new expiry are applied together
+// by the FSM, so every node ends up with the same expiry.
+func (rs *RaftStore) GetEx(key string, d time.Duration) (store.Value, bool, error) {
+	now := time.Now()
+	cmd := Command{Op: "GETEX", Key: key, Now: now}
+	if d != 0 {
+		cmd.ExpiresAt = now.Add(d)
+	}
+
+	result, err := rs.apply(cmd)
+	return result.value, result.ok, err
+}
+
+// LPush inserts elems at the head of the list stored under key on all nodes
+// and returns its new length
+func (rs *RaftStore) LPush(key string, elems ...string) (int, error) {
+	return rs.push("LPUSH", key, elems)
+}
+
+// RPush appends elems to the tail of the list stored under key on all nodes
+// and returns its new length
+func (rs *RaftStore) RPush(key string, elems ...string) (int, error) {
+	return rs.push("RPUSH", key, elems)
+}
+
+func (rs *RaftStore) push(op, key string, elems []string) (int, error) {
+	if len(elems) == 0 {
+		return 0, store.ErrNoElements
+	}
+	// The check uses this node's copy of the list, which a concurrent write
+	// may change before the push is applied
+	var size int
+	if current, ok := rs.store.Get(key); ok {
+		size = len(current.Data)
+		for _, e := range current.List {
+			size += len(e)
+		}
+	}
+	for _, e := range elems {
+		size += len(e)
+	}
+	if err := rs.checkWrite(key, size); err != nil {
+		return 0, err
+	}
+
+	result, err := rs.apply(Command{Op: op, Key: key, Elements: elems, Now: time.Now()})
+	return int(result.n), err
+}
+
+// LPop removes and returns up to count elements from the head of the list
+// stored under key on all nodes
+func (rs *RaftStore) LPop(key string, count int) ([]string, error) {
+	return rs.pop("LPOP", key, count)
+}
+
+// RPop removes and returns up to count elements from the tail of the list
+// stored under key on all nodes, last element first
+func (rs *RaftStore) RPop(key string, count int) ([]string, error) {
+	return rs.pop("RPOP", key, count)
+}
+
+func (rs *RaftStore) pop(op, key string, count int) ([]string, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("count must be positive")
+	}
+	result, err := rs.apply(Command{Op: op, Key: key, Count: count, Now: time.Now()})
+	return result.elems, err
+}
+
+// LLen returns the length of the list stored under key on this node
+func (rs *RaftStore) LLen(key string) (int, error) {
+	return rs.store.LLen(key)
+}
+
+// LRange returns the elements from start to stop inclusive of the list
+// stored under key on this node
+func (rs *RaftStore) LRange(key string, start, stop int) ([]string, error) {
+	return rs.store.LRange(key, start, stop)
+}
+
+// apply proposes a command on the leader and waits for it to be applied,
+// returning its result along with either the raft error or the error the
+// FSM returned for it
+func (rs *RaftStore) apply(cmd Command) (applyResult, error) {
+	if rs.raft.State() != raft.Leader || chaos.NotLeader() {
+		return applyResult{}, ErrNotLeader
+	}
+
+	cmd.encodeValues()
 	data, err := json.Marshal(cmd)
 	if err != nil {
+		return applyResult{}, err
+	}
+
+	future := rs.raft.Apply(data, rs.applyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			return applyResult{}, ErrNotLeader
+		}
+		return applyResult{}, err
+	}
+
+	switch resp := future.Response().(type) {
+	case error:
+		return applyResult{}, resp
+	case applyResult:
+		return resp, resp.err
+	}
+	return applyResult{}, nil
+}
+
+// VerifyRead makes the reads that follow it see every write committed before
+// it was called: it confirms with a quorum that this node is still the
+// leader, then waits for the FSM to apply what had been committed. The first
+// call in each term first waits for a barrier, committed in that term, to be
+// applied, since only then does the commit index cover every earlier write.
+// On other nodes it returns ErrNotLeader.
+func (rs *RaftStore) VerifyRead() error {
+	if rs.raft.State() != raft.Leader || chaos.NotLeader() {
+		return ErrNotLeader
+	}
+
+	if term := rs.raft.CurrentTerm(); rs.readTerm.Load() != term {
+		if err := rs.raft.Barrier(rs.applyTimeout).Error(); err != nil {
+			if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+				return ErrNotLeader
+			}
+			return fmt.Errorf("failed to commit a barrier for term %d: %w", term, err)
+		}
+		rs.readTerm.Store(term)
+	}
+
+	commitIndex := rs.raft.CommitIndex()
+	if err := rs.raft.VerifyLeader().Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return ErrNotLeader
+		}
 		return err
 	}
 
-	future := rs.raft.Apply(data, 500*time.Millisecond)
-	return future.Error()
+	deadline := time.Now().Add(rs.applyTimeout)
+	for rs.raft.AppliedIndex() < commitIndex {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting to apply index %d", commitIndex)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
 }
 
 func (rs *RaftStore) TTL(key string) (time.Duration, bool) {
 	return rs.store.TTL(key)
 }
 
+// MemoryUsage returns the approximate number of bytes key uses on this node
+func (rs *RaftStore) MemoryUsage(key string) (int64, bool) {
+	return rs.store.MemoryUsage(key)
+}
+
+// MemoryStats returns this node's memory totals and its top largest keys
+func (rs *RaftStore) MemoryStats(top int) store.MemoryStats {
+	return rs.store.MemoryStats(top)
+}
+
+// Stats returns this node's key count, memory estimate and write counters
+func (rs *RaftStore) Stats() store.Stats {
+	return rs.store.Stats()
+}
+
+// Compact rewrites this node's key-value log to hold only the live keys. The
+// raft log is compacted separately by raft snapshots.
+func (rs *RaftStore) Compact() error {
+	return rs.store.Compact()
+}
+
+// LogSize returns the size of this node's key-value log in bytes
+func (rs *RaftStore) LogSize() int64 {
+	return rs.store.LogSize()
+}
+
+// ReplayStats returns the outcome of replaying this node's log at startup
+func (rs *RaftStore) ReplayStats() store.ReplayStats {
+	return rs.store.ReplayStats()
+}
+
+// Watch reports changes to keys with the given prefix as they are applied by
+// the FSM, so events reflect committed state
+func (rs *RaftStore) Watch(prefix string, buffer int) (<-chan store.Event, func()) {
+	return rs.store.Watch(prefix, buffer)
+}
+
+// OnExpire registers fn to run when a key expires, and returns a function
+// that unregisters it. Every node applies the leader's expiries, so fn is
+// only called on the node that is leader at the time, giving one call per
+// expiry rather than one per node. Expiries applied during a leadership
+// change may be reported by neither or both leaders.
+func (rs *RaftStore) OnExpire(fn func(key string, v store.Value)) func() {
+	return rs.store.OnExpire(func(key string, v store.Value) {
+		if rs.IsLeader() {
+			fn(key, v)
+		}
+	})
+}
+
+// checkWrite checks a write of a value of valueSize bytes to key against the
+// limits and the memory limit before it is proposed
+func (rs *RaftStore) checkWrite(key string, valueSize int) error {
+	if err := rs.limits.Check(key, valueSize); err != nil {
+		return err
+	}
+	if rs.maxMemory > 0 {
+		if used := rs.store.Stats().MemoryBytes; used >= rs.maxMemory {
+			return fmt.Errorf("%w: %d of %d bytes used", store.ErrOutOfMemory, used, rs.maxMemory)
+		}
+	}
+	return nil
+}
+
+// Limits returns the key and value sizes checked before writes are proposed
+func (rs *RaftStore) Limits() store.Limits {
+	return rs.limits
+}
+
 func (rs *RaftStore) IsLeader() bool {
 	return rs.raft.State() == raft.Leader
 }
 
+// AppliedIndex returns the index of the last raft log entry this node applied
+func (rs *RaftStore) AppliedIndex() uint64 {
+	return rs.raft.AppliedIndex()
+}
+
+// GetLeader returns the address clients reach the leader on, its raft
+// address if it registered none, or "" while no leader is known
 func (rs *RaftStore) GetLeader() string {
-	addr := rs.raft.Leader()
+	addr, id := rs.raft.LeaderWithID()
 	if addr == "" {
 		return ""
 	}
+	if clientAddr := rs.fsm.clientAddr(string(id)); clientAddr != "" {
+		return clientAddr
+	}
 	return string(addr)
 }
 
-// Join adds a node to the cluster
-func (rs *RaftStore) Join(nodeID, addr string) error {
+// NodeInfo describes a node's view of the cluster
+type NodeInfo struct {
+	State        string `json:"state"` // Leader, Follower, Candidate or Shutdown
+	Term         uint64 `json:"term"`
+	Leader       string `json:"leader"` // empty while no leader is known
+	LastIndex    uint64 `json:"last_index"`
+	AppliedIndex uint64 `json:"applied_index"`
+}
+
+// NodeInfo returns the node's raft state, term and leader
+func (rs *RaftStore) NodeInfo() NodeInfo {
+	return NodeInfo{
+		State:        rs.raft.State().String(),
+		Term:         rs.raft.CurrentTerm(),
+		Leader:       rs.GetLeader(),
+		LastIndex:    rs.raft.LastIndex(),
+		AppliedIndex: rs.raft.AppliedIndex(),
+	}
+}
+
+// Peer is a member of the cluster's configuration
+type Peer struct {
+	ID         string `json:"id"`
+	Addr       string `json:"addr"`                  // raft address
+	ClientAddr string `json:"client_addr,omitempty"` // empty if it registered none
+	APIAddr    string `json:"api_addr,omitempty"`    // empty if it registered none
+	Suffrage   string `json:"suffrage"`              // Voter, Nonvoter or Staging
+	Leader     bool   `json:"leader"`
+}
+
+// ClusterStatus is a node's detailed view of the cluster, as reported by
+// STATUS and /status
+type ClusterStatus struct {
+	NodeID       string `json:"node_id"`
+	ClusterID    string `json:"cluster_id,omitempty"` // empty until the cluster elected a leader
+	State        string `json:"state"`                // Leader, Follower, Candidate or Shutdown
+	Term         uint64 `json:"term"`
+	LastIndex    uint64 `json:"last_index"`
+	CommitIndex  uint64 `json:"commit_index"`
+	AppliedIndex uint64 `json:"applied_index"`
+	LeaderID     string `json:"leader_id,omitempty"`   // empty while no leader is known
+	LeaderAddr   string `json:"leader_addr,omitempty"` // the leader's client address
+	Peers        []Peer `json:"peers"`                 // every member, this node included
+}
+
+// ClusterStatus returns the node's raft state and indexes, the leader and
+// the members of the cluster
+func (rs *RaftStore) ClusterStatus() (ClusterStatus, error) {
+	peers, err := rs.Peers()
+	if err != nil {
+		return ClusterStatus{}, err
+	}
+
+	_, leaderID := rs.raft.LeaderWithID()
+	return ClusterStatus{
+		NodeID:       rs.nodeID,
+		ClusterID:    rs.ClusterID(),
+		State:        rs.raft.State().String(),
+		Term:         rs.raft.CurrentTerm(),
+		LastIndex:    rs.raft.LastIndex(),
+		CommitIndex:  rs.raft.CommitIndex(),
+		AppliedIndex: rs.raft.AppliedIndex(),
+		LeaderID:     string(leaderID),
+		LeaderAddr:   rs.GetLeader(),
+		Peers:        peers,
+	}, nil
+}
+
+// Peers returns the members of the cluster as this node's configuration
+// lists them, with the addresses they registered, so it answers on
+// followers too
+func (rs *RaftStore) Peers() ([]Peer, error) {
+	configFuture := rs.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	_, leaderID := rs.raft.LeaderWithID()
+	var peers []Peer
+	for _, srv := range configFuture.Configuration().Servers {
+		peers = append(peers, Peer{
+			ID:         string(srv.ID),
+			Addr:       string(srv.Address),
+			ClientAddr: rs.fsm.clientAddr(string(srv.ID)),
+			APIAddr:    rs.fsm.apiAddr(string(srv.ID)),
+			Suffrage:   srv.Suffrage.String(),
+			Leader:     srv.ID == leaderID,
+		})
+	}
+	return peers, nil
+}
+
+// RaftStats returns hashicorp/raft's own counters, such as its state, term and
+// commit index, as strings keyed by name
+func (rs *RaftStore) RaftStats() map[string]string {
+	return rs.raft.Stats()
+}
+
+// Join adds a node to the cluster and registers the addresses its clients
+// and API are reached on, if given. A node that already joined only has its
+// addresses updated.
+func (rs *RaftStore) Join(nodeID, addr, clientAddr, apiAddr string) error {
 	if !rs.IsLeader() {
-		return fmt.Errorf("not the leader")
+		return ErrNotLeader
 	}
 
 	configFuture := rs.raft.GetConfiguration()
@@ -182,8 +1076,11 @@ func (rs *RaftStore) Join(nodeID, addr string) error {
 	}
 
 	for _, srv := range configFuture.Configuration().Servers {
-		if srv.ID == raft.ServerID(nodeID) || srv.Address == raft.ServerAddress(addr) {
+		if srv.ID == raft.ServerID(nodeID) {
 			// Already joined
+			return rs.registerAddrs(nodeID, clientAddr, apiAddr)
+		}
+		if srv.Address == raft.ServerAddress(addr) {
 			return nil
 		}
 	}
@@ -193,20 +1090,151 @@ func (rs *RaftStore) Join(nodeID, addr string) error {
 		return err
 	}
 
-	return nil
+	return rs.registerAddrs(nodeID, clientAddr, apiAddr)
+}
+
+// Remove takes a node out of the cluster and forgets its addresses. Removing
+// a node that is not a member only forgets them. Asked to remove itself, the
+// leader hands its leadership to another node and returns ErrNotLeader, as
+// the new leader must remove it.
+func (rs *RaftStore) Remove(nodeID string) error {
+	if !rs.IsLeader() {
+		return ErrNotLeader
+	}
+
+	if nodeID == rs.nodeID {
+		if _, err := rs.TransferLeadership(""); err != nil {
+			return err
+		}
+		return ErrNotLeader
+	}
+
+	configFuture := rs.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(nodeID) {
+			future := rs.raft.RemoveServer(srv.ID, 0, 0)
+			if err := future.Error(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	_, err := rs.apply(Command{Op: "FORGETNODE", Key: nodeID})
+	return err
+}
+
+// TransferLeadership hands the leadership to the node nodeID, or to the
+// most up to date follower if nodeID is empty, and returns the ID of the new
+// leader. It fails if this node is not the leader, or if the transfer does
+// not finish within raft's election timeout.
+func (rs *RaftStore) TransferLeadership(nodeID string) (string, error) {
+	if !rs.IsLeader() {
+		return "", ErrNotLeader
+	}
+
+	var future raft.Future
+	if nodeID == "" {
+		future = rs.raft.LeadershipTransfer()
+	} else {
+		configFuture := rs.raft.GetConfiguration()
+		if err := configFuture.Error(); err != nil {
+			return "", err
+		}
+
+		var addr raft.ServerAddress
+		for _, srv := range configFuture.Configuration().Servers {
+			if srv.ID == raft.ServerID(nodeID) {
+				addr = srv.Address
+			}
+		}
+		if addr == "" {
+			return "", fmt.Errorf("node %s is not a member of the cluster", nodeID)
+		}
+		future = rs.raft.LeadershipTransferToServer(raft.ServerID(nodeID), addr)
+	}
+	if err := future.Error(); err != nil {
+		return "", fmt.Errorf("failed to transfer leadership: %w", err)
+	}
+
+	// The old leader steps down before the new one is known
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, id := rs.raft.LeaderWithID(); id != "" && id != raft.ServerID(rs.nodeID) {
+			return string(id), nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("leadership was handed over but no new leader was elected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// LeaderAPI returns the API address the leader registered, or "" while no
+// leader is known or it registered none
+func (rs *RaftStore) LeaderAPI() string {
+	_, id := rs.raft.LeaderWithID()
+	if id == "" {
+		return ""
+	}
+	return rs.fsm.apiAddr(string(id))
+}
+
+// waitLeaderAPI returns the API address of a leader other than this node,
+// waiting up to timeout for one to be elected
+func (rs *RaftStore) waitLeaderAPI(timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if leaderAPI := rs.LeaderAPI(); leaderAPI != "" && !rs.IsLeader() {
+			return leaderAPI, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("no leader API address known")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 }
 
-// Leave removes this node from the cluster
+// Leave removes this node from the cluster, asking the leader's API to
+// remove it. A leader first hands its leadership to another node. The only
+// node of a cluster has nothing to leave.
 func (rs *RaftStore) Leave() error {
+	return rs.LeaveAs("", "")
+}
+
+// LeaveAs is Leave logging in to the leader's API as user, who must be
+// allowed the REMOVE command when the cluster has users
+func (rs *RaftStore) LeaveAs(user, password string) error {
+	configFuture := rs.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	servers := configFuture.Configuration().Servers
+	if len(servers) == 1 && servers[0].ID == raft.ServerID(rs.nodeID) {
+		return nil
+	}
+
 	if rs.IsLeader() {
+		if _, err := rs.TransferLeadership(""); err != nil {
+			return err
+		}
+	}
 
-		return rs.raft.LeadershipTransfer().Error()
+	leaderAPI, err := rs.waitLeaderAPI(5 * time.Second)
+	if err != nil {
+		return err
 	}
-	return nil
+	return RemoveFromClusterAs(leaderAPI, rs.nodeID, user, password)
 }
 
 // Shutdown closes the Raft cluster
 func (rs *RaftStore) Shutdown() error {
+	rs.stopOnce.Do(func() { close(rs.stop) })
+
 	// Shutdown the Raft instance
 	future := rs.raft.Shutdown()
 	if err := future.Error(); err != nil {
@@ -221,21 +1249,72 @@ func (rs *RaftStore) Shutdown() error {
 		return err
 	}
 
-	return nil
+	// Raft no longer applies entries, so the key-value log can be closed
+	return rs.store.Close()
 }
 
-func (rs *RaftStore) BackgroundCleaner() {
-	rs.store.BackgroundCleaner()
+// expireBatch is how many expired keys one EXPIREKEYS entry removes
+const expireBatch = 1000
+
+// BackgroundCleaner removes expired keys on the leader by proposing
+// EXPIREKEYS entries stamped with the leader's clock, so every node removes
+// the same keys whatever its own clock says. Followers never remove expired
+// keys on their own, though their reads treat them as missing. On a follower
+// it does nothing.
+func (rs *RaftStore) BackgroundCleaner() error {
+	if !rs.IsLeader() {
+		return nil
+	}
+
+	for {
+		now := time.Now()
+		keys := rs.store.ExpiredKeys(now, expireBatch)
+		if len(keys) == 0 {
+			return nil
+		}
+		if _, err := rs.apply(Command{Op: "EXPIREKEYS", Keys: keys, Now: now}); err != nil {
+			if errors.Is(err, ErrNotLeader) {
+				// The new leader takes over on its next sweep
+				return nil
+			}
+			return fmt.Errorf("failed to expire keys: %w", err)
+		}
+		if len(keys) < expireBatch {
+			return nil
+		}
+	}
 }
 
+// StartBackgroundCleaner runs BackgroundCleaner on every node every
+// CleanerInterval until Shutdown. Only the leader's sweeps remove keys, so
+// the sweeping moves with the leadership.
 func (rs *RaftStore) StartBackgroundCleaner() {
-	rs.store.StartBackgroundCleaner()
+	interval := rs.cleanerInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := rs.BackgroundCleaner(); err != nil {
+					fmt.Printf("Error sweeping expired keys: %v\n", err)
+				}
+			case <-rs.stop:
+				return
+			}
+		}
+	}()
 }
 
 // TakeSnapshot forces the creation of a snapshot
 func (rs *RaftStore) TakeSnapshot() error {
 	if rs.raft.State() != raft.Leader {
-		return fmt.Errorf("not the leader")
+		return ErrNotLeader
 	}
 
 	future := rs.raft.Snapshot()
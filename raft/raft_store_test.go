@@ -0,0 +1,105 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+func TestVerifyReadSeesEveryWriteAcrossLeaderChanges(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+
+	written := 0
+	for round := 0; round < 3; round++ {
+		leader := leaderOf(t, nodes)
+
+		// Each write is read back through the leader it was made on
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%d", written)
+			if err := leader.Set(key, store.NewValue(fmt.Sprint(written), 0)); err != nil {
+				t.Fatalf("round %d: SET %s: %v", round, key, err)
+			}
+			written++
+
+			if err := leader.VerifyRead(); err != nil {
+				t.Fatalf("round %d: VerifyRead: %v", round, err)
+			}
+			if v, ok := leader.Get(key); !ok || v.Data != fmt.Sprint(written-1) {
+				t.Fatalf("round %d: GET %s after VerifyRead = %q, %v", round, key, v.Data, ok)
+			}
+		}
+
+		// The next leader's first verified read must see every earlier write,
+		// though its commit index may lag until it commits an entry of its own
+		if _, err := leader.TransferLeadership(""); err != nil {
+			t.Fatalf("round %d: transfer: %v", round, err)
+		}
+		next := leaderOf(t, nodes)
+		if err := next.VerifyRead(); err != nil {
+			t.Fatalf("round %d: VerifyRead on new leader %s: %v", round, next.id, err)
+		}
+		for i := 0; i < written; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if v, ok := next.Get(key); !ok || v.Data != fmt.Sprint(i) {
+				t.Fatalf("round %d: new leader %s GET %s = %q, %v", round, next.id, key, v.Data, ok)
+			}
+		}
+	}
+}
+
+func TestVerifyReadOnFollower(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := leaderOf(t, nodes)
+
+	for _, node := range nodes {
+		if node == leader {
+			continue
+		}
+		if err := node.VerifyRead(); err != ErrNotLeader {
+			t.Errorf("VerifyRead on follower %s = %v, want ErrNotLeader", node.id, err)
+		}
+	}
+}
+
+func TestOnExpireRunsOnlyOnTheLeader(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := leaderOf(t, nodes)
+
+	calls := make(chan string, 10)
+	for _, node := range nodes {
+		id := node.id
+		node.OnExpire(func(key string, v store.Value) { calls <- id + " " + key })
+	}
+
+	if err := leader.Set("session", store.NewValue("x", 100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := leader.BackgroundCleaner(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "every node to apply the expiry", func() bool {
+		for _, node := range nodes {
+			if node.Stats().ExpiredKeys != 1 {
+				return false
+			}
+		}
+		return true
+	})
+
+	select {
+	case call := <-calls:
+		if want := leader.id + " session"; call != want {
+			t.Errorf("expiry callback %q, want %q", call, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expiry callback ran")
+	}
+	select {
+	case call := <-calls:
+		t.Errorf("second expiry callback %q, want one from the leader only", call)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,182 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lagPollInterval is how often the leader asks its followers' APIs how far
+// their logs have got
+const lagPollInterval = 2 * time.Second
+
+// ReplicationStats is hashicorp/raft's view of a node, with the lag derived
+// from it
+type ReplicationStats struct {
+	// Stats holds hashicorp/raft's own stats, such as last_log_index,
+	// fsm_pending and num_peers, with numbers as integers. last_contact
+	// becomes last_contact_ms once the node has heard from a leader.
+	Stats map[string]any `json:"stats"`
+
+	CommitLag uint64 `json:"commit_lag"` // entries in the log not yet committed
+	ApplyLag  uint64 `json:"apply_lag"`  // committed entries not yet applied
+
+	// Followers is how far each follower trails, as the leader last saw it.
+	// It is only set on the leader.
+	Followers []FollowerLag `json:"followers,omitempty"`
+
+	// LastSnapshot describes the latest snapshot this node wrote since it
+	// started, if any
+	LastSnapshot *SnapshotStats `json:"last_snapshot,omitempty"`
+}
+
+// FollowerLag is how far a follower's log trails the leader's, found by
+// asking the follower's API every few seconds
+type FollowerLag struct {
+	ID           string    `json:"id"`
+	LastIndex    uint64    `json:"last_index"`
+	AppliedIndex uint64    `json:"applied_index"`
+	Lag          uint64    `json:"lag"` // entries the follower's log lacks
+	CheckedAt    time.Time `json:"checked_at"`
+	Error        string    `json:"error,omitempty"` // why the last check failed
+}
+
+// followerLags holds the leader's latest view of its followers
+type followerLags struct {
+	mu   sync.Mutex
+	lags map[string]FollowerLag
+}
+
+// ReplicationStats returns hashicorp/raft's stats for this node, how far
+// its commits and applies trail its log and, on the leader, how far each
+// follower trails
+func (rs *RaftStore) ReplicationStats() ReplicationStats {
+	raw := rs.raft.Stats()
+	stats := ReplicationStats{Stats: make(map[string]any, len(raw))}
+	for name, value := range raw {
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			stats.Stats[name] = n
+			continue
+		}
+		if name == "last_contact" {
+			if d, err := time.ParseDuration(value); err == nil {
+				stats.Stats["last_contact_ms"] = d.Milliseconds()
+				continue
+			}
+		}
+		stats.Stats[name] = value
+	}
+
+	last, commit, applied := rs.raft.LastIndex(), rs.raft.CommitIndex(), rs.raft.AppliedIndex()
+	stats.CommitLag = behind(last, commit)
+	stats.ApplyLag = behind(commit, applied)
+	stats.LastSnapshot = rs.fsm.lastSnapshot.Load()
+
+	if rs.IsLeader() {
+		rs.lags.mu.Lock()
+		for _, lag := range rs.lags.lags {
+			stats.Followers = append(stats.Followers, lag)
+		}
+		rs.lags.mu.Unlock()
+		sort.Slice(stats.Followers, func(i, j int) bool {
+			return stats.Followers[i].ID < stats.Followers[j].ID
+		})
+	}
+	return stats
+}
+
+// behind returns how far b trails a, or zero if it does not
+func behind(a, b uint64) uint64 {
+	if b >= a {
+		return 0
+	}
+	return a - b
+}
+
+// pollFollowers refreshes the leader's view of its followers every
+// lagPollInterval until stop or the store's stop channel is closed, then
+// forgets it
+func (rs *RaftStore) pollFollowers(stop <-chan struct{}) {
+	ticker := time.NewTicker(lagPollInterval)
+	defer ticker.Stop()
+	defer func() {
+		rs.lags.mu.Lock()
+		rs.lags.lags = nil
+		rs.lags.mu.Unlock()
+	}()
+
+	for {
+		rs.checkFollowers()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-rs.stop:
+			return
+		}
+	}
+}
+
+// checkFollowers asks every follower's API for its log indexes at once
+func (rs *RaftStore) checkFollowers() {
+	peers, err := rs.Peers()
+	if err != nil {
+		return
+	}
+
+	leaderLast := rs.raft.LastIndex()
+	lags := make(map[string]FollowerLag, len(peers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		if p.ID == rs.nodeID {
+			continue
+		}
+		wg.Add(1)
+		go func(p Peer) {
+			defer wg.Done()
+			lag := FollowerLag{ID: p.ID, CheckedAt: time.Now()}
+			if status, err := followerStatus(p.APIAddr); err != nil {
+				lag.Error = err.Error()
+			} else {
+				lag.LastIndex, lag.AppliedIndex = status.LastIndex, status.AppliedIndex
+				lag.Lag = behind(leaderLast, status.LastIndex)
+			}
+			mu.Lock()
+			lags[p.ID] = lag
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+
+	rs.lags.mu.Lock()
+	rs.lags.lags = lags
+	rs.lags.mu.Unlock()
+}
+
+// followerStatus fetches /status from the API at apiAddr
+func followerStatus(apiAddr string) (ClusterStatus, error) {
+	if apiAddr == "" {
+		return ClusterStatus{}, fmt.Errorf("no API address registered")
+	}
+
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/status", apiAddr))
+	if err != nil {
+		return ClusterStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ClusterStatus{}, fmt.Errorf("status request failed with status: %s", resp.Status)
+	}
+	var status StatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return ClusterStatus{}, fmt.Errorf("invalid status response: %w", err)
+	}
+	return status.ClusterStatus, nil
+}
@@ -0,0 +1,85 @@
+package raft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/pixperk/yakvs/snapshot"
+)
+
+// mirroredSnapshotStore wraps the local snapshot store and copies every
+// completed snapshot to external storage in the background, so snapshots
+// survive the loss of the node
+type mirroredSnapshotStore struct {
+	raft.SnapshotStore
+	storage snapshot.Storage
+}
+
+func newMirroredSnapshotStore(local raft.SnapshotStore, storage snapshot.Storage) *mirroredSnapshotStore {
+	return &mirroredSnapshotStore{
+		SnapshotStore: local,
+		storage:       storage,
+	}
+}
+
+func (m *mirroredSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64,
+	configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := m.SnapshotStore.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mirrorSink{SnapshotSink: sink, store: m}, nil
+}
+
+// mirror uploads a completed local snapshot to the external storage
+func (m *mirroredSnapshotStore) mirror(id string) {
+	meta, rc, err := m.SnapshotStore.Open(id)
+	if err != nil {
+		fmt.Printf("Failed to open snapshot %s for mirroring: %v\n", id, err)
+		return
+	}
+	defer rc.Close()
+
+	err = m.storage.Put(snapshot.Metadata{
+		ID:        id,
+		Size:      meta.Size,
+		CreatedAt: time.Now(),
+		Index:     meta.Index,
+		Term:      meta.Term,
+	}, rc)
+	if err != nil {
+		fmt.Printf("Failed to mirror snapshot %s: %v\n", id, err)
+		return
+	}
+
+	fmt.Printf("Mirrored snapshot %s (%d bytes)\n", id, meta.Size)
+}
+
+// mirrorSink starts the upload once the local snapshot is complete
+type mirrorSink struct {
+	raft.SnapshotSink
+	store  *mirroredSnapshotStore
+	closed bool
+}
+
+func (s *mirrorSink) Cancel() error {
+	s.closed = true
+	return s.SnapshotSink.Cancel()
+}
+
+func (s *mirrorSink) Close() error {
+	// Both Snapshot.Persist and raft itself close the sink
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if err := s.SnapshotSink.Close(); err != nil {
+		return err
+	}
+
+	go s.store.mirror(s.ID())
+	return nil
+}
@@ -0,0 +1,123 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+// checkWritesContinue writes through the node leading nodes and waits for
+// every node to apply it
+func checkWritesContinue(t *testing.T, nodes []*testNode, key string) {
+	t.Helper()
+
+	leader := leaderOf(t, nodes)
+	if err := leader.Set(key, store.Value{Data: key}); err != nil {
+		t.Fatalf("write through the new leader %s: %v", leader.id, err)
+	}
+	for _, node := range nodes {
+		waitFor(t, 10*time.Second, node.id+" to apply "+key, func() bool {
+			v, ok := node.Get(key)
+			return ok && v.Data == key
+		})
+	}
+}
+
+// followerOf returns a node of nodes other than leader
+func followerOf(nodes []*testNode, leader *testNode) *testNode {
+	for _, node := range nodes {
+		if node != leader {
+			return node
+		}
+	}
+	return nil
+}
+
+func TestTransferLeadership(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := leaderOf(t, nodes)
+	target := followerOf(nodes, leader)
+
+	if _, err := target.TransferLeadership(""); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("TransferLeadership on a follower = %v, want ErrNotLeader", err)
+	}
+	if _, err := leader.TransferLeadership("node9"); err == nil {
+		t.Error("TransferLeadership to a node outside the cluster succeeded")
+	}
+
+	// To a named node
+	id, err := leader.TransferLeadership(target.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != target.id {
+		t.Errorf("leadership went to %s, want %s", id, target.id)
+	}
+	waitFor(t, 10*time.Second, "every node to follow "+target.id, func() bool {
+		for _, node := range nodes {
+			if node.GetLeader() != target.config.RaftAddr {
+				return false
+			}
+		}
+		return true
+	})
+	if leader.IsLeader() {
+		t.Error("the old leader still leads")
+	}
+	checkWritesContinue(t, nodes, "after-named")
+
+	// To whichever follower is most up to date
+	old := leaderOf(t, nodes)
+	id, err = old.TransferLeadership("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == old.id {
+		t.Errorf("leadership stayed with %s", id)
+	}
+	checkWritesContinue(t, nodes, "after-any")
+	if leader := leaderOf(t, nodes); leader.id != id {
+		t.Errorf("%s leads, the transfer reported %s", leader.id, id)
+	}
+}
+
+func TestTransferLeadershipEndpoint(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := leaderOf(t, nodes)
+	target := followerOf(nodes, leader)
+
+	transfer := func(node *testNode, body string) (int, string) {
+		t.Helper()
+
+		api := httptest.NewServer(http.HandlerFunc(NewAPI(node.RaftStore, "").handleTransfer))
+		defer api.Close()
+		resp, err := http.Post(api.URL+"/transfer-leadership", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(msg)
+	}
+
+	if code, msg := transfer(target, ""); code != http.StatusBadRequest || !strings.Contains(msg, "Not the leader") {
+		t.Errorf("transfer through a follower = %d %q", code, msg)
+	}
+	if code, _ := transfer(leader, "{"); code != http.StatusBadRequest {
+		t.Errorf("transfer with an invalid body = %d, want 400", code)
+	}
+
+	code, msg := transfer(leader, fmt.Sprintf(`{"node_id":%q}`, target.id))
+	if code != http.StatusOK || !strings.Contains(msg, target.id) {
+		t.Fatalf("transfer to %s = %d %q", target.id, code, msg)
+	}
+	waitFor(t, 10*time.Second, target.id+" to lead", target.IsLeader)
+	checkWritesContinue(t, nodes, "after-endpoint")
+}
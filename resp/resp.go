@@ -0,0 +1,195 @@
+// Package resp reads commands and writes replies in RESP2, the protocol
+// spoken by Redis clients such as redis-cli.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxArgs is the most arguments a command may have unless SetMaxArgs
+// says otherwise, the same as Redis allows
+const DefaultMaxArgs = 1 << 20
+
+// preallocArgs caps the arguments room is made for before they arrive, so a
+// client announcing a huge command cannot make the reader allocate for it
+const preallocArgs = 64
+
+// ErrProtocol is returned for input that is not valid RESP. The connection
+// cannot be read any further.
+var ErrProtocol = errors.New("protocol error")
+
+// ErrTooLarge is returned for an argument or inline command over the size
+// limit. The connection cannot be read any further.
+var ErrTooLarge = errors.New("request too large")
+
+// Reader reads commands sent as arrays of bulk strings, or as inline
+// commands made of space separated words on one line, as typed into telnet
+type Reader struct {
+	r       *bufio.Reader
+	max     int // zero disables the limit
+	maxArgs int // zero disables the limit
+}
+
+// NewReader returns a reader of commands from r whose arguments, or inline
+// lines, may be at most max bytes long. Zero disables the limit. Commands
+// may have at most DefaultMaxArgs arguments.
+func NewReader(r io.Reader, max int) *Reader {
+	return &Reader{r: bufio.NewReader(r), max: max, maxArgs: DefaultMaxArgs}
+}
+
+// SetMaxArgs sets the most arguments, the command name included, a command
+// sent as an array may have. Zero disables the limit.
+func (r *Reader) SetMaxArgs(n int) {
+	r.maxArgs = n
+}
+
+// Buffered reports whether more input has already been received, so replies
+// to pipelined commands can be flushed together
+func (r *Reader) Buffered() bool {
+	return r.r.Buffered() > 0
+}
+
+// ReadCommand returns the arguments of the next command, the first being its
+// name. An empty command is returned as no arguments.
+func (r *Reader) ReadCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(string(line)), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+	if r.maxArgs > 0 && n > r.maxArgs {
+		return nil, fmt.Errorf("%w: command has %d arguments, the limit is %d", ErrTooLarge, n, r.maxArgs)
+	}
+
+	// Room grows as arguments arrive, rather than as many as were announced
+	args := make([]string, 0, min(max(n, 0), preallocArgs))
+	for i := 0; i < n; i++ {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads one $<length> bulk string
+func (r *Reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("%w: expected '$', got %q", ErrProtocol, truncate(line))
+	}
+
+	size, err := strconv.Atoi(string(line[1:]))
+	if err != nil || size < 0 {
+		return "", fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+	if r.max > 0 && size > r.max {
+		return "", fmt.Errorf("%w: argument is %d bytes, the limit is %d", ErrTooLarge, size, r.max)
+	}
+
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return "", err
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return string(buf[:size]), nil
+}
+
+// readLine reads one line without its line ending
+func (r *Reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if r.max > 0 && len(line) > r.max {
+			return nil, fmt.Errorf("%w: line is over %d bytes", ErrTooLarge, r.max)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(line), "\r\n")), nil
+	}
+}
+
+// truncate shortens input quoted in error messages
+func truncate(b []byte) []byte {
+	if len(b) > 16 {
+		return b[:16]
+	}
+	return b
+}
+
+// Writer buffers replies until Flush
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a writer of replies to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// SimpleString writes a status reply such as +OK
+func (w *Writer) SimpleString(s string) {
+	w.w.WriteString("+" + oneLine(s) + "\r\n")
+}
+
+// Error writes an error reply. msg starts with an error code such as ERR or
+// WRONGTYPE, as Redis clients expect.
+func (w *Writer) Error(msg string) {
+	w.w.WriteString("-" + oneLine(msg) + "\r\n")
+}
+
+// Integer writes an integer reply
+func (w *Writer) Integer(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// Bulk writes a binary safe string reply
+func (w *Writer) Bulk(s string) {
+	w.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n")
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// Null writes the null bulk string, the reply for a missing key
+func (w *Writer) Null() {
+	w.w.WriteString("$-1\r\n")
+}
+
+// Array writes the header of an array of n replies, which must follow
+func (w *Writer) Array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// Flush sends the buffered replies
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// oneLine replaces line breaks, which would end a simple string or error early
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,155 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"array", "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", []string{"SET", "key", "value"}},
+		{"binary safe bulk", "*2\r\n$3\r\nGET\r\n$6\r\na\r\nb\x00c\r\n", []string{"GET", "a\r\nb\x00c"}},
+		{"empty bulk", "*2\r\n$3\r\nGET\r\n$0\r\n\r\n", []string{"GET", ""}},
+		{"empty array", "*0\r\n", []string{}},
+		{"null array", "*-1\r\n", []string{}},
+		{"inline", "PING\r\n", []string{"PING"}},
+		{"inline with a bare newline and extra spaces", "SET  key \t value\n", []string{"SET", "key", "value"}},
+		{"empty inline line", "\r\n", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input), 0)
+			got, err := r.ReadCommand()
+			if err != nil {
+				t.Fatalf("ReadCommand(%q): %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadCommand(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if _, err := r.ReadCommand(); err != io.EOF {
+				t.Errorf("ReadCommand after %q = %v, want io.EOF", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestReadCommandPipelined(t *testing.T) {
+	input := "*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\nEXISTS k\r\n"
+	r := NewReader(strings.NewReader(input), 0)
+
+	for i, want := range [][]string{{"PING"}, {"GET", "k"}, {"EXISTS", "k"}} {
+		got, err := r.ReadCommand()
+		if err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("command %d = %q, want %q", i, got, want)
+		}
+		if buffered := r.Buffered(); buffered != (i < 2) {
+			t.Errorf("Buffered after command %d = %v", i, buffered)
+		}
+	}
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		max   int
+		want  error
+	}{
+		{"nothing", "", 0, io.EOF},
+		{"bad array length", "*x\r\n", 0, ErrProtocol},
+		{"array too long", "*99999999\r\n", 0, ErrTooLarge},
+		{"integer in an array", "*1\r\n:1\r\n", 0, ErrProtocol},
+		{"bad bulk length", "*1\r\n$x\r\n", 0, ErrProtocol},
+		{"null bulk", "*1\r\n$-1\r\n", 0, ErrProtocol},
+		{"bulk longer than its length", "*1\r\n$3\r\nabcd\r\n", 0, ErrProtocol},
+		{"bulk cut short", "*1\r\n$10\r\nabc", 0, io.ErrUnexpectedEOF},
+		{"array cut short", "*2\r\n$3\r\nGET\r\n", 0, io.EOF},
+		{"bulk over the limit", "*1\r\n$11\r\nhello world\r\n", 10, ErrTooLarge},
+		{"inline line over the limit", "SET key a-long-value\r\n", 10, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input), tt.max)
+			if _, err := r.ReadCommand(); !errors.Is(err, tt.want) {
+				t.Errorf("ReadCommand(%q) = %v, want %v", tt.input, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommandAtTheLimit(t *testing.T) {
+	r := NewReader(strings.NewReader("*1\r\n$10\r\n0123456789\r\n"), 10)
+	got, err := r.ReadCommand()
+	if err != nil || !reflect.DeepEqual(got, []string{"0123456789"}) {
+		t.Errorf("ReadCommand at the limit = %q, %v", got, err)
+	}
+}
+
+func TestReadCommandMaxArgs(t *testing.T) {
+	input := "*3\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n"
+
+	r := NewReader(strings.NewReader(input), 0)
+	r.SetMaxArgs(2)
+	if _, err := r.ReadCommand(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("ReadCommand over the argument limit = %v, want ErrTooLarge", err)
+	}
+
+	r = NewReader(strings.NewReader(input), 0)
+	r.SetMaxArgs(3)
+	got, err := r.ReadCommand()
+	if err != nil || !reflect.DeepEqual(got, []string{"MGET", "a", "b"}) {
+		t.Errorf("ReadCommand at the argument limit = %q, %v", got, err)
+	}
+}
+
+func TestReadCommandLongLine(t *testing.T) {
+	// Longer than the bufio buffer, which ReadSlice fills more than once
+	value := strings.Repeat("v", 10000)
+	r := NewReader(strings.NewReader("SET key "+value+"\r\n"), 0)
+	got, err := r.ReadCommand()
+	if err != nil || !reflect.DeepEqual(got, []string{"SET", "key", value}) {
+		t.Errorf("ReadCommand of a long inline line = %d args, %v", len(got), err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SimpleString("OK")
+	w.Error("ERR bad\r\nthing")
+	w.Integer(-42)
+	w.Bulk("a\r\nb")
+	w.Bulk("")
+	w.Null()
+	w.Array(2)
+	w.Bulk("x")
+	w.Integer(1)
+
+	if buf.Len() != 0 {
+		t.Error("replies were sent before Flush")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "+OK\r\n" +
+		"-ERR bad  thing\r\n" +
+		":-42\r\n" +
+		"$4\r\na\r\nb\r\n" +
+		"$0\r\n\r\n" +
+		"$-1\r\n" +
+		"*2\r\n$1\r\nx\r\n:1\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("replies = %q, want %q", got, want)
+	}
+}
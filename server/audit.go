@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/audit"
+)
+
+// defaultAuditCount is how many entries AUDIT returns when not given a count
+const defaultAuditCount = 100
+
+// auditFunc records a command sent by user, nil before the client logs in,
+// along with the response it got
+type auditFunc func(user *acl.User, cmd Command, resp Response)
+
+// auditTo returns how to record the commands of the client at addr in log,
+// or nil if log is nil. Only writes are recorded, whether they were applied,
+// refused or failed. index, if not nil, returns the raft index a write
+// applied on this node reached.
+//
+// A raft follower that forwards a write records it with the client's
+// identity. The leader records the copy it receives too, marked forwarded,
+// as any client could mark its writes so.
+func auditTo(log *audit.Log, addr string, index func() uint64) auditFunc {
+	if log == nil {
+		return nil
+	}
+
+	return func(user *acl.User, cmd Command, resp Response) {
+		op := strings.ToUpper(cmd.Op)
+		if !writeOps[op] {
+			return
+		}
+
+		e := audit.Entry{
+			Time:      time.Now(),
+			Addr:      addr,
+			Op:        op,
+			Key:       cmd.Key,
+			Keys:      commandKeys(cmd),
+			ValueSize: valueSize(cmd),
+			Status:    resp.Status,
+			Code:      withCode(resp).Code,
+			Forwarded: cmd.Forwarded,
+		}
+		if user != nil {
+			e.User = user.Name
+		}
+		if index != nil && resp.Status == "success" {
+			e.Index = index()
+		}
+		if err := log.Record(e); err != nil {
+			fmt.Printf("Error writing audit log: %v\n", err)
+		}
+	}
+}
+
+// record calls a unless it is nil
+func (a auditFunc) record(user *acl.User, cmd Command, resp Response) {
+	if a != nil {
+		a(user, cmd, resp)
+	}
+}
+
+// wrap returns run recording each command it runs as user
+func (a auditFunc) wrap(user **acl.User, run func(Command) Response) func(Command) Response {
+	if a == nil {
+		return run
+	}
+	return func(cmd Command) Response {
+		resp := run(cmd)
+		a(*user, cmd, resp)
+		return resp
+	}
+}
+
+// wrapExec returns exec recording each command of the transactions it
+// applies as user, with the transaction's outcome
+func (a auditFunc) wrapExec(user **acl.User, exec func([]Command) Response) func([]Command) Response {
+	if a == nil {
+		return exec
+	}
+	return func(cmds []Command) Response {
+		resp := exec(cmds)
+		for _, cmd := range cmds {
+			a(*user, cmd, resp)
+		}
+		return resp
+	}
+}
+
+// commandKeys lists the keys a multi-key command names besides Key, sorted
+func commandKeys(cmd Command) []string {
+	keys := append([]string(nil), cmd.Keys...)
+	for key := range cmd.Pairs {
+		keys = append(keys, key)
+	}
+	for _, e := range cmd.Entries {
+		keys = append(keys, e.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueSize sums the sizes of the values cmd writes
+func valueSize(cmd Command) int {
+	n := len(cmd.Value)
+	for _, value := range cmd.Pairs {
+		n += len(value)
+	}
+	for _, e := range cmd.Entries {
+		n += len(e.Value)
+	}
+	for _, elem := range cmd.Elements {
+		n += len(elem)
+	}
+	return n
+}
+
+// auditResponse returns the latest count entries of log, oldest first, as
+// JSON in the response value
+func auditResponse(log *audit.Log, count int) Response {
+	if log == nil {
+		return Response{Status: "error", Message: "The audit log is not enabled on this server"}
+	}
+	if count <= 0 {
+		count = defaultAuditCount
+	}
+
+	entries, err := log.Last(count)
+	if err != nil {
+		return errorResponse(err)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return Response{Status: "success", Value: string(data), Int: int64(len(entries))}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/audit"
+	"github.com/pixperk/yakvs/client"
+)
+
+// testUsers may respectively do anything, only read, and only write keys
+// under app:
+const testUsers = `{"users": [
+	{"name": "admin", "password": "admin-pw", "commands": ["*"]},
+	{"name": "reader", "password": "reader-pw", "commands": ["GET"]},
+	{"name": "app", "password": "app-pw", "commands": ["SET", "MSET"], "key_prefix": "app:"}
+]}`
+
+// secretValue is written by the tests and must never reach the audit log
+const secretValue = "hunter2-do-not-log"
+
+func parseTestUsers(t *testing.T) *acl.Users {
+	t.Helper()
+
+	users, err := acl.Parse([]byte(testUsers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func openTestAuditLog(t *testing.T) (*audit.Log, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := audit.Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, path
+}
+
+// login opens a connection to addr logged in as user
+func login(t *testing.T, addr, user, password string) *testConn {
+	t.Helper()
+
+	conn := dialTest(t, addr)
+	if resp := conn.do(t, Command{Op: "AUTH", Key: user, Value: password}); resp.Status != "success" {
+		t.Fatalf("AUTH as %s: %s %s", user, resp.Status, resp.Message)
+	}
+	return conn
+}
+
+// sendAuditedCommands sends the server at addr, which has testUsers, writes
+// that are applied, refused and unauthenticated, and reads that are not
+// audited
+func sendAuditedCommands(t *testing.T, addr string) {
+	t.Helper()
+
+	if resp := dialTest(t, addr).do(t, Command{Op: "SET", Key: "anon", Value: secretValue}); resp.Code != CodeUnauthenticated {
+		t.Fatalf("SET before AUTH = %s %s %s", resp.Status, resp.Code, resp.Message)
+	}
+
+	reader := login(t, addr, "reader", "reader-pw")
+	if resp := reader.do(t, Command{Op: "SET", Key: "denied", Value: secretValue}); resp.Code != CodePermissionDenied {
+		t.Fatalf("SET as reader = %s %s %s", resp.Status, resp.Code, resp.Message)
+	}
+
+	app := login(t, addr, "app", "app-pw")
+	if resp := app.do(t, Command{Op: "SET", Key: "app:key", Value: secretValue}); resp.Status != "success" {
+		t.Fatalf("SET as app: %s %s", resp.Status, resp.Message)
+	}
+	if resp := app.do(t, Command{Op: "MSET", Pairs: map[string]string{"app:b": "12", "other": "345"}}); resp.Code != CodePermissionDenied {
+		t.Fatalf("MSET outside app's prefix = %s %s %s", resp.Status, resp.Code, resp.Message)
+	}
+	if resp := reader.do(t, Command{Op: "GET", Key: "app:key"}); resp.Status != "success" {
+		t.Fatalf("GET as reader: %s %s", resp.Status, resp.Message)
+	}
+}
+
+// checkAuditEntries checks the entries sendAuditedCommands left, oldest
+// first, leaving out reads
+func checkAuditEntries(t *testing.T, entries []client.AuditEntry) {
+	t.Helper()
+
+	want := []client.AuditEntry{
+		{Op: "SET", Key: "anon", ValueSize: len(secretValue), Status: "error", Code: CodeUnauthenticated},
+		{User: "reader", Op: "SET", Key: "denied", ValueSize: len(secretValue), Status: "error", Code: CodePermissionDenied},
+		{User: "app", Op: "SET", Key: "app:key", ValueSize: len(secretValue), Status: "success", Code: CodeOK},
+		{User: "app", Op: "MSET", Keys: []string{"app:b", "other"}, ValueSize: 5, Status: "error", Code: CodePermissionDenied},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d audit entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		w := want[i]
+		if e.User != w.User || e.Op != w.Op || e.Key != w.Key || strings.Join(e.Keys, ",") != strings.Join(w.Keys, ",") ||
+			e.ValueSize != w.ValueSize || e.Status != w.Status || e.Code != w.Code {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+		if e.Addr == "" || e.Time.IsZero() {
+			t.Errorf("entry %d has no address or time: %+v", i, e)
+		}
+	}
+}
+
+// checkNoValues checks no value written reached the audit log at path
+func checkNoValues(t *testing.T, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secretValue) {
+		t.Error("a value was written to the audit log")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	log, path := openTestAuditLog(t)
+	s := startTestServer(t, func(s *Server) {
+		s.SetUsers(parseTestUsers(t))
+		s.SetAuditLog(log)
+	})
+	addr := s.Listener().Addr().String()
+	sendAuditedCommands(t, addr)
+
+	c, err := client.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Auth("admin", "admin-pw"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.AuditLog(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAuditEntries(t, entries)
+	checkNoValues(t, path)
+
+	if entries, err = c.AuditLog(1); err != nil || len(entries) != 1 || entries[0].Op != "MSET" {
+		t.Errorf("AuditLog(1) = %+v, %v, want the MSET", entries, err)
+	}
+
+	// Users that may not run AUDIT cannot read the log
+	reader, err := client.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if err := reader.Auth("reader", "reader-pw"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.AuditLog(0); err == nil {
+		t.Error("a reader read the audit log")
+	}
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	s := startTestServer(t, nil)
+	c, err := client.NewClient(s.Listener().Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.AuditLog(0); err == nil {
+		t.Error("AUDIT succeeded without an audit log")
+	}
+}
+
+func TestRaftAuditLog(t *testing.T) {
+	logs := make([]*audit.Log, 2)
+	paths := make([]string, 2)
+	for i := range logs {
+		logs[i], paths[i] = openTestAuditLog(t)
+	}
+	users := parseTestUsers(t)
+	i := 0
+	nodes := startRaftCluster(t, 2, func(s *RaftServer) {
+		s.SetUsers(users)
+		s.SetAuditLog(logs[i])
+		s.SetForwarding(5 * time.Second)
+		s.SetForwardAuth("admin", "admin-pw")
+		i++
+	})
+	leader, follower := nodes[0], nodes[1]
+	if !leader.store.IsLeader() {
+		t.Skip("the bootstrapping node lost the lead")
+	}
+
+	sendAuditedCommands(t, leader.addr)
+	entries, err := logs[0].Last(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAuditEntries(t, toClientEntries(entries))
+	checkNoValues(t, paths[0])
+
+	// Only the applied write reached a raft index
+	for _, e := range entries {
+		if (e.Status == "success") != (e.Index > 0) {
+			t.Errorf("%s of %q with status %s has index %d", e.Op, e.Key, e.Status, e.Index)
+		}
+	}
+	if applied := leader.store.AppliedIndex(); entries[2].Index > applied {
+		t.Errorf("SET recorded at index %d, past the applied index %d", entries[2].Index, applied)
+	}
+
+	// A write sent to the follower is recorded there as sent by its client,
+	// and on the leader as forwarded by the forwarding user
+	app := login(t, follower.addr, "app", "app-pw")
+	if resp := app.do(t, Command{Op: "SET", Key: "app:forwarded", Value: secretValue}); resp.Status != "success" {
+		t.Fatalf("SET on the follower: %s %s", resp.Status, resp.Message)
+	}
+	onFollower, err := logs[1].Last(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onFollower) != 1 || onFollower[0].User != "app" || onFollower[0].Key != "app:forwarded" || onFollower[0].Forwarded {
+		t.Errorf("follower recorded %+v", onFollower)
+	}
+	onLeader, err := logs[0].Last(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onLeader) != 1 || onLeader[0].User != "admin" || onLeader[0].Key != "app:forwarded" || !onLeader[0].Forwarded || onLeader[0].Index == 0 {
+		t.Errorf("leader recorded %+v", onLeader)
+	}
+	checkNoValues(t, paths[1])
+}
+
+// toClientEntries converts entries read from a log to those AUDIT returns
+func toClientEntries(entries []audit.Entry) []client.AuditEntry {
+	var out []client.AuditEntry
+	for _, e := range entries {
+		out = append(out, client.AuditEntry{
+			Time: e.Time, User: e.User, Addr: e.Addr, Op: e.Op, Key: e.Key, Keys: e.Keys,
+			ValueSize: e.ValueSize, Status: e.Status, Code: e.Code, Index: e.Index, Forwarded: e.Forwarded,
+		})
+	}
+	return out
+}
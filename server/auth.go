@@ -0,0 +1,122 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/pixperk/yakvs/acl"
+)
+
+// authFreeOps may be run by any authenticated user, as they only manage the
+// connection or finish a command that was already allowed
+var authFreeOps = map[string]bool{
+	"AUTH":        true,
+	"PING":        true,
+	"VERSION":     true,
+	"MULTI":       true,
+	"EXEC":        true,
+	"DISCARD":     true,
+	"UNSUBSCRIBE": true,
+	"CHUNK":       true,
+}
+
+// keylessOps name no key, so a user's key prefix does not restrict them.
+// Every other op is checked against the prefix, and one that touches every
+// key, such as FLUSHALL, is refused to users with a prefix.
+var keylessOps = map[string]bool{
+	"PING":          true,
+	"VERSION":       true,
+	"INFO":          true,
+	"AUDIT":         true,
+	"STATS":         true,
+	"STATUS":        true,
+	"LATENCY":       true,
+	"LATENCY RESET": true,
+	"CLIENT LIST":   true,
+	"MEMORY STATS":  true,
+	"COMPACT":       true,
+	"BGSAVE":        true,
+	"JOBSTATUS":     true,
+	"TRANSFER":      true,
+	"MULTI":         true,
+	"EXEC":          true,
+	"DISCARD":       true,
+	"UNSUBSCRIBE":   true,
+	"CHUNK":         true,
+}
+
+// authorize checks cmd against the connection's user, logging the
+// connection in as another user on AUTH. It returns the response to send
+// instead of running cmd, or nil if cmd may run. Without users every
+// command may run.
+func authorize(users *acl.Users, user **acl.User, cmd Command) *Response {
+	op := strings.ToUpper(cmd.Op)
+	if users == nil {
+		if op == "AUTH" {
+			return &Response{Status: "error", Message: "AUTH is not enabled on this server"}
+		}
+		return nil
+	}
+
+	if op == "AUTH" {
+		u, ok := users.Authenticate(cmd.Key, cmd.Value)
+		if !ok {
+			return &Response{Status: "error", Code: CodeUnauthenticated, Message: "Invalid username or password"}
+		}
+		*user = u
+		return &Response{Status: "success", Message: "OK"}
+	}
+
+	if *user == nil {
+		return &Response{Status: "error", Code: CodeUnauthenticated, Message: "Authentication required, send AUTH first"}
+	}
+	if !authFreeOps[op] && !(*user).Allows(op) {
+		return &Response{Status: "error", Code: CodePermissionDenied, Message: "Permission denied: " + (*user).Name + " may not run " + op}
+	}
+	if !keysAllowed(*user, op, cmd) {
+		return &Response{Status: "error", Code: CodePermissionDenied, Message: "Permission denied: " + (*user).Name + " may only use keys starting with " + (*user).KeyPrefix}
+	}
+	return nil
+}
+
+// keysAllowed reports whether every key, pattern or prefix cmd names lies
+// within the user's key prefix
+func keysAllowed(u *acl.User, op string, cmd Command) bool {
+	if u.KeyPrefix == "" || keylessOps[op] {
+		return true
+	}
+	if op == "KEYS RANGE" {
+		return u.AllowsRange(cmd.Key, cmd.End)
+	}
+
+	keys := append([]string(nil), cmd.Keys...)
+	for key := range cmd.Pairs {
+		keys = append(keys, key)
+	}
+	// Multi-key commands may leave Key empty, while FLUSHALL and the like
+	// name no key at all and must be refused
+	if cmd.Key != "" || len(keys) == 0 {
+		keys = append(keys, cmd.Key)
+	}
+
+	for _, key := range keys {
+		if !u.AllowsKey(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// authorized wraps run so that it first checks each command against the
+// user, which AUTH commands run through it may change. Commands run or
+// refused are recorded with audit, which may be nil.
+func authorized(users *acl.Users, user **acl.User, run func(Command) Response, audit auditFunc) func(Command) Response {
+	return func(cmd Command) Response {
+		resp := authorize(users, user, cmd)
+		if resp == nil {
+			ran := run(cmd)
+			resp = &ran
+		}
+		audit.record(*user, cmd, *resp)
+		return *resp
+	}
+}
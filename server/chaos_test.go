@@ -0,0 +1,214 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/chaos"
+	"github.com/pixperk/yakvs/client"
+)
+
+// injectFailures turns on failure injection with cfg for the rest of the
+// test. The policies are shared by every node of an in-process cluster.
+func injectFailures(t *testing.T, cfg chaos.Config) {
+	t.Helper()
+
+	chaos.Enable()
+	chaos.Set(cfg)
+	t.Cleanup(func() { chaos.Set(chaos.Config{}) })
+}
+
+func TestChaosSpuriousNotLeader(t *testing.T) {
+	nodes := startRaftCluster(t, 1, nil)
+	c, err := client.NewRaftClient(nodes[0].addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A leader that keeps claiming it is not one fails the write once the
+	// client runs out of retries
+	injectFailures(t, chaos.Config{NotLeaderRate: 1})
+	if err := c.Set("key", "value", 0); !errors.Is(err, client.ErrNotLeader) {
+		t.Fatalf("Set while every write is refused = %v, want ErrNotLeader", err)
+	}
+
+	// One that recovers while the client waits does not
+	done := make(chan error, 1)
+	go func() { done <- c.Set("key", "value", 0) }()
+	time.Sleep(300 * time.Millisecond)
+	chaos.Set(chaos.Config{})
+	if err := <-done; err != nil {
+		t.Fatalf("Set across spurious not-leader responses: %v", err)
+	}
+	if got, _, err := c.Get("key"); err != nil || got != "value" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+}
+
+func TestChaosDroppedConnections(t *testing.T) {
+	nodes := startRaftCluster(t, 1, nil)
+
+	injectFailures(t, chaos.Config{DropConnectionRate: 1})
+	c, err := client.NewRaftClient(nodes[0].addr)
+	if err == nil {
+		err = c.Set("key", "value", 0)
+		c.Close()
+	}
+	if err == nil {
+		t.Fatal("Set succeeded on a dropped connection")
+	}
+
+	chaos.Set(chaos.Config{})
+	c, err = client.NewRaftClient(nodes[0].addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Errorf("Set once connections are no longer dropped: %v", err)
+	}
+}
+
+// TestChaosFailedMSetWritesNothing fails the log write of an MSET on the
+// standalone server and checks none of its keys were set. A raft MSET is a
+// single raft log entry, which is applied in full or not at all.
+func TestChaosFailedMSetWritesNothing(t *testing.T) {
+	s := startTestServer(t, nil)
+	conn := dialTest(t, s.Listener().Addr().String())
+
+	injectFailures(t, chaos.Config{WALFailureRate: 1})
+	resp := conn.do(t, Command{Op: "MSET", Pairs: map[string]string{"m1": "1", "m2": "2", "m3": "3"}})
+	if resp.Status != "error" {
+		t.Fatalf("MSET with failing log writes = %s %s", resp.Status, resp.Message)
+	}
+
+	chaos.Set(chaos.Config{})
+	if resp := conn.do(t, Command{Op: "MGET", Keys: []string{"m1", "m2", "m3"}}); len(resp.Values) != 0 {
+		t.Errorf("failed MSET left %v", resp.Values)
+	}
+}
+
+// writeWithRetries sets key through c, reconnecting to the next of addrs on
+// any error the way an application would, until deadline. It returns the
+// client to use next.
+func writeWithRetries(c *client.RaftClient, addrs []string, key string, deadline time.Time) (*client.RaftClient, error) {
+	next := 0
+	for {
+		var err error
+		if c == nil {
+			c, err = client.NewRaftClient(addrs[next%len(addrs)])
+			next++
+		}
+		if err == nil {
+			if err = c.Set(key, key, 0); err == nil {
+				return c, nil
+			}
+			c.Close()
+			c = nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to write %s: %w", key, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestChaosLeaderFailover kills the leader of a three node cluster under a
+// stream of writes slowed and spuriously refused by injected failures, and
+// checks the writes carry on through the new leader without losing any that
+// were acknowledged
+func TestChaosLeaderFailover(t *testing.T) {
+	nodes := startRaftCluster(t, 3, nil)
+	injectFailures(t, chaos.Config{
+		CommandDelayMin: time.Millisecond,
+		CommandDelayMax: 5 * time.Millisecond,
+		NotLeaderRate:   0.1,
+	})
+	var addrs []string
+	for _, node := range nodes {
+		addrs = append(addrs, node.addr)
+	}
+
+	var (
+		stop    atomic.Bool
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		written []string
+		after   int // writes acknowledged once the leader was killed
+		killed  atomic.Bool
+		failure error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var c *client.RaftClient
+		defer func() {
+			if c != nil {
+				c.Close()
+			}
+		}()
+		for i := 0; !stop.Load(); i++ {
+			key := fmt.Sprintf("key%d", i)
+			var err error
+			if c, err = writeWithRetries(c, addrs, key, time.Now().Add(10*time.Second)); err != nil {
+				mu.Lock()
+				failure = err
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			written = append(written, key)
+			if killed.Load() {
+				after++
+			}
+			mu.Unlock()
+		}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	var old *raftNode
+	for _, node := range nodes {
+		if node.store.IsLeader() {
+			old = node
+		}
+	}
+	if old == nil {
+		t.Fatal("no leader to kill")
+	}
+	old.server.Stop()
+	old.store.Shutdown()
+	killed.Store(true)
+
+	var leader *raftNode
+	waitUntil(t, "a new leader", func() bool {
+		for _, node := range nodes {
+			if node != old && node.store.IsLeader() {
+				leader = node
+				return true
+			}
+		}
+		return false
+	})
+	waitUntil(t, "writes through the new leader", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return after >= 20 || failure != nil
+	})
+	stop.Store(true)
+	wg.Wait()
+
+	if failure != nil {
+		t.Fatal(failure)
+	}
+	for _, key := range written {
+		if v, ok := leader.store.Get(key); !ok || v.Data != key {
+			t.Errorf("acknowledged write of %s lost in the failover: %q", key, v.Data)
+		}
+	}
+	t.Logf("%d writes, %d after the leader was killed", len(written), after)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// chunkSize is the number of value bytes carried by one CHUNK frame. Once
+	// base64 encoded it stays well under any sensible request size limit.
+	chunkSize = 32 * 1024
+
+	// chunkTimeout is how long a chunked upload may go without a frame before
+	// the connection is closed and the partial value discarded
+	chunkTimeout = 30 * time.Second
+
+	// maxChunkedSize caps the size announced by a chunked SET
+	maxChunkedSize = 1 << 30
+)
+
+// upload is a chunked SET or RESTORE being assembled on a connection
+type upload struct {
+	cmd   Command
+	data  []byte
+	timer *time.Timer
+}
+
+func (u *upload) discard() {
+	u.timer.Stop()
+}
+
+// handleChunked processes chunked SET and RESTORE announcements and CHUNK
+// frames, sending the response itself. It reports whether cmd was consumed.
+// commit runs a command through the server's normal path: it is called with
+// the chunked command itself to validate it before any data is accepted, and
+// with the assembled value once the final frame arrives.
+func handleChunked(cmd Command, up **upload, out *connWriter, commit func(Command) Response) bool {
+	op := strings.ToUpper(cmd.Op)
+	switch {
+	case (op == "SET" || op == "RESTORE") && cmd.Chunked:
+		if *up != nil {
+			(*up).discard()
+			*up = nil
+		}
+
+		if cmd.Size < 0 || cmd.Size > maxChunkedSize {
+			sendResponse(out, Response{
+				Status:  "error",
+				Message: fmt.Sprintf("Chunked value size must be between 0 and %d bytes", maxChunkedSize),
+			})
+			return true
+		}
+
+		resp := commit(cmd)
+		if resp.Status == "success" {
+			*up = &upload{
+				cmd:   cmd,
+				timer: time.AfterFunc(chunkTimeout, func() { out.conn.Close() }),
+			}
+		}
+		sendResponse(out, resp)
+		return true
+
+	case op == "CHUNK":
+		u := *up
+		if u == nil {
+			sendResponse(out, Response{Status: "error", Message: "No chunked upload in progress"})
+			return true
+		}
+
+		data, err := base64.StdEncoding.DecodeString(cmd.Value)
+		if err != nil {
+			u.discard()
+			*up = nil
+			sendResponse(out, Response{Status: "error", Message: "Invalid chunk encoding, upload discarded"})
+			return true
+		}
+
+		if int64(len(u.data)+len(data)) > u.cmd.Size {
+			u.discard()
+			*up = nil
+			sendResponse(out, Response{Status: "error", Message: "Chunks exceed the announced size, upload discarded"})
+			return true
+		}
+		u.data = append(u.data, data...)
+		u.timer.Reset(chunkTimeout)
+
+		if !cmd.Final {
+			sendResponse(out, Response{Status: "success"})
+			return true
+		}
+
+		u.discard()
+		*up = nil
+		if int64(len(u.data)) != u.cmd.Size {
+			sendResponse(out, Response{
+				Status:  "error",
+				Message: fmt.Sprintf("Received %d of %d bytes, upload discarded", len(u.data), u.cmd.Size),
+			})
+			return true
+		}
+
+		sendResponse(out, commit(Command{
+			Op:        u.cmd.Op,
+			Key:       u.cmd.Key,
+			Value:     string(u.data),
+			ExpiresIn: u.cmd.ExpiresIn,
+		}))
+		return true
+	}
+
+	// Any other command abandons an unfinished upload
+	if *up != nil {
+		(*up).discard()
+		*up = nil
+	}
+	return false
+}
+
+// sendChunked writes a successful GET or DUMP response as a header followed
+// by CHUNK frames when the value is too large for a single line. A value small
+// enough to send inline is encoded as the command asked.
+func sendChunked(out *connWriter, resp Response, encoding string) {
+	if resp.Status != "success" || len(resp.Value) <= chunkSize {
+		sendResponse(out, encodeValues(encoding, resp))
+		return
+	}
+
+	value := resp.Value
+	sendResponse(out, Response{
+		Status:  "success",
+		TTL:     resp.TTL,
+		Chunked: true,
+		Size:    int64(len(value)),
+	})
+
+	for len(value) > 0 {
+		n := min(chunkSize, len(value))
+		sendResponse(out, Response{
+			Status: "success",
+			Value:  base64.StdEncoding.EncodeToString([]byte(value[:n])),
+			Final:  n == len(value),
+		})
+		value = value[n:]
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pixperk/yakvs/wire"
+)
+
+// DefaultWriteTimeout is how long a write to a client may stay blocked before
+// the client is disconnected as a slow consumer
+const DefaultWriteTimeout = 10 * time.Second
+
+// DefaultMaxRequestSize is the longest request line a server accepts, which
+// leaves room for a value at the default size limit once base64 encoded
+const DefaultMaxRequestSize = 4 << 20
+
+// drainGrace is how long a draining connection may take to send its next
+// request. Closing it with a request unread would reset it, so requests
+// already on their way when a restart begins are served instead.
+const drainGrace = 200 * time.Millisecond
+
+// errRequestTooLarge is returned for a request over the size limit
+var errRequestTooLarge = wire.ErrTooLarge
+
+// requestReader reads requests of bounded length, framed as newline-terminated
+// JSON until HELLO switches the connection to another codec
+type requestReader struct {
+	r     *bufio.Reader
+	max   int // zero disables the limit
+	codec wire.Codec
+}
+
+// next returns the payload of the next request. A request longer than max is
+// skipped and reported as errRequestTooLarge, so the connection stays usable.
+// A last JSON line without a newline is returned before io.EOF.
+func (rr *requestReader) next() ([]byte, error) {
+	return rr.codec.ReadFrame(rr.r, rr.max)
+}
+
+// connWriter serializes writes from the command loop and a subscription
+// stream onto one connection. A write that cannot complete within timeout
+// closes the connection, so a client that stops reading cannot pin the
+// handler and its pending output forever.
+//
+// Replies are written as the command loop waits for them, but pushed
+// messages such as subscription events are queued without waiting. Once
+// more than limit bytes are queued for a client the connection is closed,
+// rather than the queue growing while the write timeout runs.
+type connWriter struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	timeout time.Duration  // zero disables the deadline
+	limit   int            // zero disables the output buffer limit
+	slow    *atomic.Uint64 // counts connections dropped for being slow
+	dropped bool
+	err     error // why the connection was dropped
+
+	buf      []byte     // output queued and not yet handed to conn
+	queued   int64      // bytes ever queued
+	written  int64      // bytes ever written
+	flushing bool       // a goroutine is writing buf to conn
+	flushed  *sync.Cond // broadcast as output is written or dropped
+
+	// codec encodes the messages given to send, JSON lines if nil. HELLO
+	// only switches it while nothing else is sending.
+	codec wire.Codec
+}
+
+// DefaultOutputBufferLimit is the most output a server queues for a client
+// without waiting for it to be read, as Redis allows subscribers by default
+const DefaultOutputBufferLimit = 32 << 20
+
+// errOutputBufferFull is returned for output pushed to a client that has
+// more queued than its output buffer limit, which disconnects it
+var errOutputBufferFull = errors.New("output buffer limit exceeded")
+
+// marshal encodes v as one message in the connection's codec
+func (w *connWriter) marshal(v any) ([]byte, error) {
+	c := w.codec
+	if c == nil {
+		c = wire.JSON
+	}
+	return c.Marshal(v)
+}
+
+// send encodes v as one message in the connection's codec and writes it
+func (w *connWriter) send(v any) error {
+	data, err := w.marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Write writes p after any output queued before it, returning once it was
+// written
+func (w *connWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.dropped {
+		return 0, w.err
+	}
+	w.queued += int64(len(p))
+	end := w.queued
+	if !w.flushing {
+		// Nothing is queued ahead of p, so it is written from the caller's
+		// buffer, followed by anything pushed meanwhile
+		w.flushing = true
+		w.writeLocked(p)
+		w.flushLocked()
+	} else {
+		w.buf = append(w.buf, p...)
+		for w.written < end && !w.dropped {
+			w.cond().Wait()
+		}
+	}
+	if w.written < end {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// push queues p to be written after any output queued before it, without
+// waiting for it to be written. A client with more than limit bytes queued
+// is disconnected as a slow consumer.
+func (w *connWriter) push(p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.dropped {
+		return w.err
+	}
+	if w.limit > 0 && w.queued-w.written+int64(len(p)) > int64(w.limit) {
+		w.drop(errOutputBufferFull, true)
+		return w.err
+	}
+	w.queued += int64(len(p))
+	w.buf = append(w.buf, p...)
+	if !w.flushing {
+		w.flushing = true
+		go func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.flushLocked()
+		}()
+	}
+	return nil
+}
+
+// flushLocked writes the queued output until none is left. Callers must hold
+// mu and have set flushing.
+func (w *connWriter) flushLocked() {
+	for len(w.buf) > 0 && !w.dropped {
+		data := w.buf
+		w.buf = nil
+		w.writeLocked(data)
+		if w.buf == nil && cap(data) <= 64<<10 {
+			w.buf = data[:0]
+		}
+	}
+	if w.dropped {
+		w.buf = nil
+	}
+	w.flushing = false
+}
+
+// writeLocked writes data to the connection, releasing mu while it blocks.
+// Callers must hold mu and have set flushing.
+func (w *connWriter) writeLocked(data []byte) {
+	w.mu.Unlock()
+	if w.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	n, err := w.conn.Write(data)
+	w.mu.Lock()
+
+	w.written += int64(n)
+	w.cond().Broadcast()
+	if err != nil {
+		w.drop(err, errors.Is(err, os.ErrDeadlineExceeded))
+	}
+}
+
+// drop closes the connection after a failed write, counting it as a slow
+// consumer if slow. Callers must hold mu.
+func (w *connWriter) drop(err error, slow bool) {
+	if w.dropped {
+		return
+	}
+	// Closing also unblocks the command loop's read, which ends the handler
+	w.dropped, w.err = true, err
+	w.conn.Close()
+	if slow && w.slow != nil {
+		w.slow.Add(1)
+	}
+	w.cond().Broadcast()
+}
+
+// cond returns flushed, creating it on first use. Callers must hold mu.
+func (w *connWriter) cond() *sync.Cond {
+	if w.flushed == nil {
+		w.flushed = sync.NewCond(&w.mu)
+	}
+	return w.flushed
+}
+
+// clientInfo describes an open connection for CLIENT LIST. Name, Protocol
+// and Framing are set by HELLO and User by AUTH.
+type clientInfo struct {
+	ID          uint64    `json:"id"`
+	Addr        string    `json:"addr"`
+	Name        string    `json:"name,omitempty"`
+	Protocol    int       `json:"protocol"` // 1 until the client sends HELLO
+	Framing     string    `json:"framing,omitempty"`
+	User        string    `json:"user,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// connTracker keeps track of open client connections so a server can drain
+// them before exiting and list them for CLIENT LIST
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]*clientInfo
+	lastID   uint64
+	wg       sync.WaitGroup
+	draining atomic.Bool
+}
+
+func (t *connTracker) add(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]*clientInfo)
+	}
+	// Unix socket clients have no address of their own, so they are told
+	// apart by the socket they came through
+	addr := conn.RemoteAddr().String()
+	if addr == "" || addr == "@" {
+		addr = conn.LocalAddr().Network() + ":" + conn.LocalAddr().String()
+	}
+	t.lastID++
+	t.conns[conn] = &clientInfo{
+		ID:          t.lastID,
+		Addr:        addr,
+		Protocol:    1,
+		ConnectedAt: time.Now(),
+	}
+	t.wg.Add(1)
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, conn)
+	t.wg.Done()
+}
+
+// count returns the number of open connections
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
+
+// update changes what is recorded about conn
+func (t *connTracker) update(conn net.Conn, change func(*clientInfo)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.conns[conn]; ok {
+		change(c)
+	}
+}
+
+// clients lists the open connections in the order they were accepted
+func (t *connTracker) clients() []clientInfo {
+	t.mu.Lock()
+	list := make([]clientInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		list = append(list, *c)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// names counts the open connections by the name they gave in HELLO,
+// leaving out those that gave none
+func (t *connTracker) names() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var names map[string]int
+	for _, c := range t.conns {
+		if c.Name == "" {
+			continue
+		}
+		if names == nil {
+			names = make(map[string]int)
+		}
+		names[c.Name]++
+	}
+	return names
+}
+
+// setIdleTimeout gives conn timeout to send its next request, or no limit
+// if timeout is zero. Once draining, conn only gets drainGrace.
+func (t *connTracker) setIdleTimeout(conn net.Conn, timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	conn.SetReadDeadline(deadline)
+
+	// Checked after setting the deadline, so a drain that started in between
+	// is not undone
+	if t.draining.Load() {
+		conn.SetReadDeadline(time.Now().Add(drainGrace))
+	}
+}
+
+// drain lets every connection finish the command it is processing, and any
+// it sends within drainGrace of the last, and then closes it. Connections
+// still open after timeout are closed forcibly.
+func (t *connTracker) drain(timeout time.Duration) {
+	t.draining.Store(true)
+	t.mu.Lock()
+	for conn := range t.conns {
+		// Ends handlers waiting for the next command without
+		// interrupting one that is being processed
+		conn.SetReadDeadline(time.Now().Add(drainGrace))
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.mu.Lock()
+		for conn := range t.conns {
+			conn.Close()
+		}
+		t.mu.Unlock()
+		<-done
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// encodingBase64 marks a command whose values are base64 encoded, and asks
+// for the values in its response to be encoded the same way. JSON strings
+// cannot carry arbitrary bytes, so clients use it for binary values.
+const encodingBase64 = "base64"
+
+// decodeValues replaces the encoded values of cmd with their raw bytes
+func decodeValues(cmd *Command) error {
+	switch cmd.Encoding {
+	case "":
+		return nil
+	case encodingBase64:
+	default:
+		return fmt.Errorf("unknown value encoding %q", cmd.Encoding)
+	}
+
+	for _, field := range []*string{&cmd.Value, &cmd.Expected} {
+		data, err := base64.StdEncoding.DecodeString(*field)
+		if err != nil {
+			return fmt.Errorf("invalid base64 value: %w", err)
+		}
+		*field = string(data)
+	}
+
+	if cmd.Pairs != nil {
+		pairs := make(map[string]string, len(cmd.Pairs))
+		for key, value := range cmd.Pairs {
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("invalid base64 value for key %q: %w", key, err)
+			}
+			pairs[key] = string(data)
+		}
+		cmd.Pairs = pairs
+	}
+
+	for i, elem := range cmd.Elements {
+		data, err := base64.StdEncoding.DecodeString(elem)
+		if err != nil {
+			return fmt.Errorf("invalid base64 element: %w", err)
+		}
+		cmd.Elements[i] = string(data)
+	}
+
+	for i, e := range cmd.Entries {
+		data, err := base64.StdEncoding.DecodeString(e.Value)
+		if err != nil {
+			return fmt.Errorf("invalid base64 value for key %q: %w", e.Key, err)
+		}
+		cmd.Entries[i].Value = string(data)
+	}
+	return nil
+}
+
+// encodeValues encodes the values of resp as requested by a command's
+// Encoding. The frames of a chunked reply are always base64 and are left
+// alone.
+func encodeValues(encoding string, resp Response) Response {
+	if encoding != encodingBase64 || resp.Chunked {
+		return resp
+	}
+
+	resp.Encoding = encodingBase64
+	resp.Value = base64.StdEncoding.EncodeToString([]byte(resp.Value))
+	if resp.Values != nil {
+		values := make(map[string]string, len(resp.Values))
+		for key, value := range resp.Values {
+			values[key] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		resp.Values = values
+	}
+	if resp.Elements != nil {
+		elems := make([]string, len(resp.Elements))
+		for i, elem := range resp.Elements {
+			elems[i] = base64.StdEncoding.EncodeToString([]byte(elem))
+		}
+		resp.Elements = elems
+	}
+	return resp
+}
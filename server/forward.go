@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxIdleForwardConns is how many connections to the leader a follower
+// keeps open between forwarded writes
+const maxIdleForwardConns = 4
+
+// forwardOps are the writes a raft follower forwards to the leader. Jobs
+// such as BGSAVE are not, as they belong to the node that ran them.
+var forwardOps = map[string]bool{
+	"SET":       true,
+	"DELETE":    true,
+	"MSET":      true,
+	"SETNX":     true,
+	"CAS":       true,
+	"INCR":      true,
+	"DECR":      true,
+	"INCRBY":    true,
+	"APPEND":    true,
+	"TOUCH":     true,
+	"GETEX":     true,
+	"GETSET":    true,
+	"LPUSH":     true,
+	"RPUSH":     true,
+	"LPOP":      true,
+	"RPOP":      true,
+	"DELPREFIX": true,
+	"FLUSHALL":  true,
+	"EXPIRE":    true,
+	"PERSIST":   true,
+}
+
+// forwardConn is a connection to the leader's TCP server
+type forwardConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// forwarder relays the writes a follower cannot apply to the leader and
+// hands back the leader's response, keeping a few connections to the leader
+// open between writes
+type forwarder struct {
+	timeout  time.Duration // zero disables forwarding
+	tls      *tls.Config   // nil dials plain TCP
+	user     string        // logged in as on every new connection
+	password string
+
+	mu   sync.Mutex
+	addr string // the leader the idle connections lead to
+	idle []*forwardConn
+}
+
+// forward sends cmd to the leader at addr and returns its response. The
+// command is marked forwarded so the leader never forwards it again, and
+// its values are always base64 encoded so binary values survive the trip.
+func (f *forwarder) forward(addr string, cmd Command) (Response, error) {
+	cmd.Forwarded = true
+	encodeCommandValues(&cmd)
+	req, err := json.Marshal(cmd)
+	if err != nil {
+		return Response{}, err
+	}
+
+	c, err := f.get(addr)
+	if err != nil {
+		return Response{}, err
+	}
+
+	resp, err := c.roundTrip(req, f.timeout)
+	if err != nil {
+		c.conn.Close()
+		return Response{}, err
+	}
+	f.put(addr, c)
+
+	if err := decodeResponseValues(&resp); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// get returns an idle connection to addr, or dials a new one
+func (f *forwarder) get(addr string) (*forwardConn, error) {
+	f.mu.Lock()
+	if f.addr == addr && len(f.idle) > 0 {
+		c := f.idle[len(f.idle)-1]
+		f.idle = f.idle[:len(f.idle)-1]
+		f.mu.Unlock()
+		return c, nil
+	}
+	f.mu.Unlock()
+
+	dialer := &net.Dialer{Timeout: f.timeout}
+	var conn net.Conn
+	var err error
+	if f.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, f.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &forwardConn{conn: conn, reader: bufio.NewReader(conn)}
+	if f.user != "" {
+		req, err := json.Marshal(Command{Op: "AUTH", Key: f.user, Value: f.password})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		resp, err := c.roundTrip(req, f.timeout)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if resp.Status != "success" {
+			conn.Close()
+			return nil, fmt.Errorf("leader refused login: %s", resp.Message)
+		}
+	}
+	return c, nil
+}
+
+// put keeps c open for the next write to addr. Connections to a former
+// leader are closed.
+func (f *forwarder) put(addr string, c *forwardConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.addr != addr {
+		for _, old := range f.idle {
+			old.conn.Close()
+		}
+		f.addr, f.idle = addr, nil
+	}
+	if len(f.idle) >= maxIdleForwardConns {
+		c.conn.Close()
+		return
+	}
+	f.idle = append(f.idle, c)
+}
+
+// warm dials a connection to the leader at addr ahead of the first write
+// forwarded to it, closing the idle ones to the former leader
+func (f *forwarder) warm(addr string) {
+	c, err := f.get(addr)
+	if err != nil {
+		return
+	}
+	f.put(addr, c)
+}
+
+// reset closes every idle connection, for when no other node leads
+func (f *forwarder) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.idle {
+		c.conn.Close()
+	}
+	f.addr, f.idle = "", nil
+}
+
+// roundTrip sends one request line and reads the response, giving up after
+// timeout
+func (c *forwardConn) roundTrip(req []byte, timeout time.Duration) (Response, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write(append(req, '\n')); err != nil {
+		return Response{}, err
+	}
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return Response{}, fmt.Errorf("invalid response from leader: %w", err)
+	}
+	return resp, nil
+}
+
+// encodeCommandValues base64 encodes the values of a decoded command,
+// reversing decodeValues
+func encodeCommandValues(cmd *Command) {
+	cmd.Encoding = encodingBase64
+	cmd.Value = base64.StdEncoding.EncodeToString([]byte(cmd.Value))
+	cmd.Expected = base64.StdEncoding.EncodeToString([]byte(cmd.Expected))
+	if cmd.Pairs != nil {
+		pairs := make(map[string]string, len(cmd.Pairs))
+		for key, value := range cmd.Pairs {
+			pairs[key] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		cmd.Pairs = pairs
+	}
+	if cmd.Elements != nil {
+		elems := make([]string, len(cmd.Elements))
+		for i, elem := range cmd.Elements {
+			elems[i] = base64.StdEncoding.EncodeToString([]byte(elem))
+		}
+		cmd.Elements = elems
+	}
+	if cmd.Entries != nil {
+		entries := make([]Entry, len(cmd.Entries))
+		for i, e := range cmd.Entries {
+			e.Value = base64.StdEncoding.EncodeToString([]byte(e.Value))
+			entries[i] = e
+		}
+		cmd.Entries = entries
+	}
+}
+
+// decodeResponseValues replaces the base64 values of an encoded response
+// with their raw bytes, reversing encodeValues
+func decodeResponseValues(resp *Response) error {
+	if resp.Encoding != encodingBase64 {
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Value)
+	if err != nil {
+		return fmt.Errorf("invalid base64 value: %w", err)
+	}
+	resp.Value = string(data)
+
+	if resp.Values != nil {
+		values := make(map[string]string, len(resp.Values))
+		for key, value := range resp.Values {
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("invalid base64 value for key %q: %w", key, err)
+			}
+			values[key] = string(data)
+		}
+		resp.Values = values
+	}
+
+	for i, elem := range resp.Elements {
+		data, err := base64.StdEncoding.DecodeString(elem)
+		if err != nil {
+			return fmt.Errorf("invalid base64 element: %w", err)
+		}
+		resp.Elements[i] = string(data)
+	}
+
+	resp.Encoding = ""
+	return nil
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/handover"
+	"github.com/pixperk/yakvs/yakvspb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcCodes are the gRPC codes of failed responses by code
+var grpcCodes = map[string]codes.Code{
+	CodeNotFound:    codes.NotFound,
+	CodeNotLeader:   codes.FailedPrecondition,
+	CodeReadOnly:    codes.FailedPrecondition,
+	CodeBadRequest:  codes.InvalidArgument,
+	CodeTooLarge:    codes.InvalidArgument,
+	CodeConflict:    codes.Aborted,
+	CodeWrongType:   codes.FailedPrecondition,
+	CodeNotInteger:  codes.FailedPrecondition,
+	CodeOutOfMemory: codes.ResourceExhausted,
+	CodeInternal:    codes.Internal,
+
+	CodeUnauthenticated:  codes.Unauthenticated,
+	CodePermissionDenied: codes.PermissionDenied,
+}
+
+// grpcListener serves the Yakvs gRPC service. Like RESP, each call is
+// translated into a Command and processed like any other, so redirects,
+// limits and latency stats apply to it too.
+type grpcListener struct {
+	yakvspb.UnimplementedYakvsServer
+
+	listener net.Listener
+	server   *grpc.Server
+	run      func(Command) Response
+	watch    watchFunc
+	users    *acl.Users // nil unless calls must carry credentials
+	auditor  func(addr string) auditFunc
+
+	stopOnce sync.Once
+	stopping chan struct{} // closed to end Watch streams
+}
+
+// listenGRPC starts serving gRPC on addr, or on the listener inherited from
+// the previous process during a graceful restart
+func listenGRPC(addr string, l *grpcListener, tlsConfig *tls.Config, maxSize int) error {
+	listener, err := handover.Listen("grpc", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if maxSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(maxSize))
+	}
+
+	l.listener = listener
+	l.server = grpc.NewServer(opts...)
+	l.stopping = make(chan struct{})
+	yakvspb.RegisterYakvsServer(l.server, l)
+	fmt.Printf("gRPC listener started on %s\n", addr)
+
+	go func() {
+		if err := l.server.Serve(listener); err != nil {
+			fmt.Printf("Error serving gRPC: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// close ends Watch streams and stops the server once calls in flight finish
+func (l *grpcListener) close() {
+	if l == nil {
+		return
+	}
+	l.stopOnce.Do(func() {
+		close(l.stopping)
+		l.server.GracefulStop()
+	})
+}
+
+func (l *grpcListener) Get(ctx context.Context, req *yakvspb.GetRequest) (*yakvspb.GetResponse, error) {
+	run := l.runner(ctx)
+	resp := run(Command{Op: "GET", Key: req.Key})
+	if resp.Status != "success" {
+		return nil, grpcError(resp)
+	}
+	return &yakvspb.GetResponse{Value: []byte(resp.Value), TtlMs: ttlMillis(resp.TTL), Version: resp.Version}, nil
+}
+
+func (l *grpcListener) Set(ctx context.Context, req *yakvspb.SetRequest) (*yakvspb.SetResponse, error) {
+	run := l.runner(ctx)
+	if req.TtlMs < 0 {
+		return nil, grpcError(Response{Status: "error", Message: "TTL must not be negative"})
+	}
+	resp := run(Command{Op: "SET", Key: req.Key, Value: string(req.Value), ExpiresIn: time.Duration(req.TtlMs) * time.Millisecond})
+	if resp.Status != "success" {
+		return nil, grpcError(resp)
+	}
+	return &yakvspb.SetResponse{}, nil
+}
+
+func (l *grpcListener) Delete(ctx context.Context, req *yakvspb.DeleteRequest) (*yakvspb.DeleteResponse, error) {
+	run := l.runner(ctx)
+	resp := run(Command{Op: "DELETE", Key: req.Key})
+	if resp.Status != "success" {
+		return nil, grpcError(resp)
+	}
+	return &yakvspb.DeleteResponse{}, nil
+}
+
+func (l *grpcListener) TTL(ctx context.Context, req *yakvspb.TTLRequest) (*yakvspb.TTLResponse, error) {
+	run := l.runner(ctx)
+	resp := run(Command{Op: "TTL", Key: req.Key})
+	if resp.Status != "success" {
+		return nil, grpcError(resp)
+	}
+	return &yakvspb.TTLResponse{TtlMs: ttlMillis(resp.TTL)}, nil
+}
+
+func (l *grpcListener) Expire(ctx context.Context, req *yakvspb.ExpireRequest) (*yakvspb.ExpireResponse, error) {
+	run := l.runner(ctx)
+	resp := run(Command{Op: "EXPIRE", Key: req.Key, ExpiresIn: time.Duration(req.TtlMs) * time.Millisecond})
+	if resp.Status != "success" {
+		return nil, grpcError(resp)
+	}
+	return &yakvspb.ExpireResponse{Applied: resp.Applied}, nil
+}
+
+func (l *grpcListener) Scan(ctx context.Context, req *yakvspb.ScanRequest) (*yakvspb.ScanResponse, error) {
+	run := l.runner(ctx)
+	resp := run(Command{Op: "SCAN", Cursor: req.Cursor, Key: req.Pattern, Count: int(req.Count)})
+	if resp.Status != "success" {
+		return nil, grpcError(resp)
+	}
+	return &yakvspb.ScanResponse{Keys: resp.Keys, Cursor: resp.Cursor}, nil
+}
+
+// Watch streams events like SUBSCRIBE. A watcher that stops reading is not
+// disconnected; its events are dropped and counted in the next one sent.
+func (l *grpcListener) Watch(req *yakvspb.WatchRequest, stream grpc.ServerStreamingServer[yakvspb.Event]) error {
+	if req.Pattern == "" {
+		return grpcError(Response{Status: "error", Message: "Key pattern is required"})
+	}
+	user, failed := l.login(stream.Context())
+	if failed == nil {
+		failed = authorize(l.users, &user, Command{Op: "SUBSCRIBE", Key: req.Pattern})
+	}
+	if failed != nil {
+		return grpcError(*failed)
+	}
+
+	prefix, exact := splitPattern(req.Pattern)
+	events, cancel := l.watch(prefix, subscriberBuffer)
+	defer cancel()
+
+	// Sending the headers tells the client the watch is in place, as the
+	// acknowledgement of SUBSCRIBE does
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "server is shutting down")
+			}
+			if exact && ev.Key != req.Pattern {
+				continue
+			}
+			err := stream.Send(&yakvspb.Event{
+				Event:   ev.Op,
+				Key:     ev.Key,
+				Value:   []byte(ev.Value.Data),
+				Version: ev.Version,
+				Ts:      ev.Time.UnixMilli(),
+				Lost:    int32(ev.Lost),
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-l.stopping:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+// login returns the user named by the basic credentials in the call's
+// authorization metadata, nil if it sent none or the server has no users.
+// Wrong credentials get the failed response.
+func (l *grpcListener) login(ctx context.Context) (*acl.User, *Response) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 || l.users == nil {
+		return nil, nil
+	}
+
+	var user *acl.User
+	name, password, ok := parseBasicAuth(auth[0])
+	if !ok {
+		return nil, &Response{Status: "error", Code: CodeUnauthenticated, Message: "Invalid authorization metadata, want Basic credentials"}
+	}
+	if resp := authorize(l.users, &user, Command{Op: "AUTH", Key: name, Value: password}); resp.Status != "success" {
+		return nil, resp
+	}
+	return user, nil
+}
+
+// runner returns how to run the commands of a call as its user
+func (l *grpcListener) runner(ctx context.Context) func(Command) Response {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	audit := l.auditor(addr)
+
+	user, failed := l.login(ctx)
+	if failed != nil {
+		return func(cmd Command) Response {
+			audit.record(nil, cmd, *failed)
+			return *failed
+		}
+	}
+	return authorized(l.users, &user, l.run, audit)
+}
+
+// parseBasicAuth splits "Basic base64(name:password)"
+func parseBasicAuth(auth string) (name, password string, ok bool) {
+	encoded, ok := strings.CutPrefix(auth, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// grpcError converts a failed response into a gRPC status carrying its code
+// as the reason of an ErrorInfo, and the leader of a NOT_LEADER response in
+// its metadata
+func grpcError(r Response) error {
+	r = withCode(r)
+	code, ok := grpcCodes[r.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+
+	info := &errdetails.ErrorInfo{Reason: r.Code, Domain: "yakvs"}
+	if r.Leader != "" {
+		info.Metadata = map[string]string{"leader": r.Leader}
+	}
+	st, err := status.New(code, r.Message).WithDetails(info)
+	if err != nil {
+		return status.Error(code, r.Message)
+	}
+	return st.Err()
+}
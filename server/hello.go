@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pixperk/yakvs/version"
+	"github.com/pixperk/yakvs/wire"
+)
+
+// maxClientName is the longest name HELLO accepts
+const maxClientName = 128
+
+// features lists what both servers support beyond single JSON commands, for
+// clients deciding whether to rely on them
+var features = []string{"pipelining", "binary_values", "chunked_values", "transactions", "subscriptions", "msgpack"}
+
+// helloReply is the JSON a HELLO response carries in Value
+type helloReply struct {
+	Version      string   `json:"version"`
+	Protocol     int      `json:"protocol"` // the revision agreed for the connection
+	MinProtocol  int      `json:"min_protocol"`
+	MaxProtocol  int      `json:"max_protocol"`
+	AuthRequired bool     `json:"auth_required"`
+	Features     []string `json:"features"`
+	Framing      string   `json:"framing"` // the codec used after this reply
+}
+
+// handleHello answers HELLO, which clients may send first to agree on a
+// protocol revision and give the connection a name for CLIENT LIST and INFO.
+// Clients that skip it are taken to speak protocol 1. It may be sent before
+// AUTH, so clients can learn that they need to log in. A HELLO naming a
+// framing is answered in the current one, and the connection uses the new
+// one from the next message on; busy connections, subscribed or inside
+// MULTI, cannot switch. It reports whether cmd was a HELLO.
+func handleHello(cmd Command, conn net.Conn, conns *connTracker, authRequired, busy bool, reader *requestReader, out *connWriter) bool {
+	if strings.ToUpper(cmd.Op) != "HELLO" {
+		return false
+	}
+
+	// The newest revision both sides speak, which a client that names none
+	// leaves up to the server
+	protocol := cmd.Protocol
+	if protocol == 0 || protocol > version.Protocol {
+		protocol = version.Protocol
+	}
+	if protocol < version.MinProtocol {
+		sendResponse(out, Response{
+			Status:  "error",
+			Code:    CodeBadRequest,
+			Message: fmt.Sprintf("Protocol %d is not supported, this server speaks %d to %d", cmd.Protocol, version.MinProtocol, version.Protocol),
+		})
+		return true
+	}
+	if len(cmd.Name) > maxClientName {
+		sendResponse(out, Response{
+			Status:  "error",
+			Code:    CodeBadRequest,
+			Message: fmt.Sprintf("Client name too long, the limit is %d bytes", maxClientName),
+		})
+		return true
+	}
+
+	codec := reader.codec
+	if cmd.Framing != "" {
+		var ok bool
+		if codec, ok = wire.ByName(cmd.Framing); !ok {
+			sendResponse(out, Response{Status: "error", Code: CodeBadRequest, Message: fmt.Sprintf("Unknown framing %q, want json or msgpack", cmd.Framing)})
+			return true
+		}
+		if busy && codec != reader.codec {
+			sendResponse(out, Response{Status: "error", Code: CodeBadRequest, Message: "The framing cannot change while subscribed or in MULTI"})
+			return true
+		}
+	}
+
+	data, err := json.Marshal(helloReply{
+		Version:      version.Version,
+		Protocol:     protocol,
+		MinProtocol:  version.MinProtocol,
+		MaxProtocol:  version.Protocol,
+		AuthRequired: authRequired,
+		Features:     features,
+		Framing:      codec.Name(),
+	})
+	if err != nil {
+		sendResponse(out, errorResponse(err))
+		return true
+	}
+
+	conns.update(conn, func(c *clientInfo) {
+		c.Name = cmd.Name
+		c.Protocol = protocol
+		c.Framing = codec.Name()
+	})
+	sendResponse(out, Response{
+		Status:  "success",
+		Value:   string(data),
+		Message: fmt.Sprintf("yakvs %s, protocol %d", version.Version, protocol),
+	})
+	reader.codec = codec
+	out.codec = codec
+	return true
+}
+
+// clientListResponse encodes the open connections as a JSON array in the
+// response value
+func clientListResponse(conns *connTracker) Response {
+	clients := conns.clients()
+	data, err := json.Marshal(clients)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return Response{Status: "success", Value: string(data), Message: fmt.Sprintf("%d clients", len(clients))}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixperk/yakvs/acl"
+)
+
+// httpStatuses are the HTTP statuses of failed responses by code
+var httpStatuses = map[string]int{
+	CodeNotFound:    http.StatusNotFound,
+	CodeBadRequest:  http.StatusBadRequest,
+	CodeReadOnly:    http.StatusForbidden,
+	CodeTooLarge:    http.StatusRequestEntityTooLarge,
+	CodeConflict:    http.StatusConflict,
+	CodeWrongType:   http.StatusConflict,
+	CodeNotInteger:  http.StatusConflict,
+	CodeOutOfMemory: http.StatusInsufficientStorage,
+	CodeInternal:    http.StatusInternalServerError,
+
+	CodeUnauthenticated:  http.StatusUnauthorized,
+	CodePermissionDenied: http.StatusForbidden,
+}
+
+// HTTPHandler serves the store over plain HTTP for scripting:
+//
+//	GET    /kv/{key}      the value and TTL of key
+//	PUT    /kv/{key}      store the request body, expiring after ?ttl=30s
+//	DELETE /kv/{key}      delete key
+//	GET    /kv/{key}/ttl  the TTL of key
+//	GET    /keys          the keys starting with ?prefix=, at most ?limit=
+//	GET    /watch         a WebSocket streaming changes to keys matching ?pattern=
+//
+// Replies are the same JSON responses the TCP protocol sends, with the HTTP
+// status following their code. Keys holding a slash must escape it as %2F.
+// When the server has users, requests log in with basic auth.
+func (s *Server) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kv/", s.handleKV)
+	mux.HandleFunc("/keys", s.handleKeys)
+	mux.HandleFunc("/watch", s.handleWatch)
+	return mux
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.httpRun(w, r)
+	if !ok {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/kv/")
+	escapedKey, ttlOnly := strings.CutSuffix(path, "/ttl")
+	key, err := url.PathUnescape(escapedKey)
+	if err != nil || key == "" || strings.Contains(escapedKey, "/") {
+		writeHTTPResponse(w, Response{Status: "error", Message: "Invalid key"})
+		return
+	}
+
+	switch {
+	case ttlOnly && r.Method == http.MethodGet:
+		writeHTTPResponse(w, run(Command{Op: "TTL", Key: key}))
+
+	case ttlOnly:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	case r.Method == http.MethodGet:
+		cmd := Command{Op: "GET", Key: key, Encoding: r.URL.Query().Get("encoding")}
+		writeHTTPResponse(w, encodeValues(cmd.Encoding, run(cmd)))
+
+	case r.Method == http.MethodPut:
+		cmd, resp := httpSetCommand(key, r, s.maxRequestSize)
+		if resp != nil {
+			writeHTTPResponse(w, *resp)
+			return
+		}
+		writeHTTPResponse(w, run(cmd))
+
+	case r.Method == http.MethodDelete:
+		writeHTTPResponse(w, run(Command{Op: "DELETE", Key: key}))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// httpSetCommand builds the SET for a PUT. A JSON string body is stored
+// decoded, any other body as it was sent. The failed response is returned
+// for bodies or TTLs that cannot be used.
+func httpSetCommand(key string, r *http.Request, maxSize int) (Command, *Response) {
+	cmd := Command{Op: "SET", Key: key}
+
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d < 0 {
+			return cmd, &Response{Status: "error", Message: fmt.Sprintf("Invalid TTL %q, want a duration such as 30s", ttl)}
+		}
+		cmd.ExpiresIn = d
+	}
+
+	body := r.Body
+	if maxSize > 0 {
+		body = http.MaxBytesReader(nil, r.Body, int64(maxSize))
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return cmd, &Response{Status: "too_large", Message: fmt.Sprintf("Request too large, the limit is %d bytes", maxSize)}
+	}
+	cmd.Value = string(data)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		if !json.Valid(data) {
+			return cmd, &Response{Status: "error", Message: "Invalid JSON body"}
+		}
+		var str string
+		if json.Unmarshal(data, &str) == nil {
+			cmd.Value = str
+		}
+	}
+	return cmd, nil
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	run, ok := s.httpRun(w, r)
+	if !ok {
+		return
+	}
+
+	limit := maxKeysReply
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeHTTPResponse(w, Response{Status: "error", Message: "Invalid limit"})
+			return
+		}
+		limit = min(n, maxKeysReply)
+	}
+
+	writeHTTPResponse(w, run(Command{Op: "SCANPREFIX", Key: r.URL.Query().Get("prefix"), Count: limit}))
+}
+
+// httpUser returns the user named by the basic auth credentials of r, nil
+// if it sent none or the server has no users. Wrong credentials get the
+// failed response to send.
+func (s *Server) httpUser(r *http.Request) (*acl.User, *Response) {
+	var user *acl.User
+	name, password, ok := r.BasicAuth()
+	if !ok || s.users == nil {
+		return nil, nil
+	}
+	if resp := authorize(s.users, &user, Command{Op: "AUTH", Key: name, Value: password}); resp.Status != "success" {
+		return nil, resp
+	}
+	return user, nil
+}
+
+// httpRun returns how to run the commands of r as its user. It reports false
+// after sending the response to wrong credentials.
+func (s *Server) httpRun(w http.ResponseWriter, r *http.Request) (func(Command) Response, bool) {
+	user, failed := s.httpUser(r)
+	if failed != nil {
+		writeHTTPResponse(w, *failed)
+		return nil, false
+	}
+	return authorized(s.users, &user, s.runCommand, s.auditor(r.RemoteAddr)), true
+}
+
+// writeHTTPResponse sends resp as JSON with the HTTP status of its code
+func writeHTTPResponse(w http.ResponseWriter, resp Response) {
+	resp = wireResponse(resp)
+	status := http.StatusOK
+	if resp.Status != "success" {
+		status = httpStatuses[resp.Code]
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+	}
+
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="yakvs"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pixperk/yakvs/metrics"
+	"github.com/pixperk/yakvs/raft"
+	"github.com/pixperk/yakvs/store"
+	"github.com/pixperk/yakvs/version"
+)
+
+// info is the reply to INFO, grouped into the sections the CLIs print
+type info struct {
+	Server       serverInfo                      `json:"server"`
+	Store        store.Stats                     `json:"store"`
+	Commands     map[string]uint64               `json:"commands"`
+	CommandStats map[string]metrics.CommandStats `json:"command_stats"`
+	Raft         *raft.NodeInfo                  `json:"raft,omitempty"`
+}
+
+type serverInfo struct {
+	Version                 string        `json:"version"`
+	Address                 string        `json:"address"`
+	Role                    string        `json:"role"` // primary, replica, leader or follower
+	Uptime                  time.Duration `json:"uptime"`
+	Connections             int           `json:"connections"`
+	SlowConsumerDisconnects uint64        `json:"slow_consumer_disconnects"`
+	OpsPerSec               float64       `json:"ops_per_sec"` // averaged over the last few seconds
+	// Clients counts the connections by the name they gave in HELLO
+	Clients map[string]int `json:"clients,omitempty"`
+}
+
+// commandCounts returns how many commands of each op were processed since the
+// server started or its latencies were last reset
+func commandCounts(stats map[string]metrics.CommandStats) map[string]uint64 {
+	counts := make(map[string]uint64, len(stats))
+	for op, s := range stats {
+		counts[op] = s.Calls
+	}
+	return counts
+}
+
+// infoResponse encodes INFO as JSON in the response value
+func infoResponse(i info) Response {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return Response{
+		Status:  "success",
+		Value:   string(data),
+		Message: fmt.Sprintf("%s, up %s, %d keys", i.Server.Role, i.Server.Uptime.Round(time.Second), i.Store.Keys),
+	}
+}
+
+// info gathers the server's INFO sections
+func (s *Server) info() (info, error) {
+	stats, err := s.db.Stats()
+	if err != nil {
+		return info{}, err
+	}
+
+	role := "primary"
+	if s.replicaOf != "" {
+		role = "replica"
+	}
+	cmdStats := s.latency.CommandStats()
+	return info{
+		Server:       newServerInfo(s.addr, s.Listener(), role, s.started, &s.conns, &s.latency, s.slowConsumers.Load()),
+		Store:        stats,
+		Commands:     commandCounts(cmdStats),
+		CommandStats: cmdStats,
+	}, nil
+}
+
+// newServerInfo describes a server listening on listener, or addr before
+// it has started
+func newServerInfo(addr string, listener net.Listener, role string, started time.Time, conns *connTracker, l *metrics.Latencies, slowConsumers uint64) serverInfo {
+	if listener != nil {
+		addr = listener.Addr().String()
+	}
+	return serverInfo{
+		Version:                 version.Version,
+		Address:                 addr,
+		Role:                    role,
+		Uptime:                  time.Since(started),
+		Connections:             conns.count(),
+		SlowConsumerDisconnects: slowConsumers,
+		OpsPerSec:               l.PerSecond(),
+		Clients:                 conns.names(),
+	}
+}
+
+// info gathers the node's INFO sections, including its raft state
+func (s *RaftServer) info() info {
+	role := "follower"
+	if s.store.IsLeader() {
+		role = "leader"
+	}
+
+	node := s.store.NodeInfo()
+	cmdStats := s.latency.CommandStats()
+	return info{
+		Server:       newServerInfo(s.addr, s.Listener(), role, s.started, &s.conns, &s.latency, s.slowConsumers.Load()),
+		Store:        s.store.Stats(),
+		Commands:     commandCounts(cmdStats),
+		CommandStats: cmdStats,
+		Raft:         &node,
+	}
+}
+
+// nodeStatus is a raft node's STATUS reply, carried as JSON in the response
+// value
+type nodeStatus struct {
+	raft.ClusterStatus
+	Role                    string `json:"role"` // "leader" or "follower"
+	Version                 string `json:"version"`
+	SlowConsumerDisconnects uint64 `json:"slow_consumer_disconnects"`
+
+	Raft raft.ReplicationStats `json:"raft"`
+}
+
+// statusResponse reports the node's role, raft indexes, leader and peers.
+// The message keeps the one line summary older clients parse.
+func (s *RaftServer) statusResponse() Response {
+	cluster, err := s.store.ClusterStatus()
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	st := nodeStatus{
+		ClusterStatus:           cluster,
+		Role:                    "follower",
+		Version:                 version.Version,
+		SlowConsumerDisconnects: s.slowConsumers.Load(),
+		Raft:                    s.store.ReplicationStats(),
+	}
+	if s.store.IsLeader() {
+		st.Role = "leader"
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return errorResponse(err)
+	}
+	message := fmt.Sprintf("Node status: %s, version: %s, slow consumer disconnects: %d",
+		st.Role, st.Version, st.SlowConsumerDisconnects)
+	return Response{Status: "success", Message: message, Value: string(data)}
+}
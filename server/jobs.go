@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxFinishedJobs is how many finished jobs JOBSTATUS can still report on
+const maxFinishedJobs = 100
+
+// job is an admin operation running in the background, such as a COMPACT
+// or BGSAVE, as reported by JOBSTATUS
+type job struct {
+	ID         uint64    `json:"id"`
+	Kind       string    `json:"kind"`              // "compact" or "bgsave"
+	State      string    `json:"state"`             // "running", "done" or "failed"
+	Message    string    `json:"message,omitempty"` // the outcome once finished
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// jobTracker runs background jobs and remembers the latest finished ones.
+// Only one job of each kind runs at a time.
+type jobTracker struct {
+	mu       sync.Mutex
+	jobs     map[uint64]*job
+	finished []uint64 // oldest first
+	running  map[string]uint64
+	lastID   uint64
+}
+
+// start runs fn as a job of the given kind and answers with the job, or
+// refuses if one of that kind is still running. fn returns the message
+// reported once it finishes.
+func (t *jobTracker) start(kind string, fn func() (string, error)) Response {
+	t.mu.Lock()
+	if t.jobs == nil {
+		t.jobs = make(map[uint64]*job)
+		t.running = make(map[string]uint64)
+	}
+	if id, ok := t.running[kind]; ok {
+		t.mu.Unlock()
+		return Response{Status: "error", Message: fmt.Sprintf("A %s is already running as job %d", kind, id)}
+	}
+	t.lastID++
+	j := &job{ID: t.lastID, Kind: kind, State: "running", StartedAt: time.Now()}
+	t.jobs[j.ID] = j
+	t.running[kind] = j.ID
+	started := *j
+	t.mu.Unlock()
+
+	go func() {
+		msg, err := fn()
+		t.finish(j, msg, err)
+	}()
+
+	resp := jobResponse(started)
+	resp.Message = fmt.Sprintf("Started %s job %d", kind, started.ID)
+	return resp
+}
+
+func (t *jobTracker) finish(j *job, msg string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	j.State, j.Message = "done", msg
+	if err != nil {
+		j.State, j.Message = "failed", err.Error()
+	}
+	j.FinishedAt = time.Now()
+	fmt.Printf("Job %d (%s) %s: %s\n", j.ID, j.Kind, j.State, j.Message)
+
+	delete(t.running, j.Kind)
+	t.finished = append(t.finished, j.ID)
+	if len(t.finished) > maxFinishedJobs {
+		delete(t.jobs, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+}
+
+// statusResponse reports on the job with the given ID
+func (t *jobTracker) statusResponse(id uint64) Response {
+	t.mu.Lock()
+	j, ok := t.jobs[id]
+	var snapshot job
+	if ok {
+		snapshot = *j
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		return Response{Status: "error", Message: fmt.Sprintf("No job %d, it may have finished too long ago", id)}
+	}
+	resp := jobResponse(snapshot)
+	resp.Message = fmt.Sprintf("Job %d is %s", id, snapshot.State)
+	return resp
+}
+
+// jobResponse carries j as JSON in the response value and its ID in Int
+func jobResponse(j job) Response {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return Response{Status: "success", Value: string(data), Int: int64(j.ID)}
+}
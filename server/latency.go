@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pixperk/yakvs/metrics"
+)
+
+// recordLatency adds a processed command to the latency histograms. Raft
+// writes are also split into the time spent in Apply and the rest.
+func recordLatency(l *metrics.Latencies, cmd Command, resp Response, elapsed time.Duration) {
+	// Fold unknown commands into one series so clients can't create
+	// histograms at will
+	op := strings.ToUpper(cmd.Op)
+	if resp.Status == "error" && resp.Message == "Unknown command" {
+		op = "UNKNOWN"
+	}
+
+	l.Observe(metrics.Key{Op: op, Outcome: resp.Status}, elapsed)
+	if resp.applyTime > 0 {
+		l.Observe(metrics.Key{Op: op, Outcome: resp.Status, Stage: "raft_apply"}, resp.applyTime)
+		l.Observe(metrics.Key{Op: op, Outcome: resp.Status, Stage: "local"}, elapsed-resp.applyTime)
+	}
+}
+
+// latencyResponse encodes the latency summaries as JSON in the response value
+func latencyResponse(l *metrics.Latencies) Response {
+	data, err := json.Marshal(l.Summaries())
+	if err != nil {
+		return errorResponse(err)
+	}
+	return Response{Status: "success", Value: string(data)}
+}
@@ -0,0 +1,42 @@
+package server
+
+import "github.com/pixperk/yakvs/store"
+
+// checkLimits refuses a write whose key or value is over limits before the
+// value is handed to the store or proposed to raft. A chunked SET is checked
+// against the size it announces, before any chunk is accepted, and APPEND
+// and list pushes only against what they add; the store checks the combined
+// value.
+func checkLimits(op string, cmd Command, limits store.Limits) error {
+	switch op {
+	case "SET":
+		size := len(cmd.Value)
+		if cmd.Chunked {
+			size = int(cmd.Size)
+		}
+		return limits.Check(cmd.Key, size)
+
+	case "SETNX", "CAS", "GETSET", "APPEND", "INCR", "DECR", "INCRBY":
+		return limits.Check(cmd.Key, len(cmd.Value))
+
+	case "LPUSH", "RPUSH":
+		size := 0
+		for _, elem := range cmd.Elements {
+			size += len(elem)
+		}
+		return limits.Check(cmd.Key, size)
+
+	case "MSET":
+		for key, value := range cmd.Pairs {
+			if err := limits.Check(key, len(value)); err != nil {
+				return err
+			}
+		}
+		for _, e := range cmd.Entries {
+			if err := limits.Check(e.Key, len(e.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/handover"
+)
+
+// ListenerSpec describes another address a server accepts command protocol
+// connections on, besides the one it was created with
+type ListenerSpec struct {
+	Network string      // "tcp" or "unix"
+	Addr    string      // host:port, or the path of a unix socket
+	TLS     *tls.Config // nil accepts plain connections
+
+	// NoAuth lets clients run every command without AUTH, for sockets that
+	// file permissions already protect
+	NoAuth bool
+}
+
+// handoverName is the name a listener is passed on under in a graceful
+// restart. The server's own address keeps the name "tcp" it always had.
+func (spec ListenerSpec) handoverName() string {
+	return "listen:" + spec.Network + ":" + spec.Addr
+}
+
+// cmdListener is one socket a server accepts command connections on
+type cmdListener struct {
+	net.Listener
+	name  string
+	tls   *tls.Config
+	users *acl.Users // nil unless its clients must AUTH
+}
+
+// openListeners listens on the server's own address and on each extra one,
+// or takes them over from the previous process during a graceful restart.
+// On failure the listeners already opened are closed again.
+func openListeners(addr string, tlsConfig *tls.Config, users *acl.Users, extra []ListenerSpec) ([]*cmdListener, error) {
+	var listeners []*cmdListener
+	open := func(name, network, addr string, tlsConfig *tls.Config, users *acl.Users) error {
+		l, err := handover.ListenNetwork(name, network, addr)
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, &cmdListener{Listener: l, name: name, tls: tlsConfig, users: users})
+		return nil
+	}
+
+	if err := open("tcp", "tcp", addr, tlsConfig, users); err != nil {
+		return nil, err
+	}
+	for _, spec := range extra {
+		specUsers := users
+		if spec.NoAuth {
+			specUsers = nil
+		}
+		if err := open(spec.handoverName(), spec.Network, spec.Addr, spec.TLS, specUsers); err != nil {
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// closeListeners closes every listener, returning the first error
+func closeListeners(listeners []*cmdListener) error {
+	var first error
+	for _, l := range listeners {
+		if err := l.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// listenerMap returns the listeners by the name they are handed over under
+func listenerMap(listeners []*cmdListener) map[string]net.Listener {
+	m := make(map[string]net.Listener, len(listeners))
+	for _, l := range listeners {
+		m[l.name] = l.Listener
+	}
+	return m
+}
+
+// ParseListenerSpec parses a listener given as network:address followed by
+// comma separated options, such as "unix:/run/yakvs.sock,noauth" or
+// "tcp:0.0.0.0:8443,tls". The tls option serves TLS configured by
+// tlsConfig, which must then be set, and noauth sets NoAuth.
+func ParseListenerSpec(s string, tlsConfig *tls.Config) (ListenerSpec, error) {
+	fields := strings.Split(s, ",")
+	network, addr, ok := strings.Cut(fields[0], ":")
+	if !ok || addr == "" {
+		return ListenerSpec{}, fmt.Errorf("invalid listener %q, want network:address", s)
+	}
+	if network != "tcp" && network != "unix" {
+		return ListenerSpec{}, fmt.Errorf("invalid listener %q, network must be tcp or unix", s)
+	}
+
+	spec := ListenerSpec{Network: network, Addr: addr}
+	for _, opt := range fields[1:] {
+		switch opt {
+		case "tls":
+			if tlsConfig == nil {
+				return ListenerSpec{}, fmt.Errorf("listener %q asks for TLS, which is not configured", s)
+			}
+			spec.TLS = tlsConfig
+		case "noauth":
+			spec.NoAuth = true
+		default:
+			return ListenerSpec{}, fmt.Errorf("invalid listener %q, unknown option %q", s, opt)
+		}
+	}
+	return spec, nil
+}
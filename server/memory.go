@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+const (
+	// defaultMemoryTop is the number of largest keys MEMORY STATS reports
+	// when the command does not ask for a count
+	defaultMemoryTop = 10
+	maxMemoryTop     = 1000
+)
+
+// memoryTop clamps the largest-keys count requested by MEMORY STATS
+func memoryTop(count int) int {
+	if count <= 0 {
+		return defaultMemoryTop
+	}
+	return min(count, maxMemoryTop)
+}
+
+// statsResponse encodes STATS as JSON in the response value
+func statsResponse(stats store.Stats) Response {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return Response{
+		Status:  "success",
+		Value:   string(data),
+		Message: fmt.Sprintf("%d keys, %d bytes", stats.Keys, stats.MemoryBytes),
+	}
+}
+
+// memoryStatsResponse encodes MEMORY STATS as JSON in the response value
+func memoryStatsResponse(stats store.MemoryStats) Response {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return Response{
+		Status:  "success",
+		Value:   string(data),
+		Message: fmt.Sprintf("%d entries, %d bytes", stats.Entries, stats.Bytes),
+	}
+}
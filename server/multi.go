@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+// maxQueued caps the commands a transaction may queue
+const maxQueued = 10000
+
+// queueableOps are the commands that may be queued between MULTI and EXEC
+var queueableOps = map[string]bool{
+	"SET":     true,
+	"DELETE":  true,
+	"EXPIRE":  true,
+	"PERSIST": true,
+}
+
+// transaction is a MULTI block being queued on a connection. A command that
+// cannot be queued marks it failed, and EXEC then discards it.
+type transaction struct {
+	cmds   []Command
+	failed bool
+}
+
+// handleMulti processes MULTI, EXEC and DISCARD and queues the commands sent
+// in between, sending the response itself. It reports whether cmd was
+// consumed. exec applies the queued commands as one atomic batch.
+func handleMulti(cmd Command, tx **transaction, out *connWriter, exec func([]Command) Response) bool {
+	switch strings.ToUpper(cmd.Op) {
+	case "MULTI":
+		if *tx != nil {
+			sendResponse(out, Response{Status: "error", Message: "MULTI calls can not be nested"})
+			return true
+		}
+		*tx = &transaction{}
+		sendResponse(out, Response{Status: "success", Message: "OK"})
+		return true
+
+	case "EXEC":
+		t := *tx
+		if t == nil {
+			sendResponse(out, Response{Status: "error", Message: "EXEC without MULTI"})
+			return true
+		}
+		*tx = nil
+		if t.failed {
+			sendResponse(out, Response{Status: "error", Message: "Transaction discarded because of previous errors"})
+			return true
+		}
+		sendResponse(out, exec(t.cmds))
+		return true
+
+	case "DISCARD":
+		if *tx == nil {
+			sendResponse(out, Response{Status: "error", Message: "DISCARD without MULTI"})
+			return true
+		}
+		*tx = nil
+		sendResponse(out, Response{Status: "success", Message: "Transaction discarded"})
+		return true
+	}
+
+	t := *tx
+	if t == nil {
+		return false
+	}
+
+	if msg := queueError(cmd, len(t.cmds)); msg != "" {
+		t.failed = true
+		sendResponse(out, Response{Status: "error", Message: msg + ", the transaction will be discarded"})
+		return true
+	}
+	if err := decodeValues(&cmd); err != nil {
+		t.failed = true
+		sendResponse(out, Response{Status: "error", Message: err.Error() + ", the transaction will be discarded"})
+		return true
+	}
+
+	t.cmds = append(t.cmds, cmd)
+	sendResponse(out, Response{Status: "success", Message: "Queued"})
+	return true
+}
+
+// queueError explains why cmd cannot be queued, or returns ""
+func queueError(cmd Command, queued int) string {
+	op := strings.ToUpper(cmd.Op)
+	switch {
+	case !queueableOps[op]:
+		return fmt.Sprintf("%s is not allowed in MULTI", op)
+	case cmd.Chunked:
+		return "Chunked values are not allowed in MULTI"
+	case cmd.Key == "":
+		return "Key is required"
+	case queued >= maxQueued:
+		return fmt.Sprintf("A transaction may queue at most %d commands", maxQueued)
+	}
+	return ""
+}
+
+// batchOps converts queued commands into the writes of a batch
+func batchOps(cmds []Command) []store.Op {
+	ops := make([]store.Op, 0, len(cmds))
+	for _, cmd := range cmds {
+		op := store.Op{Key: cmd.Key}
+		switch strings.ToUpper(cmd.Op) {
+		case "SET":
+			op.Kind = store.BatchSet
+			op.Value = store.NewValue(cmd.Value, cmd.ExpiresIn)
+		case "DELETE":
+			op.Kind = store.BatchDelete
+		case "EXPIRE":
+			op.Kind = store.BatchExpire
+			op.Value = store.NewValue("", cmd.ExpiresIn)
+		case "PERSIST":
+			op.Kind = store.BatchExpire
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// execResponse reports a committed transaction
+func execResponse(cmds []Command) Response {
+	return Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Applied %d commands", len(cmds)),
+		Int:     int64(len(cmds)),
+	}
+}
+
+// exec applies the commands of a transaction
+func (s *Server) exec(cmds []Command) Response {
+	if s.replicaOf != "" {
+		return Response{
+			Status:  "error",
+			Code:    CodeReadOnly,
+			Message: fmt.Sprintf("Read-only replica, send writes to: %s", s.replicaOf),
+		}
+	}
+
+	if err := s.db.ApplyBatch(batchOps(cmds)); err != nil {
+		return errorResponse(err)
+	}
+	return execResponse(cmds)
+}
+
+// exec applies the commands of a transaction as one raft log entry
+func (s *RaftServer) exec(cmds []Command) Response {
+	if err := s.store.ApplyBatch(batchOps(cmds)); err != nil {
+		return s.writeError(err, 0)
+	}
+	return execResponse(cmds)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pixperk/yakvs/wire"
+)
+
+// maxPipelined caps the commands one pipeline may carry
+const maxPipelined = 10000
+
+// unpipelinedOps are the commands that change the state of the connection or
+// span several request lines, which a pipeline cannot carry
+var unpipelinedOps = map[string]bool{
+	"SUBSCRIBE":   true,
+	"UNSUBSCRIBE": true,
+	"MULTI":       true,
+	"EXEC":        true,
+	"DISCARD":     true,
+	"CHUNK":       true,
+	"REPLICATE":   true,
+}
+
+// handlePipeline processes an array of commands in order and sends their
+// responses as one array, the nth response for the nth command. A command
+// that fails, or cannot be pipelined, only fails its own position. A request
+// that is not a valid array, or arrives while the connection is subscribed
+// or inside MULTI, gets a single error response instead. run processes one
+// decoded command.
+func handlePipeline(request []byte, codec wire.Codec, busy bool, out *connWriter, run func(Command) Response) {
+	if busy {
+		sendResponse(out, Response{Status: "error", Message: "Pipelines are not allowed while subscribed or in MULTI"})
+		return
+	}
+
+	raw, err := codec.SplitBatch(request)
+	if err != nil {
+		sendResponse(out, Response{Status: "error", Message: "Invalid command format"})
+		return
+	}
+	if len(raw) > maxPipelined {
+		sendResponse(out, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("A pipeline may carry at most %d commands", maxPipelined),
+		})
+		return
+	}
+
+	resps := make([]Response, len(raw))
+	for i, data := range raw {
+		var cmd Command
+		if err := codec.Unmarshal(data, &cmd); err != nil {
+			resps[i] = Response{Status: "error", Message: "Invalid command format"}
+			continue
+		}
+		decodeExpiry(&cmd)
+
+		op := strings.ToUpper(cmd.Op)
+		if unpipelinedOps[op] || cmd.Chunked {
+			resps[i] = Response{Status: "error", Message: fmt.Sprintf("%s cannot be pipelined", describeOp(op, cmd.Chunked))}
+			continue
+		}
+		if err := decodeValues(&cmd); err != nil {
+			resps[i] = Response{Status: "error", Message: err.Error()}
+			continue
+		}
+
+		resps[i] = encodeValues(cmd.Encoding, run(cmd))
+	}
+	sendResponses(out, resps)
+}
+
+// describeOp names an op for error messages, marking chunked ones
+func describeOp(op string, chunked bool) string {
+	if chunked {
+		return "Chunked " + op
+	}
+	return op
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pixperk/yakvs/metrics"
+	"github.com/pixperk/yakvs/store"
+)
+
+// raftGauges are the numeric hashicorp/raft stats exported as gauges
+var raftGauges = []struct {
+	stat, name, help string
+}{
+	{"term", "yakvs_raft_term", "Current raft term"},
+	{"last_log_index", "yakvs_raft_last_log_index", "Index of the last entry in the raft log"},
+	{"commit_index", "yakvs_raft_commit_index", "Index of the last committed raft entry"},
+	{"applied_index", "yakvs_raft_applied_index", "Index of the last raft entry applied to the store"},
+	{"fsm_pending", "yakvs_raft_fsm_pending", "Committed raft entries waiting to be applied"},
+	{"num_peers", "yakvs_raft_peers", "Other voters in the cluster"},
+}
+
+// MetricsHandler serves the server's metrics in the Prometheus text format
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := s.db.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		p := metrics.NewPromWriter(w)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeServerMetrics(p, &s.latency, &s.conns, s.slowConsumers.Load(), s.started, stats)
+
+		replica := 0.0
+		if s.replicaOf != "" {
+			replica = 1
+		}
+		p.Gauge("yakvs_replica", "Whether the server is a read-only replica", metrics.Sample{Value: replica})
+		logScrapeError(p)
+	})
+}
+
+// MetricsHandler serves the node's metrics in the Prometheus text format,
+// including its raft state
+func (s *RaftServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := metrics.NewPromWriter(w)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeServerMetrics(p, &s.latency, &s.conns, s.slowConsumers.Load(), s.started, s.store.Stats())
+
+		raftStats := s.store.RaftStats()
+		leader := 0.0
+		if s.store.IsLeader() {
+			leader = 1
+		}
+		p.Gauge("yakvs_raft_leader", "Whether the node is the raft leader", metrics.Sample{Value: leader})
+		p.Gauge("yakvs_raft_state", "The node's raft state, 1 for the current one",
+			metrics.Sample{Labels: []metrics.Label{{Name: "state", Value: raftStats["state"]}}, Value: 1})
+		for _, g := range raftGauges {
+			v, err := strconv.ParseFloat(raftStats[g.stat], 64)
+			if err != nil {
+				continue
+			}
+			p.Gauge(g.name, g.help, metrics.Sample{Value: v})
+		}
+		logScrapeError(p)
+	})
+}
+
+// writeServerMetrics writes the metrics both servers share: command counts
+// and latencies, connections and the store's size and counters
+func writeServerMetrics(p *metrics.PromWriter, l *metrics.Latencies, conns *connTracker, slowConsumers uint64, started time.Time, stats store.Stats) {
+	var commands, stages []metrics.Series
+	for _, series := range l.Snapshot() {
+		if series.Stage == "" {
+			commands = append(commands, series)
+		} else {
+			stages = append(stages, series)
+		}
+	}
+
+	counts := make([]metrics.Sample, len(commands))
+	for i, series := range commands {
+		counts[i] = metrics.Sample{Labels: commandLabels(series.Key), Value: float64(series.Count)}
+	}
+	p.Counter("yakvs_commands_total", "Commands processed, by op and status", counts...)
+	p.Histograms("yakvs_command_duration_seconds", "Time spent processing commands, by op and status", commands, commandLabels)
+	p.Histograms("yakvs_command_stage_duration_seconds", "Time spent in each stage of raft writes", stages, func(k metrics.Key) []metrics.Label {
+		return append(commandLabels(k), metrics.Label{Name: "stage", Value: k.Stage})
+	})
+
+	p.Gauge("yakvs_connections", "Open client connections", metrics.Sample{Value: float64(conns.count())})
+	p.Counter("yakvs_slow_consumer_disconnects_total", "Subscribers disconnected for not keeping up", metrics.Sample{Value: float64(slowConsumers)})
+	p.Gauge("yakvs_uptime_seconds", "Time since the server started", metrics.Sample{Value: time.Since(started).Seconds()})
+
+	p.Gauge("yakvs_keys", "Keys held, including expired ones not swept yet", metrics.Sample{Value: float64(stats.Keys)})
+	p.Gauge("yakvs_memory_bytes", "Estimated memory used by keys and values", metrics.Sample{Value: float64(stats.MemoryBytes)})
+	p.Gauge("yakvs_log_size_bytes", "Size of the append-only log", metrics.Sample{Value: float64(stats.LogSize)})
+	p.Counter("yakvs_sets_total", "Values written", metrics.Sample{Value: float64(stats.Sets)})
+	p.Counter("yakvs_deletes_total", "Keys deleted, including evicted ones", metrics.Sample{Value: float64(stats.Deletes)})
+	p.Counter("yakvs_expired_keys_total", "Keys removed because they expired", metrics.Sample{Value: float64(stats.ExpiredKeys)})
+	p.Counter("yakvs_evicted_keys_total", "Keys removed to stay under the memory limit", metrics.Sample{Value: float64(stats.EvictedKeys)})
+	p.Counter("yakvs_compactions_total", "Log compactions, manual and automatic", metrics.Sample{Value: float64(stats.Compactions)})
+}
+
+func commandLabels(k metrics.Key) []metrics.Label {
+	return []metrics.Label{{Name: "op", Value: k.Op}, {Name: "status", Value: k.Outcome}}
+}
+
+// logScrapeError reports a scrape that could not be written in full, which
+// usually means the scraper went away
+func logScrapeError(p *metrics.PromWriter) {
+	if err := p.Err(); err != nil {
+		fmt.Printf("Error writing metrics: %v\n", err)
+	}
+}
@@ -2,125 +2,580 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/audit"
+	"github.com/pixperk/yakvs/chaos"
+	"github.com/pixperk/yakvs/metrics"
 	"github.com/pixperk/yakvs/raft"
 	"github.com/pixperk/yakvs/store"
+	"github.com/pixperk/yakvs/version"
+	"github.com/pixperk/yakvs/wire"
 )
 
 type RaftServer struct {
 	store     *raft.RaftStore
 	addr      string
-	listener  net.Listener
-	isRunning bool
+	listeners []*cmdListener // the server's own address first
+	extra     []ListenerSpec
+	isRunning atomic.Bool
+	started   time.Time
+	conns     connTracker
+	latency   metrics.Latencies
+	jobs      jobTracker // COMPACT and BGSAVE
+
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxRequestSize    int
+	maxRESPArgs       int
+	outputBufferLimit int
+	tlsConfig         *tls.Config // nil serves plain TCP
+	slowConsumers     atomic.Uint64
+	resp              *respListener // nil unless StartRESP was called
+	grpc              *grpcListener // nil unless StartGRPC was called
+	users             *acl.Users    // nil lets every client run every command
+	auditLog          *audit.Log    // nil records nothing
+
+	// readConsistency serves reads asking for ConsistencyDefault
+	readConsistency string
+
+	// forwarder relays writes to the leader while this node follows, if
+	// SetForwarding enabled it
+	forwarder forwarder
 }
 
 func NewRaftServer(addr string, store *raft.RaftStore) *RaftServer {
 	return &RaftServer{
-		store: store,
-		addr:  addr,
+		store:             store,
+		addr:              addr,
+		writeTimeout:      DefaultWriteTimeout,
+		maxRequestSize:    DefaultMaxRequestSize,
+		maxRESPArgs:       DefaultMaxRESPArgs,
+		outputBufferLimit: DefaultOutputBufferLimit,
+		readConsistency:   ConsistencyStale,
 	}
 }
 
 func (s *RaftServer) Start() error {
-	listener, err := net.Listen("tcp", s.addr)
+	// After a graceful restart the listeners are inherited from the old process
+	listeners, err := openListeners(s.addr, s.tlsConfig, s.users, s.extra)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+		return err
 	}
 
-	s.listener = listener
-	s.isRunning = true
+	s.listeners = listeners
+	s.isRunning.Store(true)
+	s.started = time.Now()
 	fmt.Printf("Server started on %s\n", s.addr)
+	for _, spec := range s.extra {
+		fmt.Printf("Also listening on %s %s\n", spec.Network, spec.Addr)
+	}
 
 	s.store.StartBackgroundCleaner()
+	s.store.OnLeaderChange(s.leaderChanged)
 
-	go s.acceptConnections()
+	for _, l := range s.listeners {
+		go s.acceptConnections(l)
+	}
 
 	return nil
 }
 
 func (s *RaftServer) Stop() error {
-	if !s.isRunning {
+	if !s.isRunning.Swap(false) {
+		return nil
+	}
+	s.resp.close()
+	s.grpc.close()
+	return closeListeners(s.listeners)
+}
+
+// Listener returns the listening socket of the server's own address
+func (s *RaftServer) Listener() net.Listener {
+	if len(s.listeners) == 0 {
 		return nil
 	}
+	return s.listeners[0].Listener
+}
+
+// Listeners returns every command protocol listening socket by name, for
+// passing to a replacement process during a graceful restart
+func (s *RaftServer) Listeners() map[string]net.Listener {
+	return listenerMap(s.listeners)
+}
+
+// AddListener also accepts connections on the address spec describes, with
+// its own TLS and AUTH settings. It must be called before Start.
+func (s *RaftServer) AddListener(spec ListenerSpec) {
+	s.extra = append(s.extra, spec)
+}
+
+// StartRESP also serves Redis clients on addr, speaking enough RESP2 for
+// GET, SET, DEL, TTL, EXPIRE, EXISTS, PING and INFO. It takes the server's
+// TLS, timeout and size settings and must be called after Start.
+func (s *RaftServer) StartRESP(addr string) error {
+	s.resp = &respListener{
+		conns:        &s.conns,
+		run:          s.runCommand,
+		users:        s.users,
+		auditor:      s.auditor,
+		tlsConfig:    s.tlsConfig,
+		writeTimeout: s.writeTimeout,
+		idleTimeout:  s.idleTimeout,
+		maxSize:      s.maxRequestSize,
+		maxArgs:      s.maxRESPArgs,
+		slow:         &s.slowConsumers,
+	}
+	return listenRESP(addr, s.resp)
+}
+
+// RESPListener returns the RESP listening socket, or nil if StartRESP was
+// not called
+func (s *RaftServer) RESPListener() net.Listener {
+	if s.resp == nil {
+		return nil
+	}
+	return s.resp.listener
+}
+
+// StartGRPC also serves the Yakvs gRPC service defined in yakvspb on addr.
+// It takes the server's TLS and size settings and must be called after Start.
+func (s *RaftServer) StartGRPC(addr string) error {
+	s.grpc = &grpcListener{run: s.runCommand, watch: s.store.Watch, users: s.users, auditor: s.auditor}
+	return listenGRPC(addr, s.grpc, s.tlsConfig, s.maxRequestSize)
+}
+
+// GRPCListener returns the gRPC listening socket, or nil if StartGRPC was
+// not called
+func (s *RaftServer) GRPCListener() net.Listener {
+	if s.grpc == nil {
+		return nil
+	}
+	return s.grpc.listener
+}
+
+// SetWriteTimeout sets how long a write to a client may stay blocked before
+// the client is disconnected as a slow consumer. Zero disables the limit. It
+// applies to connections accepted after the call.
+func (s *RaftServer) SetWriteTimeout(timeout time.Duration) {
+	s.writeTimeout = timeout
+}
+
+// SetOutputBufferLimit sets the most bytes of pushed messages, such as
+// subscription events, the server queues for a client that has not read
+// them. A client with more queued is disconnected as a slow consumer. Zero
+// disables the limit, leaving only the write timeout. It applies to
+// connections accepted after the call.
+func (s *RaftServer) SetOutputBufferLimit(limit int) {
+	s.outputBufferLimit = limit
+}
+
+// SetIdleTimeout sets how long a client may take to send its next request
+// before it is disconnected. Zero, the default, disables the limit.
+// Subscribed clients are never disconnected for being idle. It applies to
+// connections accepted after the call.
+func (s *RaftServer) SetIdleTimeout(timeout time.Duration) {
+	s.idleTimeout = timeout
+}
+
+// SetTLSConfig makes the server accept only TLS connections on its own
+// address, configured by cfg. Nil, the default, serves plain TCP. Listeners
+// added with AddListener have their own setting. It must be called before
+// Start.
+func (s *RaftServer) SetTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+// SetUsers requires clients to log in with AUTH as one of users, and limits
+// each to the commands and keys it is allowed. Nil, the default, lets every
+// client run every command. It must be called before Start.
+func (s *RaftServer) SetUsers(users *acl.Users) {
+	s.users = users
+}
+
+// SetAuditLog records every write clients send, with who sent it and its
+// outcome, in log. Nil, the default, records nothing. It must be called
+// before Start.
+func (s *RaftServer) SetAuditLog(log *audit.Log) {
+	s.auditLog = log
+}
+
+// auditor returns how to record the writes of the client at addr
+func (s *RaftServer) auditor(addr string) auditFunc {
+	return auditTo(s.auditLog, addr, s.leaderIndex)
+}
+
+// leaderIndex returns the raft index this node has applied while it leads,
+// or zero on a follower, whose writes were forwarded and applied elsewhere
+func (s *RaftServer) leaderIndex() uint64 {
+	if !s.store.IsLeader() {
+		return 0
+	}
+	return s.store.AppliedIndex()
+}
+
+// SetMaxRequestSize sets the longest request line in bytes the server
+// accepts. Longer requests are answered with a too_large error and skipped.
+// Zero disables the limit. It applies to connections accepted after the call.
+func (s *RaftServer) SetMaxRequestSize(size int) {
+	s.maxRequestSize = size
+}
 
-	s.isRunning = false
-	return s.listener.Close()
+// SetMaxRESPArgs sets the most arguments a RESP command may have, its name
+// included. A client sending more is answered with an error and disconnected,
+// as the rest of its input cannot be parsed. Zero disables the limit. It must
+// be called before StartRESP.
+func (s *RaftServer) SetMaxRESPArgs(n int) {
+	s.maxRESPArgs = n
 }
 
-func (s *RaftServer) acceptConnections() {
-	for s.isRunning {
-		conn, err := s.listener.Accept()
+// Shutdown stops accepting connections and lets open connections finish
+// their in-flight command for up to drainTimeout. The raft store is left
+// running and must be shut down separately.
+func (s *RaftServer) Shutdown(drainTimeout time.Duration) error {
+	if err := s.Stop(); err != nil {
+		return err
+	}
+
+	s.conns.drain(drainTimeout)
+	return nil
+}
+
+func (s *RaftServer) acceptConnections(l *cmdListener) {
+	for s.isRunning.Load() {
+		conn, err := l.Accept()
 		if err != nil {
-			if s.isRunning {
+			if s.isRunning.Load() {
 				fmt.Printf("Error accepting connection: %v\n", err)
 			}
 			continue
 		}
 
-		go s.handleConnection(conn)
+		if chaos.DropConnection() {
+			conn.Close()
+			continue
+		}
+
+		// The listener itself stays plain TCP so it can be handed over on
+		// a graceful restart
+		if l.tls != nil {
+			conn = tls.Server(conn, l.tls)
+		}
+
+		s.conns.add(conn)
+		go s.handleConnection(conn, l.users)
 	}
 }
 
-func (s *RaftServer) handleConnection(conn net.Conn) {
+// handleConnection serves the commands of one client, who must log in as
+// one of users unless it is nil
+func (s *RaftServer) handleConnection(conn net.Conn, users *acl.Users) {
+	defer s.conns.remove(conn)
 	defer conn.Close()
 
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		cmdText := scanner.Text()
-		if cmdText == "" {
+	out := &connWriter{conn: conn, timeout: s.writeTimeout, limit: s.outputBufferLimit, slow: &s.slowConsumers}
+	var sub *subscription
+	var up *upload
+	var tx *transaction
+	var user *acl.User // set by AUTH when the server has users
+	auditor := s.auditor(conn.RemoteAddr().String())
+	defer func() {
+		if sub != nil {
+			sub.stop()
+		}
+		if up != nil {
+			up.discard()
+		}
+	}()
+
+	reader := &requestReader{r: bufio.NewReader(conn), max: s.maxRequestSize, codec: wire.JSON}
+	for {
+		if s.idleTimeout > 0 {
+			// Subscribers only listen, so they may stay quiet for as long
+			// as they like
+			timeout := s.idleTimeout
+			if sub != nil {
+				timeout = 0
+			}
+			s.conns.setIdleTimeout(conn, timeout)
+		}
+
+		request, err := reader.next()
+		if errors.Is(err, errRequestTooLarge) {
+			sendResponse(out, Response{
+				Status:  "too_large",
+				Message: fmt.Sprintf("Request too large, the limit is %d bytes", s.maxRequestSize),
+			})
+			continue
+		}
+		if err != nil {
+			// Idle connections and those stopped by a drain run into their
+			// read deadline, which is not an error
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				fmt.Printf("Error reading from connection: %v\n", err)
+			}
+			return
+		}
+		if len(request) == 0 {
+			continue
+		}
+
+		if reader.codec.IsBatch(request) {
+			handlePipeline(request, reader.codec, sub != nil || tx != nil, out, authorized(users, &user, s.runCommand, auditor))
 			continue
 		}
 
 		var cmd Command
-		if err := json.Unmarshal([]byte(cmdText), &cmd); err != nil {
-			sendResponse(conn, Response{
+		if err := reader.codec.Unmarshal(request, &cmd); err != nil {
+			sendResponse(out, Response{
 				Status:  "error",
 				Message: "Invalid command format",
 			})
 			continue
 		}
+		decodeExpiry(&cmd)
+
+		if handleHello(cmd, conn, &s.conns, users != nil, sub != nil || tx != nil, reader, out) {
+			continue
+		}
 
-		resp := s.processCommand(cmd)
-		sendResponse(conn, resp)
+		if resp := authorize(users, &user, cmd); resp != nil {
+			// A refused command dooms the transaction it was sent in
+			if tx != nil && resp.Status != "success" {
+				tx.failed = true
+			}
+			if user != nil && resp.Status == "success" {
+				s.conns.update(conn, func(c *clientInfo) { c.User = user.Name })
+			}
+			auditor.record(user, cmd, *resp)
+			sendResponse(out, *resp)
+			continue
+		}
+
+		if handleSubscription(cmd, &sub, out, s.store.Watch) {
+			continue
+		}
+
+		if handleMulti(cmd, &tx, out, auditor.wrapExec(&user, s.exec)) {
+			continue
+		}
+
+		if handleChunked(cmd, &up, out, auditor.wrap(&user, s.processCommand)) {
+			continue
+		}
+
+		if err := decodeValues(&cmd); err != nil {
+			sendResponse(out, Response{Status: "error", Message: err.Error()})
+			continue
+		}
+
+		resp := s.runCommand(cmd)
+		auditor.record(user, cmd, resp)
+		if cmd.Chunked && strings.ToUpper(cmd.Op) == "GET" {
+			sendChunked(out, resp, cmd.Encoding)
+			continue
+		}
+		sendResponse(out, encodeValues(cmd.Encoding, resp))
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error reading from connection: %v\n", err)
+// redirectResponse sends the client to the leader
+func (s *RaftServer) redirectResponse() Response {
+	leader := s.store.GetLeader()
+	return Response{
+		Status:  "redirect",
+		Code:    CodeNotLeader,
+		Message: fmt.Sprintf("Not the leader, try: %s", leader),
+		Leader:  leader,
 	}
 }
 
+// leaderChanged readies forwarding for a new leader, so the first write a
+// follower forwards after an election need not wait for a connection
+func (s *RaftServer) leaderChanged(isLeader bool, leaderAddr string) {
+	if s.forwarder.timeout == 0 {
+		return
+	}
+	if isLeader || leaderAddr == "" {
+		s.forwarder.reset()
+		return
+	}
+	s.forwarder.warm(leaderAddr)
+}
+
+// SetForwarding makes a follower forward the writes it cannot apply to the
+// leader's TCP server and relay the leader's response, instead of
+// redirecting the client, giving up after timeout. Zero, the default,
+// redirects. Clients that follow redirects themselves send NoForward. It
+// must be called before Start.
+func (s *RaftServer) SetForwarding(timeout time.Duration) {
+	s.forwarder.timeout = timeout
+}
+
+// SetForwardTLSConfig makes a follower forward writes over TLS, configured
+// by cfg. It must be called before Start.
+func (s *RaftServer) SetForwardTLSConfig(cfg *tls.Config) {
+	s.forwarder.tls = cfg
+}
+
+// SetForwardAuth makes a follower log in to the leader as user to forward
+// writes. The follower has already checked the client may run them, so user
+// must be allowed every write clients send. It must be called before Start.
+func (s *RaftServer) SetForwardAuth(user, password string) {
+	s.forwarder.user = user
+	s.forwarder.password = password
+}
+
+// forward relays a write to the leader if forwarding is enabled and the
+// write is one to forward. It returns the response to send instead of
+// running the write here, or nil.
+func (s *RaftServer) forward(op string, cmd Command) *Response {
+	if s.forwarder.timeout == 0 || cmd.NoForward || cmd.Forwarded || cmd.Chunked || !forwardOps[op] || s.store.IsLeader() {
+		return nil
+	}
+
+	leader := s.store.GetLeader()
+	if leader == "" {
+		return nil
+	}
+	resp, err := s.forwarder.forward(leader, cmd)
+	if err != nil {
+		fmt.Printf("Error forwarding %s to %s: %v\n", op, leader, err)
+		resp = Response{Status: "error", Code: CodeInternal, Message: fmt.Sprintf("Failed to forward to the leader at %s: %v", leader, err)}
+	}
+	return &resp
+}
+
+// SetReadConsistency sets the consistency reads asking for
+// ConsistencyDefault are served at, ConsistencyStale unless set. It must be
+// called before Start.
+func (s *RaftServer) SetReadConsistency(level string) error {
+	if level != ConsistencyStale && level != ConsistencyLinearizable {
+		return fmt.Errorf("invalid read consistency %q, want stale or linearizable", level)
+	}
+	s.readConsistency = level
+	return nil
+}
+
+// verifyRead prepares the node to serve a read at the consistency it asks
+// for. A linearizable read is redirected by followers, and waits on the
+// leader until it has confirmed its leadership. It returns the response to
+// send instead of reading, or nil.
+func (s *RaftServer) verifyRead(cmd Command) *Response {
+	level, resp := readConsistency(cmd.Consistency, s.readConsistency)
+	if resp != nil || level != ConsistencyLinearizable {
+		return resp
+	}
+
+	if err := s.store.VerifyRead(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			resp := s.redirectResponse()
+			return &resp
+		}
+		resp := errorResponse(err)
+		return &resp
+	}
+	return nil
+}
+
+// writeError builds the response for a failed replicated write, redirecting
+// the client if this node is not the leader
+func (s *RaftServer) writeError(err error, applyTime time.Duration) Response {
+	if errors.Is(err, raft.ErrNotLeader) {
+		return s.redirectResponse()
+	}
+	resp := errorResponse(err)
+	resp.applyTime = applyTime
+	return resp
+}
+
+// Latencies returns the node's command latency histograms, which LATENCY
+// RESET clears
+func (s *RaftServer) Latencies() *metrics.Latencies {
+	return &s.latency
+}
+
+// runCommand processes a decoded command and records its latency
+func (s *RaftServer) runCommand(cmd Command) Response {
+	start := time.Now()
+	chaos.DelayCommand()
+	resp := s.processCommand(cmd)
+	recordLatency(&s.latency, cmd, resp, time.Since(start))
+	return resp
+}
+
 func (s *RaftServer) processCommand(cmd Command) Response {
-	switch strings.ToUpper(cmd.Op) {
+	op := strings.ToUpper(cmd.Op)
+	if err := checkLimits(op, cmd, s.store.Limits()); err != nil {
+		return errorResponse(err)
+	}
+	if readOps[op] {
+		if resp := s.verifyRead(cmd); resp != nil {
+			return *resp
+		}
+	}
+	if resp := s.forward(op, cmd); resp != nil {
+		return *resp
+	}
+
+	switch op {
 	case "SET":
 		if cmd.Key == "" {
 			return Response{Status: "error", Message: "Key is required"}
 		}
 
-		// Create value
-		value := store.Value{
-			Data:      cmd.Value,
-			ExpiresAt: time.Now().Add(cmd.ExpiresIn),
+		// A chunked SET only announces the value, so redirect before the
+		// client starts sending chunks to a node that cannot accept them
+		if cmd.Chunked {
+			if !s.store.IsLeader() {
+				return s.redirectResponse()
+			}
+			return Response{Status: "success"}
 		}
 
+		// A zero ExpiresIn means the key never expires
+		value := store.NewValue(cmd.Value, cmd.ExpiresIn)
+
+		applyStart := time.Now()
 		err := s.store.Set(cmd.Key, value)
+		applyTime := time.Since(applyStart)
 		if err != nil {
-			// If not the leader, inform client
-			if strings.Contains(err.Error(), "not the leader") {
-				leaderAddr := s.store.GetLeader()
-				return Response{
-					Status:  "redirect",
-					Message: fmt.Sprintf("Not the leader, try: %s", leaderAddr),
-				}
-			}
-			return Response{Status: "error", Message: err.Error()}
+			return s.writeError(err, applyTime)
 		}
 
-		return Response{Status: "success"}
+		return Response{Status: "success", applyTime: applyTime}
+
+	case "MSET":
+		values, resp := msetValues(cmd)
+		if resp != nil {
+			return *resp
+		}
+
+		applyStart := time.Now()
+		versions, err := s.store.MSet(values)
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Versions: versions, applyTime: applyTime}
+
+	case "MGET":
+		values := make(map[string]string, len(cmd.Keys))
+		for key, value := range s.store.MGet(cmd.Keys) {
+			values[key] = value.Data
+		}
+		return Response{Status: "success", Values: values, Missing: missingKeys(cmd.Keys, values)}
 
 	case "GET":
 		if cmd.Key == "" {
@@ -129,7 +584,10 @@ func (s *RaftServer) processCommand(cmd Command) Response {
 
 		value, exists := s.store.Get(cmd.Key)
 		if !exists {
-			return Response{Status: "error", Message: "Key not found"}
+			return notFoundResponse("Key not found")
+		}
+		if value.IsList() {
+			return errorResponse(store.ErrWrongType)
 		}
 
 		// Get TTL
@@ -137,25 +595,93 @@ func (s *RaftServer) processCommand(cmd Command) Response {
 
 		return Response{Status: "success", Value: value.Data, TTL: ttl}
 
+	case "GETMETA":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		value, exists := s.store.Get(cmd.Key)
+		if !exists {
+			return notFoundResponse("Key not found")
+		}
+
+		return metaResponse(value)
+
 	case "DELETE":
 		if cmd.Key == "" {
 			return Response{Status: "error", Message: "Key is required"}
 		}
 
+		applyStart := time.Now()
 		err := s.store.Delete(cmd.Key)
+		applyTime := time.Since(applyStart)
 		if err != nil {
-			// If not the leader, inform client
-			if strings.Contains(err.Error(), "not the leader") {
-				leaderAddr := s.store.GetLeader()
-				return Response{
-					Status:  "redirect",
-					Message: fmt.Sprintf("Not the leader, try: %s", leaderAddr),
-				}
-			}
-			return Response{Status: "error", Message: err.Error()}
+			return s.writeError(err, applyTime)
 		}
 
-		return Response{Status: "success"}
+		return Response{Status: "success", applyTime: applyTime}
+
+	case "EXISTS":
+		return existsResponse(cmd, s.store.Exists)
+
+	case "TYPE":
+		return typeResponse(cmd, s.store.Type)
+
+	case "SCANPREFIX":
+		return scanPrefixResponse(cmd, s.store.RangePrefix)
+
+	case "KEYS RANGE":
+		return keysRangeResponse(cmd, s.store.RangeSorted)
+
+	case "KEYS", "SCAN":
+		var resp Response
+		if op == "KEYS" {
+			resp = keysResponse(cmd, s.store.Scan)
+		} else {
+			resp = scanResponse(cmd, s.store.Scan)
+		}
+
+		// Both are read from this node's store, which a follower may not
+		// have caught up
+		if resp.Status != "success" {
+			return resp
+		}
+		resp.Role = "follower"
+		if s.store.IsLeader() {
+			resp.Role = "leader"
+		}
+		return resp
+
+	case "RANDOMKEY":
+		return randomKeyResponse(cmd, s.store.RandomKey, s.store.Sample)
+
+	case "DELPREFIX":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Prefix is required"}
+		}
+
+		applyStart := time.Now()
+		n, err := s.store.DeletePrefix(cmd.Key)
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Int: int64(n), applyTime: applyTime}
+
+	case "FLUSHALL":
+		if !cmd.Confirm {
+			return Response{Status: "error", Message: errFlushUnconfirmed}
+		}
+
+		applyStart := time.Now()
+		err := s.store.FlushAll()
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", applyTime: applyTime}
 
 	case "TTL":
 		if cmd.Key == "" {
@@ -164,22 +690,265 @@ func (s *RaftServer) processCommand(cmd Command) Response {
 
 		ttl, exists := s.store.TTL(cmd.Key)
 		if !exists {
-			return Response{Status: "error", Message: "Key not found or expired"}
+			return notFoundResponse("Key not found or expired")
 		}
 
 		return Response{Status: "success", TTL: ttl}
 
-	case "STATUS":
-		isLeader := s.store.IsLeader()
-		status := "follower"
-		if isLeader {
-			status = "leader"
+	case "SETNX":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applyStart := time.Now()
+		applied, err := s.store.SetNX(cmd.Key, store.NewValue(cmd.Value, cmd.ExpiresIn))
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Applied: applied, applyTime: applyTime}
+
+	case "CAS":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applyStart := time.Now()
+		version, err := s.store.CompareAndSwap(cmd.Key, cmd.Expected, store.NewValue(cmd.Value, cmd.ExpiresIn))
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Version: version, applyTime: applyTime}
+
+	case "INCR", "DECR", "INCRBY":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applyStart := time.Now()
+		n, err := s.store.IncrBy(cmd.Key, incrDelta(strings.ToUpper(cmd.Op), cmd.Delta))
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Int: n, applyTime: applyTime}
+
+	case "GETSET":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applyStart := time.Now()
+		old, existed, err := s.store.GetSet(cmd.Key, store.NewValue(cmd.Value, cmd.ExpiresIn))
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Value: old.Data, Existed: existed, applyTime: applyTime}
+
+	case "APPEND":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applyStart := time.Now()
+		n, err := s.store.Append(cmd.Key, store.NewValue(cmd.Value, cmd.ExpiresIn))
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Int: int64(n), applyTime: applyTime}
+
+	case "EXPIRE", "PERSIST":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		var applied bool
+		var err error
+		applyStart := time.Now()
+		if strings.ToUpper(cmd.Op) == "EXPIRE" {
+			applied, err = s.store.Expire(cmd.Key, cmd.ExpiresIn)
+		} else {
+			applied, err = s.store.Persist(cmd.Key)
+		}
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Applied: applied, applyTime: applyTime}
+
+	case "TOUCH":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applyStart := time.Now()
+		applied, err := s.store.Touch(cmd.Key, cmd.ExpiresIn)
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Applied: applied, applyTime: applyTime}
+
+	case "GETEX":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applyStart := time.Now()
+		value, ok, err := s.store.GetEx(cmd.Key, cmd.ExpiresIn)
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		if !ok {
+			return Response{Status: "error", Code: CodeNotFound, Message: "Key not found", applyTime: applyTime}
+		}
+		return Response{Status: "success", Value: value.Data, TTL: newTTL(cmd.ExpiresIn), applyTime: applyTime}
+
+	case "LPUSH", "RPUSH":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		push := s.store.RPush
+		if op == "LPUSH" {
+			push = s.store.LPush
+		}
+		applyStart := time.Now()
+		n, err := push(cmd.Key, cmd.Elements...)
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Int: int64(n), applyTime: applyTime}
+
+	case "LPOP", "RPOP":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
 		}
 
+		pop := s.store.RPop
+		if op == "LPOP" {
+			pop = s.store.LPop
+		}
+		applyStart := time.Now()
+		elems, err := pop(cmd.Key, popCount(cmd.Count))
+		applyTime := time.Since(applyStart)
+		if err != nil {
+			return s.writeError(err, applyTime)
+		}
+
+		return Response{Status: "success", Elements: elems, Int: int64(len(elems)), applyTime: applyTime}
+
+	case "LLEN":
+		return llenResponse(cmd, s.store.LLen)
+
+	case "LRANGE":
+		return lrangeResponse(cmd, s.store.LRange)
+
+	case "MEMORY USAGE":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		size, exists := s.store.MemoryUsage(cmd.Key)
+		if !exists {
+			return notFoundResponse("Key not found")
+		}
+
+		return Response{Status: "success", Size: size}
+
+	case "MEMORY STATS":
+		return memoryStatsResponse(s.store.MemoryStats(memoryTop(cmd.Count)))
+
+	case "STATS":
+		return statsResponse(s.store.Stats())
+
+	case "INFO":
+		return infoResponse(s.info())
+
+	case "AUDIT":
+		return auditResponse(s.auditLog, cmd.Count)
+
+	case "COMPACT":
+		// Each node compacts its own log; nothing goes through raft, so
+		// followers run it too
+		return s.jobs.start("compact", func() (string, error) {
+			before := s.store.LogSize()
+			if err := s.store.Compact(); err != nil {
+				return "", err
+			}
+			return compactMessage(before, s.store.LogSize()), nil
+		})
+
+	case "BGSAVE":
+		// A raft snapshot of the leader's state, which lets raft truncate
+		// its log
+		if !s.store.IsLeader() {
+			return s.redirectResponse()
+		}
+		return s.jobs.start("bgsave", func() (string, error) {
+			start := time.Now()
+			if err := s.store.TakeSnapshot(); err != nil {
+				return "", fmt.Errorf("raft snapshot failed: %w", err)
+			}
+			return fmt.Sprintf("Raft snapshot taken in %s", time.Since(start)), nil
+		})
+
+	case "TRANSFER":
+		// Moves the leadership off this node, to the node named by Key if
+		// set. Followers redirect it, though clients moving the leadership
+		// off a given node should not follow.
+		leaderID, err := s.store.TransferLeadership(cmd.Key)
+		if err != nil {
+			if errors.Is(err, raft.ErrNotLeader) {
+				return s.redirectResponse()
+			}
+			return errorResponse(err)
+		}
 		return Response{
 			Status:  "success",
-			Message: fmt.Sprintf("Node status: %s", status),
+			Value:   leaderID,
+			Message: fmt.Sprintf("Leadership transferred to %s at %s", leaderID, s.store.GetLeader()),
+		}
+
+	case "JOBSTATUS":
+		return s.jobs.statusResponse(cmd.Job)
+
+	case "LATENCY":
+		return latencyResponse(&s.latency)
+
+	case "LATENCY RESET":
+		s.latency.Reset()
+		return Response{Status: "success"}
+
+	case "STATUS":
+		return s.statusResponse()
+
+	case "CLIENT LIST":
+		return clientListResponse(&s.conns)
+
+	case "PING":
+		return Response{Status: "success", Message: "PONG"}
+
+	case "VERSION":
+		info, err := json.Marshal(version.Get())
+		if err != nil {
+			return errorResponse(err)
 		}
+		return Response{Status: "success", Value: string(info), Message: version.String()}
 
 	default:
 		return Response{Status: "error", Message: "Unknown command"}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/raft"
+)
+
+// raftNode is one node of a cluster started by startRaftCluster
+type raftNode struct {
+	store  *raft.RaftStore
+	server *RaftServer
+	addr   string // the address clients reach its server on
+}
+
+// freeAddr returns a localhost address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startRaftCluster starts n raft nodes, the first bootstrapping the cluster
+// and the others joining it, each serving clients once configure has set up
+// its server, and waits for every node to know the leader
+func startRaftCluster(t *testing.T, n int, configure func(*RaftServer)) []*raftNode {
+	t.Helper()
+
+	var nodes []*raftNode
+	for i := 0; i < n; i++ {
+		dir := t.TempDir()
+		node := &raftNode{addr: freeAddr(t)}
+		config := raft.Config{
+			NodeID:           fmt.Sprintf("node%d", i),
+			RaftDir:          dir,
+			RaftAddr:         freeAddr(t),
+			LogFilePath:      filepath.Join(dir, "kv.log"),
+			Bootstrap:        i == 0,
+			ClientAddr:       node.addr,
+			HeartbeatTimeout: 100 * time.Millisecond,
+			ElectionTimeout:  100 * time.Millisecond,
+			ApplyTimeout:     5 * time.Second,
+		}
+		rs, err := raft.NewRaftStore(config)
+		if err != nil {
+			t.Fatalf("failed to start %s: %v", config.NodeID, err)
+		}
+		node.store = rs
+		t.Cleanup(func() { rs.Shutdown() })
+
+		if i == 0 {
+			waitUntil(t, "a leader to be elected", rs.IsLeader)
+		} else if err := nodes[0].store.Join(config.NodeID, config.RaftAddr, node.addr, ""); err != nil {
+			t.Fatalf("failed to join %s: %v", config.NodeID, err)
+		}
+
+		node.server = NewRaftServer(node.addr, rs)
+		if configure != nil {
+			configure(node.server)
+		}
+		if err := node.server.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { node.server.Stop() })
+		nodes = append(nodes, node)
+	}
+
+	waitUntil(t, "every node to know the leader", func() bool {
+		for _, node := range nodes {
+			if node.store.GetLeader() == "" {
+				return false
+			}
+		}
+		return true
+	})
+	return nodes
+}
+
+// waitUntil polls cond until it holds, failing the test after ten seconds
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRaftLargeRequests(t *testing.T) {
+	nodes := startRaftCluster(t, 1, nil)
+	checkLargeRequests(t, nodes[0].addr)
+}
+
+func TestRaftRequestOverTheLimit(t *testing.T) {
+	nodes := startRaftCluster(t, 1, func(s *RaftServer) { s.SetMaxRequestSize(64 << 10) })
+	checkTooLargeRequest(t, nodes[0].addr, 64<<10)
+}
+
+func TestRaftIdleTimeout(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	nodes := startRaftCluster(t, 1, func(s *RaftServer) { s.SetIdleTimeout(timeout) })
+	checkIdleTimeout(t, nodes[0].addr, timeout)
+}
+
+func TestRaftStalledReaderIsDisconnected(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	nodes := startRaftCluster(t, 1, func(s *RaftServer) { s.SetWriteTimeout(timeout) })
+	s := nodes[0].server
+	checkStalledReader(t, nodes[0].addr, timeout, s.slowConsumers.Load, s.conns.count)
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+// replicationHeartbeat is how often an idle primary tells its replicas its
+// current log offset, which keeps the lag figure fresh and detects dead peers
+const replicationHeartbeat = time.Second
+
+// ReplicationRecord is one message of the stream a primary sends to a replica
+// after a REPLICATE command. Data holds a raw log record; heartbeats carry no
+// data. Offset is the primary log position just past Data.
+type ReplicationRecord struct {
+	Resync        bool   `json:"resync,omitempty"`
+	Offset        int64  `json:"offset"`
+	PrimaryOffset int64  `json:"primary_offset"`
+	Data          []byte `json:"data,omitempty"`
+}
+
+// replicationState tracks a replica's progress for STATUS
+type replicationState struct {
+	mu            sync.Mutex
+	conn          net.Conn
+	connected     bool
+	primaryOffset int64
+	lastContact   time.Time
+}
+
+// serveReplica streams log records starting at the offset a REPLICATE asks
+// for until the connection fails or the server stops. A replica whose log ID
+// or generation differ from this log's, or whose offset is not a valid
+// position in it, is sent the whole log after a resync marker: an offset
+// alone may land on a record boundary of a log that was since rewritten.
+func (s *Server) serveReplica(conn net.Conn, cmd Command) {
+	st := s.db.Store()
+	enc := json.NewEncoder(conn)
+
+	if err := enc.Encode(withCode(Response{Status: "success"})); err != nil {
+		return
+	}
+
+	offset, generation := cmd.Offset, st.LogGeneration()
+	var resync string
+	if logID := st.LogID(); cmd.LogID != logID || cmd.Generation != generation {
+		resync = fmt.Sprintf("has log %q generation %d rather than %q generation %d", cmd.LogID, cmd.Generation, logID, generation)
+	} else if !st.IsRecordBoundary(offset) {
+		resync = fmt.Sprintf("requested offset %d outside the log", offset)
+	}
+	if resync != "" {
+		fmt.Printf("Replica %s %s, starting full resync\n", conn.RemoteAddr(), resync)
+		offset = 0
+		if err := enc.Encode(ReplicationRecord{Resync: true, PrimaryOffset: st.LogSize()}); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+
+	for s.isRunning.Load() {
+		// Grab the notification channel before reading so an append that
+		// lands during the read is not missed
+		changed := st.LogChanged()
+
+		next, err := st.ReadLogFrom(generation, offset, func(record []byte, next int64) error {
+			return enc.Encode(ReplicationRecord{
+				Offset:        next,
+				PrimaryOffset: st.LogSize(),
+				Data:          record,
+			})
+		})
+		if errors.Is(err, store.ErrLogRewritten) {
+			// The log was compacted, so the replica starts over from the new one
+			fmt.Printf("Log compacted, starting full resync of replica %s\n", conn.RemoteAddr())
+			generation, offset = st.LogGeneration(), 0
+			if err := enc.Encode(ReplicationRecord{Resync: true, PrimaryOffset: st.LogSize()}); err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Replication to %s stopped: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		offset = next
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			if err := enc.Encode(ReplicationRecord{Offset: offset, PrimaryOffset: st.LogSize()}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replicate keeps a replica in sync with its primary, reconnecting and
+// resuming from the last applied offset whenever the stream breaks
+func (s *Server) replicate() {
+	for s.isRunning.Load() {
+		if err := s.replicateOnce(); err != nil && s.isRunning.Load() {
+			fmt.Printf("Replication from %s interrupted: %v\n", s.replicaOf, err)
+		}
+
+		s.replication.mu.Lock()
+		s.replication.connected = false
+		s.replication.conn = nil
+		s.replication.mu.Unlock()
+
+		time.Sleep(time.Second)
+	}
+}
+
+func (s *Server) replicateOnce() error {
+	var conn net.Conn
+	var err error
+	if s.replicaTLS != nil {
+		conn, err = tls.Dial("tcp", s.replicaOf, s.replicaTLS)
+	} else {
+		conn, err = net.Dial("tcp", s.replicaOf)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.replication.mu.Lock()
+	s.replication.conn = conn
+	s.replication.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	if s.replicaUser != "" {
+		resp, err := replicationHandshake(conn, reader, Command{Op: "AUTH", Key: s.replicaUser, Value: s.replicaPassword})
+		if err != nil {
+			return err
+		}
+		if resp.Status != "success" {
+			return fmt.Errorf("primary refused login: %s", resp.Message)
+		}
+	}
+
+	// The replica's log mirrors the primary's byte for byte, ID record
+	// included, so its size is the offset to resume from and its ID and
+	// generation tell the primary whether that offset is still valid
+	st := s.db.Store()
+	resp, err := replicationHandshake(conn, reader, Command{
+		Op:         "REPLICATE",
+		Offset:     st.LogSize(),
+		LogID:      st.LogID(),
+		Generation: st.LogGeneration(),
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != "success" {
+		return fmt.Errorf("primary refused replication: %s", resp.Message)
+	}
+
+	s.replication.mu.Lock()
+	s.replication.connected = true
+	s.replication.mu.Unlock()
+	fmt.Printf("Replicating from %s at offset %d\n", s.replicaOf, st.LogSize())
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+
+		var rec ReplicationRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("invalid replication record: %w", err)
+		}
+
+		if rec.Resync {
+			fmt.Printf("Primary %s requested a full resync\n", s.replicaOf)
+			if err := st.Reset(); err != nil {
+				return fmt.Errorf("failed to reset store for resync: %w", err)
+			}
+		}
+		if len(rec.Data) > 0 {
+			if err := st.ApplyLogRecord(rec.Data); err != nil {
+				return fmt.Errorf("failed to apply record at offset %d: %w", rec.Offset, err)
+			}
+		}
+
+		s.replication.mu.Lock()
+		s.replication.primaryOffset = rec.PrimaryOffset
+		s.replication.lastContact = time.Now()
+		s.replication.mu.Unlock()
+	}
+}
+
+// replicationStatus describes the server's role and, for replicas, how far
+// behind the primary they are
+func (s *Server) replicationStatus() string {
+	st := s.db.Store()
+	if s.replicaOf == "" {
+		return fmt.Sprintf("Role: primary, log offset: %d", st.LogSize())
+	}
+
+	s.replication.mu.Lock()
+	defer s.replication.mu.Unlock()
+
+	if !s.replication.connected {
+		return fmt.Sprintf("Role: replica of %s, disconnected, log offset: %d", s.replicaOf, st.LogSize())
+	}
+
+	lag := s.replication.primaryOffset - st.LogSize()
+	if lag < 0 {
+		lag = 0
+	}
+	return fmt.Sprintf("Role: replica of %s, lag: %d bytes, last contact: %s ago",
+		s.replicaOf, lag, time.Since(s.replication.lastContact).Round(time.Millisecond))
+}
+
+// replicationHandshake sends cmd to the primary and reads its response
+func replicationHandshake(conn net.Conn, reader *bufio.Reader, cmd Command) (Response, error) {
+	req, err := json.Marshal(cmd)
+	if err != nil {
+		return Response{}, err
+	}
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		return Response{}, err
+	}
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return Response{}, fmt.Errorf("invalid replication handshake: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,485 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/handover"
+	"github.com/pixperk/yakvs/resp"
+)
+
+// DefaultMaxRESPArgs is the most arguments a RESP command may have unless
+// SetMaxRESPArgs says otherwise
+const DefaultMaxRESPArgs = resp.DefaultMaxArgs
+
+// respListener serves Redis clients the subset of RESP2 commands that map
+// onto yakvs: GET, SET, DEL, TTL, EXPIRE, EXISTS, PING, INFO and AUTH. Each
+// one is translated into a Command and processed like any other, so
+// redirects, permissions, limits and latency stats apply to it too.
+type respListener struct {
+	listener net.Listener
+	running  atomic.Bool
+	conns    *connTracker
+	run      func(Command) Response
+	users    *acl.Users // nil unless clients must AUTH
+	auditor  func(addr string) auditFunc
+
+	tlsConfig    *tls.Config
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	maxSize      int
+	maxArgs      int
+	slow         *atomic.Uint64
+}
+
+// listenRESP starts serving RESP on addr, or on the listener inherited from
+// the previous process during a graceful restart
+func listenRESP(addr string, l *respListener) error {
+	listener, err := handover.Listen("resp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	l.listener = listener
+	l.running.Store(true)
+	fmt.Printf("RESP listener started on %s\n", addr)
+
+	go l.accept()
+	return nil
+}
+
+// close stops accepting connections; open ones are drained with the server's
+func (l *respListener) close() error {
+	if l == nil || !l.running.Swap(false) {
+		return nil
+	}
+	return l.listener.Close()
+}
+
+func (l *respListener) accept() {
+	for l.running.Load() {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if l.running.Load() {
+				fmt.Printf("Error accepting RESP connection: %v\n", err)
+			}
+			continue
+		}
+
+		if l.tlsConfig != nil {
+			conn = tls.Server(conn, l.tlsConfig)
+		}
+
+		l.conns.add(conn)
+		go l.handle(conn)
+	}
+}
+
+func (l *respListener) handle(conn net.Conn) {
+	defer l.conns.remove(conn)
+	defer conn.Close()
+
+	var user *acl.User
+	run := authorized(l.users, &user, l.run, l.auditor(conn.RemoteAddr().String()))
+
+	r := resp.NewReader(conn, l.maxSize)
+	r.SetMaxArgs(l.maxArgs)
+	w := resp.NewWriter(&connWriter{conn: conn, timeout: l.writeTimeout, slow: l.slow})
+	for {
+		if l.idleTimeout > 0 {
+			l.conns.setIdleTimeout(conn, l.idleTimeout)
+		}
+
+		args, err := r.ReadCommand()
+		if errors.Is(err, resp.ErrProtocol) || errors.Is(err, resp.ErrTooLarge) {
+			// The rest of the stream cannot be parsed, so Redis also
+			// replies and hangs up
+			w.Error("ERR " + err.Error())
+			w.Flush()
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				fmt.Printf("Error reading from RESP connection: %v\n", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := execRESP(args, run, w)
+
+		// Pipelined commands get their replies in one write
+		if !r.Buffered() || quit {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// execRESP runs one RESP command and writes its reply, reporting whether the
+// client asked to close the connection
+func execRESP(args []string, run func(Command) Response, w *resp.Writer) bool {
+	typed := args[0]
+	name := strings.ToUpper(typed)
+	args = args[1:]
+
+	switch name {
+	case "PING":
+		switch len(args) {
+		case 0:
+			w.SimpleString("PONG")
+		case 1:
+			w.Bulk(args[0])
+		default:
+			wrongArgs(w, name)
+		}
+
+	case "GET":
+		if len(args) != 1 {
+			wrongArgs(w, name)
+			return false
+		}
+		reply := run(Command{Op: "GET", Key: args[0]})
+		switch {
+		case reply.Status == "success":
+			w.Bulk(reply.Value)
+		case reply.Code == CodeNotFound:
+			w.Null()
+		default:
+			respError(w, reply)
+		}
+
+	case "SET":
+		respSet(args, run, w)
+
+	case "DEL":
+		if len(args) == 0 {
+			wrongArgs(w, name)
+			return false
+		}
+		n, reply := deleteKeys(args, run)
+		if reply.Status != "success" {
+			respError(w, reply)
+			return false
+		}
+		w.Integer(n)
+
+	case "TTL":
+		if len(args) != 1 {
+			wrongArgs(w, name)
+			return false
+		}
+		reply := run(Command{Op: "TTL", Key: args[0]})
+		switch {
+		case reply.Status == "success" && reply.TTL < 0:
+			w.Integer(-1)
+		case reply.Status == "success":
+			w.Integer(int64(reply.TTL.Round(time.Second) / time.Second))
+		case reply.Code == CodeNotFound:
+			w.Integer(-2)
+		default:
+			respError(w, reply)
+		}
+
+	case "EXPIRE":
+		if len(args) != 2 {
+			wrongArgs(w, name)
+			return false
+		}
+		seconds, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			w.Error("ERR value is not an integer or out of range")
+			return false
+		}
+
+		// Redis deletes a key given a deadline in the past, while a zero
+		// EXPIRE would make the key persistent here
+		if seconds <= 0 {
+			n, reply := deleteKeys(args[:1], run)
+			if reply.Status != "success" {
+				respError(w, reply)
+				return false
+			}
+			w.Integer(n)
+			return false
+		}
+
+		reply := run(Command{Op: "EXPIRE", Key: args[0], ExpiresIn: time.Duration(seconds) * time.Second})
+		if reply.Status != "success" {
+			respError(w, reply)
+			return false
+		}
+		w.Integer(boolInt(reply.Applied))
+
+	case "EXISTS":
+		if len(args) == 0 {
+			wrongArgs(w, name)
+			return false
+		}
+		reply := run(Command{Op: "EXISTS", Keys: args})
+		if reply.Status != "success" {
+			respError(w, reply)
+			return false
+		}
+		w.Integer(reply.Int)
+
+	case "INFO":
+		if len(args) > 1 {
+			wrongArgs(w, name)
+			return false
+		}
+		reply := run(Command{Op: "INFO"})
+		if reply.Status != "success" {
+			respError(w, reply)
+			return false
+		}
+		var i info
+		if err := json.Unmarshal([]byte(reply.Value), &i); err != nil {
+			w.Error("ERR " + err.Error())
+			return false
+		}
+		section := ""
+		if len(args) == 1 {
+			section = args[0]
+		}
+		w.Bulk(formatRESPInfo(i, section))
+
+	case "AUTH":
+		// A password alone logs in as the default user, as in Redis
+		var cmd Command
+		switch len(args) {
+		case 1:
+			cmd = Command{Op: "AUTH", Key: "default", Value: args[0]}
+		case 2:
+			cmd = Command{Op: "AUTH", Key: args[0], Value: args[1]}
+		default:
+			wrongArgs(w, name)
+			return false
+		}
+		if reply := run(cmd); reply.Status != "success" {
+			respError(w, reply)
+			return false
+		}
+		w.SimpleString("OK")
+
+	case "COMMAND":
+		// redis-cli asks for command docs on start and copes without them
+		w.Array(0)
+
+	case "QUIT":
+		w.SimpleString("OK")
+		return true
+
+	default:
+		w.Error(unknownCommand(typed, args))
+	}
+	return false
+}
+
+// maxEchoed caps the bytes of the name, and of the arguments, that an
+// unknown command's error echoes back
+const maxEchoed = 128
+
+// unknownCommand returns the error for an unknown command, echoing its name
+// and arguments cut short the way Redis does, so a huge command does not
+// come back in full
+func unknownCommand(name string, args []string) string {
+	var echoed strings.Builder
+	for _, arg := range args {
+		if echoed.Len() >= maxEchoed {
+			break
+		}
+		echoed.WriteString("'" + truncateEcho(arg, maxEchoed-echoed.Len()) + "' ")
+	}
+	return fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", truncateEcho(name, maxEchoed), echoed.String())
+}
+
+// truncateEcho shortens s to at most n bytes
+func truncateEcho(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// respSet handles SET key value [EX seconds | PX milliseconds] [NX]
+func respSet(args []string, run func(Command) Response, w *resp.Writer) {
+	if len(args) < 2 {
+		wrongArgs(w, "SET")
+		return
+	}
+
+	cmd := Command{Op: "SET", Key: args[0], Value: args[1]}
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "EX", "PX":
+			if i+1 == len(args) || cmd.ExpiresIn != 0 {
+				w.Error("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				w.Error("ERR value is not an integer or out of range")
+				return
+			}
+			if n <= 0 {
+				w.Error("ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			cmd.ExpiresIn = time.Duration(n) * unit
+			i++
+		case "NX":
+			cmd.Op = "SETNX"
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+	}
+
+	reply := run(cmd)
+	switch {
+	case reply.Status != "success":
+		respError(w, reply)
+	case cmd.Op == "SETNX" && !reply.Applied:
+		w.Null()
+	default:
+		w.SimpleString("OK")
+	}
+}
+
+// deleteKeys deletes keys one by one and returns how many existed, or the
+// first failed response. Checking first is not atomic with the delete, so a
+// key written in between may be miscounted.
+func deleteKeys(keys []string, run func(Command) Response) (int64, Response) {
+	var n int64
+	for _, key := range keys {
+		exists := run(Command{Op: "EXISTS", Key: key})
+		if exists.Status != "success" {
+			return 0, exists
+		}
+		if !exists.Exists {
+			continue
+		}
+
+		if reply := run(Command{Op: "DELETE", Key: key}); reply.Status != "success" {
+			return 0, reply
+		}
+		n++
+	}
+	return n, Response{Status: "success"}
+}
+
+// respErrorPrefixes are the error codes Redis clients know for our codes
+var respErrorPrefixes = map[string]string{
+	CodeWrongType:   "WRONGTYPE",
+	CodeReadOnly:    "READONLY",
+	CodeOutOfMemory: "OOM",
+	CodeNotLeader:   "NOTLEADER",
+
+	CodeUnauthenticated:  "NOAUTH",
+	CodePermissionDenied: "NOPERM",
+}
+
+// respError writes a failed response as an error reply
+func respError(w *resp.Writer, r Response) {
+	prefix, ok := respErrorPrefixes[withCode(r).Code]
+	if !ok {
+		prefix = "ERR"
+	}
+	w.Error(prefix + " " + r.Message)
+}
+
+func wrongArgs(w *resp.Writer, name string) {
+	w.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// infoSection is one "# Name" block of a RESP INFO reply
+type infoSection struct {
+	name   string
+	fields [][2]string
+}
+
+// formatRESPInfo renders INFO the way Redis does: "# Section" headers
+// followed by field:value lines. section picks one section by name, or all
+// of them if it is empty, "all" or "default".
+func formatRESPInfo(i info, section string) string {
+	var total uint64
+	for _, n := range i.Commands {
+		total += n
+	}
+
+	sections := []infoSection{
+		{"Server", [][2]string{
+			{"yakvs_version", i.Server.Version},
+			{"tcp_address", i.Server.Address},
+			{"uptime_in_seconds", strconv.FormatInt(int64(i.Server.Uptime/time.Second), 10)},
+		}},
+		{"Clients", [][2]string{
+			{"connected_clients", strconv.Itoa(i.Server.Connections)},
+		}},
+		{"Memory", [][2]string{
+			{"used_memory", strconv.FormatInt(i.Store.MemoryBytes, 10)},
+		}},
+		{"Stats", [][2]string{
+			{"total_commands_processed", strconv.FormatUint(total, 10)},
+			{"instantaneous_ops_per_sec", strconv.FormatFloat(i.Server.OpsPerSec, 'f', 0, 64)},
+			{"expired_keys", strconv.FormatUint(i.Store.ExpiredKeys, 10)},
+			{"evicted_keys", strconv.FormatUint(i.Store.EvictedKeys, 10)},
+		}},
+		{"Replication", [][2]string{
+			{"role", i.Server.Role},
+		}},
+		{"Keyspace", [][2]string{
+			{"db0", fmt.Sprintf("keys=%d", i.Store.Keys)},
+		}},
+	}
+	if i.Raft != nil {
+		sections = append(sections, infoSection{"Raft", [][2]string{
+			{"raft_state", i.Raft.State},
+			{"raft_term", strconv.FormatUint(i.Raft.Term, 10)},
+			{"raft_leader", i.Raft.Leader},
+			{"raft_last_index", strconv.FormatUint(i.Raft.LastIndex, 10)},
+			{"raft_applied_index", strconv.FormatUint(i.Raft.AppliedIndex, 10)},
+		}})
+	}
+
+	all := section == "" || strings.EqualFold(section, "all") || strings.EqualFold(section, "default")
+	var b strings.Builder
+	for _, s := range sections {
+		if !all && !strings.EqualFold(section, s.name) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + s.name + "\r\n")
+		for _, f := range s.fields {
+			b.WriteString(f[0] + ":" + f[1] + "\r\n")
+		}
+	}
+	return b.String()
+}
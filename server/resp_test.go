@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/resp"
+)
+
+func TestRESPUnknownCommandEchoIsTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := resp.NewWriter(&buf)
+	long := strings.Repeat("x", 1000)
+	execRESP([]string{"nope" + long, "a", long, "b"}, nil, w)
+	w.Flush()
+
+	want := "-ERR unknown command 'nope" + long[:124] + "', with args beginning with: 'a' '" + long[:124] + "' \r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unknown command reply = %q, want %q", got, want)
+	}
+}
+
+func TestRESPMaxArgs(t *testing.T) {
+	s := startTestServer(t, func(s *Server) { s.SetMaxRESPArgs(3) })
+	addr := freeAddr(t)
+	if err := s.StartRESP(addr); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	io.WriteString(conn, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n")
+	if line, _ := r.ReadString('\n'); line != "+OK\r\n" {
+		t.Fatalf("SET at the argument limit = %q", line)
+	}
+
+	// Announcing too many arguments is refused before any is read
+	io.WriteString(conn, "*4\r\n")
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "-ERR request too large") {
+		t.Errorf("command over the argument limit = %q", line)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("read after the refused command = %v, want io.EOF", err)
+	}
+}
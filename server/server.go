@@ -2,148 +2,862 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/pixperk/yakvs"
+	"github.com/pixperk/yakvs/acl"
+	"github.com/pixperk/yakvs/audit"
+	"github.com/pixperk/yakvs/chaos"
+	"github.com/pixperk/yakvs/metrics"
 	"github.com/pixperk/yakvs/store"
+	"github.com/pixperk/yakvs/version"
+	"github.com/pixperk/yakvs/wire"
 )
 
 type Server struct {
-	store     *store.Store
+	db        *yakvs.DB
 	addr      string
-	listener  net.Listener
-	isRunning bool
+	listeners []*cmdListener // the server's own address first
+	extra     []ListenerSpec
+	isRunning atomic.Bool
+	started   time.Time
+	conns     connTracker
+	latency   metrics.Latencies
+	jobs      jobTracker // COMPACT and BGSAVE
+
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxRequestSize    int
+	maxRESPArgs       int
+	outputBufferLimit int
+	tlsConfig         *tls.Config // nil serves plain TCP
+	slowConsumers     atomic.Uint64
+	resp              *respListener // nil unless StartRESP was called
+	grpc              *grpcListener // nil unless StartGRPC was called
+	users             *acl.Users    // nil lets every client run every command
+	auditLog          *audit.Log    // nil records nothing
+
+	// replicaOf is the primary's address when running as a read-only replica,
+	// dialed over TLS if replicaTLS is set
+	replicaOf   string
+	replicaTLS  *tls.Config
+	replication replicationState
+
+	// replicaUser and replicaPassword log a replica in to its primary
+	replicaUser     string
+	replicaPassword string
+
+	// snapshotPath is where BGSAVE writes
+	snapshotPath string
 }
 
 type Command struct {
-	Op        string        `json:"op"`
-	Key       string        `json:"key"`
-	Value     string        `json:"value,omitempty"`
-	ExpiresIn time.Duration `json:"expires_in,omitempty"`
+	Op        string            `json:"op"`
+	Key       string            `json:"key"`
+	Keys      []string          `json:"keys,omitempty"`  // further keys for multi-key commands
+	Pairs     map[string]string `json:"pairs,omitempty"` // the keys and values of an MSET
+	Value     string            `json:"value,omitempty"`
+	ExpiresIn time.Duration     `json:"expires_in,omitempty"` // what commands run with, see ExpiresInMs
+	Offset    int64             `json:"offset,omitempty"`
+	Expected  string            `json:"expected,omitempty"` // the value CAS compares against
+	Delta     int64             `json:"delta,omitempty"`    // the amount INCRBY adds
+	Count     int               `json:"count,omitempty"`
+	End       string            `json:"end,omitempty"`      // the exclusive upper bound of KEYS RANGE
+	Elements  []string          `json:"elements,omitempty"` // the elements LPUSH or RPUSH adds
+	Start     int               `json:"start,omitempty"`    // the first index LRANGE returns
+	Stop      int               `json:"stop,omitempty"`     // the last index LRANGE returns
+	Confirm   bool              `json:"confirm,omitempty"`  // must be set for FLUSHALL
+	Cursor    string            `json:"cursor,omitempty"`   // where SCAN resumes, empty to start
+	Protocol  int               `json:"protocol,omitempty"` // the newest revision a HELLO speaks
+	Name      string            `json:"name,omitempty"`     // the client name HELLO records
+	Framing   string            `json:"framing,omitempty"`  // the codec HELLO switches to, "json" or "msgpack"
+	Job       uint64            `json:"job,omitempty"`      // the job JOBSTATUS reports on
+
+	// Entries are further keys of an MSET, each with its own value and
+	// expiry, set in the same batch as Pairs
+	Entries []Entry `json:"entries,omitempty"`
+
+	// ExpiresInMs is the expiry in milliseconds, zero meaning none, which
+	// decodeExpiry copies to ExpiresIn. Clients before protocol 6 send
+	// expires_in in nanoseconds instead, still accepted for one release.
+	ExpiresInMs int64 `json:"expires_in_ms,omitempty"`
+
+	// Consistency is the consistency a read asks for, one of the
+	// Consistency constants, empty meaning ConsistencyDefault
+	Consistency string `json:"consistency,omitempty"`
+
+	// NoForward asks a raft follower to redirect a write rather than
+	// forward it to the leader. Forwarded marks a write a follower forwarded,
+	// which is never forwarded again.
+	NoForward bool `json:"no_forward,omitempty"`
+	Forwarded bool `json:"forwarded,omitempty"`
+
+	// Encoding is "base64" when Value, Expected, Pairs and Elements are
+	// base64 encoded, which also asks for the values in the response to be
+	// encoded
+	Encoding string `json:"encoding,omitempty"`
+
+	// Chunked marks a SET whose value follows in CHUNK frames totalling Size
+	// bytes, or a GET whose client accepts a chunked reply. Final marks the
+	// last CHUNK frame.
+	Chunked bool  `json:"chunked,omitempty"`
+	Size    int64 `json:"size,omitempty"`
+	Final   bool  `json:"final,omitempty"`
+
+	// LogID and Generation identify the log a REPLICATE's Offset points
+	// into, as the replica's copy of the primary's log ID record gives them
+	LogID      string `json:"log_id,omitempty"`
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+// Entry is one key of an MSET with its own expiry in milliseconds, zero
+// meaning none
+type Entry struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ExpiresInMs int64  `json:"expires_in_ms,omitempty"`
 }
 
+// Response reports the outcome of a command. Status is "success", "error",
+// "redirect" on a raft follower, "conflict" for a failed CAS, "too_large"
+// for a key or value over the server's limits, "out_of_memory" for a write
+// over the memory limit, "not_integer" for INCR on a value that is not an
+// integer, or "wrong_type" for a list command on a plain value or the other
+// way round. Code classifies the outcome for programs, one of the Code
+// constants, while Message is meant for humans.
 type Response struct {
 	Status  string        `json:"status"`
+	Code    string        `json:"code,omitempty"`
 	Message string        `json:"message,omitempty"`
 	Value   string        `json:"value,omitempty"`
-	TTL     time.Duration `json:"ttl,omitempty"`
+	TTL     time.Duration `json:"ttl,omitempty"` // -1 for keys without expiry
+
+	// TTLMs is TTL in milliseconds, -1 for keys without expiry, filled in
+	// as the response is sent. Responses still carry TTL as ttl in
+	// nanoseconds for clients before protocol 6, for one release.
+	TTLMs int64 `json:"ttl_ms,omitempty"`
+
+	// Leader is the address to resend a NOT_LEADER write to, empty while no
+	// leader is known
+	Leader string `json:"leader,omitempty"`
+
+	// Encoding is "base64" when Value, Values and Elements are base64
+	// encoded, as asked for by the command
+	Encoding string `json:"encoding,omitempty"`
+
+	// Applied reports whether a conditional write such as SETNX or EXPIRE
+	// took effect
+	Applied bool `json:"applied,omitempty"`
+	// Int is the integer result of commands such as INCR
+	Int int64 `json:"int,omitempty"`
+	// Existed reports whether GETSET replaced an existing value
+	Existed bool `json:"existed,omitempty"`
+	// Exists reports whether every key given to EXISTS is present, with the
+	// number present in Int
+	Exists bool `json:"exists,omitempty"`
+	// Keys lists the keys matched by SCANPREFIX, KEYS RANGE, KEYS or SCAN,
+	// or picked by RANDOMKEY
+	Keys []string `json:"keys,omitempty"`
+	// Cursor is where the next SCAN resumes, empty once every key has been
+	// seen. KEYS sets it when its reply was cut short.
+	Cursor string `json:"cursor,omitempty"`
+	// Role is "leader" or "follower" on a raft node's KEYS and SCAN replies,
+	// which are read locally and may be stale on a follower
+	Role string `json:"role,omitempty"`
+	// Values holds the keys found by MGET and their values, and Missing
+	// the keys it did not find, in the order they were asked for
+	Values  map[string]string `json:"values,omitempty"`
+	Missing []string          `json:"missing,omitempty"`
+	// Elements lists the elements removed by LPOP or RPOP, or read by LRANGE
+	Elements []string `json:"elements,omitempty"`
+	// Versions holds the version each key of a raft MSET was given
+	Versions map[string]uint64 `json:"versions,omitempty"`
+	// Version is the key's version after CAS, or as read by GETMETA, which
+	// also reports when the key was created and last written
+	Version   uint64     `json:"version,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+
+	// Chunked marks a GET reply whose value of Size bytes follows in frames
+	// carrying base64 segments in Value, the last one with Final set
+	Chunked bool  `json:"chunked,omitempty"`
+	Size    int64 `json:"size,omitempty"`
+	Final   bool  `json:"final,omitempty"`
+
+	// applyTime is how long a raft write spent in Apply. It is only used for
+	// latency stats and never sent.
+	applyTime time.Duration
+}
+
+// writeOps are the commands a read-only replica refuses
+var writeOps = map[string]bool{
+	"SET":       true,
+	"DELETE":    true,
+	"SETNX":     true,
+	"CAS":       true,
+	"INCR":      true,
+	"DECR":      true,
+	"INCRBY":    true,
+	"APPEND":    true,
+	"TOUCH":     true,
+	"GETEX":     true,
+	"GETSET":    true,
+	"LPUSH":     true,
+	"RPUSH":     true,
+	"LPOP":      true,
+	"RPOP":      true,
+	"DELPREFIX": true,
+	"FLUSHALL":  true,
+	"MSET":      true,
+	"EXPIRE":    true,
+	"PERSIST":   true,
+	"COMPACT":   true,
+	"RESTORE":   true,
+}
+
+// readOps read keys, which a raft node serves at the consistency the
+// command asks for
+var readOps = map[string]bool{
+	"GET":          true,
+	"GETMETA":      true,
+	"MGET":         true,
+	"EXISTS":       true,
+	"TYPE":         true,
+	"TTL":          true,
+	"SCANPREFIX":   true,
+	"KEYS RANGE":   true,
+	"KEYS":         true,
+	"SCAN":         true,
+	"RANDOMKEY":    true,
+	"LLEN":         true,
+	"LRANGE":       true,
+	"MEMORY USAGE": true,
+}
+
+// Read consistencies a command may ask for
+const (
+	// ConsistencyDefault leaves it up to the server, which reads stale
+	// unless a raft server was configured otherwise
+	ConsistencyDefault = "default"
+	// ConsistencyStale reads the node's own state, which on a raft follower
+	// or replica may lag writes already acknowledged
+	ConsistencyStale = "stale"
+	// ConsistencyLinearizable reads on the raft leader once it has confirmed
+	// it still leads, seeing every write committed before the read
+	ConsistencyLinearizable = "linearizable"
+)
+
+// readConsistency resolves the consistency a read asked for against the
+// server's default, returning a response to send instead for an unknown
+// one
+func readConsistency(asked, fallback string) (string, *Response) {
+	switch strings.ToLower(asked) {
+	case "", ConsistencyDefault:
+		return fallback, nil
+	case ConsistencyStale:
+		return ConsistencyStale, nil
+	case ConsistencyLinearizable:
+		return ConsistencyLinearizable, nil
+	}
+	return "", &Response{Status: "error", Message: fmt.Sprintf("Unknown consistency %q, want default, stale or linearizable", asked)}
 }
 
 func NewServer(addr string, logFilePath string) (*Server, error) {
-	s, err := store.NewStore(logFilePath)
+	return newServer(addr, logFilePath, "", yakvs.Options{})
+}
+
+// NewReplicaServer creates a read-only server that asynchronously replicates
+// the log of the primary server at primaryAddr
+func NewReplicaServer(addr, logFilePath, primaryAddr string) (*Server, error) {
+	return newServer(addr, logFilePath, primaryAddr, yakvs.Options{})
+}
+
+// NewServerWithOptions creates a server, or a replica of primaryAddr if it is
+// not empty, whose store is opened with opts. The server sets the log path
+// and expiry handling itself, so only the other fields of opts are used, and
+// a zero CleanerInterval means every 10 seconds. An empty logFilePath keeps
+// the data only in memory, which a replica cannot do. A replica needs the same
+// encryption key as its primary, and never runs a cleaner, evicts keys or
+// compacts its log on its own.
+func NewServerWithOptions(addr, logFilePath, primaryAddr string, opts yakvs.Options) (*Server, error) {
+	return newServer(addr, logFilePath, primaryAddr, opts)
+}
+
+func newServer(addr, logFilePath, replicaOf string, opts yakvs.Options) (*Server, error) {
+	// Replicas apply the primary's expiry deletes instead of running their
+	// own cleaner or deleting on read, which keeps their log identical to
+	// the primary's. Their only writes come from the primary, so the memory
+	// limit never evicts anything either, and compacting would invalidate
+	// the offset they stream from.
+	if opts.CleanerInterval == 0 {
+		opts.CleanerInterval = 10 * time.Second
+	}
+	if replicaOf != "" {
+		opts.CleanerInterval = 0
+		opts.AutoCompaction = &store.AutoCompaction{}
+	}
+
+	// Without a log file the data is kept only in memory, and there is no
+	// log for a replica to stream into or for BGSAVE to sit next to
+	var snapshotPath string
+	if logFilePath != "" {
+		opts.Path = filepath.Dir(logFilePath)
+		opts.LogFileName = filepath.Base(logFilePath)
+		opts.Persistence = true
+		snapshotPath = logFilePath + ".snapshot"
+	} else if replicaOf != "" {
+		return nil, fmt.Errorf("a replica needs a log file")
+	}
+	opts.KeepExpired = replicaOf != ""
+	db, err := yakvs.Open(opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create store: %w", err)
+		return nil, err
+	}
+
+	if opts.Persistence {
+		replay := db.Store().ReplayStats()
+		fmt.Printf("Replayed %d log records from %s, discarded %d, skipped %d corrupt\n", replay.Replayed, logFilePath, replay.Discarded, replay.Skipped)
+	} else {
+		fmt.Println("Persistence is disabled, data is kept only in memory")
 	}
 
 	return &Server{
-		store: s,
-		addr:  addr,
+		db:                db,
+		addr:              addr,
+		replicaOf:         replicaOf,
+		writeTimeout:      DefaultWriteTimeout,
+		maxRequestSize:    DefaultMaxRequestSize,
+		maxRESPArgs:       DefaultMaxRESPArgs,
+		outputBufferLimit: DefaultOutputBufferLimit,
+		snapshotPath:      snapshotPath,
 	}, nil
 }
 
 func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", s.addr)
+	// After a graceful restart the listeners are inherited from the old process
+	listeners, err := openListeners(s.addr, s.tlsConfig, s.users, s.extra)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+		return err
 	}
 
-	s.listener = listener
-	s.isRunning = true
+	s.listeners = listeners
+	s.isRunning.Store(true)
+	s.started = time.Now()
 	fmt.Printf("Server started on %s\n", s.addr)
+	for _, spec := range s.extra {
+		fmt.Printf("Also listening on %s %s\n", spec.Network, spec.Addr)
+	}
 
-	s.store.StartBackgroundCleaner()
+	for _, l := range s.listeners {
+		go s.acceptConnections(l)
+	}
 
-	go s.acceptConnections()
+	if s.replicaOf != "" {
+		go s.replicate()
+	}
 
 	return nil
 }
 
+// Stop closes the listener and the store immediately, without waiting for
+// open connections
 func (s *Server) Stop() error {
-	if !s.isRunning {
+	if err := s.stopListening(); err != nil {
+		return err
+	}
+	return s.db.Close()
+}
+
+// stopListening stops accepting connections and ends replication
+func (s *Server) stopListening() error {
+	if !s.isRunning.Swap(false) {
 		return nil
 	}
 
-	s.isRunning = false
-	return s.listener.Close()
+	s.replication.mu.Lock()
+	if s.replication.conn != nil {
+		s.replication.conn.Close()
+	}
+	s.replication.mu.Unlock()
+
+	s.resp.close()
+	s.grpc.close()
+	return closeListeners(s.listeners)
+}
+
+// Listener returns the listening socket of the server's own address
+func (s *Server) Listener() net.Listener {
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Listener
 }
 
-func (s *Server) acceptConnections() {
-	for s.isRunning {
-		conn, err := s.listener.Accept()
+// Listeners returns every command protocol listening socket by name, for
+// passing to a replacement process during a graceful restart
+func (s *Server) Listeners() map[string]net.Listener {
+	return listenerMap(s.listeners)
+}
+
+// AddListener also accepts connections on the address spec describes, with
+// its own TLS and AUTH settings. It must be called before Start.
+func (s *Server) AddListener(spec ListenerSpec) {
+	s.extra = append(s.extra, spec)
+}
+
+// StartRESP also serves Redis clients on addr, speaking enough RESP2 for
+// GET, SET, DEL, TTL, EXPIRE, EXISTS, PING and INFO. It takes the server's
+// TLS, timeout and size settings and must be called after Start.
+func (s *Server) StartRESP(addr string) error {
+	s.resp = &respListener{
+		conns:        &s.conns,
+		run:          s.runCommand,
+		users:        s.users,
+		auditor:      s.auditor,
+		tlsConfig:    s.tlsConfig,
+		writeTimeout: s.writeTimeout,
+		idleTimeout:  s.idleTimeout,
+		maxSize:      s.maxRequestSize,
+		maxArgs:      s.maxRESPArgs,
+		slow:         &s.slowConsumers,
+	}
+	return listenRESP(addr, s.resp)
+}
+
+// RESPListener returns the RESP listening socket, or nil if StartRESP was
+// not called
+func (s *Server) RESPListener() net.Listener {
+	if s.resp == nil {
+		return nil
+	}
+	return s.resp.listener
+}
+
+// StartGRPC also serves the Yakvs gRPC service defined in yakvspb on addr.
+// It takes the server's TLS and size settings and must be called after Start.
+func (s *Server) StartGRPC(addr string) error {
+	s.grpc = &grpcListener{run: s.runCommand, watch: s.db.Watch, users: s.users, auditor: s.auditor}
+	return listenGRPC(addr, s.grpc, s.tlsConfig, s.maxRequestSize)
+}
+
+// GRPCListener returns the gRPC listening socket, or nil if StartGRPC was
+// not called
+func (s *Server) GRPCListener() net.Listener {
+	if s.grpc == nil {
+		return nil
+	}
+	return s.grpc.listener
+}
+
+// SetWriteTimeout sets how long a write to a client may stay blocked before
+// the client is disconnected as a slow consumer. Zero disables the limit. It
+// applies to connections accepted after the call.
+func (s *Server) SetWriteTimeout(timeout time.Duration) {
+	s.writeTimeout = timeout
+}
+
+// SetOutputBufferLimit sets the most bytes of pushed messages, such as
+// subscription events, the server queues for a client that has not read
+// them. A client with more queued is disconnected as a slow consumer. Zero
+// disables the limit, leaving only the write timeout. It applies to
+// connections accepted after the call.
+func (s *Server) SetOutputBufferLimit(limit int) {
+	s.outputBufferLimit = limit
+}
+
+// SetIdleTimeout sets how long a client may take to send its next request
+// before it is disconnected. Zero, the default, disables the limit.
+// Subscribed clients are never disconnected for being idle. It applies to
+// connections accepted after the call.
+func (s *Server) SetIdleTimeout(timeout time.Duration) {
+	s.idleTimeout = timeout
+}
+
+// SetTLSConfig makes the server accept only TLS connections on its own
+// address, configured by cfg. Nil, the default, serves plain TCP. Listeners
+// added with AddListener have their own setting. It must be called before
+// Start.
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+// SetUsers requires clients to log in with AUTH as one of users, and limits
+// each to the commands and keys it is allowed. Nil, the default, lets every
+// client run every command. It must be called before Start.
+func (s *Server) SetUsers(users *acl.Users) {
+	s.users = users
+}
+
+// SetAuditLog records every write clients send, with who sent it and its
+// outcome, in log. Nil, the default, records nothing. It must be called
+// before Start.
+func (s *Server) SetAuditLog(log *audit.Log) {
+	s.auditLog = log
+}
+
+// auditor returns how to record the writes of the client at addr
+func (s *Server) auditor(addr string) auditFunc {
+	return auditTo(s.auditLog, addr, nil)
+}
+
+// SetReplicaTLSConfig makes a replica connect to its primary over TLS,
+// configured by cfg. It must be called before Start.
+func (s *Server) SetReplicaTLSConfig(cfg *tls.Config) {
+	s.replicaTLS = cfg
+}
+
+// SetReplicaAuth makes a replica log in to its primary as user, who must be
+// allowed the REPLICATE command. It must be called before Start.
+func (s *Server) SetReplicaAuth(user, password string) {
+	s.replicaUser = user
+	s.replicaPassword = password
+}
+
+// SetMaxRequestSize sets the longest request line in bytes the server
+// accepts. Longer requests are answered with a too_large error and skipped.
+// Zero disables the limit. It applies to connections accepted after the call.
+func (s *Server) SetMaxRequestSize(size int) {
+	s.maxRequestSize = size
+}
+
+// SetMaxRESPArgs sets the most arguments a RESP command may have, its name
+// included. A client sending more is answered with an error and disconnected,
+// as the rest of its input cannot be parsed. Zero disables the limit. It must
+// be called before StartRESP.
+func (s *Server) SetMaxRESPArgs(n int) {
+	s.maxRESPArgs = n
+}
+
+// SetLimits sets the largest keys and values the server accepts
+func (s *Server) SetLimits(limits store.Limits) {
+	s.db.Store().SetLimits(limits)
+}
+
+// SetSyncPolicy sets when writes to the server's log are fsynced
+func (s *Server) SetSyncPolicy(policy store.SyncPolicy) error {
+	return s.db.Store().SetSyncPolicy(policy)
+}
+
+// Shutdown stops accepting connections, lets open connections finish their
+// in-flight command for up to drainTimeout, and then flushes and closes the
+// store
+func (s *Server) Shutdown(drainTimeout time.Duration) error {
+	if err := s.stopListening(); err != nil {
+		return err
+	}
+
+	s.conns.drain(drainTimeout)
+	return s.db.Close()
+}
+
+func (s *Server) acceptConnections(l *cmdListener) {
+	for s.isRunning.Load() {
+		conn, err := l.Accept()
 		if err != nil {
-			if s.isRunning {
+			if s.isRunning.Load() {
 				fmt.Printf("Error accepting connection: %v\n", err)
 			}
 			continue
 		}
 
-		go s.handleConnection(conn)
+		if chaos.DropConnection() {
+			conn.Close()
+			continue
+		}
+
+		// The listener itself stays plain TCP so it can be handed over on
+		// a graceful restart
+		if l.tls != nil {
+			conn = tls.Server(conn, l.tls)
+		}
+
+		s.conns.add(conn)
+		go s.handleConnection(conn, l.users)
 	}
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
+// handleConnection serves the commands of one client, who must log in as
+// one of users unless it is nil
+func (s *Server) handleConnection(conn net.Conn, users *acl.Users) {
+	defer s.conns.remove(conn)
 	defer conn.Close()
 
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		cmdText := scanner.Text()
-		if cmdText == "" {
+	out := &connWriter{conn: conn, timeout: s.writeTimeout, limit: s.outputBufferLimit, slow: &s.slowConsumers}
+	var sub *subscription
+	var up *upload
+	var tx *transaction
+	var user *acl.User // set by AUTH when the server has users
+	auditor := s.auditor(conn.RemoteAddr().String())
+	defer func() {
+		if sub != nil {
+			sub.stop()
+		}
+		if up != nil {
+			up.discard()
+		}
+	}()
+
+	reader := &requestReader{r: bufio.NewReader(conn), max: s.maxRequestSize, codec: wire.JSON}
+	for {
+		if s.idleTimeout > 0 {
+			// Subscribers only listen, so they may stay quiet for as long
+			// as they like
+			timeout := s.idleTimeout
+			if sub != nil {
+				timeout = 0
+			}
+			s.conns.setIdleTimeout(conn, timeout)
+		}
+
+		request, err := reader.next()
+		if errors.Is(err, errRequestTooLarge) {
+			sendResponse(out, Response{
+				Status:  "too_large",
+				Message: fmt.Sprintf("Request too large, the limit is %d bytes", s.maxRequestSize),
+			})
+			continue
+		}
+		if err != nil {
+			// Idle connections and those stopped by a drain run into their
+			// read deadline, which is not an error
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				fmt.Printf("Error reading from connection: %v\n", err)
+			}
+			return
+		}
+		if len(request) == 0 {
+			continue
+		}
+
+		if reader.codec.IsBatch(request) {
+			handlePipeline(request, reader.codec, sub != nil || tx != nil, out, authorized(users, &user, s.runCommand, auditor))
 			continue
 		}
 
 		var cmd Command
-		if err := json.Unmarshal([]byte(cmdText), &cmd); err != nil {
-			sendResponse(conn, Response{
+		if err := reader.codec.Unmarshal(request, &cmd); err != nil {
+			sendResponse(out, Response{
 				Status:  "error",
 				Message: "Invalid command format",
 			})
 			continue
 		}
+		decodeExpiry(&cmd)
 
-		resp := s.processCommand(cmd)
-		sendResponse(conn, resp)
-	}
+		if handleHello(cmd, conn, &s.conns, users != nil, sub != nil || tx != nil, reader, out) {
+			continue
+		}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error reading from connection: %v\n", err)
+		if resp := authorize(users, &user, cmd); resp != nil {
+			// A refused command dooms the transaction it was sent in
+			if tx != nil && resp.Status != "success" {
+				tx.failed = true
+			}
+			if user != nil && resp.Status == "success" {
+				s.conns.update(conn, func(c *clientInfo) { c.User = user.Name })
+			}
+			auditor.record(user, cmd, *resp)
+			sendResponse(out, *resp)
+			continue
+		}
+
+		if handleSubscription(cmd, &sub, out, s.db.Watch) {
+			continue
+		}
+
+		if handleMulti(cmd, &tx, out, auditor.wrapExec(&user, s.exec)) {
+			continue
+		}
+
+		if handleChunked(cmd, &up, out, auditor.wrap(&user, s.processCommand)) {
+			continue
+		}
+
+		// A replication request turns the connection into a one-way stream
+		if strings.ToUpper(cmd.Op) == "REPLICATE" {
+			s.serveReplica(conn, cmd)
+			return
+		}
+
+		if err := decodeValues(&cmd); err != nil {
+			sendResponse(out, Response{Status: "error", Message: err.Error()})
+			continue
+		}
+
+		resp := s.runCommand(cmd)
+		auditor.record(user, cmd, resp)
+		if op := strings.ToUpper(cmd.Op); cmd.Chunked && (op == "GET" || op == "DUMP") {
+			sendChunked(out, resp, cmd.Encoding)
+			continue
+		}
+		sendResponse(out, encodeValues(cmd.Encoding, resp))
 	}
 }
 
+// runCommand processes a decoded command and records its latency
+func (s *Server) runCommand(cmd Command) Response {
+	start := time.Now()
+	chaos.DelayCommand()
+	resp := s.processCommand(cmd)
+	recordLatency(&s.latency, cmd, resp, time.Since(start))
+	return resp
+}
+
 func (s *Server) processCommand(cmd Command) Response {
-	switch strings.ToUpper(cmd.Op) {
+	op := strings.ToUpper(cmd.Op)
+	if s.replicaOf != "" && writeOps[op] {
+		return Response{
+			Status:  "error",
+			Code:    CodeReadOnly,
+			Message: fmt.Sprintf("Read-only replica, send writes to: %s", s.replicaOf),
+		}
+	}
+
+	// A standalone server is always up to date with its own writes, but a
+	// replica cannot know what its primary has acknowledged
+	if readOps[op] {
+		level, resp := readConsistency(cmd.Consistency, ConsistencyStale)
+		if resp != nil {
+			return *resp
+		}
+		if level == ConsistencyLinearizable && s.replicaOf != "" {
+			return Response{
+				Status:  "error",
+				Code:    CodeReadOnly,
+				Message: fmt.Sprintf("Replicas only serve stale reads, read linearizably from: %s", s.replicaOf),
+			}
+		}
+	}
+
+	if err := checkLimits(op, cmd, s.db.Store().Limits()); err != nil {
+		return errorResponse(err)
+	}
+
+	switch op {
 	case "SET":
 		if cmd.Key == "" {
 			return Response{Status: "error", Message: "Key is required"}
 		}
 
-		value := store.NewValue(cmd.Value, cmd.ExpiresIn)
-		s.store.Set(cmd.Key, value)
+		// A chunked SET only announces the value, which is committed once
+		// every chunk has arrived
+		if cmd.Chunked {
+			return Response{Status: "success"}
+		}
+
+		if err := s.db.Set(cmd.Key, cmd.Value, cmd.ExpiresIn); err != nil {
+			return errorResponse(err)
+		}
 		return Response{Status: "success"}
 
+	case "MSET":
+		values, resp := msetValues(cmd)
+		if resp != nil {
+			return *resp
+		}
+
+		if err := s.db.MSetValues(values); err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success"}
+
+	case "MGET":
+		values, err := s.db.MGet(cmd.Keys...)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Values: values, Missing: missingKeys(cmd.Keys, values)}
+
 	case "GET":
 		if cmd.Key == "" {
 			return Response{Status: "error", Message: "Key is required"}
 		}
 
-		value, exists := s.store.Get(cmd.Key)
-		if !exists {
-			return Response{Status: "error", Message: "Key not found"}
+		value, ttl, err := s.db.Get(cmd.Key)
+		if errors.Is(err, yakvs.ErrKeyNotFound) {
+			return notFoundResponse("Key not found")
+		}
+		if err != nil {
+			return errorResponse(err)
 		}
 
-		// Get TTL
-		ttl, _ := s.store.TTL(cmd.Key)
+		return Response{Status: "success", Value: value, TTL: ttl}
 
-		return Response{Status: "success", Value: value.Data, TTL: ttl}
+	case "GETMETA":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		value, err := s.db.GetWithMeta(cmd.Key)
+		if errors.Is(err, yakvs.ErrKeyNotFound) {
+			return notFoundResponse("Key not found")
+		}
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		return metaResponse(value)
 
 	case "DELETE":
 		if cmd.Key == "" {
 			return Response{Status: "error", Message: "Key is required"}
 		}
 
-		s.store.Delete(cmd.Key)
+		if err := s.db.Delete(cmd.Key); err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success"}
+
+	case "EXISTS":
+		return existsResponse(cmd, s.db.Store().Exists)
+
+	case "TYPE":
+		return typeResponse(cmd, s.db.Store().Type)
+
+	case "SCANPREFIX":
+		return scanPrefixResponse(cmd, s.db.Store().RangePrefix)
+
+	case "KEYS RANGE":
+		return keysRangeResponse(cmd, s.db.Store().RangeSorted)
+
+	case "KEYS":
+		return keysResponse(cmd, s.db.Store().Scan)
+
+	case "SCAN":
+		return scanResponse(cmd, s.db.Store().Scan)
+
+	case "RANDOMKEY":
+		return randomKeyResponse(cmd, s.db.Store().RandomKey, s.db.Store().Sample)
+
+	case "DELPREFIX":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Prefix is required"}
+		}
+
+		n, err := s.db.DeletePrefix(cmd.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Int: int64(n)}
+
+	case "FLUSHALL":
+		if !cmd.Confirm {
+			return Response{Status: "error", Message: errFlushUnconfirmed}
+		}
+
+		if err := s.db.FlushAll(); err != nil {
+			return errorResponse(err)
+		}
 		return Response{Status: "success"}
 
 	case "TTL":
@@ -151,27 +865,585 @@ func (s *Server) processCommand(cmd Command) Response {
 			return Response{Status: "error", Message: "Key is required"}
 		}
 
-		ttl, exists := s.store.TTL(cmd.Key)
-		if !exists {
-			return Response{Status: "error", Message: "Key not found or expired"}
+		ttl, err := s.db.TTL(cmd.Key)
+		if errors.Is(err, yakvs.ErrKeyNotFound) {
+			return notFoundResponse("Key not found or expired")
+		}
+		if err != nil {
+			return errorResponse(err)
 		}
 
 		return Response{Status: "success", TTL: ttl}
 
+	case "SETNX":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applied, err := s.db.SetNX(cmd.Key, cmd.Value, cmd.ExpiresIn)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Applied: applied}
+
+	case "CAS":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		version, err := s.db.CompareAndSwap(cmd.Key, cmd.Expected, cmd.Value, cmd.ExpiresIn)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Version: version}
+
+	case "INCR", "DECR", "INCRBY":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		n, err := s.db.IncrBy(cmd.Key, incrDelta(op, cmd.Delta))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Int: n}
+
+	case "GETSET":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		old, existed, err := s.db.GetSet(cmd.Key, cmd.Value, cmd.ExpiresIn)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Value: old, Existed: existed}
+
+	case "APPEND":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		n, err := s.db.Append(cmd.Key, cmd.Value, cmd.ExpiresIn)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Int: int64(n)}
+
+	case "EXPIRE", "PERSIST":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		var applied bool
+		var err error
+		if op == "EXPIRE" {
+			applied, err = s.db.Expire(cmd.Key, cmd.ExpiresIn)
+		} else {
+			applied, err = s.db.Persist(cmd.Key)
+		}
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Applied: applied}
+
+	case "TOUCH":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		applied, err := s.db.Touch(cmd.Key, cmd.ExpiresIn)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Applied: applied}
+
+	case "GETEX":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		value, err := s.db.GetEx(cmd.Key, cmd.ExpiresIn)
+		if errors.Is(err, yakvs.ErrKeyNotFound) {
+			return notFoundResponse("Key not found")
+		}
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Value: value, TTL: newTTL(cmd.ExpiresIn)}
+
+	case "LPUSH", "RPUSH":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		push := s.db.RPush
+		if op == "LPUSH" {
+			push = s.db.LPush
+		}
+		n, err := push(cmd.Key, cmd.Elements...)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Int: int64(n)}
+
+	case "LPOP", "RPOP":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		pop := s.db.RPop
+		if op == "LPOP" {
+			pop = s.db.LPop
+		}
+		elems, err := pop(cmd.Key, popCount(cmd.Count))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Elements: elems, Int: int64(len(elems))}
+
+	case "LLEN":
+		return llenResponse(cmd, s.db.LLen)
+
+	case "LRANGE":
+		return lrangeResponse(cmd, s.db.LRange)
+
+	case "MEMORY USAGE":
+		if cmd.Key == "" {
+			return Response{Status: "error", Message: "Key is required"}
+		}
+
+		size, err := s.db.MemoryUsage(cmd.Key)
+		if errors.Is(err, yakvs.ErrKeyNotFound) {
+			return notFoundResponse("Key not found")
+		}
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		return Response{Status: "success", Size: size}
+
+	case "MEMORY STATS":
+		stats, err := s.db.MemoryStats(memoryTop(cmd.Count))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return memoryStatsResponse(stats)
+
+	case "STATS":
+		stats, err := s.db.Stats()
+		if err != nil {
+			return errorResponse(err)
+		}
+		return statsResponse(stats)
+
+	case "INFO":
+		i, err := s.info()
+		if err != nil {
+			return errorResponse(err)
+		}
+		return infoResponse(i)
+
+	case "AUDIT":
+		return auditResponse(s.auditLog, cmd.Count)
+
+	case "COMPACT":
+		st := s.db.Store()
+		return s.jobs.start("compact", func() (string, error) {
+			before := st.LogSize()
+			if err := s.db.Compact(); err != nil {
+				return "", err
+			}
+			return compactMessage(before, st.LogSize()), nil
+		})
+
+	case "BGSAVE":
+		return s.bgsave()
+
+	case "JOBSTATUS":
+		return s.jobs.statusResponse(cmd.Job)
+
+	case "DUMP":
+		return dumpResponse(s.db)
+
+	case "RESTORE":
+		// A chunked RESTORE only announces the snapshot, which is loaded once
+		// every chunk has arrived
+		if cmd.Chunked {
+			return Response{Status: "success"}
+		}
+		return restoreResponse(s.db, cmd.Value)
+
+	case "LATENCY":
+		return latencyResponse(&s.latency)
+
+	case "LATENCY RESET":
+		s.latency.Reset()
+		return Response{Status: "success"}
+
+	case "STATUS":
+		return Response{Status: "success", Message: fmt.Sprintf("%s, version: %s, slow consumer disconnects: %d",
+			s.replicationStatus(), version.Version, s.slowConsumers.Load())}
+
+	case "CLIENT LIST":
+		return clientListResponse(&s.conns)
+
+	case "PING":
+		return Response{Status: "success", Message: "PONG"}
+
+	case "VERSION":
+		info, err := json.Marshal(version.Get())
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Status: "success", Value: string(info), Message: version.String()}
+
 	default:
 		return Response{Status: "error", Message: "Unknown command"}
 	}
 }
 
-func sendResponse(conn net.Conn, resp Response) {
-	jsonResp, err := json.Marshal(resp)
+// typeResponse reports the kind of value stored under the key named by a
+// TYPE command in Value: "string", "list" or "none"
+func typeResponse(cmd Command, typeOf func(string) string) Response {
+	if cmd.Key == "" {
+		return Response{Status: "error", Message: "Key is required"}
+	}
+	return Response{Status: "success", Value: typeOf(cmd.Key)}
+}
+
+// existsResponse counts how many of the keys named by an EXISTS command are
+// present. A key named twice is counted twice.
+func existsResponse(cmd Command, exists func(string) bool) Response {
+	keys := cmd.Keys
+	if cmd.Key != "" {
+		keys = append([]string{cmd.Key}, keys...)
+	}
+	if len(keys) == 0 {
+		return Response{Status: "error", Message: "Key is required"}
+	}
+
+	var n int64
+	for _, key := range keys {
+		if exists(key) {
+			n++
+		}
+	}
+	return Response{Status: "success", Exists: n == int64(len(keys)), Int: n}
+}
+
+// scanPrefixResponse lists the keys starting with the command's Key, at most
+// Count of them if it is positive
+func scanPrefixResponse(cmd Command, rangePrefix func(string, func(string, store.Value) bool)) Response {
+	keys := []string{}
+	rangePrefix(cmd.Key, func(key string, _ store.Value) bool {
+		keys = append(keys, key)
+		return cmd.Count <= 0 || len(keys) < cmd.Count
+	})
+	return Response{Status: "success", Keys: keys, Int: int64(len(keys))}
+}
+
+// keysRangeResponse lists the keys from the command's Key up to but not
+// including End, at most Count of them if it is positive
+func keysRangeResponse(cmd Command, rangeSorted func(string, string, func(string, store.Value) bool)) Response {
+	if cmd.End != "" && cmd.End < cmd.Key {
+		return Response{Status: "error", Message: "End must not be before start"}
+	}
+
+	keys := []string{}
+	rangeSorted(cmd.Key, cmd.End, func(key string, _ store.Value) bool {
+		keys = append(keys, key)
+		return cmd.Count <= 0 || len(keys) < cmd.Count
+	})
+	return Response{Status: "success", Keys: keys, Int: int64(len(keys))}
+}
+
+// maxKeysReply caps the keys a single KEYS or SCAN reply lists
+const maxKeysReply = 1000
+
+// defaultScanCount is how many keys SCAN lists when the command has no Count
+const defaultScanCount = 10
+
+// keysResponse lists the keys matching the command's glob pattern, up to
+// Count of them if it is positive and never more than maxKeysReply. A reply
+// cut short carries the cursor for SCAN to continue from with the same
+// pattern.
+func keysResponse(cmd Command, scan func(cursor, pattern string, count int) ([]string, string)) Response {
+	if cmd.Key == "" {
+		return Response{Status: "error", Message: "Pattern is required"}
+	}
+	limit := maxKeysReply
+	if cmd.Count > 0 && cmd.Count < limit {
+		limit = cmd.Count
+	}
+
+	keys := []string{}
+	cursor := ""
+	for {
+		var page []string
+		page, cursor = scan(cursor, cmd.Key, limit-len(keys))
+		keys = append(keys, page...)
+		if cursor == "" || len(keys) == limit {
+			break
+		}
+	}
+	return Response{Status: "success", Keys: keys, Int: int64(len(keys)), Cursor: cursor}
+}
+
+// scanResponse lists the next keys after the command's Cursor that match its
+// pattern in Key, or every key if it is empty. Count, capped at
+// maxKeysReply, is how many keys to list; a reply may hold fewer without
+// being the last.
+func scanResponse(cmd Command, scan func(cursor, pattern string, count int) ([]string, string)) Response {
+	count := cmd.Count
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	count = min(count, maxKeysReply)
+
+	keys, cursor := scan(cmd.Cursor, cmd.Key, count)
+	return Response{Status: "success", Keys: keys, Int: int64(len(keys)), Cursor: cursor}
+}
+
+// randomKeyResponse lists one live key chosen at random, or Count distinct
+// ones if Count is above one. An empty store gives an empty list.
+func randomKeyResponse(cmd Command, randomKey func() (string, bool), sample func(int) []string) Response {
+	keys := []string{}
+	if cmd.Count > 1 {
+		keys = append(keys, sample(cmd.Count)...)
+	} else if key, ok := randomKey(); ok {
+		keys = append(keys, key)
+	}
+	return Response{Status: "success", Keys: keys, Int: int64(len(keys))}
+}
+
+// errFlushUnconfirmed is returned for a FLUSHALL without Confirm, so a
+// stray command cannot wipe the store
+const errFlushUnconfirmed = "FLUSHALL removes every key and must be confirmed"
+
+// popCount is the number of elements an LPOP or RPOP removes, one unless
+// the command asks for more
+func popCount(count int) int {
+	if count <= 0 {
+		return 1
+	}
+	return count
+}
+
+// llenResponse reports the length of the list under cmd.Key in Int
+func llenResponse(cmd Command, llen func(string) (int, error)) Response {
+	if cmd.Key == "" {
+		return Response{Status: "error", Message: "Key is required"}
+	}
+
+	n, err := llen(cmd.Key)
 	if err != nil {
-		fmt.Printf("Error marshaling response: %v\n", err)
-		return
+		return errorResponse(err)
+	}
+	return Response{Status: "success", Int: int64(n)}
+}
+
+// lrangeResponse lists the elements of the list under cmd.Key from
+// cmd.Start to cmd.Stop inclusive, with their number in Int
+func lrangeResponse(cmd Command, lrange func(string, int, int) ([]string, error)) Response {
+	if cmd.Key == "" {
+		return Response{Status: "error", Message: "Key is required"}
+	}
+
+	elems, err := lrange(cmd.Key, cmd.Start, cmd.Stop)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if elems == nil {
+		elems = []string{}
+	}
+	return Response{Status: "success", Elements: elems, Int: int64(len(elems))}
+}
+
+// decodeExpiry sets the expiry a command runs with from the milliseconds it
+// was sent in, leaving the nanoseconds of older clients alone
+func decodeExpiry(cmd *Command) {
+	if cmd.ExpiresInMs != 0 {
+		cmd.ExpiresIn = time.Duration(cmd.ExpiresInMs) * time.Millisecond
+	}
+}
+
+// msetValues gathers the values of an MSET: its pairs, which share the
+// command's expiry, and its entries, which carry their own. A key given twice
+// takes the last value. It returns the response to send instead if there
+// are none.
+func msetValues(cmd Command) (map[string]store.Value, *Response) {
+	if len(cmd.Pairs) == 0 && len(cmd.Entries) == 0 {
+		return nil, &Response{Status: "error", Message: "Pairs or entries are required"}
+	}
+
+	values := make(map[string]store.Value, len(cmd.Pairs)+len(cmd.Entries))
+	for key, value := range cmd.Pairs {
+		values[key] = store.NewValue(value, cmd.ExpiresIn)
+	}
+	for _, e := range cmd.Entries {
+		if e.Key == "" {
+			return nil, &Response{Status: "error", Message: "Every entry needs a key"}
+		}
+		values[e.Key] = store.NewValue(e.Value, time.Duration(e.ExpiresInMs)*time.Millisecond)
+	}
+	return values, nil
+}
+
+// ttlMillis converts a response TTL to milliseconds, keeping -1 for keys
+// that never expire
+func ttlMillis(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return -1
+	}
+	return ttl.Milliseconds()
+}
+
+// newTTL is the TTL reported for a key whose expiry was just reset to
+// expiresIn, zero meaning never
+func newTTL(expiresIn time.Duration) time.Duration {
+	if expiresIn == 0 {
+		return store.NoExpiry
+	}
+	return expiresIn
+}
+
+// incrDelta returns the amount an INCR, DECR or INCRBY command adds
+func incrDelta(op string, delta int64) int64 {
+	switch op {
+	case "INCR":
+		return 1
+	case "DECR":
+		return -1
+	}
+	return delta
+}
+
+// metaResponse reports a value along with its TTL, version and write times
+func metaResponse(value store.Value) Response {
+	ttl := store.NoExpiry
+	if !value.ExpiresAt.IsZero() {
+		ttl = time.Until(value.ExpiresAt)
+	}
+
+	resp := Response{Status: "success", Value: value.Data, TTL: ttl, Version: value.Version}
+	if !value.CreatedAt.IsZero() {
+		resp.CreatedAt = &value.CreatedAt
+	}
+	if !value.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &value.UpdatedAt
 	}
+	return resp
+}
+
+// missingKeys returns the keys that MGET found no value for
+func missingKeys(keys []string, values map[string]string) []string {
+	var missing []string
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// Codes of a Response
+const (
+	CodeOK          = "OK"
+	CodeNotFound    = "NOT_FOUND"     // the key does not exist or has expired
+	CodeNotLeader   = "NOT_LEADER"    // a raft follower got a write, see Leader
+	CodeReadOnly    = "READ_ONLY"     // a replica got a write
+	CodeBadRequest  = "BAD_REQUEST"   // the command is malformed or not allowed now
+	CodeTooLarge    = "TOO_LARGE"     // a key, value or request is over the limits
+	CodeConflict    = "CONFLICT"      // a CAS found another value
+	CodeOutOfMemory = "OUT_OF_MEMORY" // a write is over the memory limit
+	CodeNotInteger  = "NOT_INTEGER"   // INCR on a value that is not an integer
+	CodeWrongType   = "WRONG_TYPE"    // a list command on a plain value or the other way round
+	CodeInternal    = "INTERNAL"      // the server failed to carry out a valid command
 
-	jsonResp = append(jsonResp, '\n')
-	if _, err := conn.Write(jsonResp); err != nil {
+	CodeUnauthenticated  = "UNAUTHENTICATED"   // users are configured and the client has not sent AUTH
+	CodePermissionDenied = "PERMISSION_DENIED" // the user may not run the command or use its keys
+)
+
+// statusCodes are the codes of responses that do not set one themselves. An
+// error without a code is taken to be the client's fault.
+var statusCodes = map[string]string{
+	"success":       CodeOK,
+	"error":         CodeBadRequest,
+	"redirect":      CodeNotLeader,
+	"conflict":      CodeConflict,
+	"too_large":     CodeTooLarge,
+	"out_of_memory": CodeOutOfMemory,
+	"not_integer":   CodeNotInteger,
+	"wrong_type":    CodeWrongType,
+}
+
+// wireResponse fills in the fields of a response derived from others before
+// it is sent to a client
+func wireResponse(resp Response) Response {
+	resp = withCode(resp)
+	resp.TTLMs = ttlMillis(resp.TTL)
+	return resp
+}
+
+// withCode fills in the code of a response that does not set one
+func withCode(resp Response) Response {
+	if resp.Code == "" {
+		resp.Code = statusCodes[resp.Status]
+	}
+	return resp
+}
+
+// errorResponse reports a failed command, marking CAS conflicts and keys or
+// values over the size limits so clients can tell them from other errors.
+// Errors the store does not single out are internal failures, unless they
+// blame the command.
+func errorResponse(err error) Response {
+	var conflict *store.ConflictError
+	if errors.As(err, &conflict) {
+		return Response{Status: "conflict", Message: err.Error()}
+	}
+	if errors.Is(err, store.ErrTooLarge) {
+		return Response{Status: "too_large", Message: err.Error()}
+	}
+	if errors.Is(err, store.ErrOutOfMemory) {
+		return Response{Status: "out_of_memory", Message: err.Error()}
+	}
+	if errors.Is(err, store.ErrNotInteger) {
+		return Response{Status: "not_integer", Message: err.Error()}
+	}
+	if errors.Is(err, store.ErrWrongType) {
+		return Response{Status: "wrong_type", Message: err.Error()}
+	}
+	if errors.Is(err, store.ErrNoElements) || errors.Is(err, store.ErrOverflow) ||
+		errors.Is(err, store.ErrInvalidBatch) || errors.Is(err, store.ErrNoLog) {
+		return Response{Status: "error", Code: CodeBadRequest, Message: err.Error()}
+	}
+	return Response{Status: "error", Code: CodeInternal, Message: err.Error()}
+}
+
+// notFoundResponse reports a command on a key that does not exist
+func notFoundResponse(msg string) Response {
+	return Response{Status: "error", Code: CodeNotFound, Message: msg}
+}
+
+// compactMessage reports how much a log compaction reclaimed
+func compactMessage(before, after int64) string {
+	return fmt.Sprintf("Log compacted from %d to %d bytes", before, after)
+}
+
+func sendResponse(out *connWriter, resp Response) {
+	if err := out.send(wireResponse(resp)); err != nil {
 		fmt.Printf("Error sending response: %v\n", err)
 	}
 }
+
+// sendResponses writes the responses to a pipeline as one array message
+func sendResponses(out *connWriter, resps []Response) {
+	for i := range resps {
+		resps[i] = wireResponse(resps[i])
+	}
+	if err := out.send(resps); err != nil {
+		fmt.Printf("Error sending responses: %v\n", err)
+	}
+}
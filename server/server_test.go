@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/client"
+)
+
+// startTestServer starts a server on a free port with its log in a temporary
+// directory, once configure has set it up, and stops it when the test ends
+func startTestServer(t *testing.T, configure func(*Server)) *Server {
+	t.Helper()
+
+	s, err := NewServer("127.0.0.1:0", filepath.Join(t.TempDir(), "kv.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(s)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// testConn speaks the JSON line protocol to a server
+type testConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func dialTest(t *testing.T, addr string) *testConn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// sendLine sends one raw request line
+func (c *testConn) sendLine(t *testing.T, line []byte) {
+	t.Helper()
+
+	if _, err := c.Write(append(line, '\n')); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read reads one response
+func (c *testConn) read(t *testing.T) Response {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer c.SetReadDeadline(time.Time{})
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("failed to read a response: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatalf("invalid response %.100q: %v", line, err)
+	}
+	return resp
+}
+
+// do sends cmd and returns its response
+func (c *testConn) do(t *testing.T, cmd Command) Response {
+	t.Helper()
+
+	line, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.sendLine(t, line)
+	return c.read(t)
+}
+
+// largeValue returns a value of n bytes that is not one repeated byte
+func largeValue(n int) string {
+	return strings.Repeat("0123456789abcdef", n/16+1)[:n]
+}
+
+// checkLargeRequests sends a 1MB value to the server at addr in one request
+// line and reads it back in one response line, then does the same through a
+// client, which sends it in chunks
+func checkLargeRequests(t *testing.T, addr string) {
+	value := largeValue(1 << 20)
+
+	conn := dialTest(t, addr)
+	if resp := conn.do(t, Command{Op: "SET", Key: "big", Value: value}); resp.Status != "success" {
+		t.Fatalf("SET of a 1MB value: %s %s", resp.Status, resp.Message)
+	}
+	if resp := conn.do(t, Command{Op: "GET", Key: "big"}); resp.Status != "success" || resp.Value != value {
+		t.Fatalf("GET of a 1MB value: %s %s, %d bytes", resp.Status, resp.Message, len(resp.Value))
+	}
+
+	c, err := client.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Set("chunked", value, 0); err != nil {
+		t.Fatalf("client SET of a 1MB value: %v", err)
+	}
+	if got, _, err := c.Get("chunked"); err != nil || got != value {
+		t.Fatalf("client GET of a 1MB value: %d bytes, %v", len(got), err)
+	}
+}
+
+// checkTooLargeRequest sends a request over the limit of the server at addr
+// and checks it is refused with too_large, leaving the connection usable
+func checkTooLargeRequest(t *testing.T, addr string, limit int) {
+	conn := dialTest(t, addr)
+	line, _ := json.Marshal(Command{Op: "SET", Key: "big", Value: largeValue(2 * limit)})
+	conn.sendLine(t, line)
+	if resp := conn.read(t); resp.Status != "too_large" {
+		t.Fatalf("request over the limit = %s %s, want too_large", resp.Status, resp.Message)
+	}
+	if resp := conn.do(t, Command{Op: "SET", Key: "small", Value: "fits"}); resp.Status != "success" {
+		t.Fatalf("SET after a request over the limit = %s %s", resp.Status, resp.Message)
+	}
+	if resp := conn.do(t, Command{Op: "GET", Key: "big"}); resp.Status == "success" {
+		t.Error("the request over the limit was applied")
+	}
+}
+
+func TestLargeRequests(t *testing.T) {
+	s := startTestServer(t, nil)
+	checkLargeRequests(t, s.Listener().Addr().String())
+}
+
+func TestRequestOverTheLimit(t *testing.T) {
+	s := startTestServer(t, func(s *Server) { s.SetMaxRequestSize(64 << 10) })
+	checkTooLargeRequest(t, s.Listener().Addr().String(), 64<<10)
+}
+
+// waitClosed waits for the server to close conn and returns how long it took
+func waitClosed(t *testing.T, conn net.Conn) time.Duration {
+	t.Helper()
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for {
+		_, err := conn.Read(buf)
+		if errors.Is(err, io.EOF) {
+			return time.Since(start)
+		}
+		if err != nil {
+			t.Fatalf("connection was not closed by the server: %v", err)
+		}
+	}
+}
+
+// checkIdleTimeout checks the server at addr, whose idle timeout is timeout,
+// closes clients that send nothing or stall halfway through a request, and
+// keeps those that send requests more often
+func checkIdleTimeout(t *testing.T, addr string, timeout time.Duration) {
+	silent := dialTest(t, addr)
+	stalled := dialTest(t, addr)
+	stalled.Write([]byte(`{"op":"GET","key":`))
+	active := dialTest(t, addr)
+
+	for i := 0; i < 6; i++ {
+		time.Sleep(timeout / 3)
+		if resp := active.do(t, Command{Op: "PING"}); resp.Status != "success" {
+			t.Fatalf("PING on an active connection = %s %s", resp.Status, resp.Message)
+		}
+	}
+
+	for name, conn := range map[string]*testConn{"silent": silent, "stalled": stalled} {
+		if elapsed := waitClosed(t, conn); elapsed > timeout {
+			t.Errorf("%s connection was closed %v after the active one outlived the timeout", name, elapsed)
+		}
+	}
+	if resp := active.do(t, Command{Op: "PING"}); resp.Status != "success" {
+		t.Errorf("PING on an active connection = %s %s", resp.Status, resp.Message)
+	}
+
+	// Once quiet for longer than the timeout, it is closed too
+	if elapsed := waitClosed(t, active); elapsed < timeout/2 {
+		t.Errorf("active connection was closed %v into its idle time, the timeout is %v", elapsed, timeout)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	s := startTestServer(t, func(s *Server) { s.SetIdleTimeout(timeout) })
+	checkIdleTimeout(t, s.Listener().Addr().String(), timeout)
+
+	// Idle clients are not slow consumers
+	if n := s.slowConsumers.Load(); n != 0 {
+		t.Errorf("%d slow consumer disconnects counted for idle clients", n)
+	}
+}
+
+// checkStalledReader fills the send buffer of a client of the server at addr
+// that stops reading, and checks the server closes the connection within
+// its write timeout and counts it in slowConsumers
+func checkStalledReader(t *testing.T, addr string, timeout time.Duration, slowConsumers func() uint64, conns func() int) {
+	value := largeValue(1 << 20)
+	setter := dialTest(t, addr)
+	if resp := setter.do(t, Command{Op: "SET", Key: "big", Value: value}); resp.Status != "success" {
+		t.Fatalf("SET: %s %s", resp.Status, resp.Message)
+	}
+	setter.Close()
+
+	stalled := dialTest(t, addr)
+	stalled.Conn.(*net.TCPConn).SetReadBuffer(4096)
+	line, _ := json.Marshal(Command{Op: "GET", Key: "big"})
+	for i := 0; i < 64; i++ {
+		stalled.sendLine(t, line)
+	}
+
+	// The handler blocks once the buffers between it and the client are
+	// full, then gives up after the write timeout
+	start := time.Now()
+	waitUntil(t, "the stalled client to be disconnected", func() bool {
+		return slowConsumers() == 1
+	})
+	if elapsed := time.Since(start); elapsed > timeout+2*time.Second {
+		t.Errorf("stalled client was disconnected after %v, the write timeout is %v", elapsed, timeout)
+	}
+	waitUntil(t, "the stalled connection to be released", func() bool { return conns() == 0 })
+
+	// Other clients are unaffected
+	if resp := dialTest(t, addr).do(t, Command{Op: "GET", Key: "big"}); resp.Status != "success" || resp.Value != value {
+		t.Errorf("GET after the stalled client was dropped: %s %s", resp.Status, resp.Message)
+	}
+	if n := slowConsumers(); n != 1 {
+		t.Errorf("%d slow consumer disconnects, want 1", n)
+	}
+}
+
+func TestStalledReaderIsDisconnected(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	s := startTestServer(t, func(s *Server) { s.SetWriteTimeout(timeout) })
+	checkStalledReader(t, s.Listener().Addr().String(), timeout, s.slowConsumers.Load, s.conns.count)
+
+	// INFO reports the disconnect
+	c, err := client.NewClient(s.Listener().Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	info, err := c.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Server.SlowConsumerDisconnects != 1 {
+		t.Errorf("INFO reports %d slow consumer disconnects, want 1", info.Server.SlowConsumerDisconnects)
+	}
+}
+
+func TestSubscriberOverOutputBufferLimitIsDisconnected(t *testing.T) {
+	const limit = 256 << 10
+	s := startTestServer(t, func(s *Server) {
+		s.SetOutputBufferLimit(limit)
+		s.SetWriteTimeout(time.Minute)
+	})
+	addr := s.Listener().Addr().String()
+
+	subscriber := dialTest(t, addr)
+	subscriber.Conn.(*net.TCPConn).SetReadBuffer(4096)
+	if resp := subscriber.do(t, Command{Op: "SUBSCRIBE", Key: "*"}); resp.Status != "success" {
+		t.Fatalf("SUBSCRIBE: %s %s", resp.Status, resp.Message)
+	}
+
+	// The subscriber stops reading, so events queue up until there are more
+	// than the limit, long before the write timeout
+	setter := dialTest(t, addr)
+	value := largeValue(64 << 10)
+	deadline := time.Now().Add(10 * time.Second)
+	for i := 0; s.slowConsumers.Load() == 0; i++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("subscriber still connected after %d events of %d bytes", i, len(value))
+		}
+		if resp := setter.do(t, Command{Op: "SET", Key: "key" + strconv.Itoa(i), Value: value}); resp.Status != "success" {
+			t.Fatalf("SET: %s %s", resp.Status, resp.Message)
+		}
+	}
+	waitUntil(t, "the subscriber's connection to be released", func() bool { return s.conns.count() == 1 })
+
+	// Other clients are unaffected
+	if resp := setter.do(t, Command{Op: "GET", Key: "key0"}); resp.Status != "success" || resp.Value != value {
+		t.Errorf("GET after the subscriber was dropped: %s %s", resp.Status, resp.Message)
+	}
+	if n := s.slowConsumers.Load(); n != 1 {
+		t.Errorf("%d slow consumer disconnects, want 1", n)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pixperk/yakvs"
+)
+
+// SetSnapshotPath sets the file BGSAVE writes snapshots to
+func (s *Server) SetSnapshotPath(path string) {
+	s.snapshotPath = path
+}
+
+// bgsave starts writing a snapshot to the snapshot file as a background job
+func (s *Server) bgsave() Response {
+	path := s.snapshotPath
+	if path == "" {
+		return Response{Status: "error", Message: "No snapshot file is set, start the server with -snapshot"}
+	}
+	return s.jobs.start("bgsave", func() (string, error) {
+		start := time.Now()
+		n, err := writeSnapshotFile(s.db, path)
+		if err != nil {
+			return "", fmt.Errorf("background save to %s failed: %w", path, err)
+		}
+		return fmt.Sprintf("Wrote %d bytes to %s in %s", n, path, time.Since(start)), nil
+	})
+}
+
+// writeSnapshotFile saves a snapshot to a temporary file and renames it over
+// path once it is complete and fsynced, so path always holds a whole snapshot
+func writeSnapshotFile(db *yakvs.DB, path string) (int64, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpPath)
+
+	if err := db.SaveSnapshot(f); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// dumpResponse returns a snapshot of the store as the response value, which
+// the connection sends in chunks when it is large
+func dumpResponse(db *yakvs.DB) Response {
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(&buf); err != nil {
+		return errorResponse(err)
+	}
+	return Response{Status: "success", Value: buf.String()}
+}
+
+// restoreResponse replaces the store's contents with the snapshot in value
+func restoreResponse(db *yakvs.DB, value string) Response {
+	if err := db.LoadSnapshot(strings.NewReader(value)); err != nil {
+		return Response{Status: "error", Message: err.Error()}
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		return errorResponse(err)
+	}
+	return Response{Status: "success", Message: fmt.Sprintf("Restored %d keys", stats.Keys)}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+const (
+	// subscriberBuffer is the number of events queued per subscribed
+	// connection. Further events are dropped and reported through the "lost"
+	// field of the next event delivered.
+	subscriberBuffer = 1024
+)
+
+// Event is a key change pushed to a subscribed connection
+type Event struct {
+	Event string `json:"event"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	// Version is the key's version after a set, or the version removed by a
+	// delete or expiry
+	Version uint64 `json:"version,omitempty"`
+	TS      int64  `json:"ts"`
+	Lost    int    `json:"lost,omitempty"`
+}
+
+// watchFunc registers a store watch, as implemented by yakvs.DB and RaftStore
+type watchFunc func(prefix string, buffer int) (<-chan store.Event, func())
+
+// subscription streams store events to a connection
+type subscription struct {
+	cancel func()
+	done   chan struct{}
+}
+
+// subscribe acknowledges a SUBSCRIBE command and starts pushing events for
+// pattern, which is "*" for all keys, "prefix*" for a prefix, or an exact key
+func subscribe(out *connWriter, watch watchFunc, pattern string) *subscription {
+	prefix, exact := splitPattern(pattern)
+
+	events, cancel := watch(prefix, subscriberBuffer)
+	sub := &subscription{cancel: cancel, done: make(chan struct{})}
+
+	sendResponse(out, Response{Status: "success", Message: "Subscribed to " + pattern})
+
+	go func() {
+		defer close(sub.done)
+
+		failed := false
+		for ev := range events {
+			if failed || (exact && ev.Key != pattern) {
+				continue
+			}
+
+			// Events are queued without waiting for the subscriber to read
+			// them. One that falls too far behind is disconnected; closing
+			// the connection ends the command loop, which cancels the watch.
+			data, err := out.marshal(newEvent(ev))
+			if err == nil {
+				err = out.push(data)
+			}
+			if err != nil {
+				failed = true
+				out.conn.Close()
+			}
+		}
+	}()
+
+	return sub
+}
+
+// splitPattern turns a subscription pattern into the prefix to watch and
+// whether events must also match the pattern exactly
+func splitPattern(pattern string) (prefix string, exact bool) {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.TrimSuffix(pattern, "*"), false
+	}
+	return pattern, true
+}
+
+// newEvent converts a store event into the event pushed to subscribers
+func newEvent(ev store.Event) Event {
+	return Event{
+		Event:   ev.Op,
+		Key:     ev.Key,
+		Value:   ev.Value.Data,
+		Version: ev.Version,
+		TS:      ev.Time.UnixMilli(),
+		Lost:    ev.Lost,
+	}
+}
+
+// eventJSON encodes a store event as pushed to subscribers
+func eventJSON(ev store.Event) ([]byte, error) {
+	return json.Marshal(newEvent(ev))
+}
+
+// stop cancels the watch and waits for the stream to finish writing
+func (s *subscription) stop() {
+	s.cancel()
+	<-s.done
+}
+
+// handleSubscription processes SUBSCRIBE and UNSUBSCRIBE, and rejects other
+// commands while the connection is in streaming mode. It reports whether the
+// command was handled.
+func handleSubscription(cmd Command, sub **subscription, out *connWriter, watch watchFunc) bool {
+	switch strings.ToUpper(cmd.Op) {
+	case "SUBSCRIBE":
+		if *sub != nil {
+			sendResponse(out, Response{Status: "error", Message: "Already subscribed"})
+			return true
+		}
+		if cmd.Key == "" {
+			sendResponse(out, Response{Status: "error", Message: "Key pattern is required"})
+			return true
+		}
+		*sub = subscribe(out, watch, cmd.Key)
+		return true
+
+	case "UNSUBSCRIBE":
+		if *sub == nil {
+			sendResponse(out, Response{Status: "error", Message: "Not subscribed"})
+			return true
+		}
+		(*sub).stop()
+		*sub = nil
+		sendResponse(out, Response{Status: "success", Message: "Unsubscribed"})
+		return true
+	}
+
+	if *sub != nil {
+		sendResponse(out, Response{Status: "error", Message: "Only UNSUBSCRIBE is allowed while subscribed"})
+		return true
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pixperk/yakvs/client"
+	"github.com/pixperk/yakvs/tlsconfig"
+)
+
+// testCerts are the PEM files of a self-signed CA and the server and client
+// certificates it signed, the server's valid for 127.0.0.1
+type testCerts struct {
+	ca, serverCert, serverKey, clientCert, clientKey string
+}
+
+// writeTestCerts generates testCerts in a temporary directory
+func writeTestCerts(t *testing.T) testCerts {
+	t.Helper()
+
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "yakvs test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFile = writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+		keyFile = writePEM(t, filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+		return certFile, keyFile
+	}
+
+	certs := testCerts{ca: writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER)}
+	certs.serverCert, certs.serverKey = issue(2, "server", x509.ExtKeyUsageServerAuth)
+	certs.clientCert, certs.clientKey = issue(3, "client", x509.ExtKeyUsageClientAuth)
+	return certs
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) string {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serverTLS loads the server configuration, requiring client certificates
+// signed by the test CA if mutual is set
+func (c testCerts) serverTLS(t *testing.T, mutual bool) *tls.Config {
+	t.Helper()
+
+	caFile := ""
+	if mutual {
+		caFile = c.ca
+	}
+	cfg, err := tlsconfig.Server(c.serverCert, c.serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// clientTLS loads a client configuration trusting the test CA, presenting
+// the client certificate if withCert is set
+func (c testCerts) clientTLS(t *testing.T, withCert bool) *tls.Config {
+	t.Helper()
+
+	certFile, keyFile := "", ""
+	if withCert {
+		certFile, keyFile = c.clientCert, c.clientKey
+	}
+	cfg, err := tlsconfig.Client(c.ca, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// checkTLSRoundTrip writes and reads a key through a TLS client of addr
+func checkTLSRoundTrip(t *testing.T, addr string, cfg *tls.Config) {
+	t.Helper()
+
+	c, err := client.NewClientWithTLS(addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Set("secret", "over tls", 0); err != nil {
+		t.Fatalf("Set over TLS: %v", err)
+	}
+	if got, _, err := c.Get("secret"); err != nil || got != "over tls" {
+		t.Fatalf("Get over TLS = %q, %v", got, err)
+	}
+}
+
+func TestTLSRoundTrip(t *testing.T) {
+	certs := writeTestCerts(t)
+	s := startTestServer(t, func(s *Server) { s.SetTLSConfig(certs.serverTLS(t, false)) })
+	addr := s.Listener().Addr().String()
+
+	checkTLSRoundTrip(t, addr, certs.clientTLS(t, false))
+
+	// Plain TCP clients get no answer they can read
+	conn := dialTest(t, addr)
+	conn.sendLine(t, []byte(`{"op":"PING"}`))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, err := conn.r.ReadBytes('\n'); err == nil {
+		t.Errorf("plain TCP client got a response: %q", line)
+	}
+
+	// Clients that do not trust the server's CA fail the handshake
+	if c, err := client.NewClientWithTLS(addr, &tls.Config{MinVersion: tls.VersionTLS12}); err == nil {
+		err = c.Set("key", "value", 0)
+		c.Close()
+		if err == nil {
+			t.Error("a client not trusting the test CA connected")
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	certs := writeTestCerts(t)
+	s := startTestServer(t, func(s *Server) { s.SetTLSConfig(certs.serverTLS(t, true)) })
+	addr := s.Listener().Addr().String()
+
+	if c, err := client.NewClientWithTLS(addr, certs.clientTLS(t, false)); err == nil {
+		err = c.Set("key", "value", 0)
+		c.Close()
+		if err == nil {
+			t.Error("a client without a certificate was served")
+		}
+	}
+	checkTLSRoundTrip(t, addr, certs.clientTLS(t, true))
+}
+
+func TestRaftTLSRedirects(t *testing.T) {
+	certs := writeTestCerts(t)
+	nodes := startRaftCluster(t, 3, func(s *RaftServer) { s.SetTLSConfig(certs.serverTLS(t, true)) })
+	var leader, follower *raftNode
+	for _, node := range nodes {
+		if node.store.IsLeader() {
+			leader = node
+		} else {
+			follower = node
+		}
+	}
+	if leader == nil {
+		t.Fatal("no leader")
+	}
+
+	// Connected to a follower, the client follows the redirect to the
+	// leader over TLS, presenting its certificate again
+	c, err := client.NewRaftClientWithTLS(follower.addr, certs.clientTLS(t, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Set("secret", "over tls", 0); err != nil {
+		t.Fatalf("Set through a follower over TLS: %v", err)
+	}
+	if addr := c.ServerAddr(); addr != leader.addr {
+		t.Errorf("client ended up at %s, want the leader at %s", addr, leader.addr)
+	}
+	if got, _, err := c.Get("secret"); err != nil || got != "over tls" {
+		t.Errorf("Get over TLS = %q, %v", got, err)
+	}
+	waitUntil(t, "the write to reach the follower", func() bool {
+		v, ok := follower.store.Get("secret")
+		return ok && v.Data == "over tls"
+	})
+}
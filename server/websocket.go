@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// WebSocket opcodes, from RFC 6455
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// wsGUID is appended to the client's key to prove the server speaks WebSocket
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSFrame caps the frames read from a watcher, which only ever needs to
+// send control frames
+const maxWSFrame = 64 << 10
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// handleWatch upgrades GET /watch?pattern= to a WebSocket and sends every
+// change to a matching key as a text message holding the JSON event that
+// SUBSCRIBE pushes. Events are queued and dropped like a subscribed
+// connection's, and the watch is cancelled when the socket closes.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		writeHTTPResponse(w, Response{Status: "error", Message: "Key pattern is required"})
+		return
+	}
+
+	user, failed := s.httpUser(r)
+	if failed == nil {
+		failed = authorize(s.users, &user, Command{Op: "SUBSCRIBE", Key: pattern})
+	}
+	if failed != nil {
+		writeHTTPResponse(w, *failed)
+		return
+	}
+
+	conn, reader, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer conn.Close()
+
+	out := &connWriter{conn: conn, timeout: s.writeTimeout, limit: s.outputBufferLimit, slow: &s.slowConsumers}
+	prefix, exact := splitPattern(pattern)
+	events, cancel := s.db.Watch(prefix, subscriberBuffer)
+	defer cancel()
+
+	// The client only sends pings and the closing handshake, but it must
+	// be read to notice it going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			opcode, payload, err := readWSFrame(reader)
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case wsPing:
+				out.Write(wsFrame(wsPong, payload))
+			case wsClose:
+				out.Write(wsFrame(wsClose, nil))
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				// The store is closing
+				out.Write(wsFrame(wsClose, nil))
+				return
+			}
+			if exact && ev.Key != pattern {
+				continue
+			}
+			data, err := eventJSON(ev)
+			if err != nil {
+				continue
+			}
+			if err := out.push(wsFrame(wsText, data)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. Nothing has been written to w if it fails.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.Reader, error) {
+	if r.Method != http.MethodGet ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") {
+		return nil, nil, errors.New("expected a WebSocket upgrade")
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		return nil, nil, fmt.Errorf("unsupported WebSocket version %q, want 13", v)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be upgraded")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to take over connection: %w", err)
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+	return conn, rw.Reader, nil
+}
+
+// headerHas reports whether the comma separated header holds token
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsFrame builds an unfragmented, unmasked frame, as servers send them
+func wsFrame(opcode byte, payload []byte) []byte {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	return append(frame, payload...)
+}
+
+// readWSFrame reads one frame from a client, unmasking its payload
+func readWSFrame(r *bufio.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > maxWSFrame {
+		return 0, nil, errFrameTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,139 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	blobSuffix = ".snap"
+	metaSuffix = ".meta.json"
+)
+
+// LocalStorage keeps snapshots as files in a directory, each blob next to a
+// JSON metadata file
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates a storage rooted at dir, creating it if needed
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return &LocalStorage{dir: dir}, nil
+}
+
+func (l *LocalStorage) Put(meta Metadata, r io.Reader) error {
+	blobPath := filepath.Join(l.dir, meta.ID+blobSuffix)
+
+	// Write to a temporary file first so a failed upload never replaces a
+	// good snapshot
+	tmp, err := os.CreateTemp(l.dir, meta.ID+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	meta.Size = n
+
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(l.dir, meta.ID+metaSuffix), metaData, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), blobPath)
+}
+
+func (l *LocalStorage) Get(id string) (io.ReadCloser, Metadata, error) {
+	meta, err := l.readMeta(id)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	f, err := os.Open(filepath.Join(l.dir, id+blobSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Metadata{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return f, meta, nil
+}
+
+func (l *LocalStorage) List() ([]Metadata, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []Metadata
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, blobSuffix) {
+			continue
+		}
+
+		meta, err := l.readMeta(strings.TrimSuffix(name, blobSuffix))
+		if err != nil {
+			continue
+		}
+		metas = append(metas, meta)
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].CreatedAt.After(metas[j].CreatedAt)
+	})
+	return metas, nil
+}
+
+func (l *LocalStorage) Delete(id string) error {
+	err := os.Remove(filepath.Join(l.dir, id+blobSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	os.Remove(filepath.Join(l.dir, id+metaSuffix))
+	return nil
+}
+
+func (l *LocalStorage) readMeta(id string) (Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, id+metaSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return Metadata{}, ErrNotFound
+	}
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Metadata{}, fmt.Errorf("invalid metadata for snapshot %s: %w", id, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,357 @@
+package snapshot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3-compatible object store
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3ConfigFromURL builds a config from s3://bucket/prefix?endpoint=...&region=...
+// with credentials taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func S3ConfigFromURL(u *url.URL) S3Config {
+	q := u.Query()
+	return S3Config{
+		Endpoint:  q.Get("endpoint"),
+		Region:    q.Get("region"),
+		Bucket:    u.Host,
+		Prefix:    strings.Trim(u.Path, "/"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+}
+
+// S3Storage keeps snapshots as objects in an S3-compatible bucket, using
+// path-style requests signed with AWS Signature Version 4
+type S3Storage struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+// NewS3Storage creates a storage for the bucket described by cfg
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3 credentials are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+
+	// No overall timeout: snapshot uploads can legitimately take minutes
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+
+	return &S3Storage{
+		cfg:    cfg,
+		client: &http.Client{Transport: transport},
+	}, nil
+}
+
+func (s *S3Storage) Put(meta Metadata, r io.Reader) error {
+	req, err := s.newRequest(http.MethodPut, s.objectKey(meta.ID), nil, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = meta.Size
+	setMetaHeaders(req.Header, meta)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Get(id string) (io.ReadCloser, Metadata, error) {
+	req, err := s.newRequest(http.MethodGet, s.objectKey(id), nil, nil)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return resp.Body, metaFromHeaders(id, resp), nil
+}
+
+func (s *S3Storage) List() ([]Metadata, error) {
+	var metas []Metadata
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if s.cfg.Prefix != "" {
+			query.Set("prefix", s.cfg.Prefix+"/")
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := s.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid list response: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, s.cfg.Prefix+"/")
+			if !strings.HasSuffix(name, blobSuffix) {
+				continue
+			}
+
+			// The listing has no user metadata, so fetch it per object
+			meta, err := s.head(strings.TrimSuffix(name, blobSuffix))
+			if err != nil {
+				return nil, err
+			}
+			metas = append(metas, meta)
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].CreatedAt.After(metas[j].CreatedAt)
+	})
+	return metas, nil
+}
+
+func (s *S3Storage) Delete(id string) error {
+	req, err := s.newRequest(http.MethodDelete, s.objectKey(id), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) head(id string) (Metadata, error) {
+	req, err := s.newRequest(http.MethodHead, s.objectKey(id), nil, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	resp.Body.Close()
+
+	return metaFromHeaders(id, resp), nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Storage) objectKey(id string) string {
+	if s.cfg.Prefix == "" {
+		return id + blobSuffix
+	}
+	return s.cfg.Prefix + "/" + id + blobSuffix
+}
+
+func (s *S3Storage) newRequest(method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	path := "/" + s.cfg.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+
+	rawURL := s.cfg.Endpoint + uriEncode(path, false)
+	if len(query) > 0 {
+		rawURL += "?" + canonicalQuery(query)
+	}
+
+	return http.NewRequest(method, rawURL, body)
+}
+
+// do signs and sends a request, turning non-2xx responses into errors
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s failed with status %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header. The payload is
+// left unsigned so large snapshots can be streamed without hashing them first.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	// Sign the host and every x-amz-* header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func setMetaHeaders(h http.Header, meta Metadata) {
+	h.Set("x-amz-meta-created-at", meta.CreatedAt.UTC().Format(time.RFC3339Nano))
+	h.Set("x-amz-meta-index", strconv.FormatUint(meta.Index, 10))
+	h.Set("x-amz-meta-term", strconv.FormatUint(meta.Term, 10))
+}
+
+func metaFromHeaders(id string, resp *http.Response) Metadata {
+	meta := Metadata{ID: id, Size: resp.ContentLength}
+	meta.CreatedAt, _ = time.Parse(time.RFC3339Nano, resp.Header.Get("x-amz-meta-created-at"))
+	meta.Index, _ = strconv.ParseUint(resp.Header.Get("x-amz-meta-index"), 10, 64)
+	meta.Term, _ = strconv.ParseUint(resp.Header.Get("x-amz-meta-term"), 10, 64)
+	return meta
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except unreserved characters, and
+// slashes unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
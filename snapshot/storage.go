@@ -0,0 +1,63 @@
+// Package snapshot stores snapshot blobs outside the node that produced them,
+// so a cluster can be recovered onto fresh machines.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a snapshot does not exist in the storage
+var ErrNotFound = errors.New("snapshot not found")
+
+// Metadata describes a stored snapshot
+type Metadata struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	// Index and Term identify the raft log position a snapshot covers. They
+	// are zero for snapshots that do not come from raft.
+	Index uint64 `json:"index,omitempty"`
+	Term  uint64 `json:"term,omitempty"`
+}
+
+// Storage is a place snapshot blobs can be written to and read back from
+type Storage interface {
+	// Put stores the snapshot read from r under meta.ID. meta.Size must be
+	// the exact number of bytes r yields.
+	Put(meta Metadata, r io.Reader) error
+	// Get opens the snapshot with the given ID
+	Get(id string) (io.ReadCloser, Metadata, error)
+	// List returns the metadata of all stored snapshots, newest first
+	List() ([]Metadata, error)
+	// Delete removes the snapshot with the given ID
+	Delete(id string) error
+}
+
+// Open creates a Storage from a backend specification. A plain path or a
+// file:// URL selects a local directory; s3://bucket/prefix selects an
+// S3-compatible object store, configured with the endpoint and region query
+// parameters and credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func Open(spec string) (Storage, error) {
+	if !strings.Contains(spec, "://") {
+		return NewLocalStorage(spec)
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot backend %q: %w", spec, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewLocalStorage(u.Path)
+	case "s3":
+		return NewS3Storage(S3ConfigFromURL(u))
+	default:
+		return nil, fmt.Errorf("unsupported snapshot backend scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of write in a batch
+const (
+	BatchSet    = "SET"
+	BatchDelete = "DELETE"
+	BatchExpire = "EXPIRE"
+)
+
+// ErrInvalidBatch is returned by ApplyBatch when an op is malformed. Nothing
+// is applied.
+var ErrInvalidBatch = errors.New("invalid batch")
+
+// Op is one write in a batch applied by ApplyBatch
+type Op struct {
+	Kind string // BatchSet, BatchDelete or BatchExpire
+	Key  string
+	// Value is the value to set. An expire only uses its ExpiresAt, zero
+	// meaning never.
+	Value Value
+}
+
+// batchView tracks the state of the keys a batch has touched so far, so each
+// op sees the effect of the ones before it
+type batchView struct {
+	s       *Store
+	changed map[string]*Value // nil for keys the batch deleted
+}
+
+func (v *batchView) get(key string, now time.Time) (Value, bool) {
+	if val, ok := v.changed[key]; ok {
+		if val == nil {
+			return Value{}, false
+		}
+		return *val, true
+	}
+	val, ok := v.s.data[key]
+	if !ok || val.Expired(now) {
+		return Value{}, false
+	}
+	return val, true
+}
+
+// ValidateBatch checks that every op has a key and a known kind
+func ValidateBatch(ops []Op) error {
+	for i, op := range ops {
+		if op.Key == "" {
+			return fmt.Errorf("%w: op %d has no key", ErrInvalidBatch, i+1)
+		}
+		switch op.Kind {
+		case BatchSet, BatchDelete, BatchExpire:
+		default:
+			return fmt.Errorf("%w: op %d has unknown kind %q", ErrInvalidBatch, i+1, op.Kind)
+		}
+	}
+	return nil
+}
+
+// ApplyBatch applies ops atomically: every op is validated first, then all of
+// them are logged as a single record and applied under one lock. A crash
+// while the record is being written discards the whole batch on recovery.
+// Expiring a key that is missing, and deleting one, are not errors; they just
+// have no effect.
+func (s *Store) ApplyBatch(ops []Op) error {
+	return s.ApplyBatchAsOf(ops, time.Now())
+}
+
+// ApplyBatchAsOf is ApplyBatch judging expiry and stamping versions as of now,
+// for raft replicas applying the proposing node's clock
+func (s *Store) ApplyBatchAsOf(ops []Op, now time.Time) error {
+	if err := ValidateBatch(ops); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	var size int64
+	for _, op := range ops {
+		if op.Kind == BatchSet {
+			if err := s.limits.Check(op.Key, len(op.Value.Data)); err != nil {
+				return err
+			}
+			size += int64(len(op.Key)+len(op.Value.Data)) + entryOverhead
+		}
+	}
+	if err := s.makeRoom("", size); err != nil {
+		return err
+	}
+
+	view := batchView{s: s, changed: make(map[string]*Value)}
+	var recs []walRecord
+	for _, op := range ops {
+		old, live := view.get(op.Key, now)
+		switch op.Kind {
+		case BatchSet:
+			val := stampValue(old, live, op.Value, now)
+			view.changed[op.Key] = &val
+			recs = append(recs, walRecord{op: opSet, key: op.Key, value: val})
+
+		case BatchDelete:
+			// Like Delete, an expired key that is still stored is removed
+			_, stored := s.data[op.Key]
+			if val, ok := view.changed[op.Key]; ok {
+				stored = val != nil
+			}
+			if !stored {
+				continue
+			}
+			view.changed[op.Key] = nil
+			recs = append(recs, walRecord{op: opDelete, key: op.Key})
+
+		case BatchExpire:
+			if !live || (op.Value.ExpiresAt.IsZero() && old.ExpiresAt.IsZero()) {
+				continue
+			}
+			val := old
+			val.ExpiresAt = op.Value.ExpiresAt
+			val = stampValue(old, live, val, now)
+			view.changed[op.Key] = &val
+			recs = append(recs, walRecord{op: opSet, key: op.Key, value: val})
+		}
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+
+	if s.log != nil {
+		record, err := encodeBatch(recs)
+		if err != nil {
+			return err
+		}
+		if err := s.writeLog(record); err != nil {
+			return fmt.Errorf("failed to log batch: %w", err)
+		}
+	}
+	for _, ev := range s.applyRecord(walRecord{op: opBatch, batch: recs}) {
+		s.publish(ev.Op, ev.Key, ev.Value)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AutoCompaction controls when the store compacts its log on its own. The log
+// is compacted once it is at least MinSize bytes and Ratio times the size its
+// live keys would take. A zero Ratio disables automatic compaction.
+type AutoCompaction struct {
+	Ratio   float64
+	MinSize int64
+}
+
+// DefaultAutoCompaction is the automatic compaction of a newly opened store
+var DefaultAutoCompaction = AutoCompaction{
+	Ratio:   4,
+	MinSize: 64 << 20,
+}
+
+func (ac AutoCompaction) check() error {
+	if ac.Ratio != 0 && ac.Ratio <= 1 {
+		return fmt.Errorf("compaction ratio must be greater than 1, or 0 to disable it")
+	}
+	if ac.MinSize < 0 {
+		return fmt.Errorf("compaction minimum size must not be negative")
+	}
+	return nil
+}
+
+// SetAutoCompaction changes when the log is compacted automatically.
+// Replicas streaming a primary's log must not compact it, so they disable it.
+func (s *Store) SetAutoCompaction(ac AutoCompaction) error {
+	if err := ac.check(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.autoCompaction = ac
+	return nil
+}
+
+// AutoCompaction returns when the log is compacted automatically
+func (s *Store) AutoCompaction() AutoCompaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.autoCompaction
+}
+
+// Compact rewrites the log so it holds only the current non-expired keys,
+// dropping the history of overwritten, deleted and expired ones. The new log
+// is written to a temporary file, fsynced and renamed over the old one. Other
+// operations on the store block while the live keys are written out.
+//
+// Log offsets from before the compaction are meaningless afterwards, so
+// replicas streaming the log must start over, see LogGeneration.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if s.log == nil {
+		return nil
+	}
+
+	start, before := time.Now(), s.logSize
+	if err := s.rewriteLog(""); err != nil {
+		return fmt.Errorf("failed to compact log: %w", err)
+	}
+	s.counters.compactions.Add(1)
+	if before > s.logSize {
+		s.counters.compactionReclaimed.Add(uint64(before - s.logSize))
+	}
+	s.counters.lastCompactionDuration.Store(int64(time.Since(start)))
+	s.counters.lastCompaction.Store(time.Now().UnixNano())
+	return nil
+}
+
+// maybeCompact starts a compaction in the background if the log has grown
+// past the automatic compaction threshold and none is running. Callers must
+// hold the write lock.
+func (s *Store) maybeCompact() {
+	ac := s.autoCompaction
+	if ac.Ratio == 0 || s.log == nil || s.logSize < ac.MinSize {
+		return
+	}
+	live := s.headerSize() + s.usage.logBytes
+	if s.logEncrypted {
+		live += int64(len(s.data)) * s.enc.overhead()
+	}
+	if float64(s.logSize) < ac.Ratio*float64(live) {
+		return
+	}
+	if !s.compacting.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer s.compacting.Store(false)
+
+		if err := s.Compact(); err != nil && !errors.Is(err, ErrClosed) {
+			fmt.Printf("Error compacting log automatically: %v\n", err)
+		}
+	}()
+}
+
+// LogGeneration returns a counter that changes whenever the log is rewritten
+// by Compact, invalidating all earlier offsets. It is kept in the log's ID
+// record, so it survives restarts, and a replica reports its primary's.
+func (s *Store) LogGeneration() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.logGeneration
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+)
+
+// An encrypted log starts with encMagic instead of walMagic, followed by the
+// version and a key check value that identifies the key without revealing
+// it. Every record frame is then sealed whole with AES-GCM:
+//
+//	length  uint32  size of the rest of the frame
+//	crc     uint32  CRC-32 (IEEE) of everything after this field
+//	marker  byte    opSealed, which is never a valid op
+//	nonce   [12]byte
+//	sealed  []byte  the plain record frame, encrypted and authenticated
+//
+// The outer checksum keeps torn and corrupt records detectable without the
+// key, so a record that is intact but fails to decrypt means the wrong key or
+// tampering, and replay refuses to continue rather than dropping it.
+//
+// Nonces are random, which is safe for up to about 2^32 records per key.
+const (
+	encMagic      = "YAKVSENC"
+	kcvSize       = 8
+	encHeaderSize = walHeaderSize + kcvSize
+
+	// opSealed marks a sealed frame, so a store without the key reports
+	// ErrEncrypted instead of a corrupt record
+	opSealed byte = 0xE0
+)
+
+// EncryptionKeyEnv names the environment variable the servers read a hex
+// encoded encryption key from when no key file is given
+const EncryptionKeyEnv = "YAKVS_ENCRYPTION_KEY"
+
+var (
+	// ErrEncrypted is returned when opening an encrypted log or snapshot
+	// without a key
+	ErrEncrypted = errors.New("data is encrypted and no encryption key was given")
+	// ErrWrongKey is returned when opening an encrypted log or snapshot with
+	// a different key than it was written with
+	ErrWrongKey = errors.New("encryption key does not match")
+	// ErrDecrypt is returned for an intact record that fails to decrypt
+	ErrDecrypt = errors.New("record failed to decrypt")
+)
+
+// encryption seals and opens record frames with one key
+type encryption struct {
+	aead cipher.AEAD
+	kcv  []byte
+}
+
+func newEncryption(key []byte) (*encryption, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key check value is the encryption of a zero block, so the same key
+	// always produces the same header and a replica's log can mirror its
+	// primary's byte for byte
+	kcv := make([]byte, aes.BlockSize)
+	block.Encrypt(kcv, make([]byte, aes.BlockSize))
+	return &encryption{aead: aead, kcv: kcv[:kcvSize]}, nil
+}
+
+// seal encrypts a plain record frame into a sealed one
+func (e *encryption) seal(frame []byte) []byte {
+	nonceSize := e.aead.NonceSize()
+	sealed := make([]byte, 9+nonceSize, 9+nonceSize+len(frame)+e.aead.Overhead())
+	sealed[8] = opSealed
+	rand.Read(sealed[9:])
+	sealed = e.aead.Seal(sealed, sealed[9:], frame, nil)
+
+	binary.BigEndian.PutUint32(sealed, uint32(len(sealed)-4))
+	binary.BigEndian.PutUint32(sealed[4:], crc32.ChecksumIEEE(sealed[8:]))
+	return sealed
+}
+
+// overhead is the number of bytes sealing adds to a frame
+func (e *encryption) overhead() int64 {
+	return int64(9 + e.aead.NonceSize() + e.aead.Overhead())
+}
+
+// open checks and decrypts a sealed frame, returning the plain record frame.
+// Damage is reported as ErrCorruptLog and a failed decryption as ErrDecrypt.
+func (e *encryption) open(sealed []byte) ([]byte, error) {
+	nonceSize := e.aead.NonceSize()
+	if len(sealed) < 9+nonceSize+e.aead.Overhead() {
+		return nil, fmt.Errorf("%w: sealed record too short", ErrCorruptLog)
+	}
+	if int(binary.BigEndian.Uint32(sealed)) != len(sealed)-4 {
+		return nil, fmt.Errorf("%w: length prefix does not match record", ErrCorruptLog)
+	}
+	if crc32.ChecksumIEEE(sealed[8:]) != binary.BigEndian.Uint32(sealed[4:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptLog)
+	}
+	if sealed[8] != opSealed {
+		return nil, fmt.Errorf("%w: record is not encrypted", ErrCorruptLog)
+	}
+
+	frame, err := e.aead.Open(nil, sealed[9:9+nonceSize], sealed[9+nonceSize:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return frame, nil
+}
+
+// checkKey compares the key check value read from a header with this key's
+func (e *encryption) checkKey(kcv []byte) error {
+	if string(kcv) != string(e.kcv) {
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// ParseEncryptionKey decodes a hex encoded AES key of 16, 24 or 32 bytes
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be hex encoded: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes, got %d", len(key))
+}
+
+// LoadEncryptionKey reads a hex encoded key from the file at path or, if path
+// is empty, from the EncryptionKeyEnv environment variable. It returns nil
+// if neither is set.
+func LoadEncryptionKey(path string) ([]byte, error) {
+	if path == "" {
+		if env := os.Getenv(EncryptionKeyEnv); env != "" {
+			return ParseEncryptionKey(env)
+		}
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	return ParseEncryptionKey(string(data))
+}
+
+// logHeader returns the header a new log of this store starts with
+func (s *Store) logHeader() []byte {
+	if s.enc == nil {
+		return walHeader()
+	}
+	return append(append([]byte(encMagic), walVersion), s.enc.kcv...)
+}
+
+// headerSize returns the size of the current log file's header
+func (s *Store) headerSize() int64 {
+	if s.logEncrypted {
+		return int64(encHeaderSize)
+	}
+	return int64(walHeaderSize)
+}
+
+// sealRecord seals a record frame if the log is encrypted
+func (s *Store) sealRecord(frame []byte) []byte {
+	if !s.logEncrypted {
+		return frame
+	}
+	return s.enc.seal(frame)
+}
+
+// openRecord decodes a frame read from the log, opening it first if the log
+// is encrypted
+func (s *Store) openRecord(frame []byte) (walRecord, error) {
+	if s.logEncrypted {
+		var err error
+		if frame, err = s.enc.open(frame); err != nil {
+			return walRecord{}, err
+		}
+	}
+	return decodeRecord(frame)
+}
+
+// checkLogKey verifies that an encrypted log was written with the store's
+// key
+func (s *Store) checkLogKey() error {
+	if s.enc == nil {
+		return ErrEncrypted
+	}
+
+	kcv := make([]byte, kcvSize)
+	if _, err := s.log.ReadAt(kcv, int64(walHeaderSize)); err != nil {
+		return err
+	}
+	return s.enc.checkKey(kcv)
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func BenchmarkSetEncrypted(b *testing.B) {
+	// SyncNever leaves the cost of encrypting each record as the difference
+	for _, encrypted := range []bool{false, true} {
+		name := "plain"
+		opts := StoreOptions{SyncPolicy: SyncNever}
+		if encrypted {
+			name = "aes-gcm"
+			opts.EncryptionKey = bytes.Repeat([]byte{0x42}, 32)
+		}
+		b.Run(name, func(b *testing.B) {
+			benchSets(b, openBenchStore(b, opts))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// ErrOutOfMemory is returned by writes that would take the store past its
+// memory limit when nothing can be evicted to make room. Nothing is written.
+var ErrOutOfMemory = errors.New("out of memory")
+
+// EvictionPolicy chooses which keys a store with a memory limit drops to make
+// room for a write
+type EvictionPolicy string
+
+const (
+	// EvictNone rejects writes over the limit with ErrOutOfMemory
+	EvictNone EvictionPolicy = "noeviction"
+	// EvictAllKeysRandom drops keys chosen at random
+	EvictAllKeysRandom EvictionPolicy = "allkeys-random"
+	// EvictVolatileTTL drops the keys closest to expiring, and rejects the
+	// write once no key with an expiry is left
+	EvictVolatileTTL EvictionPolicy = "volatile-ttl"
+)
+
+// DefaultEvictionPolicy is the policy of a newly opened store
+const DefaultEvictionPolicy = EvictNone
+
+// ParseEvictionPolicy converts "noeviction", "allkeys-random" or
+// "volatile-ttl" to an EvictionPolicy
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch policy := EvictionPolicy(s); policy {
+	case EvictNone, EvictAllKeysRandom, EvictVolatileTTL:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown eviction policy %q, want noeviction, allkeys-random or volatile-ttl", s)
+}
+
+// SetMaxMemory limits the store to about maxBytes of memory, as estimated by
+// MemoryStats, and sets what writes do once it is reached. Zero means no
+// limit. Entries already stored are kept until the next write needs room.
+func (s *Store) SetMaxMemory(maxBytes int64, policy EvictionPolicy) error {
+	if maxBytes < 0 {
+		return fmt.Errorf("max memory must not be negative")
+	}
+	if _, err := ParseEvictionPolicy(string(policy)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxMemory = maxBytes
+	s.evictionPolicy = policy
+	return nil
+}
+
+// checkWrite checks a write of a value of valueSize bytes to key against the
+// limits and makes room for it under the memory limit. Callers must hold the
+// write lock.
+func (s *Store) checkWrite(key string, valueSize int) error {
+	if err := s.limits.Check(key, valueSize); err != nil {
+		return err
+	}
+	return s.makeRoom(key, int64(len(key)+valueSize)+entryOverhead)
+}
+
+// makeRoom evicts keys other than key until size more bytes fit under the
+// memory limit, logging each delete first. An empty key spares nothing, for
+// batches whose values are worked out after making room. The size is an upper
+// bound that ignores the memory an overwritten value frees. Callers must hold
+// the write lock.
+func (s *Store) makeRoom(key string, size int64) error {
+	if s.maxMemory <= 0 {
+		return nil
+	}
+
+	for s.usage.bytes+size > s.maxMemory {
+		victim, ok := s.evictionCandidate(key)
+		if !ok {
+			return fmt.Errorf("%w: %d of %d bytes used", ErrOutOfMemory, s.usage.bytes, s.maxMemory)
+		}
+
+		if err := s.appendRecord(opDelete, victim, Value{}); err != nil {
+			return fmt.Errorf("failed to log eviction of %q: %w", victim, err)
+		}
+		val, _ := s.remove(victim)
+		s.counters.evicted.Add(1)
+		s.publish(EventDelete, victim, val)
+	}
+	return nil
+}
+
+// evictionCandidate picks the next key to evict under the store's policy,
+// never key itself. Callers must hold the write lock.
+func (s *Store) evictionCandidate(key string) (string, bool) {
+	switch s.evictionPolicy {
+	case EvictAllKeysRandom:
+		for attempt := 0; attempt < randomKeyAttempts && len(s.data) > 1; attempt++ {
+			victim, ok := s.index.at(rand.Intn(len(s.data)))
+			if ok && victim != key {
+				return victim, true
+			}
+		}
+
+	case EvictVolatileTTL:
+		for len(s.expiries) > 0 {
+			e := s.expiries[0]
+			if !s.isCurrent(e) {
+				heap.Pop(&s.expiries)
+				continue
+			}
+			if e.key != key {
+				return e.key, true
+			}
+			break
+		}
+	}
+	return "", false
+}
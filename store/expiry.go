@@ -0,0 +1,153 @@
+package store
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// expiryEntry records when key was due to expire at the time it was written
+type expiryEntry struct {
+	at  int64 // ExpiresAt in UnixNano
+	key string
+}
+
+// expiryQueue is a min-heap of keys by expiry, so the cleaner only visits
+// keys that are due. Entries are never updated in place: a key whose expiry
+// changes or that is deleted leaves a stale entry behind, which is skipped
+// when it no longer matches the key's current ExpiresAt.
+type expiryQueue []expiryEntry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].at < q[j].at }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x any)        { *q = append(*q, x.(expiryEntry)) }
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = expiryEntry{}
+	*q = old[:len(old)-1]
+	return e
+}
+
+// trackExpiry queues key for the cleaner if value expires. Callers must hold
+// the write lock.
+func (s *Store) trackExpiry(key string, value Value) {
+	if value.ExpiresAt.IsZero() {
+		return
+	}
+	heap.Push(&s.expiries, expiryEntry{at: value.ExpiresAt.UnixNano(), key: key})
+
+	// Keys rewritten with a new expiry leave stale entries behind; drop them
+	// once they outnumber the live keys so the queue cannot grow unbounded
+	if len(s.expiries) > 2*len(s.data)+1024 {
+		s.rebuildExpiries()
+	}
+}
+
+// rebuildExpiries recreates the queue from the current entries. Callers must
+// hold the write lock.
+func (s *Store) rebuildExpiries() {
+	q := make(expiryQueue, 0, len(s.data))
+	for key, val := range s.data {
+		if !val.ExpiresAt.IsZero() {
+			q = append(q, expiryEntry{at: val.ExpiresAt.UnixNano(), key: key})
+		}
+	}
+	heap.Init(&q)
+	s.expiries = q
+}
+
+// isCurrent reports whether e still describes key's expiry. Callers must hold
+// the lock.
+func (s *Store) isCurrent(e expiryEntry) bool {
+	val, ok := s.data[e.key]
+	return ok && !val.ExpiresAt.IsZero() && val.ExpiresAt.UnixNano() == e.at
+}
+
+// expireKey removes key if it is still expired as of now, logging the delete
+// first. Get and TTL call it when they find an expired key so the entry does
+// not linger until the next sweep. A log failure leaves the key for the
+// cleaner, which reports it.
+func (s *Store) expireKey(key string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keepExpired {
+		return
+	}
+	val, ok := s.data[key]
+	if !ok || !val.Expired(now) {
+		return
+	}
+
+	if err := s.appendRecord(opDelete, key, Value{}); err != nil {
+		return
+	}
+	s.remove(key)
+	s.publish(EventExpire, key, val)
+}
+
+// SetLazyExpiry controls whether reads that find an expired key delete it
+// right away. It is on by default; replicas turn it off so their log only
+// holds the primary's records.
+func (s *Store) SetLazyExpiry(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keepExpired = !enabled
+}
+
+// ExpiredKeys returns up to max keys that expired before now, soonest first,
+// without removing them. A raft leader proposes their removal with
+// ExpireKeysAsOf so every node drops the same keys.
+func (s *Store) ExpiredKeys(now time.Time, max int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	var due []expiryEntry
+	for len(s.expiries) > 0 && s.expiries[0].at < now.UnixNano() && len(keys) < max {
+		e := heap.Pop(&s.expiries).(expiryEntry)
+		if !s.isCurrent(e) {
+			continue
+		}
+		keys = append(keys, e.key)
+		due = append(due, e)
+	}
+
+	// The keys stay queued until they are removed
+	for _, e := range due {
+		heap.Push(&s.expiries, e)
+	}
+	return keys
+}
+
+// ExpireKeysAsOf removes those of keys that are expired as of now, logging
+// each delete first, and returns how many it removed. Keys written again
+// with a later expiry or none since they were listed are kept.
+func (s *Store) ExpireKeysAsOf(keys []string, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	n := 0
+	for _, key := range keys {
+		val, ok := s.data[key]
+		if !ok || !val.Expired(now) {
+			continue
+		}
+
+		if err := s.appendRecord(opDelete, key, Value{}); err != nil {
+			return n, fmt.Errorf("failed to log expiry of %q: %w", key, err)
+		}
+		s.remove(key)
+		s.publish(EventExpire, key, val)
+		n++
+	}
+	return n, nil
+}
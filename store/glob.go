@@ -0,0 +1,166 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// scanWorkFactor bounds how many keys a Scan examines per key asked for, so
+// a pattern matching few keys cannot hold the lock over the whole store
+const scanWorkFactor = 10
+
+// MatchGlob reports whether key matches pattern. '*' matches any run of
+// bytes, '?' any single byte, "[abc]", "[a-z]" and "[^abc]" a byte in or not
+// in the set, and '\' escapes the next byte.
+func MatchGlob(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if MatchGlob(pattern, key[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if key == "" {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+
+		case '[':
+			if key == "" {
+				return false
+			}
+			matched, rest, ok := matchClass(pattern[1:], key[0])
+			if !ok {
+				// An unterminated class is matched literally
+				if key[0] != '[' {
+					return false
+				}
+				pattern, key = pattern[1:], key[1:]
+				continue
+			}
+			if !matched {
+				return false
+			}
+			pattern, key = rest, key[1:]
+
+		default:
+			c := pattern[0]
+			if c == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+				c = pattern[0]
+			}
+			if key == "" || key[0] != c {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+	return key == ""
+}
+
+// matchClass matches c against the class at the start of pattern, just past
+// its '['. It returns the rest of the pattern after the closing ']', or false
+// if the class is not terminated.
+func matchClass(pattern string, c byte) (matched bool, rest string, ok bool) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate, pattern = true, pattern[1:]
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		lo := pattern[i]
+		switch {
+		case lo == ']' && i > 0:
+			return matched != negate, pattern[i+1:], true
+		case lo == '\\' && i+1 < len(pattern):
+			i++
+			lo = pattern[i]
+		}
+
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return false, "", false
+}
+
+// globPrefix returns the literal bytes every key matching pattern starts with
+func globPrefix(pattern string) string {
+	var prefix strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*', '?', '[':
+			return prefix.String()
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				c = pattern[i]
+			}
+			prefix.WriteByte(c)
+		default:
+			prefix.WriteByte(c)
+		}
+	}
+	return prefix.String()
+}
+
+// Scan lists up to count live keys matching pattern that sort after cursor,
+// in sorted order, along with the cursor to pass to the next call. An empty
+// cursor starts from the first key, an empty pattern matches every key, and
+// an empty returned cursor means every key has been seen. At most
+// count*10 keys are examined per call, so a call may return fewer keys than
+// asked for, or none, without being done.
+//
+// Keys written or deleted between calls may or may not be listed, but a key
+// that exists for the whole scan is listed exactly once.
+func (s *Store) Scan(cursor, pattern string, count int) ([]string, string) {
+	if count <= 0 {
+		count = 1
+	}
+	prefix := globPrefix(pattern)
+	start := cursor
+	if start < prefix {
+		start = prefix
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []string{}
+	next, examined := "", 0
+	now := time.Now()
+	s.index.ascendFrom(start, func(key string) bool {
+		if key == cursor {
+			return true
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+
+		examined++
+		if !s.data[key].Expired(now) && (pattern == "" || MatchGlob(pattern, key)) {
+			keys = append(keys, key)
+		}
+		if len(keys) == count || examined == count*scanWorkFactor {
+			next = key
+			return false
+		}
+		return true
+	})
+	return keys, next
+}
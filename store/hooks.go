@@ -0,0 +1,105 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// expireQueueSize is how many expired keys can wait for the OnExpire
+// callbacks before further ones are dropped
+const expireQueueSize = 1024
+
+type expiredKey struct {
+	key   string
+	value Value
+}
+
+type expireHook struct {
+	fn func(key string, v Value)
+}
+
+// expireHooks holds the callbacks registered with OnExpire and the queue
+// feeding the goroutine that runs them
+type expireHooks struct {
+	mu      sync.Mutex
+	hooks   []*expireHook // in registration order, replaced rather than modified
+	queue   chan expiredKey
+	active  atomic.Bool // set while any callback is registered
+	dropped atomic.Uint64
+}
+
+// OnExpire registers fn to be called with every key removed because it
+// expired, whether by the background cleaner or by a read that found it
+// expired, along with the value it held. It returns a function that
+// unregisters fn.
+//
+// Callbacks run one at a time on a single goroutine, outside the store's
+// lock, in the order the keys expired and for each key in the order the
+// callbacks were registered. Expiries are queued for that goroutine without
+// blocking the store: if the callbacks fall expireQueueSize keys behind,
+// further expiries are dropped and counted in Stats. Callbacks may use the
+// store. They stop running once the store is closed.
+func (s *Store) OnExpire(fn func(key string, v Value)) func() {
+	h := &expireHook{fn: fn}
+
+	s.expireHooks.mu.Lock()
+	if s.expireHooks.queue == nil {
+		s.expireHooks.queue = make(chan expiredKey, expireQueueSize)
+		go s.runExpireHooks(s.expireHooks.queue)
+	}
+	s.expireHooks.hooks = append(s.expireHooks.hooks[:len(s.expireHooks.hooks):len(s.expireHooks.hooks)], h)
+	s.expireHooks.active.Store(true)
+	s.expireHooks.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.expireHooks.mu.Lock()
+			defer s.expireHooks.mu.Unlock()
+
+			hooks := make([]*expireHook, 0, len(s.expireHooks.hooks))
+			for _, other := range s.expireHooks.hooks {
+				if other != h {
+					hooks = append(hooks, other)
+				}
+			}
+			s.expireHooks.hooks = hooks
+			s.expireHooks.active.Store(len(hooks) > 0)
+		})
+	}
+}
+
+// notifyExpired queues an expired key for the OnExpire callbacks without
+// blocking. It is called with the store's write lock held.
+func (hs *expireHooks) notifyExpired(key string, value Value) {
+	// The queue is created before active is first set, so it can be read
+	// without the lock once active is seen
+	if !hs.active.Load() {
+		return
+	}
+
+	select {
+	case hs.queue <- expiredKey{key: key, value: value}:
+	default:
+		hs.dropped.Add(1)
+	}
+}
+
+// runExpireHooks calls the registered callbacks for each queued key until
+// the store is closed
+func (s *Store) runExpireHooks(queue <-chan expiredKey) {
+	for {
+		select {
+		case e := <-queue:
+			s.expireHooks.mu.Lock()
+			hooks := s.expireHooks.hooks
+			s.expireHooks.mu.Unlock()
+
+			for _, h := range hooks {
+				h.fn(e.key, e.value)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// expireAll sets each key with an hour to live, then expires them in order
+// as of two hours from now
+func expireAll(t *testing.T, s *Store, keys ...string) {
+	t.Helper()
+
+	for _, key := range keys {
+		if err := s.Set(key, NewValue("value "+key, time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := s.ExpireKeysAsOf(keys, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(keys) {
+		t.Fatalf("expired %d keys, want %d", n, len(keys))
+	}
+}
+
+// receive returns the next n calls sent on calls, failing the test if they
+// do not arrive within a few seconds
+func receive(t *testing.T, calls <-chan string, n int) []string {
+	t.Helper()
+
+	var got []string
+	for len(got) < n {
+		select {
+		case call := <-calls:
+			got = append(got, call)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d expiry callbacks, want %d: %v", len(got), n, got)
+		}
+	}
+	return got
+}
+
+func TestOnExpireOrder(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	calls := make(chan string, 100)
+	for _, name := range []string{"first", "second"} {
+		name := name
+		s.OnExpire(func(key string, v Value) {
+			calls <- fmt.Sprintf("%s %s %s", name, key, v.Data)
+		})
+	}
+
+	expireAll(t, s, "a", "b", "c")
+	want := []string{
+		"first a value a", "second a value a",
+		"first b value b", "second b value b",
+		"first c value c", "second c value c",
+	}
+	if got := receive(t, calls, len(want)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("callbacks ran as %q, want %q", got, want)
+	}
+
+	// Deleted keys are not expiries
+	mustSet(t, s, "d", "value d")
+	if err := s.Delete("d"); err != nil {
+		t.Fatal(err)
+	}
+	expireAll(t, s, "e")
+	if got := receive(t, calls, 2); got[0] != "first e value e" || got[1] != "second e value e" {
+		t.Errorf("callbacks after a delete ran as %q", got)
+	}
+}
+
+func TestOnExpireUnregister(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	calls := make(chan string, 100)
+	unregisterFirst := s.OnExpire(func(key string, v Value) { calls <- "first " + key })
+	s.OnExpire(func(key string, v Value) { calls <- "second " + key })
+
+	expireAll(t, s, "a")
+	receive(t, calls, 2)
+
+	unregisterFirst()
+	unregisterFirst() // a second call is harmless
+	expireAll(t, s, "b")
+	if got := receive(t, calls, 1); got[0] != "second b" {
+		t.Errorf("callback after unregistering = %q, want %q", got[0], "second b")
+	}
+
+	// A callback registered after an unregistration still runs
+	s.OnExpire(func(key string, v Value) { calls <- "third " + key })
+	expireAll(t, s, "c")
+	if got := receive(t, calls, 2); fmt.Sprint(got) != fmt.Sprint([]string{"second c", "third c"}) {
+		t.Errorf("callbacks = %q, want second then third", got)
+	}
+	select {
+	case call := <-calls:
+		t.Errorf("unexpected callback %q", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOnExpireDoesNotBlockTheStore(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	s.OnExpire(func(key string, v Value) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+
+	// The first expiry stalls the callback goroutine; the queue then fills
+	// and the expiries past it are dropped rather than waited for
+	keys := make([]string, expireQueueSize+10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%04d", i)
+	}
+	expireAll(t, s, keys[0])
+	<-started
+	for _, key := range keys[1:] {
+		if err := s.Set(key, NewValue("value "+key, time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.ExpireKeysAsOf(keys[1:], time.Now().Add(2*time.Hour))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expiring keys blocked on a stalled callback")
+	}
+	if stats := s.Stats(); stats.Keys != 0 {
+		t.Errorf("%d keys left after expiring them all", stats.Keys)
+	}
+
+	if dropped := s.Stats().ExpireCallbacksDropped; dropped != uint64(len(keys)-1-expireQueueSize) {
+		t.Errorf("dropped %d expiry callbacks, want %d", dropped, len(keys)-1-expireQueueSize)
+	}
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// indexBlockSize is the most keys a block of the key index holds before it
+// is split in two
+const indexBlockSize = 512
+
+// keyIndex keeps every key in sorted order so prefix and range scans only
+// visit the matching keys. The keys live in a list of small sorted blocks, which keeps
+// an insert from shifting more than one block's worth of keys.
+type keyIndex struct {
+	blocks [][]string
+}
+
+// newKeyIndex builds an index from keys, which must be sorted and unique
+func newKeyIndex(keys []string) keyIndex {
+	var ix keyIndex
+	for i := 0; i < len(keys); i += indexBlockSize {
+		j := min(i+indexBlockSize, len(keys))
+		// Cap each block so an insert reallocates it instead of spilling
+		// into the next one
+		ix.blocks = append(ix.blocks, keys[i:j:j])
+	}
+	return ix
+}
+
+// block returns the index of the block that holds key, or would hold it
+func (ix *keyIndex) block(key string) int {
+	b := sort.Search(len(ix.blocks), func(i int) bool {
+		blk := ix.blocks[i]
+		return blk[len(blk)-1] >= key
+	})
+	if b == len(ix.blocks) {
+		b--
+	}
+	return b
+}
+
+func (ix *keyIndex) insert(key string) {
+	if len(ix.blocks) == 0 {
+		ix.blocks = [][]string{{key}}
+		return
+	}
+
+	b := ix.block(key)
+	blk := ix.blocks[b]
+	i := sort.SearchStrings(blk, key)
+	if i < len(blk) && blk[i] == key {
+		return
+	}
+
+	blk = append(blk, "")
+	copy(blk[i+1:], blk[i:])
+	blk[i] = key
+	ix.blocks[b] = blk
+
+	if len(blk) > indexBlockSize {
+		half := len(blk) / 2
+		right := append([]string(nil), blk[half:]...)
+		ix.blocks[b] = blk[:half]
+		ix.blocks = append(ix.blocks, nil)
+		copy(ix.blocks[b+2:], ix.blocks[b+1:])
+		ix.blocks[b+1] = right
+	}
+}
+
+func (ix *keyIndex) remove(key string) {
+	if len(ix.blocks) == 0 {
+		return
+	}
+
+	b := ix.block(key)
+	blk := ix.blocks[b]
+	i := sort.SearchStrings(blk, key)
+	if i == len(blk) || blk[i] != key {
+		return
+	}
+
+	copy(blk[i:], blk[i+1:])
+	blk[len(blk)-1] = ""
+	blk = blk[:len(blk)-1]
+	if len(blk) == 0 {
+		ix.blocks = append(ix.blocks[:b], ix.blocks[b+1:]...)
+		return
+	}
+	ix.blocks[b] = blk
+}
+
+// ascend calls fn for each key starting with prefix in sorted order until fn
+// returns false
+func (ix *keyIndex) ascend(prefix string, fn func(key string) bool) {
+	ix.ascendFrom(prefix, func(key string) bool {
+		return strings.HasPrefix(key, prefix) && fn(key)
+	})
+}
+
+// ascendRange calls fn for each key from start up to but not including end in
+// sorted order until fn returns false. An empty end means no upper bound.
+func (ix *keyIndex) ascendRange(start, end string, fn func(key string) bool) {
+	ix.ascendFrom(start, func(key string) bool {
+		return (end == "" || key < end) && fn(key)
+	})
+}
+
+// ascendFrom calls fn for each key not less than start in sorted order until
+// fn returns false
+func (ix *keyIndex) ascendFrom(start string, fn func(key string) bool) {
+	if len(ix.blocks) == 0 {
+		return
+	}
+
+	b := ix.block(start)
+	i := sort.SearchStrings(ix.blocks[b], start)
+	for ; b < len(ix.blocks); b, i = b+1, 0 {
+		for _, key := range ix.blocks[b][i:] {
+			if !fn(key) {
+				return
+			}
+		}
+	}
+}
+
+// RangePrefix calls fn for each live key starting with prefix, in sorted
+// order, until fn returns false. fn must not modify the store.
+func (s *Store) RangePrefix(prefix string, fn func(key string, value Value) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	s.index.ascend(prefix, func(key string) bool {
+		val := s.data[key]
+		if val.Expired(now) {
+			return true
+		}
+		return fn(key, val)
+	})
+}
+
+// RangeSorted calls fn for each live key from start up to but not including
+// end, in sorted order, until fn returns false. An empty end means no upper
+// bound. fn must not modify the store.
+func (s *Store) RangeSorted(start, end string, fn func(key string, value Value) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	s.index.ascendRange(start, end, func(key string) bool {
+		val := s.data[key]
+		if val.Expired(now) {
+			return true
+		}
+		return fn(key, val)
+	})
+}
+
+// DeletePrefix removes every key starting with prefix and returns how many
+// live keys it removed. The deletes are logged in a single write before any
+// key is removed.
+func (s *Store) DeletePrefix(prefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	var keys []string
+	s.index.ascend(prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	noValue := func(string) Value { return Value{} }
+	if err := s.appendRecords(opDelete, keys, noValue); err != nil {
+		return 0, fmt.Errorf("failed to log DELPREFIX: %w", err)
+	}
+
+	now := time.Now()
+	removed := 0
+	for _, key := range keys {
+		old, _ := s.remove(key)
+		if !old.Expired(now) {
+			removed++
+		}
+		s.publish(EventDelete, key, old)
+	}
+	return removed, nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooLarge is returned by writes whose key or value exceeds the store's
+// limits. Nothing is written.
+var ErrTooLarge = errors.New("too large")
+
+// Limits caps the size in bytes of the keys and values a store accepts. A
+// zero field means no limit.
+type Limits struct {
+	MaxKeySize   int
+	MaxValueSize int
+}
+
+// DefaultLimits are the limits new stores start with
+var DefaultLimits = Limits{
+	MaxKeySize:   1 << 10,
+	MaxValueSize: 1 << 20,
+}
+
+// Check reports whether a key and a value of valueSize bytes fit within the
+// limits, returning an error wrapping ErrTooLarge if not
+func (l Limits) Check(key string, valueSize int) error {
+	if l.MaxKeySize > 0 && len(key) > l.MaxKeySize {
+		return fmt.Errorf("%w: key is %d bytes, the limit is %d", ErrTooLarge, len(key), l.MaxKeySize)
+	}
+	if l.MaxValueSize > 0 && valueSize > l.MaxValueSize {
+		return fmt.Errorf("%w: value is %d bytes, the limit is %d", ErrTooLarge, valueSize, l.MaxValueSize)
+	}
+	return nil
+}
+
+// SetLimits changes the key and value sizes the store accepts from now on.
+// Entries already stored, and those replayed from the log or loaded from a
+// snapshot, are kept whatever their size.
+func (s *Store) SetLimits(l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = l
+}
+
+// Limits returns the key and value sizes the store accepts
+func (s *Store) Limits() Limits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.limits
+}
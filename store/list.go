@@ -0,0 +1,277 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWrongType is returned by an operation on a key holding the other kind of
+// value: a list operation on a plain value, or a value operation on a list
+var ErrWrongType = errors.New("WRONGTYPE operation against a key holding the wrong kind of value")
+
+// The kinds of value Type reports
+const (
+	TypeNone   = "none"
+	TypeString = "string"
+	TypeList   = "list"
+)
+
+// ErrNoElements is returned by a push without any elements
+var ErrNoElements = errors.New("at least one element is required")
+
+// A list is copied on LPUSH but appended to in place on RPUSH. That is safe
+// because every slice sharing a backing array ends at or before the newest
+// list's length: popping from the tail caps the slice so the next append
+// reallocates instead of overwriting elements an older copy of the value,
+// such as a watch event, can still see.
+
+// pushElems returns list with elems pushed at the head, one after another so
+// the last ends up first, or appended at the tail
+func pushElems(list, elems []string, left bool) []string {
+	if !left {
+		return append(list, elems...)
+	}
+
+	pushed := make([]string, len(elems)+len(list))
+	for i, e := range elems {
+		pushed[len(elems)-1-i] = e
+	}
+	copy(pushed[len(elems):], list)
+	return pushed
+}
+
+// popElems removes up to count elements from the head or tail of list,
+// returning what is left and the removed elements in the order they were
+// popped
+func popElems(list []string, count int, left bool) (rest, popped []string) {
+	n := min(count, len(list))
+	popped = make([]string, n)
+	if left {
+		copy(popped, list[:n])
+		return list[n:], popped
+	}
+
+	for i := range popped {
+		popped[i] = list[len(list)-1-i]
+	}
+	end := len(list) - n
+	return list[:end:end], popped
+}
+
+// applyListRecord applies a push or pop record. Callers must hold the write
+// lock.
+func (s *Store) applyListRecord(rec walRecord) []Event {
+	old, ok := s.data[rec.key]
+	var list []string
+	if ok && old.IsList() {
+		list = old.List
+	}
+
+	switch rec.op {
+	case opLPush, opRPush:
+		// A push stamped version 1 created the list, replacing an expired
+		// key that may still be stored
+		if rec.value.Version == 1 {
+			list = nil
+		}
+		list = pushElems(list, rec.elems, rec.op == opLPush)
+
+	case opLPop, opRPop:
+		list, _ = popElems(list, rec.count, rec.op == opLPop)
+		if len(list) == 0 {
+			if !ok {
+				return nil
+			}
+			s.remove(rec.key)
+			return []Event{{Op: EventDelete, Key: rec.key, Value: old}}
+		}
+	}
+
+	value := rec.value
+	value.List = list
+	s.put(rec.key, value)
+	return []Event{{Op: EventSet, Key: rec.key, Value: value}}
+}
+
+// LPush inserts elems at the head of the list stored under key and returns
+// its new length. The elements are inserted one after another, so the last
+// ends up first. A missing or expired key is created as a list without
+// expiry; a key holding a plain value returns ErrWrongType.
+func (s *Store) LPush(key string, elems ...string) (int, error) {
+	return s.PushAsOf(key, elems, true, time.Now())
+}
+
+// RPush appends elems to the tail of the list stored under key and returns
+// its new length, creating it like LPush
+func (s *Store) RPush(key string, elems ...string) (int, error) {
+	return s.PushAsOf(key, elems, false, time.Now())
+}
+
+// PushAsOf is LPush, or RPush if left is false, judging expiry and stamping
+// the version as of now, for raft replicas applying the proposing node's
+// clock. Only the pushed elements are logged.
+func (s *Store) PushAsOf(key string, elems []string, left bool, now time.Time) (int, error) {
+	if len(elems) == 0 {
+		return 0, ErrNoElements
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	old, live := s.data[key]
+	if live && old.Expired(now) {
+		old, live = Value{}, false
+	}
+	if live && !old.IsList() {
+		return 0, ErrWrongType
+	}
+	pushed := Value{List: elems}
+	if err := s.limits.Check(key, int(valueSize(old)+valueSize(pushed))); err != nil {
+		return 0, err
+	}
+	if err := s.makeRoom(key, entrySize(key, pushed)); err != nil {
+		return 0, err
+	}
+
+	op := opRPush
+	if left {
+		op = opLPush
+	}
+	value := stampValue(old, live, Value{ExpiresAt: old.ExpiresAt}, now)
+	if s.log != nil {
+		record, err := encodeListRecord(op, key, value, elems, 0)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.writeLog(record); err != nil {
+			return 0, fmt.Errorf("failed to log push: %w", err)
+		}
+	}
+
+	for _, ev := range s.applyRecord(walRecord{op: op, key: key, value: value, elems: elems}) {
+		s.publish(ev.Op, ev.Key, ev.Value)
+	}
+	return len(s.data[key].List), nil
+}
+
+// LPop removes and returns up to count elements from the head of the list
+// stored under key. A missing or expired key returns no elements; popping
+// the last element removes the key.
+func (s *Store) LPop(key string, count int) ([]string, error) {
+	return s.PopAsOf(key, count, true, time.Now())
+}
+
+// RPop removes and returns up to count elements from the tail of the list
+// stored under key, last element first, like LPop
+func (s *Store) RPop(key string, count int) ([]string, error) {
+	return s.PopAsOf(key, count, false, time.Now())
+}
+
+// PopAsOf is LPop, or RPop if left is false, judging expiry and stamping the
+// version as of now, for raft replicas applying the proposing node's clock.
+// Only the number of elements popped is logged.
+func (s *Store) PopAsOf(key string, count int, left bool, now time.Time) ([]string, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("count must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	old, ok := s.data[key]
+	if !ok || old.Expired(now) {
+		return nil, nil
+	}
+	if !old.IsList() {
+		return nil, ErrWrongType
+	}
+
+	op := opRPop
+	if left {
+		op = opLPop
+	}
+	_, popped := popElems(old.List, count, left)
+	value := stampValue(old, true, Value{ExpiresAt: old.ExpiresAt}, now)
+	if s.log != nil {
+		record, err := encodeListRecord(op, key, value, nil, len(popped))
+		if err != nil {
+			return nil, err
+		}
+		if err := s.writeLog(record); err != nil {
+			return nil, fmt.Errorf("failed to log pop: %w", err)
+		}
+	}
+
+	for _, ev := range s.applyRecord(walRecord{op: op, key: key, value: value, count: len(popped)}) {
+		s.publish(ev.Op, ev.Key, ev.Value)
+	}
+	return popped, nil
+}
+
+// Type returns TypeList or TypeString for the kind of value stored under
+// key, or TypeNone if it is missing or expired
+func (s *Store) Type(key string) string {
+	val, ok := s.Get(key)
+	switch {
+	case !ok:
+		return TypeNone
+	case val.IsList():
+		return TypeList
+	}
+	return TypeString
+}
+
+// LLen returns the length of the list stored under key, 0 if it is missing
+// or expired
+func (s *Store) LLen(key string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, ok := s.data[key]
+	if !ok || val.Expired(time.Now()) {
+		return 0, nil
+	}
+	if !val.IsList() {
+		return 0, ErrWrongType
+	}
+	return len(val.List), nil
+}
+
+// LRange returns the elements of the list stored under key from start to
+// stop inclusive. Negative indexes count from the end, -1 being the last
+// element, and indexes past either end are clamped. A missing or expired key
+// returns no elements.
+func (s *Store) LRange(key string, start, stop int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, ok := s.data[key]
+	if !ok || val.Expired(time.Now()) {
+		return nil, nil
+	}
+	if !val.IsList() {
+		return nil, ErrWrongType
+	}
+
+	n := len(val.List)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []string{}, nil
+	}
+	return append([]string(nil), val.List[start:stop+1]...), nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import "time"
+
+// entryOverhead approximates the memory an entry costs beyond its key and
+// value bytes: the map slot, the key and value string headers, the expiry,
+// creation and update times, the version and the key's slot in the sorted
+// index. Allocator rounding is not counted, so the totals undershoot the heap
+// by a few bytes per entry.
+const entryOverhead = 144
+
+// MemoryStats summarizes the approximate memory held by the store's entries.
+// Expired entries that have not been swept yet are included.
+type MemoryStats struct {
+	Entries      int         `json:"entries"`
+	Bytes        int64       `json:"bytes"`
+	ValueBytes   int64       `json:"value_bytes"`
+	AvgValueSize float64     `json:"avg_value_size"`
+	Largest      []KeyMemory `json:"largest,omitempty"`
+}
+
+// KeyMemory is the approximate memory used by one entry
+type KeyMemory struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+}
+
+// memoryUsage is the running total kept up to date by put and remove
+type memoryUsage struct {
+	bytes      int64
+	valueBytes int64
+	logBytes   int64 // size of the records a compacted log would hold, see recordSize
+}
+
+// listElemOverhead approximates the memory a list element costs beyond its
+// bytes: its string header in the list's backing array
+const listElemOverhead = 16
+
+func entrySize(key string, value Value) int64 {
+	return int64(len(key)) + valueSize(value) + int64(len(value.List)*listElemOverhead) + entryOverhead
+}
+
+// valueSize is the number of bytes held by a value's data or list elements
+func valueSize(value Value) int64 {
+	n := int64(len(value.Data))
+	for _, e := range value.List {
+		n += int64(len(e))
+	}
+	return n
+}
+
+// put stores value under key and updates the memory accounting. Callers must
+// hold the write lock.
+func (s *Store) put(key string, value Value) {
+	if old, ok := s.data[key]; ok {
+		s.usage.bytes -= entrySize(key, old)
+		s.usage.valueBytes -= valueSize(old)
+		s.usage.logBytes -= recordSize(key, old)
+	} else {
+		s.index.insert(key)
+	}
+	s.data[key] = value
+	s.trackExpiry(key, value)
+	s.usage.bytes += entrySize(key, value)
+	s.usage.valueBytes += valueSize(value)
+	s.usage.logBytes += recordSize(key, value)
+}
+
+// remove deletes key and updates the memory accounting, returning the removed
+// value. Callers must hold the write lock.
+func (s *Store) remove(key string) (Value, bool) {
+	old, ok := s.data[key]
+	if !ok {
+		return Value{}, false
+	}
+	delete(s.data, key)
+	s.index.remove(key)
+	s.usage.bytes -= entrySize(key, old)
+	s.usage.valueBytes -= valueSize(old)
+	s.usage.logBytes -= recordSize(key, old)
+	return old, true
+}
+
+// reset drops every entry. Callers must hold the write lock.
+func (s *Store) reset() {
+	s.data = make(map[string]Value)
+	s.index = keyIndex{}
+	s.expiries = nil
+	s.usage = memoryUsage{}
+}
+
+// MemoryUsage returns the approximate number of bytes used by key
+func (s *Store) MemoryUsage(key string) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, ok := s.data[key]
+	if !ok || val.Expired(time.Now()) {
+		return 0, false
+	}
+	return entrySize(key, val), true
+}
+
+// MemoryStats returns the store's memory totals along with the top largest
+// entries, biggest first
+func (s *Store) MemoryStats(top int) MemoryStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := MemoryStats{
+		Entries:    len(s.data),
+		Bytes:      s.usage.bytes,
+		ValueBytes: s.usage.valueBytes,
+	}
+	if stats.Entries > 0 {
+		stats.AvgValueSize = float64(stats.ValueBytes) / float64(stats.Entries)
+	}
+	if top <= 0 {
+		return stats
+	}
+
+	// Keep the largest entries in a small sorted slice, which is cheaper than
+	// sorting the whole store for the handful of keys usually asked for
+	largest := make([]KeyMemory, 0, top)
+	for key, val := range s.data {
+		size := entrySize(key, val)
+		if len(largest) == top && size <= largest[top-1].Bytes {
+			continue
+		}
+
+		i := len(largest)
+		if i < top {
+			largest = append(largest, KeyMemory{})
+		} else {
+			i = top - 1
+		}
+		for i > 0 && largest[i-1].Bytes < size {
+			largest[i] = largest[i-1]
+			i--
+		}
+		largest[i] = KeyMemory{Key: key, Bytes: size}
+	}
+	stats.Largest = largest
+
+	return stats
+}
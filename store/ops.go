@@ -0,0 +1,416 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrNotInteger is returned by IncrBy when the key holds a value that is not
+// a base 10 64-bit integer
+var ErrNotInteger = errors.New("value is not an integer")
+
+// ErrOverflow is returned by IncrBy when the result would not fit in 64 bits
+var ErrOverflow = errors.New("increment would overflow")
+
+// Expire sets key to expire after d, or never if d is zero. It returns false
+// without changing anything if the key is missing or already expired.
+func (s *Store) Expire(key string, d time.Duration) (bool, error) {
+	now := time.Now()
+	var expiresAt time.Time
+	if d != 0 {
+		expiresAt = now.Add(d)
+	}
+	return s.ExpireAt(key, expiresAt, now)
+}
+
+// Persist removes key's expiry. It returns false if the key is missing,
+// expired or already has no expiry.
+func (s *Store) Persist(key string) (bool, error) {
+	return s.ExpireAt(key, time.Time{}, time.Now())
+}
+
+// ExpireAt sets key's expiry to expiresAt, zero meaning never, deciding
+// whether the key is still live as of now. Raft replicas pass the proposing
+// node's clock so they all reach the same decision. The value is logged in
+// full, so replay needs no special record.
+func (s *Store) ExpireAt(key string, expiresAt, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok := s.data[key]
+	if !ok || val.Expired(now) {
+		return false, nil
+	}
+	if expiresAt.IsZero() && val.ExpiresAt.IsZero() {
+		return false, nil
+	}
+
+	val.ExpiresAt = expiresAt
+	val = s.stamp(key, val, now)
+	if err := s.appendRecord(opSet, key, val); err != nil {
+		return false, fmt.Errorf("failed to log EXPIRE: %w", err)
+	}
+	s.put(key, val)
+	s.publish(EventSet, key, val)
+	return true, nil
+}
+
+// Touch resets key's expiry to newTTL from now, or removes it if newTTL is
+// zero, reporting false if the key is missing or expired. Unlike Expire it
+// always writes, so sliding expiries can be extended without reading the
+// value back.
+func (s *Store) Touch(key string, newTTL time.Duration) (bool, error) {
+	now := time.Now()
+	var expiresAt time.Time
+	if newTTL != 0 {
+		expiresAt = now.Add(newTTL)
+	}
+	return s.TouchAt(key, expiresAt, now)
+}
+
+// TouchAt is Touch setting the expiry to expiresAt, zero meaning never, and
+// judging expiry as of now, for raft replicas applying the proposing node's
+// clock
+func (s *Store) TouchAt(key string, expiresAt, now time.Time) (bool, error) {
+	_, ok, err := s.resetExpiry(key, expiresAt, now, true)
+	return ok, err
+}
+
+// GetEx returns key's value while resetting its expiry to newTTL from now,
+// or removing it if newTTL is zero, as a single logged write. It reports
+// false if the key is missing or expired, and returns ErrWrongType without
+// changing anything for a list.
+func (s *Store) GetEx(key string, newTTL time.Duration) (Value, bool, error) {
+	now := time.Now()
+	var expiresAt time.Time
+	if newTTL != 0 {
+		expiresAt = now.Add(newTTL)
+	}
+	return s.GetExAt(key, expiresAt, now)
+}
+
+// GetExAt is GetEx setting the expiry to expiresAt, zero meaning never, and
+// judging expiry as of now, for raft replicas applying the proposing node's
+// clock
+func (s *Store) GetExAt(key string, expiresAt, now time.Time) (Value, bool, error) {
+	return s.resetExpiry(key, expiresAt, now, false)
+}
+
+// resetExpiry sets key's expiry to expiresAt and returns its value. A list
+// is only accepted if lists is true.
+func (s *Store) resetExpiry(key string, expiresAt, now time.Time, lists bool) (Value, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return Value{}, false, ErrClosed
+	}
+
+	val, ok := s.data[key]
+	if !ok || val.Expired(now) {
+		return Value{}, false, nil
+	}
+	if val.IsList() && !lists {
+		return Value{}, false, ErrWrongType
+	}
+
+	val.ExpiresAt = expiresAt
+	val = s.stamp(key, val, now)
+	if err := s.appendRecord(opSet, key, val); err != nil {
+		return Value{}, false, fmt.Errorf("failed to log new expiry: %w", err)
+	}
+	s.put(key, val)
+	s.publish(EventSet, key, val)
+	return val, true, nil
+}
+
+// SetNX stores value under key only if the key does not exist or has
+// expired, reporting whether it did
+func (s *Store) SetNX(key string, value Value) (bool, error) {
+	return s.SetNXAsOf(key, value, time.Now())
+}
+
+// SetNXAsOf is SetNX judging expiry as of now, for raft replicas applying the
+// proposing node's clock
+func (s *Store) SetNXAsOf(key string, value Value, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false, ErrClosed
+	}
+
+	if err := s.checkWrite(key, len(value.Data)); err != nil {
+		return false, err
+	}
+
+	if old, ok := s.data[key]; ok && !old.Expired(now) {
+		return false, nil
+	}
+
+	value = s.stamp(key, value, now)
+	if err := s.appendRecord(opSet, key, value); err != nil {
+		return false, fmt.Errorf("failed to log SETNX: %w", err)
+	}
+	s.put(key, value)
+	s.publish(EventSet, key, value)
+	return true, nil
+}
+
+// ConflictError is returned by CompareAndSwap when the key does not hold the
+// expected value
+type ConflictError struct {
+	Key string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("compare-and-swap conflict on key %q", e.Key)
+}
+
+// CompareAndSwap stores value under key only if the key currently holds
+// expected, or is absent or expired when expected is empty, and returns the
+// key's new version. Otherwise it returns a *ConflictError.
+func (s *Store) CompareAndSwap(key, expected string, value Value) (uint64, error) {
+	return s.CompareAndSwapAsOf(key, expected, value, time.Now())
+}
+
+// CompareAndSwapAsOf is CompareAndSwap judging expiry as of now, for raft
+// replicas applying the proposing node's clock
+func (s *Store) CompareAndSwapAsOf(key, expected string, value Value, now time.Time) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	if err := s.checkWrite(key, len(value.Data)); err != nil {
+		return 0, err
+	}
+
+	old, ok := s.data[key]
+	if !ok || old.Expired(now) {
+		old = Value{}
+	}
+	if old.IsList() {
+		return 0, ErrWrongType
+	}
+	if old.Data != expected {
+		return 0, &ConflictError{Key: key}
+	}
+
+	value = s.stamp(key, value, now)
+	if err := s.appendRecord(opSet, key, value); err != nil {
+		return 0, fmt.Errorf("failed to log CAS: %w", err)
+	}
+	s.put(key, value)
+	s.publish(EventSet, key, value)
+	return value.Version, nil
+}
+
+// IncrBy adds delta to the integer stored under key and returns the result.
+// A missing or expired key counts as 0 and is created without expiry; an
+// existing key keeps its expiry.
+func (s *Store) IncrBy(key string, delta int64) (int64, error) {
+	return s.IncrByAsOf(key, delta, time.Now())
+}
+
+// IncrByAsOf is IncrBy judging expiry as of now, for raft replicas applying
+// the proposing node's clock
+func (s *Store) IncrByAsOf(key string, delta int64, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	if err := s.checkWrite(key, 0); err != nil {
+		return 0, err
+	}
+
+	val, ok := s.data[key]
+	if !ok || val.Expired(now) {
+		val = Value{Data: "0"}
+	}
+	if val.IsList() {
+		return 0, ErrWrongType
+	}
+
+	n, err := strconv.ParseInt(val.Data, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+
+	n += delta
+	val.Data = strconv.FormatInt(n, 10)
+	val = s.stamp(key, val, now)
+	if err := s.appendRecord(opSet, key, val); err != nil {
+		return 0, fmt.Errorf("failed to log INCRBY: %w", err)
+	}
+	s.put(key, val)
+	s.publish(EventSet, key, val)
+	return n, nil
+}
+
+// Append adds suffix.Data to the end of the value stored under key and
+// returns the new length. A missing or expired key is created with suffix's
+// expiry; an existing key keeps its own. The whole resulting value is logged.
+func (s *Store) Append(key string, suffix Value) (int, error) {
+	return s.AppendAsOf(key, suffix, time.Now())
+}
+
+// AppendAsOf is Append judging expiry as of now, for raft replicas applying
+// the proposing node's clock
+func (s *Store) AppendAsOf(key string, suffix Value, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	val, ok := s.data[key]
+	if !ok || val.Expired(now) {
+		val = Value{ExpiresAt: suffix.ExpiresAt}
+	}
+	if val.IsList() {
+		return 0, ErrWrongType
+	}
+
+	if err := s.checkWrite(key, len(val.Data)+len(suffix.Data)); err != nil {
+		return 0, err
+	}
+
+	val.Data += suffix.Data
+	val = s.stamp(key, val, now)
+	if err := s.appendRecord(opSet, key, val); err != nil {
+		return 0, fmt.Errorf("failed to log APPEND: %w", err)
+	}
+	s.put(key, val)
+	s.publish(EventSet, key, val)
+	return len(val.Data), nil
+}
+
+// GetSet stores value under key and returns the value it replaced. An
+// expired old value counts as not existing.
+func (s *Store) GetSet(key string, value Value) (Value, bool, error) {
+	return s.GetSetAsOf(key, value, time.Now())
+}
+
+// GetSetAsOf is GetSet judging expiry as of now, for raft replicas applying
+// the proposing node's clock
+func (s *Store) GetSetAsOf(key string, value Value, now time.Time) (Value, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return Value{}, false, ErrClosed
+	}
+
+	if err := s.checkWrite(key, len(value.Data)); err != nil {
+		return Value{}, false, err
+	}
+
+	old, existed := s.data[key]
+	if existed && old.Expired(now) {
+		old, existed = Value{}, false
+	}
+	if old.IsList() {
+		return Value{}, false, ErrWrongType
+	}
+
+	value = s.stamp(key, value, now)
+	if err := s.appendRecord(opSet, key, value); err != nil {
+		return Value{}, false, fmt.Errorf("failed to log GETSET: %w", err)
+	}
+	s.put(key, value)
+	s.publish(EventSet, key, value)
+	return old, existed, nil
+}
+
+// MSet stores every pair under one lock acquisition, logging them in a
+// single write before any becomes visible
+func (s *Store) MSet(pairs map[string]Value) error {
+	_, err := s.MSetAsOf(pairs, time.Now())
+	return err
+}
+
+// MSetAsOf is MSet stamping the values' versions and times as of now, for
+// raft replicas applying the proposing node's clock. It returns the version
+// each key was given.
+func (s *Store) MSetAsOf(pairs map[string]Value, now time.Time) (map[string]uint64, error) {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	var size int64
+	for _, key := range keys {
+		if err := s.limits.Check(key, len(pairs[key].Data)); err != nil {
+			return nil, err
+		}
+		size += int64(len(key)+len(pairs[key].Data)) + entryOverhead
+	}
+	if err := s.makeRoom("", size); err != nil {
+		return nil, err
+	}
+
+	recs := make([]walRecord, 0, len(keys))
+	versions := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		val := s.stamp(key, pairs[key], now)
+		recs = append(recs, walRecord{op: opSet, key: key, value: val})
+		versions[key] = val.Version
+	}
+
+	// Logged as one batch record, so a crash mid write replays none of it
+	if s.log != nil {
+		record, err := encodeBatch(recs)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.writeLog(record); err != nil {
+			return nil, fmt.Errorf("failed to log MSET: %w", err)
+		}
+	}
+	for _, ev := range s.applyRecord(walRecord{op: opBatch, batch: recs}) {
+		s.publish(ev.Op, ev.Key, ev.Value)
+	}
+	return versions, nil
+}
+
+// MGet returns the live values of keys. Missing and expired keys, and lists,
+// are left out of the result.
+func (s *Store) MGet(keys []string) map[string]Value {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := make(map[string]Value, len(keys))
+	if s.closed {
+		return values
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		if val, ok := s.data[key]; ok && !val.Expired(now) && !val.IsList() {
+			values[key] = val
+		}
+	}
+	return values
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// StoreOptions configures a store opened with NewStoreWithOptions. The zero
+// value is an in-memory store with the defaults of NewStore.
+type StoreOptions struct {
+	// LogPath is the log file to open or create. Empty means an in-memory
+	// store without a log.
+	LogPath string
+	// SyncPolicy controls when log writes are fsynced. Empty means
+	// DefaultSyncPolicy.
+	SyncPolicy SyncPolicy
+	// ReplayMode controls what opening a log with corrupt records does.
+	// Empty means DefaultReplayMode.
+	ReplayMode ReplayMode
+	// EncryptionKey, if set, encrypts the log and saved snapshots, see
+	// NewEncryptedStore. It requires a LogPath.
+	EncryptionKey []byte
+
+	// CleanerInterval is how often expired keys are swept. Zero disables the
+	// background cleaner; expired keys are still hidden from reads.
+	CleanerInterval time.Duration
+	// KeepExpired stops reads from deleting the expired keys they find, see
+	// SetLazyExpiry
+	KeepExpired bool
+
+	// Limits caps the size of keys and values. Nil means DefaultLimits; a
+	// zero field means no limit.
+	Limits *Limits
+	// MaxMemory caps the store's estimated memory in bytes, see
+	// SetMaxMemory. Zero means no limit.
+	MaxMemory int64
+	// EvictionPolicy chooses what writes over MaxMemory do. Empty means
+	// DefaultEvictionPolicy.
+	EvictionPolicy EvictionPolicy
+
+	// AutoCompaction controls when the log is compacted on its own. Nil
+	// means DefaultAutoCompaction; a zero Ratio disables it.
+	AutoCompaction *AutoCompaction
+}
+
+// withDefaults returns opts with empty fields set to their defaults, or an
+// error if a field is invalid
+func (opts StoreOptions) withDefaults() (StoreOptions, error) {
+	if opts.SyncPolicy == "" {
+		opts.SyncPolicy = DefaultSyncPolicy
+	}
+	if opts.ReplayMode == "" {
+		opts.ReplayMode = DefaultReplayMode
+	}
+	if opts.EvictionPolicy == "" {
+		opts.EvictionPolicy = DefaultEvictionPolicy
+	}
+	if opts.Limits == nil {
+		opts.Limits = &DefaultLimits
+	}
+	if opts.AutoCompaction == nil {
+		opts.AutoCompaction = &DefaultAutoCompaction
+	}
+
+	if _, err := ParseSyncPolicy(string(opts.SyncPolicy)); err != nil {
+		return opts, err
+	}
+	if _, err := ParseReplayMode(string(opts.ReplayMode)); err != nil {
+		return opts, err
+	}
+	if _, err := ParseEvictionPolicy(string(opts.EvictionPolicy)); err != nil {
+		return opts, err
+	}
+	if opts.EncryptionKey != nil && opts.LogPath == "" {
+		return opts, fmt.Errorf("an encryption key requires a log path")
+	}
+	if opts.CleanerInterval < 0 {
+		return opts, fmt.Errorf("cleaner interval must not be negative")
+	}
+	if opts.MaxMemory < 0 {
+		return opts, fmt.Errorf("max memory must not be negative")
+	}
+	if opts.Limits.MaxKeySize < 0 || opts.Limits.MaxValueSize < 0 {
+		return opts, fmt.Errorf("limits must not be negative")
+	}
+	if err := opts.AutoCompaction.check(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// NewStoreWithOptions opens a store configured by opts, checking every option
+// before the log is touched
+func NewStoreWithOptions(opts StoreOptions) (*Store, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	var s *Store
+	if opts.LogPath == "" {
+		s = NewMemoryStore()
+	} else if s, err = newStore(opts.LogPath, opts.ReplayMode, opts.EncryptionKey); err != nil {
+		return nil, err
+	}
+
+	if opts.LogPath != "" {
+		if err := s.SetSyncPolicy(opts.SyncPolicy); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if err := s.SetMaxMemory(opts.MaxMemory, opts.EvictionPolicy); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.SetAutoCompaction(*opts.AutoCompaction); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.SetLimits(*opts.Limits)
+	s.SetLazyExpiry(!opts.KeepExpired)
+	if opts.CleanerInterval > 0 {
+		s.StartBackgroundCleanerEvery(opts.CleanerInterval)
+	}
+	return s, nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ReplayMode controls what replaying the log on open does with a corrupt
+// record that is not at the end of the log. A torn or corrupt final record
+// is always cut off, since a crash mid-write leaves one behind, and so is
+// any bad stretch at the end of the log that no valid record follows.
+type ReplayMode string
+
+const (
+	// ReplayStrict refuses to open the log, so no data is silently lost
+	ReplayStrict ReplayMode = "strict"
+	// ReplaySkip skips each corrupt stretch of the log, reporting its
+	// offset, and carries on with the next valid record. The log is then
+	// rewritten without them and the original kept with a .corrupt suffix.
+	ReplaySkip ReplayMode = "skip"
+)
+
+// DefaultReplayMode is the mode NewStore replays the log in
+const DefaultReplayMode = ReplayStrict
+
+// corruptSuffix is appended to the name of a log kept after ReplaySkip
+// skipped part of it
+const corruptSuffix = ".corrupt"
+
+// ParseReplayMode converts "strict" or "skip" to a ReplayMode
+func ParseReplayMode(s string) (ReplayMode, error) {
+	switch mode := ReplayMode(s); mode {
+	case ReplayStrict, ReplaySkip:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown replay mode %q, want strict or skip", s)
+}
+
+// nextValidRecord returns the offset of the first valid record after the
+// corrupt one at offset, or the end of the log if there is none. Every byte
+// offset is tried, and the checksum makes a false match very unlikely.
+// Callers must hold the write lock.
+func (s *Store) nextValidRecord(offset int64) int64 {
+	start := offset + 1
+	if end, ok := s.tornBatchEnd(offset); ok {
+		start = end
+	}
+	for next := start; next+8 <= s.logSize; next++ {
+		if s.validRecordAt(next) {
+			return next
+		}
+	}
+	return s.logSize
+}
+
+// batchDataOffset is where the records nested in an opBatch record start,
+// after its length, checksum, op, empty key, expiry, version, times and data
+// length
+const batchDataOffset = 4 + 4 + 1 + 4 + 8 + metaSize + 4
+
+// tornBatchEnd returns the end of the complete records nested in a batch
+// record at offset that runs past the end of the log. They are valid records
+// in their own right, which must not be mistaken for records following a
+// corrupt one, as the batch was torn by a crash and none of it applies. Only
+// plain logs are affected: nested records are sealed with their batch.
+func (s *Store) tornBatchEnd(offset int64) (int64, bool) {
+	if s.logEncrypted {
+		return 0, false
+	}
+	var header [9]byte
+	if _, err := s.log.ReadAt(header[:], offset); err != nil || header[8] != opBatch {
+		return 0, false
+	}
+	if offset+4+int64(binary.BigEndian.Uint32(header[:])) <= s.logSize {
+		return 0, false
+	}
+
+	end := offset + batchDataOffset
+	for end < s.logSize && s.validRecordAt(end) {
+		var prefix [4]byte
+		if _, err := s.log.ReadAt(prefix[:], end); err != nil {
+			break
+		}
+		end += 4 + int64(binary.BigEndian.Uint32(prefix[:]))
+	}
+	return end, true
+}
+
+// validRecordsAfter reports whether the log holds a valid record anywhere
+// after the corrupt one at offset. Callers must hold the write lock.
+func (s *Store) validRecordsAfter(offset int64) bool {
+	return s.nextValidRecord(offset) < s.logSize
+}
+
+// validRecordAt reports whether a complete record with a matching checksum
+// starts at offset
+func (s *Store) validRecordAt(offset int64) bool {
+	var prefix [4]byte
+	if _, err := s.log.ReadAt(prefix[:], offset); err != nil {
+		return false
+	}
+	n := int64(binary.BigEndian.Uint32(prefix[:]))
+	if n < 4 || n > s.logSize-offset-4 {
+		return false
+	}
+
+	frame := make([]byte, 4+n)
+	if _, err := s.log.ReadAt(frame, offset); err != nil {
+		return false
+	}
+	_, err := s.openRecord(frame)
+	return err == nil
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	// ErrNoLog is returned by log streaming operations on an in-memory store
+	ErrNoLog = errors.New("store has no log file")
+	// ErrLogRewritten is returned by ReadLogFrom when the log was compacted
+	// since the caller's offset was obtained
+	ErrLogRewritten = errors.New("log was rewritten")
+)
+
+// LogSize returns the number of bytes in the log file. Log offsets used for
+// replication are byte positions in this file.
+func (s *Store) LogSize() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.logSize
+}
+
+// LogID returns the ID of the log file, which changes whenever the log is
+// created or rewritten. A replica's log carries its primary's ID. It is empty
+// for in-memory stores and for a replica's log right after Reset.
+func (s *Store) LogID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.logID
+}
+
+// LogChanged returns a channel that is closed the next time the log grows
+func (s *Store) LogChanged() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.logNotify
+}
+
+// IsRecordBoundary reports whether offset is the start of a log record (or
+// the end of the log), i.e. a position a replica can resume from. Offsets
+// within the file header count as the start of the first record.
+func (s *Store) IsRecordBoundary(offset int64) bool {
+	s.mu.RLock()
+	if s.log == nil {
+		s.mu.RUnlock()
+		return false
+	}
+	name, size, header := s.log.Name(), s.logSize, s.headerSize()
+	s.mu.RUnlock()
+
+	if offset >= 0 && offset <= header {
+		return true
+	}
+	if offset < 0 || offset > size {
+		return false
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	end, err := scanFrames(f, header, size, func(end int64) bool { return end < offset })
+	return err == nil && end == offset
+}
+
+// ReadLogFrom calls fn for every complete record between offset and the
+// current end of the log, passing the offset just past each record. It
+// returns the offset reached, which is where the next call should resume.
+// The log is read through a separate file handle so writers are not blocked.
+// If the log has been rewritten since generation, nothing is read and
+// ErrLogRewritten is returned.
+func (s *Store) ReadLogFrom(generation uint64, offset int64, fn func(record []byte, next int64) error) (int64, error) {
+	s.mu.RLock()
+	if s.log == nil {
+		s.mu.RUnlock()
+		return offset, ErrNoLog
+	}
+	name, size, current, header := s.log.Name(), s.logSize, s.logGeneration, s.headerSize()
+	s.mu.RUnlock()
+
+	if current != generation {
+		return offset, ErrLogRewritten
+	}
+
+	if offset < header {
+		offset = header
+	}
+	if offset >= size {
+		return offset, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	// A compaction between reading the name and opening it would leave f
+	// pointing at the new file
+	if s.LogGeneration() != generation {
+		return offset, ErrLogRewritten
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(f, offset, size-offset))
+	for {
+		record, err := readFrame(reader, size-offset)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// A partial record at the end is picked up by the next call
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		offset += int64(len(record))
+		if err := fn(record, offset); err != nil {
+			return offset, err
+		}
+	}
+}
+
+// ApplyLogRecord applies a record read from another store's log and appends
+// it verbatim to this store's log, so a replica's log mirrors its primary's.
+// The records of an encrypted primary can only be applied by a replica
+// opened with the same key.
+func (s *Store) ApplyLogRecord(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.openRecord(record)
+	if err != nil {
+		return err
+	}
+	if err := s.writeFrame(record); err != nil {
+		return err
+	}
+	for _, ev := range s.applyRecord(rec) {
+		s.publish(ev.Op, ev.Key, ev.Value)
+	}
+	return nil
+}
+
+// Reset removes all data and truncates the log back to its header, used
+// before a replica performs a full resync from its primary. The log is left
+// without an ID until the primary's ID record is applied.
+func (s *Store) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	s.reset()
+	if s.log == nil {
+		return nil
+	}
+
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	s.logSize = 0
+	header := s.logHeader()
+	if _, err := s.log.Write(header); err != nil {
+		return err
+	}
+	s.logSize = int64(len(header))
+	s.logEncrypted = s.enc != nil
+	s.logID = ""
+	s.logGeneration++
+	close(s.logNotify)
+	s.logNotify = make(chan struct{})
+	return nil
+}
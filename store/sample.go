@@ -0,0 +1,100 @@
+package store
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// randomKeyAttempts is how many random positions RandomKey tries before
+	// concluding most keys are expired and falling back to Sample
+	randomKeyAttempts = 16
+
+	// sampleBatch is how many keys Sample visits per hold of the read lock
+	sampleBatch = indexBlockSize
+)
+
+// at returns the key at position i in sorted order
+func (ix *keyIndex) at(i int) (string, bool) {
+	for _, blk := range ix.blocks {
+		if i < len(blk) {
+			return blk[i], true
+		}
+		i -= len(blk)
+	}
+	return "", false
+}
+
+// RandomKey returns a live key chosen uniformly at random, or false if there
+// are none. It picks random positions in the key index, so its cost does not
+// grow with the number of keys unless nearly all of them have expired.
+func (s *Store) RandomKey() (string, bool) {
+	s.mu.RLock()
+	now := time.Now()
+	for attempt := 0; attempt < randomKeyAttempts && len(s.data) > 0; attempt++ {
+		key, ok := s.index.at(rand.Intn(len(s.data)))
+		if ok && !s.data[key].Expired(now) {
+			s.mu.RUnlock()
+			return key, true
+		}
+	}
+	s.mu.RUnlock()
+
+	keys := s.Sample(1)
+	if len(keys) == 0 {
+		return "", false
+	}
+	return keys[0], true
+}
+
+// Sample returns up to n distinct live keys chosen uniformly at random, in
+// random order. It makes one reservoir sampling pass over the key index,
+// holding the read lock for sampleBatch keys at a time so writers are not
+// stalled by a large store. Keys written or deleted during the pass may or
+// may not be considered.
+func (s *Store) Sample(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	sample := make([]string, 0, n)
+	seen := 0
+	start := ""
+	for {
+		visited := 0
+		last := ""
+
+		s.mu.RLock()
+		now := time.Now()
+		s.index.ascendFrom(start, func(key string) bool {
+			if visited == sampleBatch {
+				return false
+			}
+			visited++
+			last = key
+
+			if s.data[key].Expired(now) {
+				return true
+			}
+			seen++
+			if len(sample) < n {
+				sample = append(sample, key)
+			} else if j := rand.Intn(seen); j < n {
+				sample[j] = key
+			}
+			return true
+		})
+		s.mu.RUnlock()
+
+		if visited < sampleBatch {
+			break
+		}
+		// The smallest key after last, so the next batch carries on from it
+		start = last + "\x00"
+	}
+
+	rand.Shuffle(len(sample), func(i, j int) {
+		sample[i], sample[j] = sample[j], sample[i]
+	})
+	return sample
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// chiSquare returns the chi-square statistic of counts against an even
+// spread of total over them
+func chiSquare(counts map[string]int, keys []string, total int) float64 {
+	expected := float64(total) / float64(len(keys))
+	x := 0.0
+	for _, key := range keys {
+		d := float64(counts[key]) - expected
+		x += d * d / expected
+	}
+	return x
+}
+
+// maxChiSquare is far enough above the mean of a chi-square statistic over n
+// keys, six standard deviations, that a uniform pick fails it about once in
+// a billion runs
+func maxChiSquare(n int) float64 {
+	df := float64(n - 1)
+	return df + 6*math.Sqrt(2*df)
+}
+
+// fillStore sets n keys with no expiry and returns them
+func fillStore(t *testing.T, s *Store, n int) []string {
+	t.Helper()
+
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%05d", i)
+		mustSet(t, s, keys[i], "value "+keys[i])
+	}
+	return keys
+}
+
+// newKeepExpiredStore opens an in-memory store whose reads leave expired
+// keys in place, so they stay for the sampler to skip
+func newKeepExpiredStore(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := NewStoreWithOptions(StoreOptions{KeepExpired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRandomKeyIsUniform(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+	keys := fillStore(t, s, 100)
+
+	const draws = 20000
+	counts := make(map[string]int)
+	for i := 0; i < draws; i++ {
+		key, ok := s.RandomKey()
+		if !ok {
+			t.Fatal("RandomKey found nothing in a full store")
+		}
+		counts[key]++
+	}
+	if len(counts) != len(keys) {
+		t.Errorf("RandomKey picked %d distinct keys of %d", len(counts), len(keys))
+	}
+	if x := chiSquare(counts, keys, draws); x > maxChiSquare(len(keys)) {
+		t.Errorf("RandomKey picks are not uniform: chi-square %.0f over %d keys", x, len(keys))
+	}
+}
+
+func TestRandomKeySkipsExpiredKeys(t *testing.T) {
+	s := newKeepExpiredStore(t)
+	for i := 0; i < 1000; i++ {
+		setExpired(t, s, fmt.Sprintf("expired%04d", i))
+	}
+	live := []string{"a", "b", "c", "d", "e"}
+	for _, key := range live {
+		mustSet(t, s, key, "value "+key)
+	}
+
+	const draws = 5000
+	counts := make(map[string]int)
+	for i := 0; i < draws; i++ {
+		key, ok := s.RandomKey()
+		if !ok {
+			t.Fatal("RandomKey found nothing with live keys left")
+		}
+		counts[key]++
+	}
+	if x := chiSquare(counts, live, draws); len(counts) != len(live) || x > maxChiSquare(len(live)) {
+		t.Errorf("RandomKey among mostly expired keys picked %v", counts)
+	}
+}
+
+func TestSampleIsUniform(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	// Enough keys for the pass to take several holds of the lock
+	keys := fillStore(t, s, 2*sampleBatch+200)
+
+	const rounds, n = 3000, 10
+	counts := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		sample := s.Sample(n)
+		if len(sample) != n {
+			t.Fatalf("Sample(%d) returned %d keys", n, len(sample))
+		}
+		seen := make(map[string]bool)
+		for _, key := range sample {
+			if seen[key] {
+				t.Fatalf("Sample returned %q twice", key)
+			}
+			seen[key] = true
+			counts[key]++
+		}
+	}
+	if x := chiSquare(counts, keys, rounds*n); x > maxChiSquare(len(keys)) {
+		t.Errorf("Sample picks are not uniform: chi-square %.0f over %d keys", x, len(keys))
+	}
+
+	// Each lock hold's share of the keys gets its share of the picks
+	picks := make([]int, (len(keys)+sampleBatch-1)/sampleBatch)
+	for i, key := range keys {
+		picks[i/sampleBatch] += counts[key]
+	}
+	for b, got := range picks {
+		size := sampleBatch
+		if b == len(picks)-1 {
+			size = len(keys) - b*sampleBatch
+		}
+		want := float64(size) / float64(len(keys))
+		if share := float64(got) / float64(rounds*n); math.Abs(share-want) > 0.02 {
+			t.Errorf("batch %d got %.3f of the picks, want %.3f", b, share, want)
+		}
+	}
+}
+
+func TestSampleSmallAndEmptyStores(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	if key, ok := s.RandomKey(); ok {
+		t.Errorf("RandomKey on an empty store = %q", key)
+	}
+	if sample := s.Sample(5); len(sample) != 0 {
+		t.Errorf("Sample on an empty store = %q", sample)
+	}
+
+	keys := fillStore(t, s, 3)
+	sample := s.Sample(10)
+	if len(sample) != len(keys) {
+		t.Fatalf("Sample(10) of 3 keys = %q", sample)
+	}
+	seen := make(map[string]bool)
+	for _, key := range sample {
+		seen[key] = true
+	}
+	for _, key := range keys {
+		if !seen[key] {
+			t.Errorf("Sample(10) of 3 keys left out %q", key)
+		}
+	}
+	if sample := s.Sample(0); sample != nil {
+		t.Errorf("Sample(0) = %q", sample)
+	}
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+)
+
+// A snapshot holds the live entries of a store in key order:
+//
+//	magic   [8]byte "YAKVSSNP"
+//	version byte
+//	count   uint64  number of entries
+//	entries         count opSet records, framed as in the log
+//	crc     uint32  CRC-32 (IEEE) of everything before it
+//
+// Integers are big endian. Each record also carries its own checksum, see
+// wal.go. A store opened with an encryption key writes snapshots starting
+// with snapshotEncMagic, with the key check value after the count and every
+// entry sealed as in an encrypted log, see encrypt.go.
+const (
+	snapshotMagic      = "YAKVSSNP"
+	snapshotEncMagic   = "YAKVSSNE"
+	snapshotVersion    = 1
+	snapshotHeaderSize = len(snapshotMagic) + 1 + 8
+)
+
+// ErrCorruptSnapshot is returned by LoadSnapshot when the snapshot cannot be
+// decoded or its checksum does not match
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+type snapshotEntry struct {
+	key   string
+	value Value
+}
+
+// SaveSnapshot writes every live entry to w. The entries are copied under the
+// read lock and written out after it is released, so writes are only blocked
+// while the copy is taken.
+func (s *Store) SaveSnapshot(w io.Writer) error {
+	entries, err := s.liveEntries()
+	if err != nil {
+		return err
+	}
+
+	hash := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, hash))
+
+	magic := snapshotMagic
+	if s.enc != nil {
+		magic = snapshotEncMagic
+	}
+	header := append([]byte(magic), snapshotVersion)
+	header = binary.BigEndian.AppendUint64(header, uint64(len(entries)))
+	if s.enc != nil {
+		header = append(header, s.enc.kcv...)
+	}
+	bw.Write(header)
+	for _, e := range entries {
+		record, err := encodeRecord(opSet, e.key, e.value)
+		if err != nil {
+			return fmt.Errorf("failed to encode %q: %w", e.key, err)
+		}
+		if s.enc != nil {
+			record = s.enc.seal(record)
+		}
+		if _, err := bw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], hash.Sum32())
+	_, err = w.Write(sum[:])
+	return err
+}
+
+// liveEntries copies the unexpired entries in key order
+func (s *Store) liveEntries() ([]snapshotEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+	entries := make([]snapshotEntry, 0, len(s.data))
+	s.index.ascend("", func(key string) bool {
+		if val := s.data[key]; !val.Expired(now) {
+			entries = append(entries, snapshotEntry{key: key, value: val})
+		}
+		return true
+	})
+	return entries, nil
+}
+
+// LoadSnapshot replaces the contents of the store with a snapshot written by
+// SaveSnapshot. The whole snapshot is read and verified before anything
+// changes, then the entries are swapped in and the log rewritten to hold just
+// them, as Compact would. Watchers are not told about the replaced keys.
+func (s *Store) LoadSnapshot(r io.Reader) error {
+	data, err := readSnapshot(r, s.enc)
+	if err != nil {
+		return err
+	}
+	return s.BulkLoad(data)
+}
+
+// readSnapshot decodes a snapshot, dropping entries that have expired. An
+// encrypted snapshot is opened with enc, which may be nil for a plain one.
+func readSnapshot(r io.Reader, enc *encryption) (map[string]Value, error) {
+	br := bufio.NewReader(r)
+	hash := crc32.NewIEEE()
+
+	header := make([]byte, snapshotHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: short header", ErrCorruptSnapshot)
+	}
+	encrypted := string(header[:len(snapshotEncMagic)]) == snapshotEncMagic
+	if !encrypted && string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: not a snapshot", ErrCorruptSnapshot)
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", v)
+	}
+	hash.Write(header)
+	count := binary.BigEndian.Uint64(header[len(snapshotMagic)+1:])
+
+	if encrypted {
+		if enc == nil {
+			return nil, ErrEncrypted
+		}
+		kcv := make([]byte, kcvSize)
+		if _, err := io.ReadFull(br, kcv); err != nil {
+			return nil, fmt.Errorf("%w: short header", ErrCorruptSnapshot)
+		}
+		if err := enc.checkKey(kcv); err != nil {
+			return nil, err
+		}
+		hash.Write(kcv)
+	}
+
+	// The count comes from the file, so it only hints at the map size
+	data := make(map[string]Value, min(count, 1<<20))
+	now := time.Now()
+	for i := uint64(0); i < count; i++ {
+		frame, err := readFrame(br, maxRecordSize+4)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("%w: truncated after %d of %d entries", ErrCorruptSnapshot, i, count)
+			}
+			return nil, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+		}
+		hash.Write(frame)
+		if encrypted {
+			if frame, err = enc.open(frame); errors.Is(err, ErrDecrypt) {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+		}
+		var rec walRecord
+		if err == nil {
+			rec, err = decodeRecord(frame)
+		}
+		if err != nil || rec.op != opSet {
+			return nil, fmt.Errorf("%w: bad entry %d", ErrCorruptSnapshot, i)
+		}
+
+		if !rec.value.Expired(now) {
+			data[rec.key] = rec.value
+		}
+	}
+
+	var sum [4]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return nil, fmt.Errorf("%w: missing checksum", ErrCorruptSnapshot)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != hash.Sum32() {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return data, nil
+}
+
+// BulkLoad replaces the contents of the store with entries, keeping their
+// versions and times. Instead of logging each entry it writes a fresh log
+// holding just them in one pass, replacing the old log and its history. If
+// the log cannot be written the old contents are put back. Watchers are not
+// told about the replaced keys, and replicas streaming the log start over.
+func (s *Store) BulkLoad(entries map[string]Value) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	// Building the index and expiry queue in one go is much faster than
+	// inserting a million keys one at a time
+	data := make(map[string]Value, len(entries))
+	keys := make([]string, 0, len(entries))
+	var usage memoryUsage
+	for key, value := range entries {
+		data[key] = value
+		keys = append(keys, key)
+		usage.bytes += entrySize(key, value)
+		usage.valueBytes += valueSize(value)
+		usage.logBytes += recordSize(key, value)
+	}
+	sort.Strings(keys)
+
+	oldData, oldIndex, oldExpiries, oldUsage := s.data, s.index, s.expiries, s.usage
+	s.data, s.index, s.usage = data, newKeyIndex(keys), usage
+	s.rebuildExpiries()
+
+	if s.log == nil {
+		return nil
+	}
+	if err := s.rewriteLog(""); err != nil {
+		s.data, s.index, s.expiries, s.usage = oldData, oldIndex, oldExpiries, oldUsage
+		return fmt.Errorf("failed to rewrite log: %w", err)
+	}
+	s.counters.lastCompaction.Store(time.Now().UnixNano())
+	return nil
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats summarizes the size of the store and the writes it has seen since it
+// was opened
+type Stats struct {
+	// Keys counts the entries held, including expired ones the cleaner has
+	// not swept yet
+	Keys        int   `json:"keys"`
+	MemoryBytes int64 `json:"memory_bytes"` // see MemoryStats for how it is estimated
+
+	Sets        uint64 `json:"sets"`
+	Deletes     uint64 `json:"deletes"`
+	ExpiredKeys uint64 `json:"expired_keys"` // removed by the cleaner or by reads finding them expired
+	EvictedKeys uint64 `json:"evicted_keys"` // removed to stay under the memory limit, also counted in Deletes
+	// ExpireCallbacksDropped counts expiries not passed to the OnExpire
+	// callbacks because they had fallen too far behind
+	ExpireCallbacksDropped uint64 `json:"expire_callbacks_dropped"`
+
+	LogSize         int64     `json:"log_size"`
+	LogBytesWritten uint64    `json:"log_bytes_written"`
+	LastCompaction  time.Time `json:"last_compaction"` // zero if the log was never compacted
+
+	Compactions              uint64        `json:"compactions"` // manual and automatic
+	CompactionBytesReclaimed uint64        `json:"compaction_bytes_reclaimed"`
+	LastCompactionDuration   time.Duration `json:"last_compaction_duration"`
+}
+
+// storeCounters are bumped as changes are applied and read without the
+// store's lock
+type storeCounters struct {
+	sets           atomic.Uint64
+	deletes        atomic.Uint64
+	expired        atomic.Uint64
+	evicted        atomic.Uint64
+	logBytes       atomic.Uint64
+	lastCompaction atomic.Int64 // UnixNano, zero for never
+
+	compactions            atomic.Uint64
+	compactionReclaimed    atomic.Uint64
+	lastCompactionDuration atomic.Int64
+}
+
+func (c *storeCounters) count(op string) {
+	switch op {
+	case EventSet:
+		c.sets.Add(1)
+	case EventDelete:
+		c.deletes.Add(1)
+	case EventExpire:
+		c.expired.Add(1)
+	}
+}
+
+// Stats returns the store's key count, memory estimate and write counters
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	stats := Stats{
+		Keys:        len(s.data),
+		MemoryBytes: s.usage.bytes,
+		LogSize:     s.logSize,
+	}
+	s.mu.RUnlock()
+
+	stats.Sets = s.counters.sets.Load()
+	stats.Deletes = s.counters.deletes.Load()
+	stats.ExpiredKeys = s.counters.expired.Load()
+	stats.EvictedKeys = s.counters.evicted.Load()
+	stats.ExpireCallbacksDropped = s.expireHooks.dropped.Load()
+	stats.LogBytesWritten = s.counters.logBytes.Load()
+	stats.Compactions = s.counters.compactions.Load()
+	stats.CompactionBytesReclaimed = s.counters.compactionReclaimed.Load()
+	stats.LastCompactionDuration = time.Duration(s.counters.lastCompactionDuration.Load())
+	if ns := s.counters.lastCompaction.Load(); ns != 0 {
+		stats.LastCompaction = time.Unix(0, ns)
+	}
+	return stats
+}
@@ -2,25 +2,195 @@ package store
 
 import (
 	"bufio"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/pixperk/yakvs/chaos"
 )
 
 // Store provides a persistent key-value store with expiration
 type Store struct {
-	mu   sync.RWMutex
-	data map[string]Value
-	log  *os.File
+	mu       sync.RWMutex
+	data     map[string]Value
+	index    keyIndex    // data's keys in sorted order
+	expiries expiryQueue // keys with an expiry, soonest first
+	log      *os.File    // nil for in-memory stores
+
+	logSize       int64         // bytes written to the log file
+	logNotify     chan struct{} // closed and replaced whenever the log grows or is rewritten
+	logGeneration uint64        // incremented whenever the log is rewritten, kept in its ID record
+	logID         string        // from the log's ID record, empty if it has none
+
+	enc          *encryption // nil unless the store was opened with a key
+	logEncrypted bool        // the log file's records are sealed by enc
+
+	syncPolicy SyncPolicy
+	unsynced   bool // log writes not yet fsynced under SyncEverySec
+
+	replayMode  ReplayMode
+	keepExpired bool // leave expired keys found by reads for the cleaner, see SetLazyExpiry
+	limits      Limits
+
+	maxMemory      int64 // zero for no limit, see SetMaxMemory
+	evictionPolicy EvictionPolicy
+
+	autoCompaction AutoCompaction
+	compacting     atomic.Bool // an automatic compaction is running
+
+	stop     chan struct{} // closed by Close to end background goroutines
+	stopOnce sync.Once
+	closed   bool
+
+	watchers    watchers
+	expireHooks expireHooks
+	usage       memoryUsage
+	counters    storeCounters
+	replay      ReplayStats
 }
 
+// ErrClosed is returned by writes to a store after Close
+var ErrClosed = errors.New("store is closed")
+
+// NoExpiry is the TTL reported for keys that never expire
+const NoExpiry time.Duration = -1
+
+// Value is a stored value. A zero ExpiresAt means it never expires.
+//
+// Version, CreatedAt and UpdatedAt are filled in by the store on every write:
+// Version counts the writes to the key since it was created, starting at 1,
+// and CreatedAt is kept until the key is deleted or expires. Keys written
+// before versions were tracked report version 0 and zero times.
+//
+// A list key holds its elements in List instead of Data. Lists are never
+// empty: popping the last element removes the key.
 type Value struct {
-	Data      string
+	Data      string // may hold arbitrary bytes
+	List      []string
 	ExpiresAt time.Time
+	Version   uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// valueJSON is how a Value is encoded in raft commands and snapshots. Data
+// that is not valid UTF-8 would be mangled as a JSON string, so it goes in
+// DataBase64 instead, and likewise a list with such an element goes in
+// ListBase64.
+type valueJSON struct {
+	Data       string   `json:",omitempty"`
+	DataBase64 []byte   `json:",omitempty"`
+	List       []string `json:",omitempty"`
+	ListBase64 [][]byte `json:",omitempty"`
+	ExpiresAt  time.Time
+	Version    uint64 `json:",omitempty"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	aux := valueJSON{
+		ExpiresAt: v.ExpiresAt,
+		Version:   v.Version,
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+	}
+	if utf8.ValidString(v.Data) {
+		aux.Data = v.Data
+	} else {
+		aux.DataBase64 = []byte(v.Data)
+	}
+	aux.List = v.List
+	for _, e := range v.List {
+		if !utf8.ValidString(e) {
+			aux.List = nil
+			for _, e := range v.List {
+				aux.ListBase64 = append(aux.ListBase64, []byte(e))
+			}
+			break
+		}
+	}
+	return json.Marshal(aux)
+}
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var aux valueJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	*v = Value{
+		Data:      aux.Data,
+		List:      aux.List,
+		ExpiresAt: aux.ExpiresAt,
+		Version:   aux.Version,
+		CreatedAt: aux.CreatedAt,
+		UpdatedAt: aux.UpdatedAt,
+	}
+	if aux.DataBase64 != nil {
+		v.Data = string(aux.DataBase64)
+	}
+	for _, e := range aux.ListBase64 {
+		v.List = append(v.List, string(e))
+	}
+	return nil
+}
+
+// IsList reports whether the value is a list
+func (v Value) IsList() bool {
+	return v.List != nil
+}
+
+// Expired reports whether the value's expiry has passed at now
+func (v Value) Expired(now time.Time) bool {
+	return !v.ExpiresAt.IsZero() && v.ExpiresAt.Before(now)
 }
 
+// NewStore opens or creates the log at logFilePath and replays it in
+// DefaultReplayMode. It is NewStoreWithOptions with only a log path.
 func NewStore(logFilePath string) (*Store, error) {
+	return NewStoreWithReplayMode(logFilePath, DefaultReplayMode)
+}
+
+// NewStoreWithReplayMode is NewStore with a choice of what to do with
+// corrupt records found while replaying the log
+func NewStoreWithReplayMode(logFilePath string, mode ReplayMode) (*Store, error) {
+	if logFilePath == "" {
+		return nil, fmt.Errorf("log file path is required")
+	}
+	return NewStoreWithOptions(StoreOptions{LogPath: logFilePath, ReplayMode: mode})
+}
+
+// NewEncryptedStore is NewStoreWithReplayMode for a log whose records, and
+// the snapshots the store saves, are encrypted with key using AES-GCM. The
+// key must be 16, 24 or 32 bytes. An unencrypted log is read and then
+// rewritten encrypted; opening an encrypted log without the key, or with a
+// different one, fails.
+func NewEncryptedStore(logFilePath string, key []byte, mode ReplayMode) (*Store, error) {
+	if key == nil {
+		return nil, fmt.Errorf("encryption key is required")
+	}
+	return NewStoreWithOptions(StoreOptions{LogPath: logFilePath, ReplayMode: mode, EncryptionKey: key})
+}
+
+func newStore(logFilePath string, mode ReplayMode, key []byte) (*Store, error) {
+	if _, err := ParseReplayMode(string(mode)); err != nil {
+		return nil, err
+	}
+	var enc *encryption
+	if key != nil {
+		var err error
+		if enc, err = newEncryption(key); err != nil {
+			return nil, err
+		}
+	}
 
 	logFile, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
@@ -28,152 +198,530 @@ func NewStore(logFilePath string) (*Store, error) {
 	}
 
 	s := &Store{
-		data: make(map[string]Value),
-		log:  logFile,
+		data:       make(map[string]Value),
+		log:        logFile,
+		logNotify:  make(chan struct{}),
+		syncPolicy: DefaultSyncPolicy,
+		replayMode: mode,
+		enc:        enc,
+		stop:       make(chan struct{}),
+		limits:     DefaultLimits,
+
+		evictionPolicy: DefaultEvictionPolicy,
+		autoCompaction: DefaultAutoCompaction,
+	}
+
+	format, err := detectFormat(logFile)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	switch format {
+	case formatEmpty:
+		// Also covers a header torn by a crash right after creating the log
+		if err := logFile.Truncate(0); err != nil {
+			logFile.Close()
+			return nil, err
+		}
+		start, _, err := s.newLogStart(0)
+		if err != nil {
+			logFile.Close()
+			return nil, err
+		}
+		if _, err := logFile.Write(start); err != nil {
+			logFile.Close()
+			return nil, err
+		}
+		s.logEncrypted = enc != nil
+
+	case formatEncrypted:
+		if err := s.checkLogKey(); err != nil {
+			logFile.Close()
+			return nil, fmt.Errorf("failed to open %s: %w", logFilePath, err)
+		}
+		s.logEncrypted = true
+
+	case formatLegacy, formatBinaryV1, formatBinaryV2:
+		suffix := ".text"
+		switch format {
+		case formatBinaryV1:
+			suffix = ".v1"
+		case formatBinaryV2:
+			suffix = ".v2"
+		}
+		if err := s.migrateLog(format, suffix); err != nil {
+			s.log.Close()
+			return nil, fmt.Errorf("failed to migrate log: %w", err)
+		}
+		fmt.Printf("Migrated %s to the current log format, the original is kept as %s%s\n", logFilePath, logFilePath, suffix)
 	}
 
-	s.ReplayLogs()
+	info, err := s.log.Stat()
+	if err != nil {
+		s.log.Close()
+		return nil, err
+	}
+	s.logSize = info.Size()
+
+	if _, _, err := s.ReplayLogs(); err != nil {
+		s.log.Close()
+		return nil, err
+	}
+	if enc != nil && !s.logEncrypted {
+		if err := s.rewriteLog(""); err != nil {
+			s.log.Close()
+			return nil, fmt.Errorf("failed to encrypt log: %w", err)
+		}
+		fmt.Printf("Encrypted %s\n", logFilePath)
+	} else if s.logID == "" {
+		if err := s.rewriteLog(""); err != nil {
+			s.log.Close()
+			return nil, fmt.Errorf("failed to add a log ID: %w", err)
+		}
+		fmt.Printf("Added a log ID to %s\n", logFilePath)
+	}
 
+	s.startFlusher()
 	return s, nil
 }
 
+// NewMemoryStore creates a store that keeps data only in memory, without a log file
+func NewMemoryStore() *Store {
+	return &Store{
+		data:       make(map[string]Value),
+		logNotify:  make(chan struct{}),
+		syncPolicy: SyncNever,
+		stop:       make(chan struct{}),
+		limits:     DefaultLimits,
+
+		evictionPolicy: DefaultEvictionPolicy,
+	}
+}
+
+// appendRecord writes a record to the log file. It is a no-op for in-memory
+// stores, and fails with ErrClosed once the store is closed. Callers must hold
+// the write lock.
+func (s *Store) appendRecord(op byte, key string, value Value) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.log == nil {
+		return nil
+	}
+
+	record, err := encodeRecord(op, key, value)
+	if err != nil {
+		return err
+	}
+	return s.writeLog(record)
+}
+
+// appendRecords logs one op record per key in a single write, taking each
+// record's value from value
+func (s *Store) appendRecords(op byte, keys []string, value func(key string) Value) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.log == nil {
+		return nil
+	}
+
+	var batch []byte
+	for _, key := range keys {
+		record, err := encodeRecord(op, key, value(key))
+		if err != nil {
+			return err
+		}
+		batch = append(batch, record...)
+	}
+	return s.writeLog(batch)
+}
+
+// writeLog appends raw bytes to the log file and wakes anyone waiting on
+// LogChanged. Callers must hold the write lock.
+func (s *Store) writeLog(record []byte) error {
+	if err := s.writeFrame(s.sealRecord(record)); err != nil {
+		return err
+	}
+	s.maybeCompact()
+	return nil
+}
+
+// writeFrame writes a frame to the log file as is. Callers must hold the
+// write lock.
+func (s *Store) writeFrame(record []byte) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.log == nil {
+		return nil
+	}
+	if err := chaos.WALWriteError(); err != nil {
+		return err
+	}
+
+	n, err := s.log.Write(record)
+	if err != nil && n > 0 && s.log.Truncate(s.logSize) == nil {
+		// Cut off the partial frame rather than leave it mid-log, where
+		// replay would take it for corruption once more records follow
+		n = 0
+	}
+	if n > 0 {
+		s.logSize += int64(n)
+		s.counters.logBytes.Add(uint64(n))
+		s.unsynced = true
+		close(s.logNotify)
+		s.logNotify = make(chan struct{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.syncPolicy == SyncAlways {
+		if err := s.log.Sync(); err != nil {
+			return err
+		}
+		s.unsynced = false
+	}
+	return nil
+}
+
+// NewValue creates a value that expires after expiresAfter, or never if it
+// is zero
 func NewValue(data string, expiresAfter time.Duration) Value {
-	expiresAt := time.Now().Add(expiresAfter)
 	val := Value{
-		Data:      data,
-		ExpiresAt: expiresAt,
+		Data: data,
+	}
+	if expiresAfter != 0 {
+		val.ExpiresAt = time.Now().Add(expiresAfter)
 	}
 
 	return val
 }
 
-func (s *Store) Set(key string, value Value) {
+// Set stores value under key. The write is logged before it becomes visible,
+// so if logging fails the store is left unchanged and the error returned. A
+// key or value over the store's Limits is refused with ErrTooLarge.
+func (s *Store) Set(key string, value Value) error {
+	return s.SetAsOf(key, value, time.Now())
+}
+
+// SetAsOf is Set stamping the value's version and times as of now, for raft
+// replicas applying the proposing node's clock
+func (s *Store) SetAsOf(key string, value Value, now time.Time) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.closed {
+		return ErrClosed
+	}
+
+	if err := s.checkWrite(key, len(value.Data)); err != nil {
+		return err
+	}
+
 	//append to log with expiry timestamp
-	expiryTimestamp := value.ExpiresAt.Format(time.RFC3339)
-	_, err := s.log.WriteString(time.Now().Format(time.RFC3339) + " SET " + key + " " + expiryTimestamp + " " + value.Data + "\n")
-	if err != nil {
-		return
+	value = s.stamp(key, value, now)
+	if err := s.appendRecord(opSet, key, value); err != nil {
+		return fmt.Errorf("failed to log SET: %w", err)
+	}
+	s.put(key, value)
+	s.publish(EventSet, key, value)
+	return nil
+}
+
+// SetWithMeta stores value under key exactly as given, keeping its version
+// and times, for restoring values taken from another store
+func (s *Store) SetWithMeta(key string, value Value) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if err := s.appendRecord(opSet, key, value); err != nil {
+		return fmt.Errorf("failed to log SET: %w", err)
+	}
+	s.put(key, value)
+	s.publish(EventSet, key, value)
+	return nil
+}
+
+// stamp fills in value's version and times for a write to key at now. A live
+// key's version is incremented and its creation time kept; a missing or
+// expired one starts again at version 1. Callers must hold the write lock.
+func (s *Store) stamp(key string, value Value, now time.Time) Value {
+	old, ok := s.data[key]
+	return stampValue(old, ok && !old.Expired(now), value, now)
+}
+
+// stampValue fills in value's version and times for a write replacing old,
+// which is only taken into account if live
+func stampValue(old Value, live bool, value Value, now time.Time) Value {
+	if live {
+		value.Version = old.Version + 1
+		value.CreatedAt = old.CreatedAt
+	} else {
+		value.Version = 1
+		value.CreatedAt = now
 	}
-	s.data[key] = value
+	value.UpdatedAt = now
+	return value
 }
 
+// Get returns the live value stored under key. A closed store reports every
+// key as missing. An expired key is deleted when found, see SetLazyExpiry.
 func (s *Store) Get(key string) (Value, bool) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
+	if s.closed {
+		s.mu.RUnlock()
+		return Value{}, false
+	}
 	val, ok := s.data[key]
-	if val.ExpiresAt.Before(time.Now()) {
+	s.mu.RUnlock()
+
+	if !ok {
 		return Value{}, false
 	}
-	return val, ok
+	if now := time.Now(); val.Expired(now) {
+		s.expireKey(key, now)
+		return Value{}, false
+	}
+	return val, true
 }
 
-func (s *Store) Delete(key string) {
+// Exists reports whether key is present and not expired
+func (s *Store) Exists(key string) bool {
+	_, ok := s.Get(key)
+	return ok
+}
+
+// Delete removes key, logging the delete first like Set
+func (s *Store) Delete(key string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.closed {
+		return ErrClosed
+	}
+
 	//append to log
-	_, err := s.log.WriteString(time.Now().Format(time.RFC3339) + " DELETE " + key + "\n")
-	if err != nil {
-		return
+	if err := s.appendRecord(opDelete, key, Value{}); err != nil {
+		return fmt.Errorf("failed to log DELETE: %w", err)
+	}
+	if old, ok := s.remove(key); ok {
+		s.publish(EventDelete, key, old)
 	}
-	delete(s.data, key)
+	return nil
 }
 
-// ReplayLogs rebuilds the store's in-memory data by replaying all operations from the log file.
+// ReplayLogs rebuilds the store's in-memory data by replaying all operations from the log file,
+// returning how many records were applied and how many corrupt stretches were skipped.
 // This should only be called during initialization, before any concurrent access to the store.
-func (s *Store) ReplayLogs() {
+// A torn or corrupt final record is truncated from the log. A corrupt record followed by
+// others stops the replay with an error wrapping ErrCorruptLog under ReplayStrict, or is
+// skipped under ReplaySkip, see ReplayMode.
+func (s *Store) ReplayLogs() (replayed, skipped int, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.log.Seek(0, 0)
-
-	s.data = make(map[string]Value)
-
-	scanner := bufio.NewScanner(s.log)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Split(line, " ")
+	if s.closed {
+		return 0, 0, ErrClosed
+	}
+	if s.log == nil {
+		return 0, 0, nil
+	}
 
-		if len(parts) < 3 {
-			continue
+	s.reset()
+	s.replay = ReplayStats{}
+	s.logID = ""
+	err = s.replayFrom(s.headerSize())
+	if err == nil && s.replay.Skipped > 0 {
+		// Rewrite the log so the next open does not trip over the same records
+		if err = s.rewriteLog(corruptSuffix); err == nil {
+			fmt.Printf("Rewrote %s without the skipped records, the original is kept as %s%s\n", s.log.Name(), s.log.Name(), corruptSuffix)
 		}
+	}
+	return s.replay.Replayed, s.replay.Skipped, err
+}
 
-		operation := parts[1]
-		key := parts[2]
-
-		switch operation {
-		case "SET":
-			if len(parts) < 5 {
-				continue // Need at least timestamp, operation, key, expiry, and data
-			}
-
-			expiryTimestamp := parts[3]
-			data := strings.Join(parts[4:], " ")
+// replayFrom applies the records from offset to the end of the log. Callers
+// must hold the write lock.
+func (s *Store) replayFrom(offset int64) error {
+	reader := bufio.NewReader(io.NewSectionReader(s.log, offset, s.logSize-offset))
+	for {
+		frame, err := readFrame(reader, s.logSize-offset)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
 
-			// Parse the expiry timestamp
-			expiresAt, err := time.Parse(time.RFC3339, expiryTimestamp)
-			if err != nil {
-				continue
+		var rec walRecord
+		if err == nil {
+			rec, err = s.openRecord(frame)
+		}
+		if errors.Is(err, ErrDecrypt) {
+			return fmt.Errorf("failed to replay log record %d at offset %d: %w", s.replay.Replayed+1, offset, err)
+		}
+		if err != nil {
+			if s.replayMode != ReplaySkip {
+				// Only the end of the log can have been torn by a crash, which
+				// may leave a partial record, zeros or garbage behind. Valid
+				// records after a bad one show it is corruption mid-log.
+				if !s.validRecordsAfter(offset) {
+					return s.truncateLog(offset)
+				}
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					err = fmt.Errorf("%w: record length runs past the records that follow", ErrCorruptLog)
+				}
+				return fmt.Errorf("failed to replay log record %d at offset %d: %w", s.replay.Replayed+1, offset, err)
 			}
 
-			s.data[key] = Value{
-				Data:      data,
-				ExpiresAt: expiresAt,
+			next := s.nextValidRecord(offset)
+			if next == s.logSize {
+				return s.truncateLog(offset)
 			}
+			s.replay.Skipped++
+			s.replay.SkippedBytes += next - offset
+			fmt.Printf("Skipped %d corrupt bytes after record %d, at offset %d of %s: %v\n", next-offset, s.replay.Replayed, offset, s.log.Name(), err)
 
-		case "DELETE":
-			delete(s.data, key)
+			offset = next
+			reader = bufio.NewReader(io.NewSectionReader(s.log, offset, s.logSize-offset))
+			continue
 		}
+
+		s.applyRecord(rec)
+		s.replay.Replayed++
+		offset += int64(len(frame))
 	}
-	if err := scanner.Err(); err != nil {
-		// In a real implementation, you might want to log this error
-		return
+}
+
+// truncateLog discards the log from offset on, counting it as one discarded
+// record. Callers must hold the write lock.
+func (s *Store) truncateLog(offset int64) error {
+	if err := s.log.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate log: %w", err)
 	}
+
+	s.replay.Discarded++
+	s.replay.DiscardedBytes += s.logSize - offset
+	fmt.Printf("Discarded %d bytes of a torn or corrupt record at offset %d of %s\n", s.logSize-offset, offset, s.log.Name())
+	s.logSize = offset
+	return nil
 }
 
-func (s *Store) TTL(key string) (time.Duration, bool) {
+// ReplayStats returns the outcome of the last log replay
+func (s *Store) ReplayStats() ReplayStats {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.replay
+}
+
+// TTL returns the time left before key expires, or NoExpiry if it never does
+func (s *Store) TTL(key string) (time.Duration, bool) {
+	s.mu.RLock()
 	val, ok := s.data[key]
-	if !ok || val.ExpiresAt.Before(time.Now()) {
+	s.mu.RUnlock()
+
+	if !ok {
 		return 0, false
 	}
+	if now := time.Now(); val.Expired(now) {
+		s.expireKey(key, now)
+		return 0, false
+	}
+	if val.ExpiresAt.IsZero() {
+		return NoExpiry, true
+	}
 
 	ttl := time.Until(val.ExpiresAt)
 	return ttl, true
 }
 
-func (s *Store) BackgroundCleaner() {
+// BackgroundCleaner deletes expired keys, logging each delete first. Keys are
+// taken from the expiry queue, so a sweep only visits keys that are due
+// rather than the whole store. It stops at the first log failure; the
+// remaining keys are retried on the next sweep.
+func (s *Store) BackgroundCleaner() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now()
-	for key, val := range s.data {
-		if val.ExpiresAt.Before(now) {
-			delete(s.data, key)
+	now := time.Now().UnixNano()
+	for len(s.expiries) > 0 && s.expiries[0].at < now {
+		e := heap.Pop(&s.expiries).(expiryEntry)
+		if !s.isCurrent(e) {
+			continue
+		}
 
-			_, err := s.log.WriteString(time.Now().Format(time.RFC3339) + " DELETE " + key + "\n")
-			if err != nil {
-				// In a real implementation, you might want to log this error
-				continue
-			}
+		if err := s.appendRecord(opDelete, e.key, Value{}); err != nil {
+			heap.Push(&s.expiries, e)
+			return fmt.Errorf("failed to log expiry of %q: %w", e.key, err)
 		}
+
+		val, _ := s.remove(e.key)
+		s.publish(EventExpire, e.key, val)
 	}
+	return nil
 }
 
 func (s *Store) StartBackgroundCleaner() {
+	s.StartBackgroundCleanerEvery(10 * time.Second)
+}
+
+// StartBackgroundCleanerEvery runs the expiry sweep at the given interval until the store is closed
+func (s *Store) StartBackgroundCleanerEvery(interval time.Duration) {
 	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
 		for {
-			time.Sleep(10 * time.Second)
-			s.BackgroundCleaner()
+			select {
+			case <-ticker.C:
+				if err := s.BackgroundCleaner(); err != nil {
+					fmt.Printf("Error sweeping expired keys: %v\n", err)
+				}
+			case <-s.stop:
+				return
+			}
 		}
 	}()
 }
 
+// Close stops the background cleaner and flusher, ends every watch, fsyncs
+// the log and closes it. Writes after Close fail with ErrClosed and reads
+// find nothing. Closing an already closed store is a no-op.
+func (s *Store) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.watchers.closeAll()
+
+	if s.log == nil {
+		return nil
+	}
+
+	// Writes go straight to the file, so there is no buffer to flush first
+	syncErr := s.log.Sync()
+	closeErr := s.log.Close()
+	s.log = nil
+	if syncErr != nil {
+		return fmt.Errorf("failed to sync log: %w", syncErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close log: %w", closeErr)
+	}
+	return nil
+}
+
 // Range iterates over all key-value pairs in the store, calling fn for each
 func (s *Store) Range(fn func(key string, value Value) bool) {
 	s.mu.RLock()
@@ -186,10 +734,25 @@ func (s *Store) Range(fn func(key string, value Value) bool) {
 	}
 }
 
-// Clear removes all key-value pairs from the store
-func (s *Store) Clear() {
+// Clear removes all key-value pairs from the store and empties its log, so
+// they stay gone after a restart. The log is replaced the same way Compact
+// replaces it, so replicas streaming it start over.
+func (s *Store) Clear() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.data = make(map[string]Value)
+	if s.closed {
+		return ErrClosed
+	}
+
+	oldData, oldIndex, oldExpiries, oldUsage := s.data, s.index, s.expiries, s.usage
+	s.reset()
+	if s.log == nil {
+		return nil
+	}
+	if err := s.rewriteLog(""); err != nil {
+		s.data, s.index, s.expiries, s.usage = oldData, oldIndex, oldExpiries, oldUsage
+		return fmt.Errorf("failed to clear log: %w", err)
+	}
+	return nil
 }
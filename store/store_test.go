@@ -0,0 +1,249 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestartKeepsArbitraryKeysAndValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.log")
+	pairs := map[string]string{
+		"plain":              "value",
+		"with spaces":        "a value with spaces",
+		"line\nbreaks":       "first\nsecond\r\nthird\n",
+		"ключ":               "значение",
+		"日本語 キー":             "値 🎉",
+		"tab\tand\x00nul":    "\x00\x01\xff\xfe binary",
+		"":                   "empty key",
+		"empty value":        "",
+		"2024-01-01 SET x y": "looks like the old text format",
+		"invalid utf-8 \xc3": "\xc3\x28",
+	}
+
+	s := openTestStore(t, path, ReplayStrict)
+	for key, value := range pairs {
+		if err := s.Set(key, NewValue(value, time.Hour)); err != nil {
+			t.Fatalf("SET %q: %v", key, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, path, ReplayStrict)
+	for key, want := range pairs {
+		v, ok := s.Get(key)
+		if !ok || v.Data != want {
+			t.Errorf("GET %q after restart = %q, %v, want %q", key, v.Data, ok, want)
+		}
+		if ttl, _ := s.TTL(key); ttl <= 0 || ttl > time.Hour {
+			t.Errorf("TTL %q after restart = %v, want under an hour", key, ttl)
+		}
+	}
+
+	// Compaction writes the same records afresh
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, path, ReplayStrict)
+	for key, want := range pairs {
+		if v, ok := s.Get(key); !ok || v.Data != want {
+			t.Errorf("GET %q after compaction and restart = %q, %v, want %q", key, v.Data, ok, want)
+		}
+	}
+}
+
+// makeLogReadOnly swaps the store's log file for a read-only handle, so
+// every write to it fails
+func makeLogReadOnly(t *testing.T, s *Store) {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.log.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.log.Close()
+	s.log = f
+}
+
+func TestWritesFailWhenTheLogCannotBeWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.log")
+	s := openTestStore(t, path, ReplayStrict)
+	mustSet(t, s, "kept", "value kept")
+	makeLogReadOnly(t, s)
+
+	if err := s.Set("new", NewValue("value new", 0)); err == nil {
+		t.Error("SET with a read-only log succeeded")
+	}
+	if err := s.Delete("kept"); err == nil {
+		t.Error("DELETE with a read-only log succeeded")
+	}
+	if err := s.MSet(map[string]Value{"m1": NewValue("1", 0), "m2": NewValue("2", 0)}); err == nil {
+		t.Error("MSET with a read-only log succeeded")
+	}
+	if _, err := s.IncrBy("counter", 1); err == nil {
+		t.Error("INCRBY with a read-only log succeeded")
+	}
+	if _, err := s.RPush("list", "x"); err == nil {
+		t.Error("RPUSH with a read-only log succeeded")
+	}
+	if err := s.ApplyBatch([]Op{{Kind: BatchSet, Key: "b", Value: NewValue("1", 0)}}); err == nil {
+		t.Error("BATCH with a read-only log succeeded")
+	}
+
+	// Failed writes leave the data as it was
+	checkKeys(t, s, "kept")
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, path, ReplayStrict)
+	checkKeys(t, s, "kept")
+}
+
+func TestWritesAfterCloseFail(t *testing.T) {
+	stores := map[string]*Store{
+		"log":       openTestStore(t, filepath.Join(t.TempDir(), "kv.log"), ReplayStrict),
+		"in-memory": NewMemoryStore(),
+	}
+	for name, s := range stores {
+		mustSet(t, s, "kept", "value kept")
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Set("new", NewValue("1", 0)); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: SET after Close = %v, want ErrClosed", name, err)
+		}
+		if err := s.Delete("kept"); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: DELETE after Close = %v, want ErrClosed", name, err)
+		}
+		if err := s.MSet(map[string]Value{"m": NewValue("1", 0)}); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: MSET after Close = %v, want ErrClosed", name, err)
+		}
+		if _, err := s.LPush("list", "x"); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: LPUSH after Close = %v, want ErrClosed", name, err)
+		}
+		if _, err := s.DeletePrefix(""); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: DeletePrefix after Close = %v, want ErrClosed", name, err)
+		}
+		if err := s.Clear(); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: Clear after Close = %v, want ErrClosed", name, err)
+		}
+
+		n := 0
+		s.Range(func(string, Value) bool { n++; return true })
+		if n != 1 {
+			t.Errorf("%s: %d keys left after writes to a closed store, want 1", name, n)
+		}
+	}
+}
+
+func TestClearedDataStaysGoneAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.log")
+	s := openTestStore(t, path, ReplayStrict)
+	mustSet(t, s, "a", "value a")
+	mustSet(t, s, "b", "value b")
+	if _, err := s.RPush("list", "x", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, path, ReplayStrict)
+	checkKeys(t, s)
+
+	// Writes after a Clear survive it
+	mustSet(t, s, "c", "value c")
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, s, "d", "value d")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, path, ReplayStrict)
+	checkKeys(t, s, "d")
+}
+
+func BenchmarkSetPersistence(b *testing.B) {
+	b.Run("log", func(b *testing.B) {
+		benchSets(b, openBenchStore(b, StoreOptions{}))
+	})
+	b.Run("memory", func(b *testing.B) {
+		s, err := NewStoreWithOptions(StoreOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer s.Close()
+		benchSets(b, s)
+	})
+}
+
+// BenchmarkRestore1M replaces a store holding a million keys with a million
+// others, as a raft snapshot restore does: in one bulk load, and the way
+// restores used to, clearing the store and setting the keys one at a time
+func BenchmarkRestore1M(b *testing.B) {
+	const n = 1_000_000
+	dataset := func(prefix string) map[string]Value {
+		entries := make(map[string]Value, n)
+		for i := 0; i < n; i++ {
+			entries[prefix+strconv.Itoa(i)] = NewValue(strings.Repeat("v", 100), time.Hour)
+		}
+		return entries
+	}
+	before, after := dataset("before"), dataset("after")
+
+	restores := map[string]func(s *Store, entries map[string]Value) error{
+		"bulk": func(s *Store, entries map[string]Value) error {
+			return s.BulkLoad(entries)
+		},
+		"per-key": func(s *Store, entries map[string]Value) error {
+			if err := s.Clear(); err != nil {
+				return err
+			}
+			for key, value := range entries {
+				if err := s.SetWithMeta(key, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	for _, name := range []string{"bulk", "per-key"} {
+		restore := restores[name]
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s := openBenchStore(b, StoreOptions{})
+				if err := s.BulkLoad(before); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if err := restore(s, after); err != nil {
+					b.Fatal(err)
+				}
+				if keys := s.Stats().Keys; keys != n {
+					b.Fatalf("restored %d keys, want %d", keys, n)
+				}
+			}
+		})
+	}
+}
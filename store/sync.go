@@ -0,0 +1,102 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy controls when writes to the log are fsynced to disk
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs every record before the write returns, so an
+	// acknowledged write survives an OS crash or power loss
+	SyncAlways SyncPolicy = "always"
+	// SyncEverySec fsyncs at most once a second from a background goroutine,
+	// losing up to a second of writes on an OS crash
+	SyncEverySec SyncPolicy = "everysec"
+	// SyncNever leaves flushing to the OS. Writes survive a process crash but
+	// not an OS crash.
+	SyncNever SyncPolicy = "no"
+)
+
+// DefaultSyncPolicy is the policy of a newly opened store
+const DefaultSyncPolicy = SyncEverySec
+
+// syncInterval is how often the background flusher runs under SyncEverySec
+const syncInterval = time.Second
+
+// ParseSyncPolicy converts "always", "everysec" or "no" to a SyncPolicy
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(s); policy {
+	case SyncAlways, SyncEverySec, SyncNever:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown sync policy %q, want always, everysec or no", s)
+}
+
+// SetSyncPolicy changes when log writes are fsynced. Switching to SyncAlways
+// first syncs any writes still pending from the previous policy.
+func (s *Store) SetSyncPolicy(policy SyncPolicy) error {
+	if _, err := ParseSyncPolicy(string(policy)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.syncPolicy = policy
+	if policy == SyncAlways && s.unsynced && s.log != nil {
+		if err := s.log.Sync(); err != nil {
+			return err
+		}
+		s.unsynced = false
+	}
+	return nil
+}
+
+// SyncPolicy returns the store's current sync policy
+func (s *Store) SyncPolicy() SyncPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.syncPolicy
+}
+
+// startFlusher fsyncs pending writes every syncInterval while the policy is
+// SyncEverySec, until the store is closed
+func (s *Store) startFlusher() {
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.flushPending()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Store) flushPending() {
+	s.mu.Lock()
+	if s.syncPolicy != SyncEverySec || !s.unsynced || s.log == nil {
+		s.mu.Unlock()
+		return
+	}
+	f := s.log
+	s.unsynced = false
+	s.mu.Unlock()
+
+	// Sync outside the lock so writers are not held up by the disk. If a
+	// compaction swaps the file meanwhile, it has already synced the new one.
+	if err := f.Sync(); err != nil {
+		fmt.Printf("Error syncing log: %v\n", err)
+		s.mu.Lock()
+		s.unsynced = true
+		s.mu.Unlock()
+	}
+}
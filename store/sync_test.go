@@ -0,0 +1,47 @@
+package store
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// openBenchStore opens a store configured by opts with its log in a
+// temporary directory, without automatic compaction, and closes it when the
+// benchmark ends
+func openBenchStore(b *testing.B, opts StoreOptions) *Store {
+	b.Helper()
+
+	opts.LogPath = filepath.Join(b.TempDir(), "kv.log")
+	opts.AutoCompaction = &AutoCompaction{}
+	s, err := NewStoreWithOptions(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.Close() })
+	return s
+}
+
+// benchSets sets b.N distinct keys to a 100 byte value and reports the
+// throughput in bytes of value written
+func benchSets(b *testing.B, s *Store) {
+	value := NewValue(strings.Repeat("v", 100), 0)
+
+	b.SetBytes(int64(len(value.Data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Set("key"+strconv.Itoa(i), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSetSyncPolicy(b *testing.B) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncEverySec, SyncNever} {
+		b.Run(string(policy), func(b *testing.B) {
+			benchSets(b, openBenchStore(b, StoreOptions{SyncPolicy: policy}))
+		})
+	}
+}
@@ -0,0 +1,710 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The log file starts with a header naming the format and its version,
+// followed by length-prefixed records:
+//
+//	length  uint32  size of the rest of the record
+//	crc     uint32  CRC-32 (IEEE) of everything after this field
+//	op      byte    one of the op constants below
+//	keyLen  uint32
+//	key     [keyLen]byte
+//	expiry  int64   unix nanoseconds, 0 for none
+//	version uint64  the value's Version
+//	created int64   unix nanoseconds, 0 if unknown
+//	updated int64   unix nanoseconds, 0 if unknown
+//	valLen  uint32
+//	value   [valLen]byte
+//
+// Integers are big endian. Keys and values may contain any bytes, including
+// spaces and newlines. Older logs are rewritten on open: version 1 logs lack
+// the crc field and version 2 logs the version, created and updated fields.
+//
+// List keys are logged by the list operations themselves: the value of an
+// opLPush or opRPush record holds the pushed elements and that of an opLPop
+// or opRPop the number popped, while opSetList stores a whole list, as
+// compaction writes it. Elements are encoded as a uint32 length followed by
+// the bytes. The metadata fields always describe the resulting value.
+//
+// Every log starts with an opLogID record whose key is a random ID, chosen
+// afresh whenever the log is created or rewritten, and whose version field
+// counts the rewrites. A replica's log mirrors its primary's, ID record
+// included, so the two tell whether a replica's offset still points into the
+// primary's log, even across restarts. Logs written before the record existed
+// are rewritten on open to gain one.
+const (
+	walMagic      = "YAKVSWAL"
+	walVersion    = 3
+	walHeaderSize = len(walMagic) + 1
+
+	// metaSize is the size of the version, created and updated fields
+	metaSize = 8 + 8 + 8
+
+	opSet     byte = 1
+	opDelete  byte = 2
+	opBatch   byte = 3
+	opSetList byte = 4
+	opLPush   byte = 5
+	opRPush   byte = 6
+	opLPop    byte = 7
+	opRPop    byte = 8
+	opLogID   byte = 9
+
+	// logIDSize is the number of random bytes in a log ID
+	logIDSize = 16
+
+	// maxRecordSize bounds the records written. Readers also bound a length
+	// prefix by the bytes left, see readFrame.
+	maxRecordSize = 1<<31 - 1
+
+	// frameChunk is the largest frame readFrame allocates before reading it
+	frameChunk = 1 << 20
+)
+
+// ErrCorruptLog is returned when the log contains a record that cannot be
+// decoded
+var ErrCorruptLog = errors.New("corrupt log")
+
+// ReplayStats describes the outcome of replaying the log on open
+type ReplayStats struct {
+	Replayed       int   // records applied
+	Discarded      int   // torn or corrupt records cut from the end of the log
+	DiscardedBytes int64 // bytes cut from the end of the log
+	Skipped        int   // corrupt stretches skipped under ReplaySkip
+	SkippedBytes   int64 // bytes skipped under ReplaySkip
+}
+
+// walRecord is a decoded log record. An opSetList record is decoded as an
+// opSet of a list value.
+type walRecord struct {
+	op    byte
+	key   string
+	value Value
+	batch []walRecord // the set and delete records of an opBatch
+	elems []string    // the elements pushed by an opLPush or opRPush
+	count int         // the number of elements removed by an opLPop or opRPop
+}
+
+func walHeader() []byte {
+	return append([]byte(walMagic), walVersion)
+}
+
+// newLogStart returns the header and ID record a new log starts with, along
+// with the log's ID. generation goes in the ID record's version field.
+func (s *Store) newLogStart(generation uint64) ([]byte, string, error) {
+	raw := make([]byte, logIDSize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate log ID: %w", err)
+	}
+	id := hex.EncodeToString(raw)
+
+	record, err := encodeRecord(opLogID, id, Value{Version: generation})
+	if err != nil {
+		return nil, "", err
+	}
+	if s.enc != nil {
+		record = s.enc.seal(record)
+	}
+	return append(s.logHeader(), record...), id, nil
+}
+
+// encodeRecord frames a record for the log. An opSet of a list value is
+// written as opSetList.
+func encodeRecord(op byte, key string, value Value) ([]byte, error) {
+	if op == opSet && value.IsList() {
+		op, value.Data = opSetList, encodeElems(value.List)
+	}
+
+	n := 4 + 1 + 4 + len(key) + 8 + metaSize + 4 + len(value.Data)
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("record of %d bytes exceeds the maximum of %d", n, maxRecordSize)
+	}
+
+	buf := make([]byte, 4+n)
+	binary.BigEndian.PutUint32(buf, uint32(n))
+	body := buf[8:]
+	body[0] = op
+	binary.BigEndian.PutUint32(body[1:], uint32(len(key)))
+	p := 5 + copy(body[5:], key)
+	binary.BigEndian.PutUint64(body[p:], uint64(unixNano(value.ExpiresAt)))
+	binary.BigEndian.PutUint64(body[p+8:], value.Version)
+	binary.BigEndian.PutUint64(body[p+16:], uint64(unixNano(value.CreatedAt)))
+	binary.BigEndian.PutUint64(body[p+24:], uint64(unixNano(value.UpdatedAt)))
+	binary.BigEndian.PutUint32(body[p+32:], uint32(len(value.Data)))
+	copy(body[p+36:], value.Data)
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+
+	return buf, nil
+}
+
+// recordSize is the size of the set record encodeRecord frames for key and
+// value, before sealing
+func recordSize(key string, value Value) int64 {
+	n := int64(4+4+1+4+len(key)+8+metaSize+4) + valueSize(value)
+	return n + int64(4*len(value.List))
+}
+
+// encodeBatch frames set and delete records as one opBatch record, whose
+// value holds the framed records back to back. The record is only applied if
+// it was written in full, which makes the batch atomic across a crash.
+func encodeBatch(recs []walRecord) ([]byte, error) {
+	var body []byte
+	for _, rec := range recs {
+		record, err := encodeRecord(rec.op, rec.key, rec.value)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, record...)
+	}
+	return encodeRecord(opBatch, "", Value{Data: string(body)})
+}
+
+// encodeListRecord frames a list push or pop record. value holds the
+// resulting metadata; a push logs elems, a pop the number of elements popped.
+func encodeListRecord(op byte, key string, value Value, elems []string, count int) ([]byte, error) {
+	value.List = nil
+	if op == opLPush || op == opRPush {
+		value.Data = encodeElems(elems)
+	} else {
+		value.Data = string(binary.BigEndian.AppendUint32(nil, uint32(count)))
+	}
+	return encodeRecord(op, key, value)
+}
+
+// encodeElems packs list elements into a record value
+func encodeElems(elems []string) string {
+	n := 0
+	for _, e := range elems {
+		n += 4 + len(e)
+	}
+	buf := make([]byte, 0, n)
+	for _, e := range elems {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(e)))
+		buf = append(buf, e...)
+	}
+	return string(buf)
+}
+
+// decodeElems reverses encodeElems
+func decodeElems(data string) ([]string, error) {
+	var elems []string
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: list element too short", ErrCorruptLog)
+		}
+		n := int(binary.BigEndian.Uint32([]byte(data[:4])))
+		if n > len(data)-4 {
+			return nil, fmt.Errorf("%w: list element length out of range", ErrCorruptLog)
+		}
+		elems = append(elems, data[4:4+n])
+		data = data[4+n:]
+	}
+	return elems, nil
+}
+
+// unixNano returns t in unix nanoseconds, or 0 for the zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano reverses unixNano
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// decodeRecord parses a framed record, rejecting any whose checksum does not
+// match or whose fields do not exactly fill the frame
+func decodeRecord(frame []byte) (walRecord, error) {
+	return decodeChecked(frame, true)
+}
+
+// decodeRecordV2 parses a record from a version 2 log, which has no version,
+// created or updated fields
+func decodeRecordV2(frame []byte) (walRecord, error) {
+	return decodeChecked(frame, false)
+}
+
+func decodeChecked(frame []byte, withMeta bool) (walRecord, error) {
+	if len(frame) < 8 {
+		return walRecord{}, fmt.Errorf("%w: record too short", ErrCorruptLog)
+	}
+	if int(binary.BigEndian.Uint32(frame)) != len(frame)-4 {
+		return walRecord{}, fmt.Errorf("%w: length prefix does not match record", ErrCorruptLog)
+	}
+	if crc32.ChecksumIEEE(frame[8:]) != binary.BigEndian.Uint32(frame[4:]) {
+		return walRecord{}, fmt.Errorf("%w: checksum mismatch", ErrCorruptLog)
+	}
+	return decodeBody(frame[8:], withMeta)
+}
+
+// decodeRecordV1 parses a record from a version 1 log, which has no checksum
+func decodeRecordV1(frame []byte) (walRecord, error) {
+	if len(frame) < 4 || int(binary.BigEndian.Uint32(frame)) != len(frame)-4 {
+		return walRecord{}, fmt.Errorf("%w: length prefix does not match record", ErrCorruptLog)
+	}
+	return decodeBody(frame[4:], false)
+}
+
+// decodeBody parses the fields of a record following its length and
+// checksum. Records from logs older than version 3 have no metadata fields.
+func decodeBody(body []byte, withMeta bool) (walRecord, error) {
+	fixed := 8 + 4 // expiry and valLen
+	if withMeta {
+		fixed += metaSize
+	}
+	if len(body) < 1+4+fixed {
+		return walRecord{}, fmt.Errorf("%w: record too short", ErrCorruptLog)
+	}
+
+	op := body[0]
+	if op == opSealed {
+		return walRecord{}, ErrEncrypted
+	}
+	if op < opSet || op > opLogID || (op > opDelete && !withMeta) {
+		return walRecord{}, fmt.Errorf("%w: unknown op %d", ErrCorruptLog, op)
+	}
+
+	keyLen := int(binary.BigEndian.Uint32(body[1:]))
+	body = body[5:]
+	if keyLen > len(body)-fixed {
+		return walRecord{}, fmt.Errorf("%w: key length out of range", ErrCorruptLog)
+	}
+	key := string(body[:keyLen])
+	body = body[keyLen:]
+
+	rec := walRecord{op: op, key: key}
+	rec.value.ExpiresAt = fromUnixNano(int64(binary.BigEndian.Uint64(body)))
+	body = body[8:]
+	if withMeta {
+		rec.value.Version = binary.BigEndian.Uint64(body)
+		rec.value.CreatedAt = fromUnixNano(int64(binary.BigEndian.Uint64(body[8:])))
+		rec.value.UpdatedAt = fromUnixNano(int64(binary.BigEndian.Uint64(body[16:])))
+		body = body[metaSize:]
+	}
+
+	valLen := int(binary.BigEndian.Uint32(body))
+	if valLen != len(body)-4 {
+		return walRecord{}, fmt.Errorf("%w: value length does not match record", ErrCorruptLog)
+	}
+	rec.value.Data = string(body[4:])
+
+	switch op {
+	case opBatch:
+		return decodeBatch(rec.value.Data)
+
+	case opSetList, opLPush, opRPush:
+		elems, err := decodeElems(rec.value.Data)
+		if err != nil {
+			return walRecord{}, err
+		}
+		if len(elems) == 0 {
+			return walRecord{}, fmt.Errorf("%w: empty list", ErrCorruptLog)
+		}
+		rec.value.Data = ""
+		if op == opSetList {
+			rec.op, rec.value.List = opSet, elems
+		} else {
+			rec.elems = elems
+		}
+
+	case opLPop, opRPop:
+		if len(rec.value.Data) != 4 {
+			return walRecord{}, fmt.Errorf("%w: bad pop count", ErrCorruptLog)
+		}
+		rec.count = int(binary.BigEndian.Uint32([]byte(rec.value.Data)))
+		rec.value.Data = ""
+	}
+	return rec, nil
+}
+
+// decodeBatch parses the records held in the value of an opBatch record
+func decodeBatch(data string) (walRecord, error) {
+	rec := walRecord{op: opBatch}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return walRecord{}, fmt.Errorf("%w: batch entry too short", ErrCorruptLog)
+		}
+		n := 4 + int(binary.BigEndian.Uint32([]byte(data[:4])))
+		if n > len(data) {
+			return walRecord{}, fmt.Errorf("%w: batch entry length out of range", ErrCorruptLog)
+		}
+
+		entry, err := decodeRecord([]byte(data[:n]))
+		if err != nil {
+			return walRecord{}, err
+		}
+		if entry.op == opBatch {
+			return walRecord{}, fmt.Errorf("%w: nested batch", ErrCorruptLog)
+		}
+		rec.batch = append(rec.batch, entry)
+		data = data[n:]
+	}
+	return rec, nil
+}
+
+// readFrame reads one record frame from r, which has at most left bytes to
+// give. It returns io.EOF at a clean end of the log and io.ErrUnexpectedEOF
+// if the final record is incomplete, which includes a length prefix running
+// past the end, whether torn by a crash or corrupt. Frames are allocated as
+// their bytes arrive beyond frameChunk, so a corrupt length cannot make a
+// stream of unknown size trigger a huge allocation.
+func readFrame(r *bufio.Reader, left int64) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	n := int64(binary.BigEndian.Uint32(prefix[:]))
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("%w: record length %d out of range", ErrCorruptLog, n)
+	}
+	if n > left-4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if n <= frameChunk {
+		frame := make([]byte, 4+n)
+		copy(frame, prefix[:])
+		if _, err := io.ReadFull(r, frame[4:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	frame := bytes.NewBuffer(make([]byte, 0, 4+frameChunk))
+	frame.Write(prefix[:])
+	if _, err := io.CopyN(frame, r, n); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame.Bytes(), nil
+}
+
+// applyRecord applies a decoded record to the in-memory data and returns the
+// resulting change events. Callers must hold the write lock.
+func (s *Store) applyRecord(rec walRecord) []Event {
+	switch rec.op {
+	case opSet:
+		s.put(rec.key, rec.value)
+		return []Event{{Op: EventSet, Key: rec.key, Value: rec.value}}
+
+	case opBatch:
+		var events []Event
+		for _, entry := range rec.batch {
+			events = append(events, s.applyRecord(entry)...)
+		}
+		return events
+
+	case opLPush, opRPush, opLPop, opRPop:
+		return s.applyListRecord(rec)
+
+	case opLogID:
+		s.logID, s.logGeneration = rec.key, rec.value.Version
+		return nil
+	}
+
+	old, ok := s.remove(rec.key)
+	if !ok {
+		return nil
+	}
+	return []Event{{Op: EventDelete, Key: rec.key, Value: old}}
+}
+
+// scanFrames walks the record frames of a log of the given size, starting
+// after its header, using only their length prefixes. It calls fn with the end
+// offset of each complete frame until fn returns false, and returns the end
+// of the last complete frame visited.
+func scanFrames(f io.ReaderAt, header, size int64, fn func(end int64) bool) (int64, error) {
+	end := header
+	if size <= end {
+		return end, nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, end, size-end))
+	for {
+		var prefix [4]byte
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return end, nil
+			}
+			return end, err
+		}
+
+		n := int64(binary.BigEndian.Uint32(prefix[:]))
+		if end+4+n > size {
+			return end, nil
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			return end, err
+		}
+
+		end += 4 + n
+		if !fn(end) {
+			return end, nil
+		}
+	}
+}
+
+type logFormat int
+
+const (
+	formatEmpty logFormat = iota
+	formatBinary
+	formatBinaryV1
+	formatBinaryV2
+	formatEncrypted
+	formatLegacy
+)
+
+// detectFormat tells a current binary log from an older binary one or a
+// plain-text one written before the binary format existed. A file holding
+// only part of a header is treated as empty.
+func detectFormat(f *os.File) (logFormat, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() == 0 {
+		return formatEmpty, nil
+	}
+
+	head := make([]byte, walHeaderSize)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	head = head[:n]
+
+	header := walHeader()
+	encHeader := append([]byte(encMagic), walVersion)
+	switch {
+	case n < walHeaderSize && (bytes.HasPrefix(header, head) || bytes.HasPrefix(encHeader, head)):
+		return formatEmpty, nil
+	case bytes.Equal(head, encHeader):
+		// A key check value torn off by a crash leaves a log with no records
+		if info.Size() < int64(encHeaderSize) {
+			return formatEmpty, nil
+		}
+		return formatEncrypted, nil
+	case bytes.HasPrefix(head, []byte(encMagic)):
+		return 0, fmt.Errorf("unsupported encrypted log version %d", head[len(encMagic)])
+	case bytes.HasPrefix(head, []byte(walMagic)) && n == walHeaderSize:
+		switch head[len(walMagic)] {
+		case walVersion:
+			return formatBinary, nil
+		case 1:
+			return formatBinaryV1, nil
+		case 2:
+			return formatBinaryV2, nil
+		}
+		return 0, fmt.Errorf("unsupported log version %d", head[len(walMagic)])
+	}
+	return formatLegacy, nil
+}
+
+// migrateLog loads a log in an older format and replaces it with a current
+// binary log of the live keys. The original is kept next to it with the
+// given suffix.
+func (s *Store) migrateLog(format logFormat, suffix string) error {
+	switch format {
+	case formatLegacy:
+		s.replayLegacyLog()
+	case formatBinaryV1:
+		if err := s.replayOldLog(decodeRecordV1); err != nil {
+			return err
+		}
+	case formatBinaryV2:
+		if err := s.replayOldLog(decodeRecordV2); err != nil {
+			return err
+		}
+	}
+	return s.rewriteLog(suffix)
+}
+
+// replayLegacyLog loads a plain-text log. A final line without a newline was
+// torn mid-write and is ignored.
+func (s *Store) replayLegacyLog() {
+	reader := bufio.NewReader(io.NewSectionReader(s.log, 0, 1<<62))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		s.applyLegacyLine(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// replayOldLog loads an older binary log whose records are parsed by decode,
+// ignoring a torn final record
+func (s *Store) replayOldLog(decode func([]byte) (walRecord, error)) error {
+	info, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	offset := int64(walHeaderSize)
+	reader := bufio.NewReader(io.NewSectionReader(s.log, offset, size-offset))
+	for {
+		frame, err := readFrame(reader, size-offset)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read log record at offset %d: %w", offset, err)
+		}
+
+		rec, err := decode(frame)
+		if err != nil {
+			return fmt.Errorf("failed to decode log record at offset %d: %w", offset, err)
+		}
+		s.applyRecord(rec)
+		offset += int64(len(frame))
+	}
+}
+
+// rewriteLog replaces the log file with one holding a SET record for every
+// live key. The old file is kept next to it with the given suffix, or dropped
+// if suffix is empty. Callers must hold the write lock.
+func (s *Store) rewriteLog(suffix string) error {
+	path := s.log.Name()
+
+	tmpPath := path + ".rewrite"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	generation := s.logGeneration + 1
+	start, id, err := s.newLogStart(generation)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	w.Write(start)
+	now := time.Now()
+	for key, value := range s.data {
+		if value.Expired(now) {
+			continue
+		}
+		record, err := encodeRecord(opSet, key, value)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if s.enc != nil {
+			record = s.enc.seal(record)
+		}
+		w.Write(record)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if suffix != "" {
+		if err := os.Rename(path, path+suffix); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.log.Close()
+	s.log = f
+	s.logSize = info.Size()
+	s.logEncrypted = s.enc != nil
+	s.unsynced = false
+	s.logID, s.logGeneration = id, generation
+	close(s.logNotify)
+	s.logNotify = make(chan struct{})
+	return nil
+}
+
+// syncDir flushes a directory so a rename inside it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// applyLegacyLine applies one record of a plain-text log, in the form
+// "<timestamp> SET <key> <expiry> <data>" or "<timestamp> DELETE <key>".
+// Malformed lines are ignored, as the text format always did.
+func (s *Store) applyLegacyLine(line string) {
+	parts := strings.Split(line, " ")
+	if len(parts) < 3 {
+		return
+	}
+
+	key := parts[2]
+	switch parts[1] {
+	case "SET":
+		if len(parts) < 5 {
+			return
+		}
+
+		expiresAt, err := time.Parse(time.RFC3339, parts[3])
+		if err != nil {
+			return
+		}
+		s.put(key, Value{Data: strings.Join(parts[4:], " "), ExpiresAt: expiresAt})
+
+	case "DELETE":
+		s.remove(key)
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestShortMSetWriteLeavesNothing fails an MSET partway through writing its
+// record, by capping the size files may grow to, and checks neither the
+// store nor the log keeps any of it
+func TestShortMSetWriteLeavesNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.log")
+	s := openTestStore(t, path, ReplayStrict)
+	mustSet(t, s, "a", "value a")
+	before := s.LogSize()
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	capped := limit
+	capped.Cur = uint64(before) + 64
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &capped); err != nil {
+		t.Skipf("cannot cap file sizes: %v", err)
+	}
+	err := s.MSet(map[string]Value{
+		"m1": NewValue("value m1", 0),
+		"m2": NewValue("value m2", 0),
+		"m3": NewValue("value m3", 0),
+	})
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatal("MSET past the file size limit succeeded")
+	}
+
+	checkKeys(t, s, "a")
+	if s.LogSize() != before {
+		t.Errorf("log size %d after the failed MSET, want %d", s.LogSize(), before)
+	}
+	checkAppendAndReplay(t, s, path, "a")
+}
@@ -0,0 +1,354 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestStore opens the log at path in mode, without automatic
+// compaction, and closes it when the test ends
+func openTestStore(t *testing.T, path string, mode ReplayMode) *Store {
+	t.Helper()
+
+	s, err := NewStoreWithOptions(StoreOptions{LogPath: path, ReplayMode: mode, AutoCompaction: &AutoCompaction{}})
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// writeTestLog writes a log holding one SET of "value <key>" per key and
+// returns its bytes and the offset each key's record starts at, followed by
+// the end of the log
+func writeTestLog(t *testing.T, path string, keys ...string) ([]byte, []int64) {
+	t.Helper()
+
+	s := openTestStore(t, path, ReplayStrict)
+	var offsets []int64
+	for _, key := range keys {
+		offsets = append(offsets, s.LogSize())
+		mustSet(t, s, key, "value "+key)
+	}
+	offsets = append(offsets, s.LogSize())
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, offsets
+}
+
+func mustSet(t *testing.T, s *Store, key, value string) {
+	t.Helper()
+
+	if err := s.Set(key, NewValue(value, 0)); err != nil {
+		t.Fatalf("SET %q: %v", key, err)
+	}
+}
+
+// checkKeys fails the test unless s holds exactly the keys of want, each with
+// the value "value <key>"
+func checkKeys(t *testing.T, s *Store, want ...string) {
+	t.Helper()
+
+	for _, key := range want {
+		v, ok := s.Get(key)
+		if !ok || v.Data != "value "+key {
+			t.Errorf("GET %q = %q, %v, want %q", key, v.Data, ok, "value "+key)
+		}
+	}
+	n := 0
+	s.Range(func(string, Value) bool { n++; return true })
+	if n != len(want) {
+		t.Errorf("store holds %d keys, want %d", n, len(want))
+	}
+}
+
+// checkAppendAndReplay writes another key to the store opened at path,
+// closes it and checks a strict replay finds it along with want
+func checkAppendAndReplay(t *testing.T, s *Store, path string, want ...string) {
+	t.Helper()
+
+	mustSet(t, s, "appended", "value appended")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, path, ReplayStrict)
+	checkKeys(t, s, append(want, "appended")...)
+	if stats := s.ReplayStats(); stats.Discarded != 0 || stats.Skipped != 0 {
+		t.Errorf("replay after append = %+v, want nothing discarded or skipped", stats)
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var replayModes = []ReplayMode{ReplayStrict, ReplaySkip}
+
+func TestTruncatedFinalRecordAtEveryOffset(t *testing.T) {
+	dir := t.TempDir()
+	data, offsets := writeTestLog(t, filepath.Join(dir, "full.log"), "a", "b", "c")
+	start, end := offsets[2], offsets[3]
+
+	for _, mode := range replayModes {
+		for cut := start; cut < end; cut++ {
+			path := filepath.Join(dir, fmt.Sprintf("%s-%d.log", mode, cut))
+			writeFile(t, path, data[:cut])
+
+			s := openTestStore(t, path, mode)
+			checkKeys(t, s, "a", "b")
+			wantDiscarded := 0
+			if cut > start {
+				wantDiscarded = 1
+			}
+			if stats := s.ReplayStats(); stats.Discarded != wantDiscarded || stats.DiscardedBytes != cut-start {
+				t.Errorf("%s cut at %d: replay = %+v, want %d record of %d bytes discarded", mode, cut, stats, wantDiscarded, cut-start)
+			}
+			if s.LogSize() != start {
+				t.Errorf("%s cut at %d: log size %d, want %d", mode, cut, s.LogSize(), start)
+			}
+			checkAppendAndReplay(t, s, path, "a", "b")
+		}
+	}
+}
+
+func TestTruncatedMSetReplaysNoneOfIt(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.log")
+	s := openTestStore(t, full, ReplayStrict)
+	mustSet(t, s, "a", "value a")
+	start := s.LogSize()
+	pairs := map[string]Value{}
+	for _, key := range []string{"m1", "m2", "m3"} {
+		pairs[key] = NewValue("value "+key, 0)
+	}
+	if err := s.MSet(pairs); err != nil {
+		t.Fatal(err)
+	}
+	end := s.LogSize()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkKeys(t, openTestStore(t, full, ReplayStrict), "a", "m1", "m2", "m3")
+	for _, mode := range replayModes {
+		for cut := start; cut < end; cut++ {
+			path := filepath.Join(dir, fmt.Sprintf("%s-%d.log", mode, cut))
+			writeFile(t, path, data[:cut])
+
+			s := openTestStore(t, path, mode)
+			checkKeys(t, s, "a")
+			if s.LogSize() != start {
+				t.Errorf("%s cut at %d: log size %d, want %d", mode, cut, s.LogSize(), start)
+			}
+		}
+	}
+}
+
+func TestTornWriteAtEndOfLog(t *testing.T) {
+	dir := t.TempDir()
+	data, offsets := writeTestLog(t, filepath.Join(dir, "full.log"), "a", "b", "c")
+	half := offsets[2] + (offsets[3]-offsets[2])/2
+
+	garbage := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	tails := map[string][]byte{
+		// A crash can extend the file before its data reaches the disk
+		"zeros": make([]byte, 4096),
+		// or leave whatever the disk held before
+		"garbage": garbage,
+	}
+
+	for name, tail := range tails {
+		for _, mode := range replayModes {
+			path := filepath.Join(dir, fmt.Sprintf("%s-%s.log", name, mode))
+			writeFile(t, path, append(bytes.Clone(data[:half]), tail...))
+
+			s := openTestStore(t, path, mode)
+			checkKeys(t, s, "a", "b")
+			if s.LogSize() != offsets[2] {
+				t.Errorf("%s %s: log size %d, want %d", name, mode, s.LogSize(), offsets[2])
+			}
+			checkAppendAndReplay(t, s, path, "a", "b")
+		}
+	}
+}
+
+func TestCorruptByteMidLog(t *testing.T) {
+	dir := t.TempDir()
+	data, offsets := writeTestLog(t, filepath.Join(dir, "full.log"), "a", "b", "c")
+
+	// Every byte of b's record is covered by its length prefix or checksum
+	for i := offsets[1]; i < offsets[2]; i++ {
+		corrupt := bytes.Clone(data)
+		corrupt[i] ^= 0x5a
+
+		path := filepath.Join(dir, fmt.Sprintf("strict-%d.log", i))
+		writeFile(t, path, corrupt)
+		if _, err := NewStoreWithReplayMode(path, ReplayStrict); !errors.Is(err, ErrCorruptLog) {
+			t.Errorf("strict open with byte %d corrupt = %v, want ErrCorruptLog", i, err)
+		}
+		if after, _ := os.ReadFile(path); !bytes.Equal(after, corrupt) {
+			t.Errorf("strict open with byte %d corrupt changed the log", i)
+		}
+
+		path = filepath.Join(dir, fmt.Sprintf("skip-%d.log", i))
+		writeFile(t, path, corrupt)
+		s := openTestStore(t, path, ReplaySkip)
+		checkKeys(t, s, "a", "c")
+		if stats := s.ReplayStats(); stats.Skipped != 1 || stats.SkippedBytes != offsets[2]-offsets[1] {
+			t.Errorf("skip with byte %d corrupt: replay = %+v, want b's %d bytes skipped", i, stats, offsets[2]-offsets[1])
+		}
+		if kept, _ := os.ReadFile(path + corruptSuffix); !bytes.Equal(kept, corrupt) {
+			t.Errorf("skip with byte %d corrupt did not keep the original log", i)
+		}
+		checkAppendAndReplay(t, s, path, "a", "c")
+	}
+}
+
+func TestCorruptRecordAtEndOfLog(t *testing.T) {
+	dir := t.TempDir()
+	data, offsets := writeTestLog(t, filepath.Join(dir, "full.log"), "a", "b", "c")
+
+	// A complete final record with a bad checksum is cut off like a torn one
+	for _, mode := range replayModes {
+		corrupt := bytes.Clone(data)
+		corrupt[offsets[3]-1] ^= 0xff
+
+		path := filepath.Join(dir, string(mode)+".log")
+		writeFile(t, path, corrupt)
+		s := openTestStore(t, path, mode)
+		checkKeys(t, s, "a", "b")
+		if stats := s.ReplayStats(); stats.Discarded != 1 {
+			t.Errorf("%s: replay = %+v, want the final record discarded", mode, stats)
+		}
+		checkAppendAndReplay(t, s, path, "a", "b")
+	}
+}
+
+func TestGarbageBetweenValidRecords(t *testing.T) {
+	dir := t.TempDir()
+	data, offsets := writeTestLog(t, filepath.Join(dir, "full.log"), "a", "b", "c")
+
+	garbage := [][]byte{[]byte("garbage line\n"), []byte(`{"op":"SET","key":"x"}` + "\n")}
+	var log []byte
+	log = append(log, data[:offsets[1]]...)
+	log = append(log, garbage[0]...)
+	log = append(log, data[offsets[1]:offsets[2]]...)
+	log = append(log, garbage[1]...)
+	log = append(log, data[offsets[2]:]...)
+
+	path := filepath.Join(dir, "strict.log")
+	writeFile(t, path, log)
+	if _, err := NewStoreWithReplayMode(path, ReplayStrict); !errors.Is(err, ErrCorruptLog) {
+		t.Fatalf("strict open = %v, want ErrCorruptLog", err)
+	}
+
+	path = filepath.Join(dir, "skip.log")
+	writeFile(t, path, log)
+	s := openTestStore(t, path, ReplaySkip)
+	checkKeys(t, s, "a", "b", "c")
+	want := ReplayStats{Skipped: 2, SkippedBytes: int64(len(garbage[0]) + len(garbage[1]))}
+	if stats := s.ReplayStats(); stats.Skipped != want.Skipped || stats.SkippedBytes != want.SkippedBytes || stats.Discarded != 0 {
+		t.Errorf("skip replay = %+v, want %+v", stats, want)
+	}
+
+	// The rewritten log opens in strict mode, and no longer holds the garbage
+	checkAppendAndReplay(t, s, path, "a", "b", "c")
+	if rewritten, _ := os.ReadFile(path); bytes.Contains(rewritten, []byte("garbage")) {
+		t.Error("rewritten log still holds the garbage")
+	}
+}
+
+func TestReplayLogsReportsCounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.log")
+	s := openTestStore(t, path, ReplaySkip)
+	mustSet(t, s, "a", "value a")
+	mustSet(t, s, "b", "value b")
+
+	// The log's ID record counts as a replayed record
+	replayed, skipped, err := s.ReplayLogs()
+	if err != nil || replayed != 3 || skipped != 0 {
+		t.Errorf("ReplayLogs = %d, %d, %v, want 3, 0, nil", replayed, skipped, err)
+	}
+	checkKeys(t, s, "a", "b")
+}
+
+func TestOversizedLengthPrefix(t *testing.T) {
+	dir := t.TempDir()
+	data, offsets := writeTestLog(t, filepath.Join(dir, "full.log"), "a", "b", "c")
+
+	// A length far beyond the bytes left must not be allocated. Mid-log it is
+	// corruption; in the final record it is a torn write.
+	for _, at := range []int{1, 2} {
+		corrupt := bytes.Clone(data)
+		binary.BigEndian.PutUint32(corrupt[offsets[at]:], 0x7ffffff0)
+
+		for _, mode := range replayModes {
+			path := filepath.Join(dir, fmt.Sprintf("%s-%d.log", mode, at))
+			writeFile(t, path, corrupt)
+
+			if mode == ReplayStrict && at == 1 {
+				if _, err := NewStoreWithReplayMode(path, mode); !errors.Is(err, ErrCorruptLog) {
+					t.Errorf("strict open with a huge length mid-log = %v, want ErrCorruptLog", err)
+				}
+				continue
+			}
+
+			s := openTestStore(t, path, mode)
+			if at == 1 {
+				checkKeys(t, s, "a", "c")
+				checkAppendAndReplay(t, s, path, "a", "c")
+			} else {
+				checkKeys(t, s, "a", "b")
+				checkAppendAndReplay(t, s, path, "a", "b")
+			}
+		}
+	}
+}
+
+func TestReadFrameBoundsLength(t *testing.T) {
+	frame, err := encodeRecord(opSet, "key", Value{Data: "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(left int64) error {
+		_, err := readFrame(bufio.NewReader(bytes.NewReader(frame)), left)
+		return err
+	}
+	if err := read(int64(len(frame))); err != nil {
+		t.Errorf("readFrame with exactly the frame left = %v", err)
+	}
+	if err := read(int64(len(frame) - 1)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("readFrame with a byte too few left = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	// Frames over frameChunk grow as they are read
+	big := append(binary.BigEndian.AppendUint32(nil, 3*frameChunk), make([]byte, 2*frameChunk)...)
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(big)), maxRecordSize+4); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("readFrame of a short stream = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event operations reported to watchers
+const (
+	EventSet    = "set"
+	EventDelete = "delete"
+	EventExpire = "expire"
+)
+
+// Event describes a change to a key
+type Event struct {
+	Op    string
+	Key   string
+	Value Value // the new value for set events, the old value otherwise
+	// Version is the key's version after a set, or the version removed by a
+	// delete or expiry
+	Version uint64
+	Time    time.Time
+	// Lost is the number of events dropped for this watcher immediately
+	// before this one because its buffer was full
+	Lost int
+}
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+	lost   int
+}
+
+// watchers holds the registered watchers. It has its own lock so events can
+// be published while the store's write lock is held.
+type watchers struct {
+	mu     sync.Mutex
+	subs   map[*watcher]struct{}
+	closed bool // set by Close, after which new watches start closed
+}
+
+// Watch returns a channel receiving an event for every change to a key with
+// the given prefix (an empty prefix matches all keys), and a function that
+// cancels the watch and closes the channel.
+//
+// Writers never block on watchers: once buffer events are queued, new events
+// for that watcher are dropped and the next delivered event reports how many
+// were lost. Closing the store closes every watch channel, so a goroutine
+// ranging over one always ends.
+func (s *Store) Watch(prefix string, buffer int) (<-chan Event, func()) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	w := &watcher{
+		prefix: prefix,
+		ch:     make(chan Event, buffer),
+	}
+
+	s.watchers.mu.Lock()
+	if s.watchers.closed {
+		s.watchers.mu.Unlock()
+		close(w.ch)
+		return w.ch, func() {}
+	}
+	if s.watchers.subs == nil {
+		s.watchers.subs = make(map[*watcher]struct{})
+	}
+	s.watchers.subs[w] = struct{}{}
+	s.watchers.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.watchers.mu.Lock()
+			// Close may already have closed the channel
+			if _, ok := s.watchers.subs[w]; ok {
+				delete(s.watchers.subs, w)
+				close(w.ch)
+			}
+			s.watchers.mu.Unlock()
+		})
+	}
+
+	return w.ch, cancel
+}
+
+// closeAll ends every watch, closing its channel
+func (ws *watchers) closeAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.closed = true
+	for w := range ws.subs {
+		close(w.ch)
+	}
+	ws.subs = nil
+}
+
+// publish counts a change in the store's stats, queues expiries for the
+// OnExpire callbacks and delivers it to every matching watcher without
+// blocking. It is called with the store's write lock held, so events are
+// delivered in the order the changes were applied.
+func (s *Store) publish(op, key string, value Value) {
+	s.counters.count(op)
+	if op == EventExpire {
+		s.expireHooks.notifyExpired(key, value)
+	}
+
+	s.watchers.mu.Lock()
+	defer s.watchers.mu.Unlock()
+
+	if len(s.watchers.subs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for w := range s.watchers.subs {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+
+		select {
+		case w.ch <- Event{Op: op, Key: key, Value: value, Version: value.Version, Time: now, Lost: w.lost}:
+			w.lost = 0
+		default:
+			w.lost++
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package version reports which build of YAKVS is running. The values are set
+// at link time:
+//
+//	go build -ldflags "-X github.com/pixperk/yakvs/version.Version=v1.0.0 \
+//	  -X github.com/pixperk/yakvs/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/pixperk/yakvs/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Protocol is the wire protocol revision spoken by this build. It is bumped
+// whenever commands or response fields are added, so clients can tell when a
+// server knows things they don't.
+const Protocol = 8
+
+// MinProtocol is the oldest revision servers of this build still speak, and
+// the one assumed for clients that do not negotiate one with HELLO
+const MinProtocol = 1
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	Protocol  int    `json:"protocol"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		Protocol:  Protocol,
+	}
+
+	// Fall back to the VCS stamp the go tool embeds when building from a checkout
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "unknown":
+				info.Commit = s.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			case s.Key == "vcs.time" && info.BuildDate == "unknown":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+
+	return info
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, protocol %d)", i.Version, i.Commit, i.BuildDate, i.Protocol)
+}
+
+// String returns a one-line description of the running build
+func String() string {
+	return Get().String()
+}
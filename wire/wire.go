@@ -0,0 +1,220 @@
+// Package wire frames the messages of the TCP protocol. Connections start out
+// exchanging newline-terminated JSON and may switch to length-prefixed
+// MessagePack, which is cheaper to encode and decode, by naming it in HELLO.
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+)
+
+// ErrTooLarge is returned for a message over the size limit
+var ErrTooLarge = errors.New("message too large")
+
+// Codec encodes and frames the commands and responses of a connection. A
+// pipeline is sent as one message holding an array of them.
+type Codec interface {
+	// Name is what HELLO asks for the codec by
+	Name() string
+	// Marshal encodes v as one framed message, ready to be written
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes the payload of a message read by ReadFrame into v
+	Unmarshal(data []byte, v any) error
+	// ReadFrame reads the payload of the next message. A message longer than
+	// max bytes is skipped and reported as ErrTooLarge, so the stream stays
+	// usable; zero disables the limit.
+	ReadFrame(r *bufio.Reader, max int) ([]byte, error)
+	// IsBatch reports whether a payload holds an array of messages
+	IsBatch(data []byte) bool
+	// SplitBatch splits the payload of an array into the payloads of its
+	// elements
+	SplitBatch(data []byte) ([][]byte, error)
+}
+
+var (
+	// JSON is the default codec: one JSON document per line
+	JSON Codec = jsonCodec{}
+	// MsgPack frames each MessagePack message with its length as a 4 byte
+	// big-endian prefix. Struct fields keep their JSON names.
+	MsgPack Codec = msgpackCodec{}
+)
+
+// ByName returns the codec with the given name
+func ByName(name string) (Codec, bool) {
+	switch strings.ToLower(name) {
+	case "", "json":
+		return JSON, true
+	case "msgpack":
+		return MsgPack, true
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// ReadFrame returns the next line without its line ending. A last line
+// without a newline is returned before io.EOF.
+func (jsonCodec) ReadFrame(r *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	tooLarge := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			n := len(chunk)
+			if err == nil {
+				n-- // the newline
+			}
+			if max > 0 && len(line)+n > max {
+				tooLarge, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if tooLarge {
+			return nil, ErrTooLarge
+		}
+		if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+			return nil, err
+		}
+		for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+			line = line[:len(line)-1]
+		}
+		return line, nil
+	}
+}
+
+func (jsonCodec) IsBatch(data []byte) bool {
+	return strings.HasPrefix(strings.TrimLeft(string(data), " \t"), "[")
+}
+
+func (jsonCodec) SplitBatch(data []byte) ([][]byte, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	elems := make([][]byte, len(raw))
+	for i, r := range raw {
+		elems[i] = r
+	}
+	return elems, nil
+}
+
+// msgpackHandle encodes times as the MessagePack timestamp extension
+var msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+// maxPooledBuffer is the largest encoding buffer kept for reuse
+const maxPooledBuffer = 64 << 10
+
+// msgpackEncoder pairs an encoder with the buffer it encodes into
+type msgpackEncoder struct {
+	enc *codec.Encoder
+	buf []byte
+}
+
+// Encoders and decoders are costly to create but may be reset and reused
+var (
+	encoders = sync.Pool{New: func() any { return &msgpackEncoder{enc: codec.NewEncoderBytes(nil, msgpackHandle)} }}
+	decoders = sync.Pool{New: func() any { return codec.NewDecoderBytes(nil, msgpackHandle) }}
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	e := encoders.Get().(*msgpackEncoder)
+	defer func() {
+		// Keep one large value from pinning its buffer in the pool
+		if cap(e.buf) > maxPooledBuffer {
+			e.buf = nil
+		}
+		encoders.Put(e)
+	}()
+
+	// The payload goes to the reused buffer first, since its length comes
+	// before it
+	e.enc.ResetBytes(&e.buf)
+	if err := e.enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 4+len(e.buf))
+	binary.BigEndian.PutUint32(frame, uint32(len(e.buf)))
+	copy(frame[4:], e.buf)
+	return frame, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := decoders.Get().(*codec.Decoder)
+	defer decoders.Put(dec)
+
+	dec.ResetBytes(data)
+	return dec.Decode(v)
+}
+
+func (msgpackCodec) ReadFrame(r *bufio.Reader, max int) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(prefix[:])
+	if max > 0 && uint64(size) > uint64(max) {
+		if _, err := r.Discard(int(size)); err != nil {
+			return nil, err
+		}
+		return nil, ErrTooLarge
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// IsBatch reports whether the payload starts with an array header
+func (msgpackCodec) IsBatch(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	b := data[0]
+	return b&0xf0 == 0x90 || b == 0xdc || b == 0xdd
+}
+
+func (c msgpackCodec) SplitBatch(data []byte) ([][]byte, error) {
+	var raw []codec.Raw
+	if err := c.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	elems := make([][]byte, len(raw))
+	for i, r := range raw {
+		elems[i] = r
+	}
+	return elems, nil
+}
@@ -0,0 +1,603 @@
+// Package yakvs embeds the YAKVS storage engine in a Go program without
+// running a separate server process.
+//
+// A DB is safe for concurrent use by multiple goroutines. Every operation on a
+// single key is atomic, and writes are appended to the log before they become
+// visible to readers, so a write that has returned survives a restart when
+// persistence is enabled.
+package yakvs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/pixperk/yakvs/store"
+)
+
+var (
+	// ErrKeyNotFound is returned when a key does not exist or has expired
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrClosed is returned by operations on a DB after Close
+	ErrClosed = errors.New("database is closed")
+	// ErrTooLarge is returned by writes whose key or value exceeds the limits
+	ErrTooLarge = store.ErrTooLarge
+	// ErrNotInteger is returned by IncrBy when the key holds a value that is
+	// not an integer
+	ErrNotInteger = store.ErrNotInteger
+	// ErrOutOfMemory is returned by writes over Options.MaxMemory when
+	// nothing can be evicted
+	ErrOutOfMemory = store.ErrOutOfMemory
+	// ErrWrongType is returned by value operations on a list key and list
+	// operations on a value key
+	ErrWrongType = store.ErrWrongType
+)
+
+// DefaultLogFileName is the log file created inside Options.Path
+const DefaultLogFileName = "kvs.log"
+
+// Options configures a DB opened with Open
+type Options struct {
+	// Path is the data directory. It is created if it does not exist.
+	Path string
+	// LogFileName overrides the name of the log file inside Path
+	LogFileName string
+	// Persistence enables the append-only log. Without it all data is lost on Close.
+	Persistence bool
+	// CleanerInterval is how often expired keys are swept. Zero disables the
+	// background cleaner; expired keys are still hidden from reads.
+	CleanerInterval time.Duration
+	// SyncPolicy controls when log writes are fsynced. Empty means
+	// store.DefaultSyncPolicy.
+	SyncPolicy store.SyncPolicy
+	// ReplayMode controls what opening a log with corrupt records does.
+	// Empty means store.DefaultReplayMode, which refuses to open it.
+	ReplayMode store.ReplayMode
+	// EncryptionKey, if set, encrypts the log and saved snapshots with
+	// AES-GCM. It must be 16, 24 or 32 bytes, see store.NewEncryptedStore.
+	EncryptionKey []byte
+	// KeepExpired stops reads from deleting the expired keys they find,
+	// leaving them to the cleaner or, on a replica, to the primary's deletes
+	KeepExpired bool
+	// Limits caps the size of keys and values. Nil means store.DefaultLimits;
+	// a zero field means no limit.
+	Limits *store.Limits
+	// MaxMemory caps the estimated memory used by the data in bytes. Zero
+	// means no limit.
+	MaxMemory int64
+	// EvictionPolicy chooses what writes over MaxMemory do. Empty means
+	// store.DefaultEvictionPolicy, which rejects them.
+	EvictionPolicy store.EvictionPolicy
+	// AutoCompaction controls when the log is compacted on its own. Nil
+	// means store.DefaultAutoCompaction; a zero Ratio disables it.
+	AutoCompaction *store.AutoCompaction
+}
+
+// DB is an embedded key-value store
+type DB struct {
+	store  *store.Store
+	closed atomic.Bool
+}
+
+// Open opens or creates a database described by opts
+func Open(opts Options) (*DB, error) {
+	storeOpts := store.StoreOptions{
+		SyncPolicy:      opts.SyncPolicy,
+		ReplayMode:      opts.ReplayMode,
+		EncryptionKey:   opts.EncryptionKey,
+		CleanerInterval: opts.CleanerInterval,
+		KeepExpired:     opts.KeepExpired,
+		Limits:          opts.Limits,
+		MaxMemory:       opts.MaxMemory,
+		EvictionPolicy:  opts.EvictionPolicy,
+		AutoCompaction:  opts.AutoCompaction,
+	}
+	if opts.Persistence {
+		if opts.Path == "" {
+			return nil, fmt.Errorf("path is required when persistence is enabled")
+		}
+		if err := os.MkdirAll(opts.Path, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+
+		logFileName := opts.LogFileName
+		if logFileName == "" {
+			logFileName = DefaultLogFileName
+		}
+		storeOpts.LogPath = filepath.Join(opts.Path, logFileName)
+	} else if opts.EncryptionKey != nil {
+		return nil, fmt.Errorf("encryption requires persistence")
+	}
+
+	s, err := store.NewStoreWithOptions(storeOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store: %w", err)
+	}
+	return &DB{store: s}, nil
+}
+
+// NoExpiry is the TTL reported for keys that never expire
+const NoExpiry = store.NoExpiry
+
+// Set stores value under key, expiring after expiresIn, or never if it is zero
+func (db *DB) Set(key, value string, expiresIn time.Duration) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+
+	return db.store.Set(key, store.NewValue(value, expiresIn))
+}
+
+// Get returns the value stored under key and its remaining TTL
+func (db *DB) Get(key string) (string, time.Duration, error) {
+	if db.closed.Load() {
+		return "", 0, ErrClosed
+	}
+
+	value, ok := db.store.Get(key)
+	if !ok {
+		return "", 0, ErrKeyNotFound
+	}
+	if value.IsList() {
+		return "", 0, ErrWrongType
+	}
+
+	ttl, _ := db.store.TTL(key)
+	return value.Data, ttl, nil
+}
+
+// Op is one write in a batch applied by ApplyBatch
+type Op = store.Op
+
+// Kinds of write in a batch
+const (
+	BatchSet    = store.BatchSet
+	BatchDelete = store.BatchDelete
+	BatchExpire = store.BatchExpire
+)
+
+// ApplyBatch applies ops atomically: either every write lands, including
+// after a crash, or none does
+func (db *DB) ApplyBatch(ops []Op) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.store.ApplyBatch(ops)
+}
+
+// Value is a stored value with its version and write times
+type Value = store.Value
+
+// GetWithMeta returns the value stored under key along with its version,
+// creation and update times
+func (db *DB) GetWithMeta(key string) (Value, error) {
+	if db.closed.Load() {
+		return Value{}, ErrClosed
+	}
+
+	value, ok := db.store.Get(key)
+	if !ok {
+		return Value{}, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// Exists reports whether key is present and not expired
+func (db *DB) Exists(key string) (bool, error) {
+	if db.closed.Load() {
+		return false, ErrClosed
+	}
+	return db.store.Exists(key), nil
+}
+
+// Type returns "string" or "list" for the kind of value stored under key,
+// or "none" if it is missing or expired
+func (db *DB) Type(key string) (string, error) {
+	if db.closed.Load() {
+		return "", ErrClosed
+	}
+	return db.store.Type(key), nil
+}
+
+// RandomKey returns a live key chosen uniformly at random, or false if the
+// database is empty
+func (db *DB) RandomKey() (string, bool, error) {
+	if db.closed.Load() {
+		return "", false, ErrClosed
+	}
+	key, ok := db.store.RandomKey()
+	return key, ok, nil
+}
+
+// Sample returns up to n distinct live keys chosen uniformly at random
+func (db *DB) Sample(n int) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return db.store.Sample(n), nil
+}
+
+// MSet stores every key-value pair in pairs atomically, each expiring after
+// expiresIn, or never if it is zero
+func (db *DB) MSet(pairs map[string]string, expiresIn time.Duration) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+
+	values := make(map[string]store.Value, len(pairs))
+	for key, value := range pairs {
+		values[key] = store.NewValue(value, expiresIn)
+	}
+	return db.store.MSet(values)
+}
+
+// MSetValues stores every value in values atomically, each with its own
+// expiry
+func (db *DB) MSetValues(values map[string]Value) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.store.MSet(values)
+}
+
+// MGet returns the values of the keys that exist. Missing keys are left out
+// of the result.
+func (db *DB) MGet(keys ...string) (map[string]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	values := make(map[string]string, len(keys))
+	for key, value := range db.store.MGet(keys) {
+		values[key] = value.Data
+	}
+	return values, nil
+}
+
+// SetBytes stores a binary value under key. Values are stored as Go strings,
+// which hold arbitrary bytes, so Set and SetBytes are interchangeable.
+func (db *DB) SetBytes(key string, value []byte, expiresIn time.Duration) error {
+	return db.Set(key, string(value), expiresIn)
+}
+
+// GetBytes returns the value stored under key as bytes and its remaining TTL
+func (db *DB) GetBytes(key string) ([]byte, time.Duration, error) {
+	value, ttl, err := db.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return []byte(value), ttl, nil
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (db *DB) Delete(key string) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+
+	return db.store.Delete(key)
+}
+
+// TTL returns the remaining time before key expires, or NoExpiry
+func (db *DB) TTL(key string) (time.Duration, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	ttl, ok := db.store.TTL(key)
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return ttl, nil
+}
+
+// SetNX stores value under key only if the key does not exist, reporting
+// whether it did
+func (db *DB) SetNX(key, value string, expiresIn time.Duration) (bool, error) {
+	if db.closed.Load() {
+		return false, ErrClosed
+	}
+	return db.store.SetNX(key, store.NewValue(value, expiresIn))
+}
+
+// ConflictError is returned by CompareAndSwap when the key does not hold the
+// expected value
+type ConflictError = store.ConflictError
+
+// CompareAndSwap stores value under key only if the key currently holds
+// expected, or does not exist when expected is empty, and returns the key's
+// new version. Otherwise it returns a *ConflictError.
+func (db *DB) CompareAndSwap(key, expected, value string, expiresIn time.Duration) (uint64, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	return db.store.CompareAndSwap(key, expected, store.NewValue(value, expiresIn))
+}
+
+// GetSet stores value under key and returns the value it replaced, reporting
+// whether there was one
+func (db *DB) GetSet(key, value string, expiresIn time.Duration) (string, bool, error) {
+	if db.closed.Load() {
+		return "", false, ErrClosed
+	}
+
+	old, existed, err := db.store.GetSet(key, store.NewValue(value, expiresIn))
+	return old.Data, existed, err
+}
+
+// IncrBy adds delta to the integer stored under key and returns the result.
+// A missing key counts as 0, and a value that is not an integer returns
+// ErrNotInteger.
+func (db *DB) IncrBy(key string, delta int64) (int64, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	return db.store.IncrBy(key, delta)
+}
+
+// Append adds suffix to the end of the value stored under key and returns
+// the new length. A missing key is created expiring after expiresIn, or never
+// if it is zero; an existing key keeps its expiry.
+func (db *DB) Append(key, suffix string, expiresIn time.Duration) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	return db.store.Append(key, store.NewValue(suffix, expiresIn))
+}
+
+// Expire sets key to expire after expiresIn, or never if it is zero. It
+// reports false if the key does not exist.
+func (db *DB) Expire(key string, expiresIn time.Duration) (bool, error) {
+	if db.closed.Load() {
+		return false, ErrClosed
+	}
+	return db.store.Expire(key, expiresIn)
+}
+
+// Touch resets key's expiry to expiresIn from now, or removes it if
+// expiresIn is zero. It reports false if the key does not exist.
+func (db *DB) Touch(key string, expiresIn time.Duration) (bool, error) {
+	if db.closed.Load() {
+		return false, ErrClosed
+	}
+	return db.store.Touch(key, expiresIn)
+}
+
+// GetEx returns key's value while resetting its expiry to expiresIn from now,
+// or removing it if expiresIn is zero, in one atomic write. It returns
+// ErrKeyNotFound if the key does not exist.
+func (db *DB) GetEx(key string, expiresIn time.Duration) (string, error) {
+	if db.closed.Load() {
+		return "", ErrClosed
+	}
+
+	val, ok, err := db.store.GetEx(key, expiresIn)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return val.Data, nil
+}
+
+// LPush inserts elems at the head of the list stored under key, the last
+// ending up first, and returns its new length. A missing key is created as
+// a list; a key holding a plain value returns ErrWrongType.
+func (db *DB) LPush(key string, elems ...string) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	return db.store.LPush(key, elems...)
+}
+
+// RPush appends elems to the tail of the list stored under key and returns
+// its new length, creating it like LPush
+func (db *DB) RPush(key string, elems ...string) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	return db.store.RPush(key, elems...)
+}
+
+// LPop removes and returns up to count elements from the head of the list
+// stored under key. Popping the last element removes the key.
+func (db *DB) LPop(key string, count int) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return db.store.LPop(key, count)
+}
+
+// RPop removes and returns up to count elements from the tail of the list
+// stored under key, last element first
+func (db *DB) RPop(key string, count int) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return db.store.RPop(key, count)
+}
+
+// LLen returns the length of the list stored under key, 0 if it does not
+// exist
+func (db *DB) LLen(key string) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	return db.store.LLen(key)
+}
+
+// LRange returns the elements of the list stored under key from start to
+// stop inclusive. Negative indexes count from the end, so LRange(key, 0, -1)
+// returns the whole list.
+func (db *DB) LRange(key string, start, stop int) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return db.store.LRange(key, start, stop)
+}
+
+// Persist removes key's expiry. It reports false if the key does not exist
+// or has no expiry.
+func (db *DB) Persist(key string) (bool, error) {
+	if db.closed.Load() {
+		return false, ErrClosed
+	}
+	return db.store.Persist(key)
+}
+
+// Keys returns all live keys in lexicographic order
+func (db *DB) Keys() ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	return db.KeysWithPrefix("")
+}
+
+// KeysWithPrefix returns the live keys starting with prefix in lexicographic
+// order
+func (db *DB) KeysWithPrefix(prefix string) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	var keys []string
+	db.store.RangePrefix(prefix, func(key string, _ store.Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, nil
+}
+
+// KeysInRange returns the live keys from start up to but not including end in
+// lexicographic order. An empty end means no upper bound.
+func (db *DB) KeysInRange(start, end string) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	var keys []string
+	db.store.RangeSorted(start, end, func(key string, _ store.Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, nil
+}
+
+// DeletePrefix removes every key starting with prefix and returns how many
+// live keys were removed
+func (db *DB) DeletePrefix(prefix string) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	return db.store.DeletePrefix(prefix)
+}
+
+// MemoryStats summarizes the approximate memory used by the DB's entries
+type MemoryStats = store.MemoryStats
+
+// MemoryUsage returns the approximate number of bytes used by key, counting
+// the key, the value and per-entry bookkeeping
+func (db *DB) MemoryUsage(key string) (int64, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	size, ok := db.store.MemoryUsage(key)
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return size, nil
+}
+
+// MemoryStats returns memory totals and the top largest keys, biggest first
+func (db *DB) MemoryStats(top int) (MemoryStats, error) {
+	if db.closed.Load() {
+		return MemoryStats{}, ErrClosed
+	}
+	return db.store.MemoryStats(top), nil
+}
+
+// Stats summarizes the size of a DB and the writes it has seen since Open
+type Stats = store.Stats
+
+// Stats returns the key count, memory estimate and write counters
+func (db *DB) Stats() (Stats, error) {
+	if db.closed.Load() {
+		return Stats{}, ErrClosed
+	}
+	return db.store.Stats(), nil
+}
+
+// FlushAll removes every key and empties the log, so they stay gone after a
+// restart
+func (db *DB) FlushAll() error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.store.Clear()
+}
+
+// Compact rewrites the log to hold only the live keys, reclaiming the space
+// used by overwritten, deleted and expired ones
+func (db *DB) Compact() error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.store.Compact()
+}
+
+// SaveSnapshot writes every live key to w in a compact binary format that
+// LoadSnapshot reads back. Writes are only blocked while the keys are copied.
+func (db *DB) SaveSnapshot(w io.Writer) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.store.SaveSnapshot(w)
+}
+
+// LoadSnapshot replaces the contents of the DB with a snapshot written by
+// SaveSnapshot. A corrupt snapshot is rejected without changing anything.
+func (db *DB) LoadSnapshot(r io.Reader) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.store.LoadSnapshot(r)
+}
+
+// Event describes a change to a watched key
+type Event = store.Event
+
+// Watch returns a channel of changes to keys with the given prefix and a
+// function that cancels the watch. Writers never block on a slow watcher;
+// events beyond buffer are dropped and counted in the next Event's Lost field.
+func (db *DB) Watch(prefix string, buffer int) (<-chan Event, func()) {
+	return db.store.Watch(prefix, buffer)
+}
+
+// OnExpire registers fn to run when a key is removed because it expired, and
+// returns a function that unregisters it. Callbacks run in order on a
+// background goroutine; expiries are dropped if they fall too far behind.
+func (db *DB) OnExpire(fn func(key string, v Value)) func() {
+	return db.store.OnExpire(fn)
+}
+
+// Store returns the underlying storage engine for lower-level access such as
+// log streaming. Writes made directly through it bypass the DB's closed check.
+func (db *DB) Store() *store.Store {
+	return db.store
+}
+
+// Close stops background work and releases the log file. Operations after
+// Close return ErrClosed.
+func (db *DB) Close() error {
+	if db.closed.Swap(true) {
+		return nil
+	}
+	return db.store.Close()
+}
@@ -0,0 +1,5 @@
+// Package yakvspb holds the Yakvs gRPC service defined in yakvs.proto and the
+// code generated from it. Run go generate after editing the definition.
+package yakvspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative yakvs.proto